@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Users who signed up through an OIDC provider have no password. To confirm
+// it is them, they ask for a code by email and send it where others send
+// their password. A code works once, for 10 minutes, and one is mailed a
+// minute at most.
+const (
+	purposeReauthCode  = "reauth_code"
+	reauthCodeTTL      = 10 * time.Minute
+	reauthCodeInterval = time.Minute
+)
+
+// currentUser loads the signed-in user and checks password against theirs,
+// or against an emailed code when they have no password. It sends the error
+// response itself and returns nil when either fails.
+func currentUser(c *fiber.Ctx, password string) (*User, error) {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	user, err := store.GetUserByID(c.Context(), userID)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		return nil, err
+	}
+	if user.PasswordHash == "" {
+		if ok, err := checkReauthCode(c, user, password, time.Now().UTC()); !ok {
+			return nil, err
+		}
+		return user, nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Current password is incorrect"})
+	}
+	return user, nil
+}
+
+// reauthCodeHash binds a code to its user, as codes are short enough for
+// two users to get the same one.
+func reauthCodeHash(userID primitive.ObjectID, code string) string {
+	return hashSecretToken(userID.Hex() + ":" + strings.TrimSpace(code))
+}
+
+// checkReauthCode accepts an unused code mailed to the user, using it up.
+// Like checkSecondFactor it counts wrong codes as failed logins, sends the
+// error response itself and returns false when the code is refused.
+func checkReauthCode(c *fiber.Ctx, user *User, code string, now time.Time) (bool, error) {
+	keys := loginAttemptKeys(user.Email, c.IP())
+	wait, err := loginAttemptWait(c.Context(), keys, now)
+	if err != nil {
+		return false, err
+	}
+	if wait > 0 {
+		return false, tooManyLoginAttempts(c, wait)
+	}
+	rc, err := store.GetOneTimeToken(c.Context(), purposeReauthCode, reauthCodeHash(user.ID, code))
+	if err == nil && (rc.UserID != user.ID || rc.UsedAt != nil || !now.Before(rc.ExpiresAt)) {
+		err = ErrTokenUsed
+	}
+	if err == nil {
+		err = store.UseOneTimeToken(c.Context(), rc.ID, now)
+	}
+	if err == ErrNotFound || err == ErrTokenUsed {
+		if err := recordLoginFailure(c, keys, user, now); err != nil {
+			return false, err
+		}
+		return false, c.Status(400).JSON(fiber.Map{"error": "Invalid or expired code"})
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// sendReauthCodeHandler mails a code to a user without a password, which
+// they then send in place of the current password.
+func sendReauthCodeHandler(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	user, err := store.GetUserByID(c.Context(), userID)
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		return err
+	}
+	if user.PasswordHash != "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Use your password instead"})
+	}
+	now := time.Now().UTC()
+	recent, err := store.RecentOneTimeTokens(c.Context(), user.ID, purposeReauthCode, now.Add(-reauthCodeInterval))
+	if err != nil {
+		return err
+	}
+	if len(recent) > 0 {
+		seconds := int((recent[0].CreatedAt.Add(reauthCodeInterval).Sub(now) + time.Second - 1) / time.Second)
+		c.Set(fiber.HeaderRetryAfter, fmt.Sprint(seconds))
+		return c.Status(429).JSON(fiber.Map{"error": "A code was just sent", "retryAfter": seconds})
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	// Only the latest code works
+	if err := store.UseUserOneTimeTokens(c.Context(), user.ID, purposeReauthCode, now); err != nil {
+		return err
+	}
+	err = store.CreateOneTimeToken(c.Context(), &OneTimeToken{
+		UserID:    user.ID,
+		Purpose:   purposeReauthCode,
+		Email:     user.Email,
+		TokenHash: reauthCodeHash(user.ID, code),
+		CreatedAt: now,
+		ExpiresAt: now.Add(reauthCodeTTL),
+	})
+	if err != nil {
+		return err
+	}
+	sendMailAsync(Mail{
+		To:      user.Email,
+		Subject: "Your confirmation code",
+		Body: fmt.Sprintf("Hi %s,\n\nYour confirmation code is\n\n%s\n\n"+
+			"It works once and expires in %d minutes. If you did not ask for it, someone may be signed in to your account: "+
+			"sign out everywhere on your profile page.\n", user.Name, code, int(reauthCodeTTL.Minutes())),
+	})
+	return c.JSON(fiber.Map{"success": true})
+}
+
+// keepsLoginMethod reports whether the user can still sign in without the
+// linked account or passkey skip: with a password, another linked account
+// or another passkey. Sign-in links alone do not count, as they only work
+// while the user still owns the email.
+func keepsLoginMethod(ctx context.Context, user *User, skip primitive.ObjectID) (bool, error) {
+	if user.PasswordHash != "" {
+		return true, nil
+	}
+	identities, err := store.ListIdentities(ctx, user.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, i := range identities {
+		if i.ID != skip {
+			return true, nil
+		}
+	}
+	passkeys, err := store.ListPasskeys(ctx, user.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range passkeys {
+		if p.ID != skip {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// lastLoginMethod refuses to remove the only way a user without a password
+// has to sign in.
+func lastLoginMethod(c *fiber.Ctx) error {
+	return c.Status(409).JSON(fiber.Map{"error": "This is your only way to sign in. Set a password or add a passkey first"})
+}
+
+// signOutOtherSessions ends every session of the user but keep, which may be
+// the zero ObjectID, and revokes their refresh tokens. API tokens are left
+// alone; changePasswordHandler deletes them itself.
+func signOutOtherSessions(ctx context.Context, userID, keep primitive.ObjectID, now time.Time) error {
+	sessions, err := store.ListSessions(ctx, userID, now)
+	if err != nil {
+		return err
+	}
+	for _, s := range sessions {
+		if s.ID == keep {
+			continue
+		}
+		if err := endSession(ctx, s.ID, now); err != nil {
+			return err
+		}
+	}
+	// Logins from before sessions existed have refresh tokens but no session
+	return store.RevokeUserRefreshTokens(ctx, userID, keep, now)
+}
+
+// changePasswordHandler sets a new password, signs out every other device
+// and deletes the user's API tokens, which may have leaked with the old
+// password.
+func changePasswordHandler(c *fiber.Ctx) error {
+	var payload struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	if len(payload.NewPassword) < 6 {
+		return c.Status(400).JSON(fiber.Map{"error": "Password must be at least 6 characters"})
+	}
+	user, err := currentUser(c, payload.CurrentPassword)
+	if user == nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(payload.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	passwordHash := string(hash)
+	if _, err := store.UpdateUser(c.Context(), user.ID, UserUpdate{PasswordHash: &passwordHash, UpdatedAt: now}); err != nil {
+		return err
+	}
+	if err := store.UseUserOneTimeTokens(c.Context(), user.ID, purposePasswordReset, now); err != nil {
+		return err
+	}
+	claims := c.Locals("claims").(*AuthClaims)
+	current, _ := primitive.ObjectIDFromHex(claims.SessionID)
+	if err := signOutOtherSessions(c.Context(), user.ID, current, now); err != nil {
+		return err
+	}
+	if err := store.DeleteUserAPITokens(c.Context(), user.ID); err != nil {
+		return err
+	}
+	sendMailAsync(securityNotice(user, "Your password was changed",
+		"The password of your account was just changed. Every other device was signed out, "+
+			"and your personal access tokens were deleted.", ""))
+	return c.JSON(fiber.Map{"success": true})
+}
+
+// changeEmailHandler moves the account to a new email, which then needs to be
+// verified. The old address is told about the change.
+func changeEmailHandler(c *fiber.Ctx) error {
+	var payload struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	email := strings.ToLower(strings.TrimSpace(payload.Email))
+	if !emailRegex.MatchString(email) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid email"})
+	}
+	user, err := currentUser(c, payload.Password)
+	if user == nil {
+		return err
+	}
+	if email == user.Email {
+		return c.Status(400).JSON(fiber.Map{"error": "That is already your email"})
+	}
+	now := time.Now().UTC()
+	var unverified *time.Time
+	updated, err := store.UpdateUser(c.Context(), user.ID, UserUpdate{Email: &email, EmailVerifiedAt: &unverified, UpdatedAt: now})
+	if err != nil {
+		if err == ErrDuplicateEmail {
+			return c.Status(409).JSON(fiber.Map{"error": "Email already registered"})
+		}
+		return err
+	}
+	// Reset links sent to the old address must not work anymore
+	if err := store.UseUserOneTimeTokens(c.Context(), user.ID, purposePasswordReset, now); err != nil {
+		return err
+	}
+	sendMailAsync(Mail{
+		To:      user.Email,
+		Subject: "Your email was changed",
+		Body: fmt.Sprintf("Hi %s,\n\nThe email of your account was just changed from %s to %s.\n\n"+
+			"If this was not you, someone else knows your password. Contact us by replying to this email.\n",
+			user.Name, user.Email, email),
+	})
+	if err := sendVerificationEmail(c.Context(), updated); err != nil {
+		log.Printf("Error creating verification email for %s: %v", updated.Email, err)
+	}
+	return c.JSON(userJSON(updated))
+}
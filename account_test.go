@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// passwordlessUser stores a user as an OIDC sign-up creates them, without a
+// password, and signs them in with a magic link. It returns their access
+// token and the user.
+func passwordlessUser(t *testing.T, app *fiber.App, mail *testMailer, email string) (string, *User) {
+	t.Helper()
+	now := time.Now().UTC()
+	user := newUser("Olivia", "", email, "", now)
+	user.EmailVerifiedAt = &now
+	if err := store.CreateUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	if status, res := request(t, app, "POST", "/api/auth/magic", "", fiber.Map{"email": email}); status != 200 {
+		t.Fatalf("magic link: %d %v", status, res)
+	}
+	status, res := request(t, app, "POST", "/api/auth/magic/verify", "", fiber.Map{"token": magicLinkToken(t, mail, email)})
+	if status != 200 {
+		t.Fatalf("magic link sign-in: %d %v", status, res)
+	}
+	if res["user"].(map[string]interface{})["hasPassword"] != false {
+		t.Errorf("user without a password: %v", res["user"])
+	}
+	return res["token"].(string), user
+}
+
+var reauthCodeRegex = regexp.MustCompile(`\b\d{6}\b`)
+
+// reauthCode asks for a code for the user of token and returns it.
+func reauthCode(t *testing.T, app *fiber.App, mail *testMailer, token, email string) string {
+	t.Helper()
+	if status, res := request(t, app, "POST", "/api/auth/reauth-code", token, nil); status != 200 {
+		t.Fatalf("reauth code: %d %v", status, res)
+	}
+	code := reauthCodeRegex.FindString(mail.waitFor(t, email, "confirmation code").Body)
+	if code == "" {
+		t.Fatal("no code in the mail")
+	}
+	return code
+}
+
+func TestPasswordlessUserSetsAPasswordWithAnEmailedCode(t *testing.T) {
+	app, mail := newTestApp(t)
+	token, _ := passwordlessUser(t, app, mail, "olivia@example.com")
+
+	change := func(current string) (int, map[string]interface{}) {
+		return request(t, app, "POST", "/api/auth/change-password", token,
+			fiber.Map{"currentPassword": current, "newPassword": "secret2"})
+	}
+	if status, res := change(""); status != 400 {
+		t.Errorf("without a code: status %d, want 400: %v", status, res)
+	}
+	code := reauthCode(t, app, mail, token, "olivia@example.com")
+	if status, res := change(code); status != 200 {
+		t.Fatalf("with the code: %d %v", status, res)
+	}
+	if status, res := login(t, app, "olivia@example.com", "secret2"); status != 200 || res["user"].(map[string]interface{})["hasPassword"] != true {
+		t.Errorf("login with the new password: %d %v", status, res)
+	}
+	// From now on the password is needed, not a code
+	if status, res := change(code); status != 400 {
+		t.Errorf("code after setting a password: status %d, want 400: %v", status, res)
+	}
+	if status, res := request(t, app, "POST", "/api/auth/reauth-code", token, nil); status != 400 {
+		t.Errorf("code for a user with a password: status %d, want 400: %v", status, res)
+	}
+}
+
+func TestReauthCodeWorksOnceAndIsLimited(t *testing.T) {
+	app, mail := newTestApp(t)
+	token, _ := passwordlessUser(t, app, mail, "olivia@example.com")
+	code := reauthCode(t, app, mail, token, "olivia@example.com")
+	if status, res := request(t, app, "POST", "/api/auth/reauth-code", token, nil); status != 429 || res["retryAfter"] == nil {
+		t.Errorf("second code within a minute: %d %v", status, res)
+	}
+
+	body := fiber.Map{"password": code}
+	if status, res := request(t, app, "POST", "/api/auth/2fa/setup", token, body); status != 200 {
+		t.Fatalf("2fa setup with the code: %d %v", status, res)
+	}
+	if status, res := request(t, app, "POST", "/api/auth/2fa/setup", token, body); status != 400 {
+		t.Errorf("code used again: status %d, want 400: %v", status, res)
+	}
+}
+
+func TestPasswordlessUserKeepsALoginMethod(t *testing.T) {
+	app, mail := newTestApp(t)
+	token, user := passwordlessUser(t, app, mail, "olivia@example.com")
+	ctx := context.Background()
+	now := time.Now().UTC()
+	var identities []*Identity
+	for _, provider := range []string{"google", "work"} {
+		identity := &Identity{UserID: user.ID, Provider: provider, Subject: "olivia", Email: user.Email, CreatedAt: now}
+		if err := store.CreateIdentity(ctx, identity); err != nil {
+			t.Fatal(err)
+		}
+		identities = append(identities, identity)
+	}
+	remove := func(path string) int {
+		status, _ := request(t, app, "DELETE", path, token, nil)
+		return status
+	}
+
+	if status := remove("/api/auth/identities/" + identities[0].ID.Hex()); status != 200 {
+		t.Errorf("unlinking one of two accounts: status %d", status)
+	}
+	if status := remove("/api/auth/identities/" + identities[1].ID.Hex()); status != 409 {
+		t.Errorf("unlinking the last account: status %d, want 409", status)
+	}
+	passkey := &Passkey{UserID: user.ID, Name: "Phone", CredentialID: []byte("olivia's phone"), CreatedAt: now}
+	if err := store.CreatePasskey(ctx, passkey); err != nil {
+		t.Fatal(err)
+	}
+	if status := remove("/api/auth/identities/" + identities[1].ID.Hex()); status != 200 {
+		t.Errorf("unlinking the account with a passkey left: status %d", status)
+	}
+	if status := remove("/api/auth/passkeys/" + passkey.ID.Hex()); status != 409 {
+		t.Errorf("removing the last passkey: status %d, want 409", status)
+	}
+}
+
+func TestUserWithAPasswordCanUnlinkEveryAccount(t *testing.T) {
+	app, _ := newTestApp(t)
+	token, alice := register(t, app, "Alice", "alice@example.com")
+	userID, _ := primitive.ObjectIDFromHex(alice["_id"].(string))
+	identity := &Identity{UserID: userID, Provider: "google", Subject: "alice", Email: "alice@example.com", CreatedAt: time.Now().UTC()}
+	if err := store.CreateIdentity(context.Background(), identity); err != nil {
+		t.Fatal(err)
+	}
+	if status, res := request(t, app, "DELETE", "/api/auth/identities/"+identity.ID.Hex(), token, nil); status != 200 {
+		t.Errorf("status %d: %v", status, res)
+	}
+}
+
+func TestChangePasswordSignsOutOtherDevices(t *testing.T) {
+	app, mail := newTestApp(t)
+	status, res := request(t, app, "POST", "/api/auth/register", "",
+		fiber.Map{"name": "Alice", "email": "alice@example.com", "password": "secret1"})
+	if status != 201 {
+		t.Fatalf("register: %d %v", status, res)
+	}
+	token, refresh := res["token"].(string), res["refreshToken"].(string)
+	status, res = login(t, app, "alice@example.com", "secret1")
+	if status != 200 {
+		t.Fatalf("login: %d %v", status, res)
+	}
+	other, otherRefresh := res["token"].(string), res["refreshToken"].(string)
+	apiToken := createAPIToken(t, app, token, scopeTodosRead)
+
+	for _, payload := range []fiber.Map{
+		{"currentPassword": "wrong1", "newPassword": "secret2"},
+		{"currentPassword": "secret1", "newPassword": "short"},
+	} {
+		if status, res := request(t, app, "POST", "/api/auth/change-password", token, payload); status != 400 {
+			t.Errorf("%v: %d %v", payload, status, res)
+		}
+	}
+	status, res = request(t, app, "POST", "/api/auth/change-password", token, fiber.Map{"currentPassword": "secret1", "newPassword": "secret2"})
+	if status != 200 {
+		t.Fatalf("change: %d %v", status, res)
+	}
+	if notice := mail.waitFor(t, "alice@example.com", "Your password was changed"); !strings.Contains(notice.Body, "reset your password right away at") {
+		t.Errorf("notice: %q", notice.Body)
+	}
+
+	for _, c := range []struct {
+		name, token string
+		want        int
+	}{
+		{"this device", token, 200},
+		{"another device", other, 401},
+		{"an API token", apiToken, 401},
+	} {
+		if status, res := request(t, app, "GET", "/api/wishlist", c.token, nil); status != c.want {
+			t.Errorf("%s: %d %v, want %d", c.name, status, res, c.want)
+		}
+	}
+	if status, res := request(t, app, "POST", "/api/auth/refresh", "", fiber.Map{"refreshToken": otherRefresh}); status != 401 {
+		t.Errorf("another device's refresh token: %d %v", status, res)
+	}
+	if status, res := request(t, app, "POST", "/api/auth/refresh", "", fiber.Map{"refreshToken": refresh}); status != 200 {
+		t.Errorf("this device's refresh token: %d %v", status, res)
+	}
+	if status, _ := login(t, app, "alice@example.com", "secret1"); status != 401 {
+		t.Errorf("old password: %d", status)
+	}
+	if status, res := login(t, app, "alice@example.com", "secret2"); status != 200 {
+		t.Errorf("new password: %d %v", status, res)
+	}
+}
+
+func TestChangeEmailNeedsVerifyingTheNewAddress(t *testing.T) {
+	app, mail := newTestApp(t)
+	token, _ := register(t, app, "Alice", "alice@example.com")
+	verifyEmail(t, app, mail, "alice@example.com")
+	register(t, app, "Bob", "bob@example.com")
+	if status, res := request(t, app, "POST", "/api/auth/forgot-password", "", fiber.Map{"email": "alice@example.com"}); status != 200 {
+		t.Fatalf("forgot-password: %d %v", status, res)
+	}
+	m := resetLinkRegex.FindStringSubmatch(mail.waitFor(t, "alice@example.com", "Reset your password").Body)
+	if m == nil {
+		t.Fatal("no link in the reset mail")
+	}
+	reset, _ := url.QueryUnescape(m[1])
+
+	for _, c := range []struct {
+		payload fiber.Map
+		want    int
+	}{
+		{fiber.Map{"email": "new@example.com", "password": "wrong1"}, 400},
+		{fiber.Map{"email": "not an email", "password": "secret1"}, 400},
+		{fiber.Map{"email": " Alice@example.com", "password": "secret1"}, 400},
+		{fiber.Map{"email": "bob@example.com", "password": "secret1"}, 409},
+	} {
+		if status, res := request(t, app, "POST", "/api/auth/change-email", token, c.payload); status != c.want {
+			t.Errorf("%v: %d %v, want %d", c.payload, status, res, c.want)
+		}
+	}
+	status, res := request(t, app, "POST", "/api/auth/change-email", token, fiber.Map{"email": " New@example.com ", "password": "secret1"})
+	if status != 200 || res["email"] != "new@example.com" || res["emailVerified"] != false {
+		t.Fatalf("change: %d %v", status, res)
+	}
+	notice := mail.waitFor(t, "alice@example.com", "Your email was changed")
+	if !strings.Contains(notice.Body, "new@example.com") {
+		t.Errorf("notice to the old address: %q", notice.Body)
+	}
+	verifyEmail(t, app, mail, "new@example.com")
+
+	if status, res := request(t, app, "POST", "/api/auth/reset-password", "", fiber.Map{"token": reset, "password": "secret2"}); status != 400 {
+		t.Errorf("reset link sent to the old address: %d %v", status, res)
+	}
+	if status, _ := login(t, app, "alice@example.com", "secret1"); status != 401 {
+		t.Errorf("login with the old email: %d", status)
+	}
+	if status, res := login(t, app, "new@example.com", "secret1"); status != 200 {
+		t.Errorf("login with the new email: %d %v", status, res)
+	}
+}
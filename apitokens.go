@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Personal access tokens let scripts use the API without logging in. They
+// start with apiTokenPrefix, which is how authMiddleware tells them from
+// JWTs, and only open routes marked with tokenScope for one of their scopes.
+const apiTokenPrefix = "tdp_"
+
+const (
+	scopeTodosRead  = "todos:read"
+	scopeTodosWrite = "todos:write"
+)
+
+var apiTokenScopes = []string{scopeTodosRead, scopeTodosWrite}
+
+const (
+	apiTokenDefaultDays = 30
+	apiTokenMaxDays     = 365
+	// Last use is written at most once a minute per token
+	apiTokenUseInterval = time.Minute
+)
+
+// tokenScope marks a route as open to API tokens with scope. Routes without
+// it refuse API tokens, so a token can never manage the account or make
+// more tokens.
+func tokenScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("tokenScope", scope)
+		return c.Next()
+	}
+}
+
+// apiTokenError is why an API token was refused, with the response status.
+type apiTokenError struct {
+	status  int
+	message string
+}
+
+func (e *apiTokenError) Error() string { return e.message }
+
+// checkAPIToken returns the API token raw if it may be used on this route,
+// and records its use. A refused token returns an *apiTokenError.
+func checkAPIToken(c *fiber.Ctx, raw string) (*APIToken, error) {
+	token, err := store.GetAPITokenByHash(c.Context(), hashSecretToken(raw))
+	if err == ErrNotFound {
+		return nil, &apiTokenError{401, "Invalid token"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if !now.Before(token.ExpiresAt) {
+		return nil, &apiTokenError{401, "Token expired"}
+	}
+	scope, _ := c.Locals("tokenScope").(string)
+	if scope == "" {
+		return nil, &apiTokenError{403, "Personal access tokens cannot be used here"}
+	}
+	if !containsString(token.Scopes, scope) {
+		return nil, &apiTokenError{403, "Token lacks the " + scope + " scope"}
+	}
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= apiTokenUseInterval {
+		if err := store.UseAPIToken(c.Context(), token.ID, now); err != nil {
+			return nil, err
+		}
+	}
+	return token, nil
+}
+
+// apiTokenAuth authenticates a request made with an API token, for
+// authMiddleware.
+func apiTokenAuth(c *fiber.Ctx, raw string) error {
+	token, err := checkAPIToken(c, raw)
+	if err != nil {
+		var refused *apiTokenError
+		if errors.As(err, &refused) {
+			return c.Status(refused.status).JSON(fiber.Map{"error": refused.message})
+		}
+		return err
+	}
+	c.Locals("userId", token.UserID.Hex())
+	c.Locals("apiToken", token)
+	return c.Next()
+}
+
+func listAPITokensHandler(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	tokens, err := store.ListAPITokens(c.Context(), userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"items": tokens})
+}
+
+// createAPITokenHandler makes a token and returns it. It is only shown this
+// once; afterwards the hint tells it apart. A token outlives the session
+// that made it, so making one takes the password.
+func createAPITokenHandler(c *fiber.Ctx) error {
+	var payload struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays *int     `json:"expiresInDays"`
+		Password      string   `json:"password"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	name := strings.TrimSpace(payload.Name)
+	if name == "" || len(name) > 100 {
+		return c.Status(400).JSON(fiber.Map{"error": "Name must be 1 to 100 characters"})
+	}
+	var scopes []string
+	for _, scope := range payload.Scopes {
+		if !containsString(apiTokenScopes, scope) {
+			return c.Status(400).JSON(fiber.Map{"error": "Unknown scope " + scope})
+		}
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Choose at least one scope"})
+	}
+	days := apiTokenDefaultDays
+	if payload.ExpiresInDays != nil {
+		days = *payload.ExpiresInDays
+	}
+	if days < 1 || days > apiTokenMaxDays {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Tokens must expire in 1 to %d days", apiTokenMaxDays)})
+	}
+	user, err := currentUser(c, payload.Password)
+	if user == nil {
+		return err
+	}
+	secret, _, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	raw := apiTokenPrefix + secret
+	now := time.Now().UTC()
+	token := &APIToken{
+		UserID:    user.ID,
+		Name:      name,
+		Scopes:    scopes,
+		TokenHash: hashSecretToken(raw),
+		Hint:      raw[:len(apiTokenPrefix)+4],
+		CreatedAt: now,
+		ExpiresAt: now.AddDate(0, 0, days),
+	}
+	if err := store.CreateAPIToken(c.Context(), token); err != nil {
+		return err
+	}
+	recordAudit(c.Context(), AuditEntry{
+		Action: "api_token_created", UserID: &user.ID, Subject: user.Email, IP: c.IP(),
+		Details: name + " (" + strings.Join(scopes, ", ") + ")", CreatedAt: now,
+	})
+	sendMailAsync(securityNotice(user, "A personal access token was created",
+		fmt.Sprintf("The personal access token %q (%s) was just created for your account. It expires on %s.",
+			name, strings.Join(scopes, ", "), token.ExpiresAt.Format("2 January 2006")),
+		"delete it on your profile page"))
+	return c.Status(201).JSON(fiber.Map{"token": raw, "apiToken": token})
+}
+
+func deleteAPITokenHandler(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Token not found"})
+	}
+	if err := store.DeleteAPIToken(c.Context(), userID, id); err != nil {
+		if err == ErrNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Token not found"})
+		}
+		return err
+	}
+	return c.Status(200).JSON(fiber.Map{"success": true})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// createAPIToken makes a token with scopes for the user of token, whose
+// password is "secret1", and returns it.
+func createAPIToken(t *testing.T, app *fiber.App, token string, scopes ...string) string {
+	t.Helper()
+	status, res := request(t, app, "POST", "/api/auth/tokens", token,
+		fiber.Map{"name": "Script", "scopes": scopes, "password": "secret1"})
+	if status != 201 {
+		t.Fatalf("create token: %d %v", status, res)
+	}
+	return res["token"].(string)
+}
+
+var resetLinkRegex = regexp.MustCompile(`/reset-password\?token=(\S+)`)
+
+func TestAPITokensEndWithThePassword(t *testing.T) {
+	for _, end := range []struct {
+		name string
+		run  func(t *testing.T, app *fiber.App, mail *testMailer, token string)
+	}{
+		{"logout-all", func(t *testing.T, app *fiber.App, mail *testMailer, token string) {
+			if status, res := request(t, app, "POST", "/api/auth/logout-all", token, nil); status != 200 {
+				t.Fatalf("logout-all: %d %v", status, res)
+			}
+		}},
+		{"change-password", func(t *testing.T, app *fiber.App, mail *testMailer, token string) {
+			status, res := request(t, app, "POST", "/api/auth/change-password", token,
+				fiber.Map{"currentPassword": "secret1", "newPassword": "secret2"})
+			if status != 200 {
+				t.Fatalf("change-password: %d %v", status, res)
+			}
+		}},
+		{"reset-password", func(t *testing.T, app *fiber.App, mail *testMailer, token string) {
+			if status, res := request(t, app, "POST", "/api/auth/forgot-password", "", fiber.Map{"email": "alice@example.com"}); status != 200 {
+				t.Fatalf("forgot-password: %d %v", status, res)
+			}
+			m := resetLinkRegex.FindStringSubmatch(mail.waitFor(t, "alice@example.com", "Reset your password").Body)
+			if m == nil {
+				t.Fatal("no link in the reset mail")
+			}
+			reset, _ := url.QueryUnescape(m[1])
+			if status, res := request(t, app, "POST", "/api/auth/reset-password", "", fiber.Map{"token": reset, "password": "secret2"}); status != 200 {
+				t.Fatalf("reset-password: %d %v", status, res)
+			}
+		}},
+	} {
+		t.Run(end.name, func(t *testing.T) {
+			app, mail := newTestApp(t)
+			token, _ := register(t, app, "Alice", "alice@example.com")
+			apiToken := createAPIToken(t, app, token, scopeTodosRead)
+			if status, res := request(t, app, "GET", "/api/wishlist", apiToken, nil); status != 200 {
+				t.Fatalf("token before: %d %v", status, res)
+			}
+			end.run(t, app, mail, token)
+			if status, res := request(t, app, "GET", "/api/wishlist", apiToken, nil); status != 401 {
+				t.Errorf("token after %s: status %d, want 401: %v", end.name, status, res)
+			}
+		})
+	}
+}
+
+func TestCreateAPITokenNeedsThePassword(t *testing.T) {
+	app, mail := newTestApp(t)
+	token, _ := register(t, app, "Alice", "alice@example.com")
+	for _, password := range []string{"", "wrong"} {
+		status, res := request(t, app, "POST", "/api/auth/tokens", token,
+			fiber.Map{"name": "Script", "scopes": []string{scopeTodosRead}, "password": password})
+		if status != 400 {
+			t.Errorf("password %q: status %d, want 400: %v", password, status, res)
+		}
+	}
+	if status, res := request(t, app, "GET", "/api/auth/tokens", token, nil); status != 200 || len(items(t, res)) != 0 {
+		t.Errorf("tokens after refusals: %d %v", status, res)
+	}
+
+	createAPIToken(t, app, token, scopeTodosRead)
+	mail.waitFor(t, "alice@example.com", "A personal access token was created")
+}
+
+func TestGetTodosRefusesBadTokens(t *testing.T) {
+	app, _ := newTestApp(t)
+	token, alice := register(t, app, "Alice", "alice@example.com")
+	seedTodo(t, "shared", "", 1)
+	seedTodo(t, "alice's", alice["_id"].(string), 2)
+
+	userID, _ := primitive.ObjectIDFromHex(alice["_id"].(string))
+	expired := apiTokenPrefix + "expired"
+	past := time.Now().UTC().Add(-time.Hour)
+	if err := store.CreateAPIToken(context.Background(), &APIToken{
+		UserID: userID, Name: "Old", Scopes: []string{scopeTodosRead}, TokenHash: hashSecretToken(expired),
+		Hint: expired[:len(apiTokenPrefix)+4], CreatedAt: past.AddDate(0, 0, -1), ExpiresAt: past,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name, token string
+		want        int
+	}{
+		{"invalid JWT", "not-a-jwt", 401},
+		{"unknown API token", apiTokenPrefix + "unknown", 401},
+		{"expired API token", expired, 401},
+		{"write-only API token", createAPIToken(t, app, token, scopeTodosWrite), 403},
+	} {
+		if status, res := request(t, app, "GET", "/api/todos", c.token, nil); status != c.want {
+			t.Errorf("%s: status %d, want %d: %v", c.name, status, c.want, res)
+		}
+	}
+
+	status, res := request(t, app, "GET", "/api/todos", createAPIToken(t, app, token, scopeTodosRead), nil)
+	if got := bodies(t, res); status != 200 || !equalStrings(got, []string{"alice's", "shared"}) {
+		t.Errorf("read token: %d %v", status, got)
+	}
+}
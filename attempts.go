@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// attemptLimit slows down failed logins counted under one key. The first
+// free failures cost nothing; after that each failure doubles the wait,
+// starting at loginBackoffBase and capped at loginBackoffMax, and
+// lockoutAfter failures lock the key for loginLockoutDuration.
+type attemptLimit struct {
+	free         int
+	lockoutAfter int
+}
+
+const (
+	loginBackoffBase = time.Second
+	loginBackoffMax  = time.Minute
+)
+
+// An IP address may be shared by many users, so it gets more room than an
+// account.
+var (
+	accountAttemptLimit = attemptLimit{free: 3, lockoutAfter: envInt("LOGIN_MAX_FAILURES", 10)}
+	ipAttemptLimit      = attemptLimit{free: 10, lockoutAfter: envInt("LOGIN_MAX_FAILURES_PER_IP", 50)}
+)
+
+func envInt(key string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
+func loginLockoutDuration() time.Duration {
+	return envDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+}
+
+// loginAttemptWindow is how long failures are remembered after the last
+// one. It is never shorter than a lockout.
+func loginAttemptWindow() time.Duration {
+	window := envDuration("LOGIN_ATTEMPT_WINDOW", time.Hour)
+	if lockout := loginLockoutDuration(); window < lockout {
+		window = lockout
+	}
+	return window
+}
+
+type loginAttemptKey struct {
+	key   string
+	limit attemptLimit
+}
+
+// loginAttemptKeys names the counters a login for email from ip is checked
+// against. Emails without an account are counted too, so a lockout does not
+// tell whether an account exists.
+func loginAttemptKeys(email, ip string) []loginAttemptKey {
+	return []loginAttemptKey{
+		{key: "account:" + email, limit: accountAttemptLimit},
+		{key: "ip:" + ip, limit: ipAttemptLimit},
+	}
+}
+
+// locked reports whether a has reached the lockout.
+func (l attemptLimit) locked(a RateLimit) bool {
+	return a.Hits >= l.lockoutAfter
+}
+
+// wait returns how long after now the next attempt under a is allowed.
+func (l attemptLimit) wait(a RateLimit, now time.Time) time.Duration {
+	var delay time.Duration
+	switch {
+	case !a.ExpiresAt.After(now):
+		return 0
+	case l.locked(a):
+		delay = loginLockoutDuration()
+	case a.Hits > l.free:
+		delay = loginBackoffMax
+		if n := a.Hits - l.free - 1; n < 16 {
+			delay = min(loginBackoffBase<<n, loginBackoffMax)
+		}
+	default:
+		return 0
+	}
+	return a.LastHitAt.Add(delay).Sub(now)
+}
+
+// loginAttemptWait returns how long the caller has to wait before trying to
+// log in again.
+func loginAttemptWait(ctx context.Context, keys []loginAttemptKey, now time.Time) (time.Duration, error) {
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k.key
+	}
+	found, err := store.GetRateLimits(ctx, names)
+	if err != nil {
+		return 0, err
+	}
+	var wait time.Duration
+	for _, a := range found {
+		for _, k := range keys {
+			if k.key == a.Key {
+				wait = max(wait, k.limit.wait(a, now))
+			}
+		}
+	}
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed login under every key and writes an
+// audit entry for each key it locks. A key whose lockout is over starts
+// again from zero, so that a single failure does not lock it right away.
+// user is nil when no account has the email.
+func recordLoginFailure(c *fiber.Ctx, keys []loginAttemptKey, user *User, now time.Time) error {
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k.key
+	}
+	found, err := store.GetRateLimits(c.Context(), names)
+	if err != nil {
+		return err
+	}
+	for _, a := range found {
+		for _, k := range keys {
+			if k.key != a.Key || !k.limit.locked(a) || k.limit.wait(a, now) > 0 {
+				continue
+			}
+			if err := store.ResetRateLimit(c.Context(), a.Key); err != nil {
+				return err
+			}
+		}
+	}
+	for i, k := range keys {
+		a, err := store.HitRateLimit(c.Context(), k.key, now, loginAttemptWindow())
+		if err != nil {
+			return err
+		}
+		if a.Hits != k.limit.lockoutAfter {
+			continue
+		}
+		entry := AuditEntry{
+			Action:    "login_lockout",
+			Subject:   k.key,
+			IP:        c.IP(),
+			Details:   fmt.Sprintf("%d failed logins, locked for %s", a.Hits, loginLockoutDuration()),
+			CreatedAt: now,
+		}
+		if i == 0 && user != nil {
+			entry.UserID = &user.ID
+		}
+		recordAudit(c.Context(), entry)
+	}
+	return nil
+}
+
+func tooManyLoginAttempts(c *fiber.Ctx, wait time.Duration) error {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(429).JSON(fiber.Map{"error": "Too many login attempts", "retryAfter": seconds})
+}
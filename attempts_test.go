@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// seedLoginFailures records n failed logins for the account, the last one
+// ago before now.
+func seedLoginFailures(t *testing.T, email string, n int, ago time.Duration) {
+	t.Helper()
+	at := time.Now().UTC().Add(-ago)
+	for i := 0; i < n; i++ {
+		if _, err := store.HitRateLimit(context.Background(), "account:"+email, at, loginAttemptWindow()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func auditCount(t *testing.T, action string) int {
+	t.Helper()
+	entries, err := store.ListAuditEntries(context.Background(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, e := range entries {
+		if e.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+func login(t *testing.T, app *fiber.App, email, password string) (int, map[string]interface{}) {
+	t.Helper()
+	return request(t, app, "POST", "/api/auth/login", "", fiber.Map{"email": email, "password": password})
+}
+
+func TestLoginLocksAccountAfterTooManyFailures(t *testing.T) {
+	app, _ := newTestApp(t)
+	register(t, app, "Alice", "alice@example.com")
+	seedLoginFailures(t, "alice@example.com", accountAttemptLimit.lockoutAfter-1, time.Hour/2)
+
+	if status, res := login(t, app, "alice@example.com", "wrong"); status != 401 {
+		t.Fatalf("failure that locks: %d %v", status, res)
+	}
+	if n := auditCount(t, "login_lockout"); n != 1 {
+		t.Errorf("%d lockout audit entries, want 1", n)
+	}
+	status, res := login(t, app, "alice@example.com", "secret1")
+	if status != 429 || res["retryAfter"] == nil {
+		t.Errorf("right password while locked: %d %v", status, res)
+	}
+}
+
+func TestLoginLockoutEndsWithAFreshCount(t *testing.T) {
+	app, _ := newTestApp(t)
+	register(t, app, "Alice", "alice@example.com")
+	seedLoginFailures(t, "alice@example.com", accountAttemptLimit.lockoutAfter, loginLockoutDuration()+time.Minute)
+
+	if status, res := login(t, app, "alice@example.com", "wrong"); status != 401 {
+		t.Fatalf("failure after the lockout: %d %v", status, res)
+	}
+	if n := auditCount(t, "login_lockout"); n != 0 {
+		t.Errorf("one failure after a lockout wrote %d lockout audit entries", n)
+	}
+	found, err := store.GetRateLimits(context.Background(), []string{"account:alice@example.com"})
+	if err != nil || len(found) != 1 || found[0].Hits != 1 {
+		t.Errorf("failures after the lockout: %+v, %v", found, err)
+	}
+	if status, res := login(t, app, "alice@example.com", "secret1"); status != 200 {
+		t.Errorf("right password after the lockout: %d %v", status, res)
+	}
+}
+
+func TestLoginBacksOffAfterFreeFailures(t *testing.T) {
+	app, _ := newTestApp(t)
+	register(t, app, "Alice", "alice@example.com")
+	for i := 0; i <= accountAttemptLimit.free; i++ {
+		if status, res := login(t, app, "alice@example.com", "wrong"); status != 401 {
+			t.Fatalf("failure %d: %d %v", i+1, status, res)
+		}
+	}
+	if status, res := login(t, app, "alice@example.com", "secret1"); status != 429 {
+		t.Errorf("right password during the backoff: %d %v", status, res)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// recordAudit saves entry. A failure is only logged, since the action that
+// is audited has already happened.
+func recordAudit(ctx context.Context, entry AuditEntry) {
+	if entry.Details != "" {
+		log.Printf("audit: %s %s from %s: %s", entry.Action, entry.Subject, entry.IP, entry.Details)
+	} else {
+		log.Printf("audit: %s %s from %s", entry.Action, entry.Subject, entry.IP)
+	}
+	if err := store.CreateAuditEntry(ctx, &entry); err != nil {
+		log.Printf("Error saving audit entry %s %s: %v", entry.Action, entry.Subject, err)
+	}
+}
+
+// listAuditHandler returns the newest audit entries, 100 unless ?limit says
+// otherwise.
+func listAuditHandler(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 100)
+	if limit < 1 || limit > 1000 {
+		return c.Status(400).JSON(fiber.Map{"error": "Limit must be between 1 and 1000"})
+	}
+	entries, err := store.ListAuditEntries(c.Context(), limit)
+	if err != nil {
+		return err
+	}
+	return c.JSON(entries)
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"regexp"
 	"strings"
 	"time"
@@ -10,58 +9,46 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type AuthClaims struct {
 	UserID string `json:"userId"`
+	// SessionID is the refresh token family the token was issued with.
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func getJWTSecret() []byte {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "dev-secret-change-me"
-	}
-	return []byte(secret)
+func accessTokenTTL() time.Duration {
+	return envDuration("JWT_EXPIRES_IN", 15*time.Minute)
 }
 
-func generateToken(userID string) (string, error) {
-	ttlStr := os.Getenv("JWT_EXPIRES_IN") // e.g., 24h
-	var ttl time.Duration
-	if ttlStr == "" {
-		ttl = 24 * time.Hour
-	} else {
-		if d, err := time.ParseDuration(ttlStr); err == nil {
-			ttl = d
-		} else {
-			ttl = 24 * time.Hour
-		}
-	}
+// generateToken signs a short-lived access token; clients renew it with a
+// refresh token (see refreshHandler).
+func generateToken(userID, sessionID string) (string, error) {
 	now := time.Now()
 	claims := &AuthClaims{
-		UserID: userID,
+		UserID:    userID,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        primitive.NewObjectID().Hex(),
 			Issuer:    "project-go",
 			Subject:   userID,
 			IssuedAt:  jwt.NewNumericDate(now),
-			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL())),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(getJWTSecret())
+	return signJWT(claims, now)
 }
 
 func parseToken(tokenStr string) (*AuthClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &AuthClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return getJWTSecret(), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenStr, &AuthClaims{}, jwtKeyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}))
 	if err != nil {
 		return nil, err
 	}
-	if claims, ok := token.Claims.(*AuthClaims); ok && token.Valid {
+	// Sign-in links are signed with the same keys but carry an audience
+	if claims, ok := token.Claims.(*AuthClaims); ok && token.Valid && len(claims.Audience) == 0 {
 		return claims, nil
 	}
 	return nil, fmt.Errorf("invalid token")
@@ -76,14 +63,44 @@ func authMiddleware(c *fiber.Ctx) error {
 	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
 		return c.Status(401).JSON(fiber.Map{"error": "Invalid Authorization header"})
 	}
+	if strings.HasPrefix(parts[1], apiTokenPrefix) {
+		return apiTokenAuth(c, parts[1])
+	}
 	claims, err := parseToken(parts[1])
 	if err != nil {
 		return c.Status(401).JSON(fiber.Map{"error": "Invalid token"})
 	}
+	revoked, err := isTokenRevoked(c.Context(), claims)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return c.Status(401).JSON(fiber.Map{"error": "Token revoked"})
+	}
 	c.Locals("userId", claims.UserID)
+	c.Locals("claims", claims)
 	return c.Next()
 }
 
+// optionalAuth lets requests without an Authorization header through
+// anonymously, and authenticates the others like authMiddleware. A token
+// that is sent must be valid: an invalid, expired or revoked one, or an API
+// token the route refuses, fails the request rather than making it
+// anonymous.
+func optionalAuth(c *fiber.Ctx) error {
+	if c.Get("Authorization") == "" {
+		return c.Next()
+	}
+	return authMiddleware(c)
+}
+
+// sessionUserID returns the id of the signed-in user set by authMiddleware.
+func sessionUserID(c *fiber.Ctx) (primitive.ObjectID, bool) {
+	uid, _ := c.Locals("userId").(string)
+	oid, err := primitive.ObjectIDFromHex(uid)
+	return oid, err == nil
+}
+
 var emailRegex = regexp.MustCompile(`^[\w\.-]+@[\w\.-]+\.[a-zA-Z]{2,}$`)
 
 func validateRegister(name, email, password string) string {
@@ -99,6 +116,37 @@ func validateRegister(name, email, password string) string {
 	return ""
 }
 
+// userJSON is a user as the auth routes return it.
+func userJSON(user *User) fiber.Map {
+	return fiber.Map{
+		"_id":           user.ID.Hex(),
+		"name":          user.Name,
+		"username":      user.Username,
+		"avatar":        user.Avatar,
+		"email":         user.Email,
+		"emailVerified": user.EmailVerifiedAt != nil,
+		"twoFactor":     user.TOTPEnabledAt != nil,
+		"hasPassword":   user.PasswordHash != "",
+		"createdAt":     user.CreatedAt,
+		"updatedAt":     user.UpdatedAt,
+	}
+}
+
+// newUser is a user as registration creates it; the username defaults to
+// the name. Users who sign up through an OIDC provider have no password hash.
+func newUser(name, username, email, passwordHash string, now time.Time) *User {
+	user := &User{
+		Name:         name,
+		Username:     strings.TrimSpace(username),
+		Email:        strings.ToLower(email),
+		PasswordHash: passwordHash,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if user.Username == "" { user.Username = strings.TrimSpace(name) }
+	return user
+}
+
 func registerHandler(c *fiber.Ctx) error {
 	var payload struct {
 		Name     string `json:"name"`
@@ -113,54 +161,32 @@ func registerHandler(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": msg})
 	}
 	// Check exists
-	count, err := usersCollection.CountDocuments(c.Context(), bson.M{"email": strings.ToLower(payload.Email)})
-	if err != nil {
-		return err
-	}
-	if count > 0 {
+	if _, err := store.GetUserByEmail(c.Context(), strings.ToLower(payload.Email)); err == nil {
 		return c.Status(409).JSON(fiber.Map{"error": "Email already registered"})
+	} else if err != ErrNotFound {
+		return err
 	}
 	hash, err := bcrypt.GenerateFromPassword([]byte(payload.Password), bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
 	now := time.Now().UTC()
-	user := &User{
-		Name:         payload.Name,
-		Username:     strings.TrimSpace(payload.Username),
-		Email:        strings.ToLower(payload.Email),
-		PasswordHash: string(hash),
-		CreatedAt:    now,
-		UpdatedAt:    now,
-	}
-	if user.Username == "" { user.Username = strings.TrimSpace(payload.Name) }
-	res, err := usersCollection.InsertOne(c.Context(), user)
-	if err != nil {
-		if writeErr, ok := err.(mongo.WriteException); ok {
-			for _, we := range writeErr.WriteErrors {
-				if we.Code == 11000 {
-					return c.Status(409).JSON(fiber.Map{"error": "Email already registered"})
-				}
-			}
+	user := newUser(payload.Name, payload.Username, payload.Email, string(hash), now)
+	if err := store.CreateUser(c.Context(), user); err != nil {
+		if err == ErrDuplicateEmail {
+			return c.Status(409).JSON(fiber.Map{"error": "Email already registered"})
 		}
 		return err
 	}
-	user.ID = res.InsertedID.(primitive.ObjectID)
-	token, err := generateToken(user.ID.Hex())
+	startVerification(c.Context(), user)
+	token, refreshToken, err := startSession(c, user.ID)
 	if err != nil {
 		return err
 	}
 	return c.Status(201).JSON(fiber.Map{
-		"token": token,
-		"user": fiber.Map{
-			"_id":       user.ID.Hex(),
-			"name":      user.Name,
-			"username":  user.Username,
-			"avatar":    user.Avatar,
-			"email":     user.Email,
-			"createdAt": user.CreatedAt,
-			"updatedAt": user.UpdatedAt,
-		},
+		"token":        token,
+		"refreshToken": refreshToken,
+		"user": userJSON(user),
 	})
 }
 
@@ -172,31 +198,43 @@ func loginHandler(c *fiber.Ctx) error {
 	if err := c.BodyParser(&payload); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
 	}
-	var user User
-	if err := usersCollection.FindOne(c.Context(), bson.M{"email": strings.ToLower(payload.Email)}).Decode(&user); err != nil {
-		if err == mongo.ErrNoDocuments {
-			return c.Status(401).JSON(fiber.Map{"error": "Invalid credentials"})
-		}
+	email := strings.ToLower(payload.Email)
+	now := time.Now().UTC()
+	keys := loginAttemptKeys(email, c.IP())
+	wait, err := loginAttemptWait(c.Context(), keys, now)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		return tooManyLoginAttempts(c, wait)
+	}
+	user, err := store.GetUserByEmail(c.Context(), email)
+	if err != nil && err != ErrNotFound {
 		return err
 	}
-	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(payload.Password)) != nil {
+	if user == nil || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(payload.Password)) != nil {
+		if err := recordLoginFailure(c, keys, user, now); err != nil {
+			return err
+		}
 		return c.Status(401).JSON(fiber.Map{"error": "Invalid credentials"})
 	}
-	token, err := generateToken(user.ID.Hex())
+	// The failures are only cleared once the second factor is right too
+	if user.TOTPEnabledAt != nil {
+		return startLoginChallenge(c, user, now)
+	}
+	// Only the account is cleared: one valid login must not reset an IP
+	// that is guessing passwords for other accounts
+	if err := store.ResetRateLimit(c.Context(), keys[0].key); err != nil {
+		return err
+	}
+	token, refreshToken, err := startSession(c, user.ID)
 	if err != nil {
 		return err
 	}
 	return c.JSON(fiber.Map{
-		"token": token,
-		"user": fiber.Map{
-			"_id":       user.ID.Hex(),
-			"name":      user.Name,
-			"username":  user.Username,
-			"avatar":    user.Avatar,
-			"email":     user.Email,
-			"createdAt": user.CreatedAt,
-			"updatedAt": user.UpdatedAt,
-		},
+		"token":        token,
+		"refreshToken": refreshToken,
+		"user": userJSON(user),
 	})
 }
 
@@ -206,22 +244,14 @@ func meHandler(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
 	}
-	var user User
-	if err := usersCollection.FindOne(c.Context(), bson.M{"_id": oid}).Decode(&user); err != nil {
-		if err == mongo.ErrNoDocuments {
+	user, err := store.GetUserByID(c.Context(), oid)
+	if err != nil {
+		if err == ErrNotFound {
 			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
 		}
 		return err
 	}
-	return c.JSON(fiber.Map{
-		"_id":       user.ID.Hex(),
-		"name":      user.Name,
-		"username":  user.Username,
-		"avatar":    user.Avatar,
-		"email":     user.Email,
-		"createdAt": user.CreatedAt,
-		"updatedAt": user.UpdatedAt,
-	})
+	return c.JSON(userJSON(user))
 }
 
 // update current user profile
@@ -250,31 +280,19 @@ func updateMeHandler(c *fiber.Ctx) error {
 	} else {
 		fmt.Println("updateMeHandler: avatar not provided in payload")
 	}
-	toSet := bson.M{"updatedAt": time.Now().UTC()}
-	if strings.TrimSpace(payload.Name) != "" { toSet["name"] = strings.TrimSpace(payload.Name) }
-	if strings.TrimSpace(payload.Username) != "" { toSet["username"] = strings.TrimSpace(payload.Username) }
+	update := UserUpdate{UpdatedAt: time.Now().UTC()}
+	if name := strings.TrimSpace(payload.Name); name != "" { update.Name = &name }
+	if username := strings.TrimSpace(payload.Username); username != "" { update.Username = &username }
 	if payload.Avatar != nil {
 		// allow setting avatar to null/empty to remove
-		if strings.TrimSpace(*payload.Avatar) == "" {
-			toSet["avatar"] = nil
-		} else {
-			toSet["avatar"] = *payload.Avatar
-		}
+		avatar := *payload.Avatar
+		if strings.TrimSpace(avatar) == "" { avatar = "" }
+		update.Avatar = &avatar
 	}
-	if len(toSet) == 1 { // only updatedAt
+	if update.Name == nil && update.Username == nil && update.Avatar == nil { // only updatedAt
 		return c.Status(400).JSON(fiber.Map{"error":"No changes"})
 	}
-	res := usersCollection.FindOneAndUpdate(c.Context(), bson.M{"_id": oid}, bson.M{"$set": toSet},)
-	if res.Err() != nil { if res.Err() == mongo.ErrNoDocuments { return c.Status(404).JSON(fiber.Map{"error":"User not found"}) } ; return res.Err() }
-	var user User
-	if err := usersCollection.FindOne(c.Context(), bson.M{"_id": oid}).Decode(&user); err != nil { return err }
-	return c.JSON(fiber.Map{
-		"_id": user.ID.Hex(),
-		"name": user.Name,
-		"username": user.Username,
-		"avatar": user.Avatar,
-		"email": user.Email,
-		"createdAt": user.CreatedAt,
-		"updatedAt": user.UpdatedAt,
-	})
+	user, err := store.UpdateUser(c.Context(), oid, update)
+	if err != nil { if err == ErrNotFound { return c.Status(404).JSON(fiber.Map{"error":"User not found"}) } ; return err }
+	return c.JSON(userJSON(user))
 }
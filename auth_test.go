@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRegisterCreatesUserAndSignsIn(t *testing.T) {
+	app, mail := newTestApp(t)
+	status, res := request(t, app, "POST", "/api/auth/register", "",
+		fiber.Map{"name": "Alice", "email": "Alice@Example.com", "password": "secret1"})
+	if status != 201 {
+		t.Fatalf("status %d: %v", status, res)
+	}
+	if res["token"] == nil || res["refreshToken"] == nil {
+		t.Errorf("missing tokens: %v", res)
+	}
+	user := res["user"].(map[string]interface{})
+	if user["email"] != "alice@example.com" || user["username"] != "Alice" || user["emailVerified"] != false {
+		t.Errorf("unexpected user %v", user)
+	}
+	if _, ok := user["passwordHash"]; ok {
+		t.Error("response contains the password hash")
+	}
+
+	stored, err := store.GetUserByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.PasswordHash == "" || stored.PasswordHash == "secret1" {
+		t.Errorf("password stored as %q", stored.PasswordHash)
+	}
+	status, me := request(t, app, "GET", "/api/auth/me", res["token"].(string), nil)
+	if status != 200 || me["_id"] != user["_id"] {
+		t.Errorf("me: %d %v", status, me)
+	}
+	mail.waitFor(t, "alice@example.com", "Verify")
+}
+
+func TestRegisterRefusesTakenEmail(t *testing.T) {
+	app, _ := newTestApp(t)
+	register(t, app, "Alice", "alice@example.com")
+	status, res := request(t, app, "POST", "/api/auth/register", "",
+		fiber.Map{"name": "Other", "email": "ALICE@example.com", "password": "secret2"})
+	if status != 409 {
+		t.Errorf("status %d, want 409: %v", status, res)
+	}
+}
+
+func TestRegisterValidates(t *testing.T) {
+	app, _ := newTestApp(t)
+	for _, body := range []fiber.Map{
+		{"name": "A", "email": "a@example.com", "password": "secret1"},
+		{"name": "Alice", "email": "not an email", "password": "secret1"},
+		{"name": "Alice", "email": "a@example.com", "password": "short"},
+	} {
+		if status, res := request(t, app, "POST", "/api/auth/register", "", body); status != 400 {
+			t.Errorf("%v: status %d, want 400: %v", body, status, res)
+		}
+	}
+	if _, err := store.GetUserByEmail(context.Background(), "a@example.com"); err != ErrNotFound {
+		t.Errorf("invalid registration stored a user: %v", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesTheFamily(t *testing.T) {
+	app, _ := newTestApp(t)
+	status, res := request(t, app, "POST", "/api/auth/register", "",
+		fiber.Map{"name": "Alice", "email": "alice@example.com", "password": "secret1"})
+	if status != 201 {
+		t.Fatalf("register: %d %v", status, res)
+	}
+	first := res["refreshToken"].(string)
+
+	status, res = request(t, app, "POST", "/api/auth/refresh", "", fiber.Map{"refreshToken": first})
+	if status != 200 || res["token"] == nil || res["refreshToken"] == nil || res["refreshToken"] == first {
+		t.Fatalf("refresh: %d %v", status, res)
+	}
+	second := res["refreshToken"].(string)
+
+	// Replaying the used token gives the copy away, so the newer one goes too
+	if status, res := request(t, app, "POST", "/api/auth/refresh", "", fiber.Map{"refreshToken": first}); status != 401 || res["error"] != "Refresh token reused" {
+		t.Errorf("replayed token: %d %v", status, res)
+	}
+	if status, res := request(t, app, "POST", "/api/auth/refresh", "", fiber.Map{"refreshToken": second}); status != 401 {
+		t.Errorf("newer token after reuse: %d %v", status, res)
+	}
+	if status, res := request(t, app, "POST", "/api/auth/refresh", "", fiber.Map{"refreshToken": "nonsense"}); status != 401 {
+		t.Errorf("unknown token: %d %v", status, res)
+	}
+}
+
+func TestLoginRightAfterLogoutAllWorks(t *testing.T) {
+	app, _ := newTestApp(t)
+	old, _ := register(t, app, "Alice", "alice@example.com")
+	if status, res := request(t, app, "POST", "/api/auth/logout-all", old, nil); status != 200 {
+		t.Fatalf("logout-all: %d %v", status, res)
+	}
+	// Within the same second as the logout, so iat cannot tell them apart
+	status, res := login(t, app, "alice@example.com", "secret1")
+	if status != 200 {
+		t.Fatalf("login: %d %v", status, res)
+	}
+	if status, res := request(t, app, "GET", "/api/auth/me", res["token"].(string), nil); status != 200 {
+		t.Errorf("new token: %d %v", status, res)
+	}
+	if status, res := request(t, app, "GET", "/api/auth/me", old, nil); status != 401 {
+		t.Errorf("old token: %d %v", status, res)
+	}
+}
@@ -0,0 +1,114 @@
+import React, { useState } from "react";
+import { BASE_URL } from "../App";
+import { useAuth } from "../hooks/useAuth";
+import CurrentPasswordInput from "./CurrentPasswordInput";
+
+// AccountSecurityCard changes the account's email or password. Both need the
+// current password, or an emailed code for accounts without one.
+const AccountSecurityCard: React.FC = () => {
+  const { user, token } = useAuth();
+  const [email, setEmail] = useState("");
+  const [emailPassword, setEmailPassword] = useState("");
+  const [emailMsg, setEmailMsg] = useState<{ ok: boolean; text: string } | null>(null);
+  const [currentPassword, setCurrentPassword] = useState("");
+  const [newPassword, setNewPassword] = useState("");
+  const [passwordMsg, setPasswordMsg] = useState<{ ok: boolean; text: string } | null>(null);
+  const [saving, setSaving] = useState(false);
+
+  const post = async (path: string, body: unknown) => {
+    const res = await fetch(`${BASE_URL}${path}`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json", Authorization: `Bearer ${token}` },
+      body: JSON.stringify(body),
+    });
+    const data = await res.json().catch(() => null);
+    if (!res.ok) throw new Error(data?.error || "Request failed");
+    return data;
+  };
+
+  const changeEmail = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setSaving(true);
+    setEmailMsg(null);
+    try {
+      const updated = await post("/auth/change-email", { email, password: emailPassword });
+      const storedRaw = localStorage.getItem("auth_user");
+      const stored = storedRaw ? JSON.parse(storedRaw) : {};
+      localStorage.setItem("auth_user", JSON.stringify({ ...stored, ...updated }));
+      window.dispatchEvent(new CustomEvent("auth-updated"));
+      setEmail("");
+      setEmailPassword("");
+      setEmailMsg({ ok: true, text: `Email changed. We sent a verification link to ${updated.email}.` });
+    } catch (e: any) {
+      setEmailMsg({ ok: false, text: e?.message || "Could not change the email" });
+    } finally {
+      setSaving(false);
+    }
+  };
+
+  const changePassword = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (newPassword.length < 6) {
+      setPasswordMsg({ ok: false, text: "Password must be at least 6 characters" });
+      return;
+    }
+    setSaving(true);
+    setPasswordMsg(null);
+    try {
+      await post("/auth/change-password", { currentPassword, newPassword });
+      if (user?.hasPassword === false) {
+        const storedRaw = localStorage.getItem("auth_user");
+        const stored = storedRaw ? JSON.parse(storedRaw) : {};
+        localStorage.setItem("auth_user", JSON.stringify({ ...stored, hasPassword: true }));
+        window.dispatchEvent(new CustomEvent("auth-updated"));
+      }
+      setCurrentPassword("");
+      setNewPassword("");
+      setPasswordMsg({ ok: true, text: "Password saved. Your other devices were signed out and your access tokens deleted." });
+    } catch (e: any) {
+      setPasswordMsg({ ok: false, text: e?.message || "Could not change the password" });
+    } finally {
+      setSaving(false);
+    }
+  };
+
+  return (
+    <div className="max-w-xl mx-auto mt-6 bg-base-100 border border-base-300 rounded-xl p-6 space-y-6">
+      <form onSubmit={changeEmail} className="space-y-2">
+        <h3 className="text-xl font-bold">Email</h3>
+        <p className="text-sm opacity-70">Currently {user?.email}</p>
+        <input
+          className="input input-bordered w-full"
+          placeholder="New email"
+          type="email"
+          value={email}
+          onChange={(e) => setEmail(e.target.value)}
+          required
+        />
+        <CurrentPasswordInput value={emailPassword} onChange={setEmailPassword} />
+        <button className="btn btn-primary btn-sm" disabled={saving}>Change email</button>
+        {emailMsg && <div className={`text-sm ${emailMsg.ok ? "text-success" : "text-error"}`}>{emailMsg.text}</div>}
+      </form>
+      <form onSubmit={changePassword} className="space-y-2">
+        <h3 className="text-xl font-bold">Password</h3>
+        {user?.hasPassword === false && (
+          <p className="text-sm opacity-70">You sign in through a linked account. Set a password to sign in with it too.</p>
+        )}
+        <CurrentPasswordInput value={currentPassword} onChange={setCurrentPassword} />
+        <input
+          className="input input-bordered w-full"
+          placeholder="New password"
+          type="password"
+          value={newPassword}
+          onChange={(e) => setNewPassword(e.target.value)}
+          required
+          minLength={6}
+        />
+        <button className="btn btn-primary btn-sm" disabled={saving}>Change password</button>
+        {passwordMsg && <div className={`text-sm ${passwordMsg.ok ? "text-success" : "text-error"}`}>{passwordMsg.text}</div>}
+      </form>
+    </div>
+  );
+};
+
+export default AccountSecurityCard;
@@ -0,0 +1,156 @@
+import React, { useEffect, useState } from "react";
+import { BASE_URL } from "../App";
+import { useAuth } from "../hooks/useAuth";
+import type { ApiToken } from "../types/Auth";
+import CurrentPasswordInput from "./CurrentPasswordInput";
+
+const SCOPES = [
+  { id: "todos:read", label: "Read todos" },
+  { id: "todos:write", label: "Create and change todos" },
+];
+
+// ApiTokensCard makes personal access tokens for scripts and deletes them.
+// A new token is shown once, right after it is made. Making one takes the
+// password.
+const ApiTokensCard: React.FC = () => {
+  const { token } = useAuth();
+  const [tokens, setTokens] = useState<ApiToken[]>([]);
+  const [name, setName] = useState("");
+  const [scopes, setScopes] = useState<string[]>(["todos:read"]);
+  const [days, setDays] = useState(30);
+  const [password, setPassword] = useState("");
+  const [created, setCreated] = useState<string | null>(null);
+  const [error, setError] = useState<string | null>(null);
+  const [saving, setSaving] = useState(false);
+
+  useEffect(() => {
+    if (!token) return;
+    (async () => {
+      try {
+        const res = await fetch(`${BASE_URL}/auth/tokens`, {
+          headers: { Authorization: `Bearer ${token}` },
+        });
+        const data = await res.json();
+        if (!res.ok) throw new Error(data?.error || "Failed to load tokens");
+        setTokens(data.items || []);
+        setError(null);
+      } catch (e: any) {
+        setError(e?.message || "Failed to load tokens");
+      }
+    })();
+  }, [token]);
+
+  const toggleScope = (scope: string) =>
+    setScopes((prev) => (prev.includes(scope) ? prev.filter((s) => s !== scope) : [...prev, scope]));
+
+  const create = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setSaving(true);
+    setError(null);
+    try {
+      const res = await fetch(`${BASE_URL}/auth/tokens`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json", Authorization: `Bearer ${token}` },
+        body: JSON.stringify({ name, scopes, expiresInDays: days, password }),
+      });
+      const data = await res.json().catch(() => null);
+      if (!res.ok) throw new Error(data?.error || "Failed to create the token");
+      setTokens((prev) => [data.apiToken, ...prev]);
+      setCreated(data.token);
+      setName("");
+      setPassword("");
+    } catch (e: any) {
+      setError(e?.message || "Failed to create the token");
+    } finally {
+      setSaving(false);
+    }
+  };
+
+  const remove = async (t: ApiToken) => {
+    try {
+      const res = await fetch(`${BASE_URL}/auth/tokens/${t._id}`, {
+        method: "DELETE",
+        headers: { Authorization: `Bearer ${token}` },
+      });
+      if (!res.ok) {
+        const data = await res.json().catch(() => null);
+        throw new Error(data?.error || "Failed to delete the token");
+      }
+      setTokens((prev) => prev.filter((p) => p._id !== t._id));
+    } catch (e: any) {
+      setError(e?.message || "Failed to delete the token");
+    }
+  };
+
+  return (
+    <div className="max-w-xl mx-auto mt-6 bg-base-100 border border-base-300 rounded-xl p-6">
+      <h3 className="text-xl font-bold mb-1">Personal access tokens</h3>
+      <p className="text-sm opacity-70 mb-4">
+        Let scripts and integrations use your todos. Send a token as <code>Authorization: Bearer …</code>.
+      </p>
+      {error && <div className="alert alert-error mb-3">{error}</div>}
+      {created && (
+        <div className="alert alert-warning flex-col items-start mb-3">
+          <span>Copy the new token now. It is not shown again.</span>
+          <code className="font-mono text-sm break-all">{created}</code>
+        </div>
+      )}
+      <ul className="divide-y divide-base-300">
+        {tokens.map((t) => (
+          <li key={t._id} className="py-3 flex items-center justify-between gap-3">
+            <div>
+              <div className="font-medium">
+                {t.name} <span className="font-mono text-xs opacity-70">{t.hint}…</span>
+              </div>
+              <div className="text-xs opacity-70">
+                {t.scopes.join(", ")} · expires {new Date(t.expiresAt).toLocaleDateString()}
+                {t.lastUsedAt ? <> · last used {new Date(t.lastUsedAt).toLocaleString()}</> : " · never used"}
+              </div>
+            </div>
+            <button className="btn btn-sm btn-outline" onClick={() => remove(t)}>
+              Delete
+            </button>
+          </li>
+        ))}
+        {tokens.length === 0 && !error && <li className="py-3 text-sm opacity-70">No tokens yet</li>}
+      </ul>
+      <form onSubmit={create} className="space-y-2 mt-4">
+        <input
+          className="input input-bordered input-sm w-full"
+          placeholder="Name, e.g. Nightly import"
+          value={name}
+          onChange={(e) => setName(e.target.value)}
+          maxLength={100}
+          required
+        />
+        <div className="flex flex-wrap gap-4">
+          {SCOPES.map((s) => (
+            <label key={s.id} className="label cursor-pointer gap-2">
+              <input
+                type="checkbox"
+                className="checkbox checkbox-sm"
+                checked={scopes.includes(s.id)}
+                onChange={() => toggleScope(s.id)}
+              />
+              <span className="label-text">{s.label}</span>
+            </label>
+          ))}
+        </div>
+        <CurrentPasswordInput value={password} onChange={setPassword} className="input input-bordered input-sm w-full" />
+        <div className="flex gap-2">
+          <select className="select select-bordered select-sm" value={days} onChange={(e) => setDays(Number(e.target.value))}>
+            <option value={7}>Expires in 7 days</option>
+            <option value={30}>Expires in 30 days</option>
+            <option value={90}>Expires in 90 days</option>
+            <option value={365}>Expires in a year</option>
+          </select>
+          <button className="btn btn-primary btn-sm" disabled={saving || scopes.length === 0}>
+            {saving ? "Creating..." : "Create token"}
+          </button>
+        </div>
+      </form>
+    </div>
+  );
+};
+
+export default ApiTokensCard;
@@ -1,8 +1,9 @@
-import React, { useState } from "react";
+import React, { useEffect, useState } from "react";
 import { BASE_URL } from "../App";
 import { useAuth } from "../hooks/useAuth";
-import type { AuthResponse } from "../types/Auth";
+import type { AuthResponse, OidcProvider, TwoFactorChallenge } from "../types/Auth";
 import { useNavigate } from "react-router-dom";
+import { getPasskey, passkeysSupported } from "../passkeys";
 
 interface Props {
   onClose: () => void;
@@ -17,6 +18,17 @@ const AuthModal: React.FC<Props> = ({ onClose }) => {
   const [error, setError] = useState<string | null>(null);
   const [emailError, setEmailError] = useState<string | null>(null);
   const [passwordError, setPasswordError] = useState<string | null>(null);
+  const [notice, setNotice] = useState<string | null>(null);
+  const [challenge, setChallenge] = useState<string | null>(null);
+  const [code, setCode] = useState("");
+  const [providers, setProviders] = useState<OidcProvider[]>([]);
+
+  useEffect(() => {
+    fetch(`${BASE_URL}/auth/oidc/providers`)
+      .then((res) => (res.ok ? res.json() : null))
+      .then((data) => setProviders(data?.items || []))
+      .catch(() => setProviders([]));
+  }, []);
 
   const emailRegex = /^[^\s@]+@[^\s@]+\.[^\s@]{2,}$/;
   const validateEmail = (v: string) => {
@@ -70,6 +82,11 @@ const AuthModal: React.FC<Props> = ({ onClose }) => {
             throw new Error(msg2);
           }
           ar = payload2 as AuthResponse;
+        } else if (res.status === 429) {
+          const seconds = Number(res.headers.get("Retry-After")) || (payload as any)?.retryAfter || 60;
+          throw new Error(seconds < 120
+            ? `Too many sign in attempts. Try again in ${seconds} seconds.`
+            : `Too many sign in attempts. Try again in ${Math.ceil(seconds / 60)} minutes.`);
         } else {
           const msg = typeof payload === "string" ? payload : (payload as any)?.error || `Auth API not available at ${url}`;
           throw new Error(msg);
@@ -77,7 +94,11 @@ const AuthModal: React.FC<Props> = ({ onClose }) => {
       } else {
         ar = payload as AuthResponse;
       }
-      login(ar.token, ar.user);
+      if ((ar as unknown as TwoFactorChallenge).twoFactorRequired) {
+        setChallenge((ar as unknown as TwoFactorChallenge).challengeToken);
+        return;
+      }
+      login(ar.token, ar.user, ar.refreshToken);
       onClose();
     } catch (e: any) {
       const raw = e?.message || "Sign in failed";
@@ -98,6 +119,118 @@ const AuthModal: React.FC<Props> = ({ onClose }) => {
     }
   };
 
+  // Second step for accounts with two-factor authentication
+  const verifyCode = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setLoading(true);
+    setError(null);
+    try {
+      const res = await fetch(`${BASE_URL}/auth/2fa/verify`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ challengeToken: challenge, code: code.trim() }),
+      });
+      const data = await res.json().catch(() => null);
+      if (res.status === 401) {
+        // The challenge expired; the password has to be entered again
+        setChallenge(null);
+        setCode("");
+      }
+      if (!res.ok) throw new Error(data?.error || "Sign in failed");
+      const ar = data as AuthResponse;
+      login(ar.token, ar.user, ar.refreshToken);
+      onClose();
+    } catch (e: any) {
+      setError(e?.message || "Sign in failed");
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const passkeyLogin = async () => {
+    setLoading(true);
+    setError(null);
+    try {
+      const begin = await fetch(`${BASE_URL}/auth/passkeys/login/begin`, { method: "POST" });
+      const options = await begin.json();
+      if (!begin.ok) throw new Error(options?.error || "Sign in failed");
+      const credential = await getPasskey(options);
+      const res = await fetch(`${BASE_URL}/auth/passkeys/login/finish`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(credential),
+      });
+      const data = await res.json().catch(() => null);
+      if (!res.ok) throw new Error(data?.error || "Sign in failed");
+      const ar = data as AuthResponse;
+      login(ar.token, ar.user, ar.refreshToken);
+      onClose();
+    } catch (e: any) {
+      // NotAllowedError means the user closed the browser's passkey prompt
+      if (e?.name !== "NotAllowedError") setError(e?.message || "Sign in failed");
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  // Sends the browser to the provider, which comes back to /oidc/callback
+  const providerLogin = async (provider: OidcProvider) => {
+    setLoading(true);
+    setError(null);
+    try {
+      const res = await fetch(`${BASE_URL}/auth/oidc/${provider.id}/begin`, { method: "POST" });
+      const data = await res.json().catch(() => null);
+      if (!res.ok) throw new Error(data?.error || "Sign in failed");
+      sessionStorage.setItem("oidc_state", data.state);
+      window.location.assign(data.url);
+    } catch (e: any) {
+      setError(e?.message || "Sign in failed");
+      setLoading(false);
+    }
+  };
+
+  const forgotPassword = async () => {
+    const eErr = validateEmail(email);
+    setEmailError(eErr);
+    if (eErr) return;
+    setError(null);
+    try {
+      const res = await fetch(`${BASE_URL}/auth/forgot-password`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ email }),
+      });
+      if (!res.ok) {
+        const data = await res.json().catch(() => null);
+        throw new Error(data?.error || "Could not send the reset email");
+      }
+      setNotice("If an account exists for that email, we sent it a link to reset the password.");
+    } catch (e: any) {
+      setError(e?.message || "Could not send the reset email");
+    }
+  };
+
+  const magicLink = async () => {
+    const eErr = validateEmail(email);
+    setEmailError(eErr);
+    if (eErr) return;
+    setError(null);
+    try {
+      const res = await fetch(`${BASE_URL}/auth/magic`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ email }),
+      });
+      if (!res.ok) {
+        const data = await res.json().catch(() => null);
+        throw new Error(data?.error || "Could not send the sign-in link");
+      }
+      setNotice("If an account exists for that email, we sent it a link that signs you in.");
+    } catch (e: any) {
+      setError(e?.message || "Could not send the sign-in link");
+    }
+  };
+
   // Demo login helper previously existed; replaced by static demo credentials text below
 
   return (
@@ -108,6 +241,29 @@ const AuthModal: React.FC<Props> = ({ onClose }) => {
           <button onClick={onClose} className="btn btn-sm">x</button>
         </div>
         {error && <div className="alert alert-error mb-3">{error}</div>}
+        {notice && <div className="alert alert-info mb-3">{notice}</div>}
+        {challenge ? (
+          <form onSubmit={verifyCode} className="space-y-3">
+            <p className="text-sm opacity-80">
+              Enter the 6-digit code from your authenticator app, or one of your recovery codes.
+            </p>
+            <input
+              className="input input-bordered w-full"
+              placeholder="Code"
+              autoComplete="one-time-code"
+              autoFocus
+              value={code}
+              onChange={(e) => { setCode(e.target.value); if (error) setError(null); }}
+              required
+            />
+            <button className="btn btn-primary w-full" disabled={loading}>
+              {loading ? "Verifying..." : "Verify"}
+            </button>
+            <button type="button" className="btn btn-link btn-xs px-0" onClick={() => { setChallenge(null); setCode(""); }}>
+              Back
+            </button>
+          </form>
+        ) : (
         <form onSubmit={submit} className="space-y-3">
           <div>
             <input
@@ -133,11 +289,28 @@ const AuthModal: React.FC<Props> = ({ onClose }) => {
               minLength={6}
             />
             {passwordError && <p className="text-error text-xs mt-1">{passwordError}</p>}
+            <button type="button" className="btn btn-link btn-xs px-0 mt-1" onClick={forgotPassword}>
+              Forgot password?
+            </button>
           </div>
           <button className="btn btn-primary w-full" disabled={loading || !!emailError || !!passwordError}>
             {loading ? "Signing in..." : "Sign in"}
           </button>
+          {passkeysSupported() && (
+            <button type="button" className="btn btn-outline w-full" disabled={loading} onClick={passkeyLogin}>
+              Sign in with a passkey
+            </button>
+          )}
+          {providers.map((p) => (
+            <button key={p.id} type="button" className="btn btn-outline w-full" disabled={loading} onClick={() => providerLogin(p)}>
+              Continue with {p.name}
+            </button>
+          ))}
+          <button type="button" className="btn btn-ghost btn-sm w-full" disabled={loading} onClick={magicLink}>
+            Email me a sign-in link instead
+          </button>
         </form>
+        )}
         <div className="mt-3 text-sm opacity-80">
           Demo user: <span className="font-semibold">cerepe3206@neuraxo.com</span> &nbsp; password: <span className="font-semibold">ppond333</span>
         </div>
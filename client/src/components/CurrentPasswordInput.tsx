@@ -0,0 +1,70 @@
+import React, { useState } from "react";
+import { BASE_URL } from "../App";
+import { useAuth } from "../hooks/useAuth";
+
+interface CurrentPasswordInputProps {
+  value: string;
+  onChange: (value: string) => void;
+  className?: string;
+}
+
+// CurrentPasswordInput asks for the current password. Users without one,
+// who signed up through a provider, get a code by email to type instead.
+const CurrentPasswordInput: React.FC<CurrentPasswordInputProps> = ({ value, onChange, className = "input input-bordered w-full" }) => {
+  const { user, token } = useAuth();
+  const [msg, setMsg] = useState<{ ok: boolean; text: string } | null>(null);
+  const [sending, setSending] = useState(false);
+
+  if (user?.hasPassword !== false) {
+    return (
+      <input
+        className={className}
+        placeholder="Current password"
+        type="password"
+        value={value}
+        onChange={(e) => onChange(e.target.value)}
+        required
+      />
+    );
+  }
+
+  const sendCode = async () => {
+    setSending(true);
+    setMsg(null);
+    try {
+      const res = await fetch(`${BASE_URL}/auth/reauth-code`, {
+        method: "POST",
+        headers: { Authorization: `Bearer ${token}` },
+      });
+      const data = await res.json().catch(() => null);
+      if (!res.ok) throw new Error(data?.error || "Could not send a code");
+      setMsg({ ok: true, text: `We sent a code to ${user.email}.` });
+    } catch (e: any) {
+      setMsg({ ok: false, text: e?.message || "Could not send a code" });
+    } finally {
+      setSending(false);
+    }
+  };
+
+  return (
+    <div className="space-y-1">
+      <div className="flex gap-2">
+        <input
+          className={className}
+          placeholder="Code from your email"
+          autoComplete="one-time-code"
+          inputMode="numeric"
+          value={value}
+          onChange={(e) => onChange(e.target.value)}
+          required
+        />
+        <button type="button" className="btn btn-sm" disabled={sending} onClick={sendCode}>
+          Email me a code
+        </button>
+      </div>
+      {msg && <div className={`text-xs ${msg.ok ? "text-success" : "text-error"}`}>{msg.text}</div>}
+    </div>
+  );
+};
+
+export default CurrentPasswordInput;
@@ -0,0 +1,49 @@
+import React, { useState } from "react";
+import { BASE_URL } from "../App";
+import { useAuth } from "../hooks/useAuth";
+
+// EmailVerificationBanner reminds users with an unverified email to click the
+// link we sent, and sends another one on request.
+const EmailVerificationBanner: React.FC = () => {
+  const { user, token } = useAuth();
+  const [sending, setSending] = useState(false);
+  const [msg, setMsg] = useState<string | null>(null);
+
+  const resend = async () => {
+    try {
+      setSending(true);
+      setMsg(null);
+      const res = await fetch(`${BASE_URL}/auth/resend-verification`, {
+        method: "POST",
+        headers: { Authorization: `Bearer ${token}` },
+      });
+      const data = await res.json().catch(() => null);
+      if (res.status === 429) {
+        const minutes = Math.ceil((data?.retryAfter || 60) / 60);
+        throw new Error(`Please wait ${minutes} minute${minutes === 1 ? "" : "s"} before asking for another email`);
+      }
+      if (!res.ok) throw new Error(data?.error || "Could not send the email");
+      setMsg("Sent. Check your inbox.");
+    } catch (e: any) {
+      setMsg(e?.message || "Could not send the email");
+    } finally {
+      setSending(false);
+    }
+  };
+
+  return (
+    <div className="max-w-xl mx-auto mb-4 alert alert-warning flex flex-col items-start gap-2">
+      <span>
+        Please verify <span className="font-semibold">{user?.email}</span> using the link we emailed you.
+      </span>
+      <div className="flex items-center gap-3">
+        <button className="btn btn-sm" onClick={resend} disabled={sending}>
+          {sending ? "Sending..." : "Resend email"}
+        </button>
+        {msg && <span className="text-sm">{msg}</span>}
+      </div>
+    </div>
+  );
+};
+
+export default EmailVerificationBanner;
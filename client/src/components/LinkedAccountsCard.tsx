@@ -0,0 +1,82 @@
+import React, { useEffect, useState } from "react";
+import { BASE_URL } from "../App";
+import { useAuth } from "../hooks/useAuth";
+import type { Identity, OidcProvider } from "../types/Auth";
+
+// LinkedAccountsCard lists the sign-in providers linked to the account and
+// unlinks them. Signing in with a provider links it when the emails match.
+const LinkedAccountsCard: React.FC = () => {
+  const { token } = useAuth();
+  const [identities, setIdentities] = useState<Identity[]>([]);
+  const [providers, setProviders] = useState<OidcProvider[]>([]);
+  const [error, setError] = useState<string | null>(null);
+
+  useEffect(() => {
+    if (!token) return;
+    (async () => {
+      try {
+        const [res, providersRes] = await Promise.all([
+          fetch(`${BASE_URL}/auth/identities`, { headers: { Authorization: `Bearer ${token}` } }),
+          fetch(`${BASE_URL}/auth/oidc/providers`),
+        ]);
+        const data = await res.json();
+        if (!res.ok) throw new Error(data?.error || "Failed to load linked accounts");
+        setIdentities(data.items || []);
+        const providersData = providersRes.ok ? await providersRes.json() : null;
+        setProviders(providersData?.items || []);
+        setError(null);
+      } catch (e: any) {
+        setError(e?.message || "Failed to load linked accounts");
+      }
+    })();
+  }, [token]);
+
+  const unlink = async (identity: Identity) => {
+    try {
+      const res = await fetch(`${BASE_URL}/auth/identities/${identity._id}`, {
+        method: "DELETE",
+        headers: { Authorization: `Bearer ${token}` },
+      });
+      if (!res.ok) {
+        const data = await res.json().catch(() => null);
+        throw new Error(data?.error || "Failed to unlink the account");
+      }
+      setIdentities((prev) => prev.filter((i) => i._id !== identity._id));
+    } catch (e: any) {
+      setError(e?.message || "Failed to unlink the account");
+    }
+  };
+
+  const providerName = (id: string) => providers.find((p) => p.id === id)?.name || id;
+
+  if (providers.length === 0 && identities.length === 0 && !error) return null;
+
+  return (
+    <div className="max-w-xl mx-auto mt-6 bg-base-100 border border-base-300 rounded-xl p-6">
+      <h3 className="text-xl font-bold mb-1">Linked accounts</h3>
+      <p className="text-sm opacity-70 mb-4">Accounts at other services you can sign in with.</p>
+      {error && <div className="alert alert-error mb-3">{error}</div>}
+      <ul className="divide-y divide-base-300">
+        {identities.map((i) => (
+          <li key={i._id} className="py-3 flex items-center justify-between gap-3">
+            <div>
+              <div className="font-medium">{providerName(i.provider)}</div>
+              <div className="text-xs opacity-70">
+                {i.email}
+                {i.lastUsedAt && <> · last used {new Date(i.lastUsedAt).toLocaleString()}</>}
+              </div>
+            </div>
+            <button className="btn btn-sm btn-outline" onClick={() => unlink(i)}>
+              Unlink
+            </button>
+          </li>
+        ))}
+        {identities.length === 0 && !error && (
+          <li className="py-3 text-sm opacity-70">None yet. Sign in with a provider using this account's email to link it.</li>
+        )}
+      </ul>
+    </div>
+  );
+};
+
+export default LinkedAccountsCard;
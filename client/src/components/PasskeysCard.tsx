@@ -0,0 +1,144 @@
+import React, { useEffect, useState } from "react";
+import { BASE_URL } from "../App";
+import { useAuth } from "../hooks/useAuth";
+import type { Passkey } from "../types/Auth";
+import { createPasskey, passkeysSupported } from "../passkeys";
+import CurrentPasswordInput from "./CurrentPasswordInput";
+
+// PasskeysCard lists the account's passkeys, adds one for this device and
+// removes lost ones. Adding one takes the password, and a code with 2FA on.
+const PasskeysCard: React.FC = () => {
+  const { user, token } = useAuth();
+  const [passkeys, setPasskeys] = useState<Passkey[]>([]);
+  const [name, setName] = useState("");
+  const [password, setPassword] = useState("");
+  const [code, setCode] = useState("");
+  const [error, setError] = useState<string | null>(null);
+  const [adding, setAdding] = useState(false);
+
+  const fetchPasskeys = async () => {
+    if (!token) return;
+    try {
+      const res = await fetch(`${BASE_URL}/auth/passkeys`, {
+        headers: { Authorization: `Bearer ${token}` },
+      });
+      const data = await res.json();
+      if (!res.ok) throw new Error(data?.error || "Failed to load passkeys");
+      setPasskeys(data.items || []);
+      setError(null);
+    } catch (e: any) {
+      setError(e?.message || "Failed to load passkeys");
+    }
+  };
+
+  useEffect(() => {
+    fetchPasskeys();
+  }, [token]);
+
+  const add = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setAdding(true);
+    setError(null);
+    try {
+      const begin = await fetch(`${BASE_URL}/auth/passkeys/register/begin`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json", Authorization: `Bearer ${token}` },
+        body: JSON.stringify({ password, code: code.trim() }),
+      });
+      setPassword("");
+      setCode("");
+      const options = await begin.json();
+      if (!begin.ok) throw new Error(options?.error || "Failed to add passkey");
+      const credential = await createPasskey(options);
+      const res = await fetch(`${BASE_URL}/auth/passkeys/register/finish`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json", Authorization: `Bearer ${token}` },
+        body: JSON.stringify({ name, credential }),
+      });
+      const data = await res.json().catch(() => null);
+      if (!res.ok) throw new Error(data?.error || "Failed to add passkey");
+      setPasskeys((prev) => [...prev, data]);
+      setName("");
+    } catch (e: any) {
+      // The browser rejects with NotAllowedError when the user cancels
+      setError(e?.name === "NotAllowedError" ? "Adding the passkey was cancelled" : e?.message || "Failed to add passkey");
+    } finally {
+      setAdding(false);
+    }
+  };
+
+  const remove = async (passkey: Passkey) => {
+    try {
+      const res = await fetch(`${BASE_URL}/auth/passkeys/${passkey._id}`, {
+        method: "DELETE",
+        headers: { Authorization: `Bearer ${token}` },
+      });
+      if (!res.ok) {
+        const data = await res.json().catch(() => null);
+        throw new Error(data?.error || "Failed to remove passkey");
+      }
+      setPasskeys((prev) => prev.filter((p) => p._id !== passkey._id));
+    } catch (e: any) {
+      setError(e?.message || "Failed to remove passkey");
+    }
+  };
+
+  return (
+    <div className="max-w-xl mx-auto mt-6 bg-base-100 border border-base-300 rounded-xl p-6">
+      <h3 className="text-xl font-bold mb-1">Passkeys</h3>
+      <p className="text-sm opacity-70 mb-4">Sign in with your fingerprint, face or screen lock instead of a password.</p>
+      {error && <div className="alert alert-error mb-3">{error}</div>}
+      <ul className="divide-y divide-base-300">
+        {passkeys.map((p) => (
+          <li key={p._id} className="py-3 flex items-center justify-between gap-3">
+            <div>
+              <div className="font-medium">
+                {p.name}
+                {p.synced && <span className="badge badge-ghost badge-sm ml-2">Synced</span>}
+              </div>
+              <div className="text-xs opacity-70">
+                Added {new Date(p.createdAt).toLocaleDateString()}
+                {p.lastUsedAt && <> · last used {new Date(p.lastUsedAt).toLocaleString()}</>}
+              </div>
+            </div>
+            <button className="btn btn-sm btn-outline" onClick={() => remove(p)}>
+              Remove
+            </button>
+          </li>
+        ))}
+        {passkeys.length === 0 && !error && <li className="py-3 text-sm opacity-70">No passkeys yet</li>}
+      </ul>
+      {passkeysSupported() ? (
+        <form onSubmit={add} className="space-y-2 mt-4">
+          <input
+            className="input input-bordered input-sm w-full"
+            placeholder="Name (optional), e.g. Work laptop"
+            value={name}
+            onChange={(e) => setName(e.target.value)}
+            maxLength={100}
+          />
+          <div className="flex gap-2">
+            <CurrentPasswordInput value={password} onChange={setPassword} className="input input-bordered input-sm flex-1" />
+            {user?.twoFactor && (
+              <input
+                className="input input-bordered input-sm flex-1"
+                placeholder="Code or recovery code"
+                autoComplete="one-time-code"
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+                required
+              />
+            )}
+            <button className="btn btn-primary btn-sm" disabled={adding}>
+              {adding ? "Adding..." : "Add passkey"}
+            </button>
+          </div>
+        </form>
+      ) : (
+        <p className="text-sm opacity-70 mt-4">This browser does not support passkeys.</p>
+      )}
+    </div>
+  );
+};
+
+export default PasskeysCard;
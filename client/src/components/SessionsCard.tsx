@@ -0,0 +1,106 @@
+import React, { useEffect, useState } from "react";
+import { BASE_URL } from "../App";
+import { useAuth } from "../hooks/useAuth";
+import type { Session } from "../types/Auth";
+import ConfirmModal from "./ConfirmModal";
+
+// SessionsCard lists the devices signed in to the account and lets the user
+// sign any of them out, e.g. a lost laptop.
+const SessionsCard: React.FC = () => {
+  const { token, logout } = useAuth();
+  const [sessions, setSessions] = useState<Session[]>([]);
+  const [error, setError] = useState<string | null>(null);
+  const [confirmAll, setConfirmAll] = useState(false);
+
+  const fetchSessions = async () => {
+    if (!token) return;
+    try {
+      const res = await fetch(`${BASE_URL}/auth/sessions`, {
+        headers: { Authorization: `Bearer ${token}` },
+      });
+      const data = await res.json();
+      if (!res.ok) throw new Error(data?.error || "Failed to load sessions");
+      setSessions(data.items || []);
+      setError(null);
+    } catch (e: any) {
+      setError(e?.message || "Failed to load sessions");
+    }
+  };
+
+  useEffect(() => {
+    fetchSessions();
+  }, [token]);
+
+  const revoke = async (session: Session) => {
+    if (session.current) {
+      logout();
+      return;
+    }
+    try {
+      const res = await fetch(`${BASE_URL}/auth/sessions/${session._id}`, {
+        method: "DELETE",
+        headers: { Authorization: `Bearer ${token}` },
+      });
+      if (!res.ok) {
+        const data = await res.json().catch(() => null);
+        throw new Error(data?.error || "Failed to sign out device");
+      }
+      setSessions((prev) => prev.filter((s) => s._id !== session._id));
+    } catch (e: any) {
+      setError(e?.message || "Failed to sign out device");
+    }
+  };
+
+  const logoutEverywhere = async () => {
+    setConfirmAll(false);
+    try {
+      await fetch(`${BASE_URL}/auth/logout-all`, {
+        method: "POST",
+        headers: { Authorization: `Bearer ${token}` },
+      });
+    } finally {
+      logout();
+    }
+  };
+
+  return (
+    <div className="max-w-xl mx-auto mt-6 bg-base-100 border border-base-300 rounded-xl p-6">
+      <div className="flex items-center justify-between mb-4">
+        <h3 className="text-xl font-bold">Signed-in devices</h3>
+        <button className="btn btn-sm btn-ghost" onClick={() => setConfirmAll(true)}>
+          Sign out everywhere
+        </button>
+      </div>
+      {error && <div className="text-sm text-error mb-2">{error}</div>}
+      <ul className="divide-y divide-base-300">
+        {sessions.map((s) => (
+          <li key={s._id} className="flex items-center justify-between py-3">
+            <div>
+              <div className="font-medium">
+                {s.device}
+                {s.current && <span className="badge badge-primary badge-sm ml-2">This device</span>}
+              </div>
+              <div className="text-xs opacity-70">
+                {s.ip} · last active {new Date(s.lastSeenAt).toLocaleString()} · signed in{" "}
+                {new Date(s.createdAt).toLocaleDateString()}
+              </div>
+            </div>
+            <button className="btn btn-sm btn-outline btn-error" onClick={() => revoke(s)}>
+              Sign out
+            </button>
+          </li>
+        ))}
+      </ul>
+      <ConfirmModal
+        isOpen={confirmAll}
+        title="Sign out everywhere?"
+        description="Every device, including this one, will have to sign in again, and your personal access tokens are deleted."
+        confirmText="Sign out everywhere"
+        onConfirm={logoutEverywhere}
+        onCancel={() => setConfirmAll(false)}
+      />
+    </div>
+  );
+};
+
+export default SessionsCard;
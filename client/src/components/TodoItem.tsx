@@ -3,12 +3,6 @@ import type { Todo } from "../types/Todo";
 import { BASE_URL } from "../App";
 import { useAuth } from "../hooks/useAuth";
 import AuthGateModal from "./AuthGateModal";
-import {
-  addWishlistId,
-  removeWishlistId,
-  getWishlistIds,
-} from "../utils/wishlistCache";
-import { markNoStarEndpoint, hasNoStarEndpoint } from "../utils/apiCompat";
 
 interface TodoItemProps {
   todo: Todo;
@@ -41,15 +35,10 @@ const TodoItem: React.FC<TodoItemProps> = ({
   const [dateError, setDateError] = useState<string>("");
   const todayStr = new Date().toISOString().slice(0, 10);
   const [showGate, setShowGate] = useState(false);
-  const userId =
-    JSON.parse(localStorage.getItem("auth_user") || "{}")?._id || null;
-  // Prefer server-side per-user starredBy array; only consider current user's membership
-  const serverStarredBy = Array.isArray(todo.starredBy) ? todo.starredBy : [];
-  const cachedStar = userId ? getWishlistIds(userId).has(todo._id) : false;
-  // Only treat as starred for the current user (prevents seeing others' wishlist)
-  const isStarred = Boolean(
-    (userId && serverStarredBy.includes(userId)) || cachedStar
-  );
+  // The server tells whether the current user starred this todo; the local
+  // copy lets the star update before the list is refetched
+  const [isStarred, setIsStarred] = useState(Boolean(todo.starredByMe));
+  React.useEffect(() => setIsStarred(Boolean(todo.starredByMe)), [todo.starredByMe]);
 
   const handleToggle = async () => {
     if (!token) {
@@ -113,70 +102,31 @@ const TodoItem: React.FC<TodoItemProps> = ({
     if (isStarring) return;
     const desired = !isStarred;
     setIsStarring(true);
-    let success = false;
-    // Fast path: if we previously determined this host doesn't support the
-    // per-user star endpoint, update the local cache immediately and skip
-    // the network call.
-    if (hasNoStarEndpoint(BASE_URL)) {
-      try {
-        if (desired) addWishlistId(todo._id, userId);
-        else removeWishlistId(todo._id, userId);
-        success = true;
-      } finally {
-        setIsStarring(false);
-      }
-    } else {
-      try {
-        const res = await fetch(`${BASE_URL}/todos/${todo._id}/star`, {
-          method: "PATCH",
-          headers: {
-            "Content-Type": "application/json",
-            Authorization: `Bearer ${token}`,
-          },
-          body: JSON.stringify({ starred: desired }),
-        });
-        // record status implicitly via res.ok checks below
-        if (!res.ok) {
-          console.warn("toggle star failed, status:", res.status);
-          if (res.status === 401) {
-            // token expired or missing: prompt login
-            window.dispatchEvent(new CustomEvent("open-login-modal"));
-          } else if (res.status === 403) {
-            // Server indicates this endpoint isn't usable for this resource/host.
-            // Fallback: mark host and update local per-user wishlist cache so the
-            // user still gets the expected UX.
-            console.warn("You don't have permission to modify this item (403)");
-            try {
-              markNoStarEndpoint(BASE_URL);
-            } catch (e) {}
-            // Update local cache as if the toggle succeeded and consider this a
-            // success for the UI so we can optimistically reflect the change.
-            success = true;
-            if (desired) addWishlistId(todo._id, userId);
-            else removeWishlistId(todo._id, userId);
-          }
-        } else {
-          success = true;
+    setIsStarred(desired);
+    try {
+      const res = await fetch(`${BASE_URL}/todos/${todo._id}/star`, {
+        method: "PATCH",
+        headers: {
+          "Content-Type": "application/json",
+          Authorization: `Bearer ${token}`,
+        },
+        body: JSON.stringify({ starred: desired }),
+      });
+      if (!res.ok) {
+        console.warn("toggle star failed, status:", res.status);
+        setIsStarred(!desired);
+        if (res.status === 401) {
+          // token expired or missing: prompt login
+          window.dispatchEvent(new CustomEvent("open-login-modal"));
         }
-      } catch (e) {
-        console.error("toggle star failed:", e);
-      } finally {
-        setIsStarring(false);
       }
+    } catch (e) {
+      console.error("toggle star failed:", e);
+      setIsStarred(!desired);
+    } finally {
+      setIsStarring(false);
     }
-
-    if (!success) {
-      // Don't apply optimistic local updates when the server rejected the change.
-      // Refresh list to reflect authoritative state.
-      window.dispatchEvent(new CustomEvent("todos-refetch"));
-      return;
-    }
-
-    // Update per-user wishlist cache only and re-sync the list. Do not call the
-    // generic onEdit/PATCH endpoint here to avoid accidental side-effects.
-    if (desired) addWishlistId(todo._id, userId);
-    else removeWishlistId(todo._id, userId);
-    // Re-sync authoritative list
+    // Re-sync authoritative list (the wishlist drops unstarred items)
     window.dispatchEvent(new CustomEvent("todos-refetch"));
   };
 
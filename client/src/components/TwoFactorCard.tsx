@@ -0,0 +1,151 @@
+import React, { useState } from "react";
+import { BASE_URL } from "../App";
+import { useAuth } from "../hooks/useAuth";
+import type { User } from "../types/Auth";
+import CurrentPasswordInput from "./CurrentPasswordInput";
+
+interface Setup {
+  secret: string;
+  otpauthUrl: string;
+  qrCode: string;
+}
+
+// TwoFactorCard turns TOTP two-factor authentication on and off. Turning it on
+// takes the password, then a code from the app that scanned the QR code.
+const TwoFactorCard: React.FC = () => {
+  const { user, token } = useAuth();
+  const [password, setPassword] = useState("");
+  const [code, setCode] = useState("");
+  const [setup, setSetup] = useState<Setup | null>(null);
+  const [recoveryCodes, setRecoveryCodes] = useState<string[] | null>(null);
+  const [msg, setMsg] = useState<{ ok: boolean; text: string } | null>(null);
+  const [saving, setSaving] = useState(false);
+
+  const post = async (path: string, body: unknown) => {
+    const res = await fetch(`${BASE_URL}${path}`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json", Authorization: `Bearer ${token}` },
+      body: JSON.stringify(body),
+    });
+    const data = await res.json().catch(() => null);
+    if (!res.ok) throw new Error(data?.error || "Request failed");
+    return data;
+  };
+
+  const storeUser = (updated: User) => {
+    const storedRaw = localStorage.getItem("auth_user");
+    const stored = storedRaw ? JSON.parse(storedRaw) : {};
+    localStorage.setItem("auth_user", JSON.stringify({ ...stored, ...updated }));
+    window.dispatchEvent(new CustomEvent("auth-updated"));
+  };
+
+  const run = async (action: () => Promise<void>) => {
+    setSaving(true);
+    setMsg(null);
+    try {
+      await action();
+    } catch (e: any) {
+      setMsg({ ok: false, text: e?.message || "Request failed" });
+    } finally {
+      setSaving(false);
+    }
+  };
+
+  const start = (e: React.FormEvent) => {
+    e.preventDefault();
+    run(async () => {
+      setSetup(await post("/auth/2fa/setup", { password }));
+      setPassword("");
+    });
+  };
+
+  const enable = (e: React.FormEvent) => {
+    e.preventDefault();
+    run(async () => {
+      const data = await post("/auth/2fa/enable", { code: code.trim() });
+      storeUser(data.user);
+      setRecoveryCodes(data.recoveryCodes);
+      setSetup(null);
+      setCode("");
+      setMsg({ ok: true, text: "Two-factor authentication is on." });
+    });
+  };
+
+  const disable = (e: React.FormEvent) => {
+    e.preventDefault();
+    run(async () => {
+      storeUser(await post("/auth/2fa/disable", { password, code: code.trim() }));
+      setPassword("");
+      setCode("");
+      setRecoveryCodes(null);
+      setMsg({ ok: true, text: "Two-factor authentication is off." });
+    });
+  };
+
+  const newCodes = () => {
+    run(async () => {
+      const data = await post("/auth/2fa/recovery-codes", { password });
+      setRecoveryCodes(data.recoveryCodes);
+      setPassword("");
+      setMsg({ ok: true, text: "New recovery codes created; the old ones no longer work." });
+    });
+  };
+
+  return (
+    <div className="max-w-xl mx-auto mt-6 bg-base-100 border border-base-300 rounded-xl p-6 space-y-3">
+      <h3 className="text-xl font-bold">Two-factor authentication</h3>
+      {recoveryCodes && (
+        <div className="alert alert-warning flex-col items-start">
+          <span>Save these recovery codes somewhere safe. Each works once if you lose your authenticator app, and they are not shown again.</span>
+          <pre className="font-mono text-sm">{recoveryCodes.join("\n")}</pre>
+        </div>
+      )}
+      {user?.twoFactor ? (
+        <form onSubmit={disable} className="space-y-2">
+          <p className="text-sm opacity-70">On. Signing in asks for a code from your authenticator app.</p>
+          <CurrentPasswordInput value={password} onChange={setPassword} />
+          <input
+            className="input input-bordered w-full"
+            placeholder="Code or recovery code (to turn off)"
+            autoComplete="one-time-code"
+            value={code}
+            onChange={(e) => setCode(e.target.value)}
+          />
+          <div className="flex gap-2">
+            <button className="btn btn-error btn-sm" disabled={saving || !code}>Turn off</button>
+            <button type="button" className="btn btn-sm" disabled={saving || !password} onClick={newCodes}>
+              New recovery codes
+            </button>
+          </div>
+        </form>
+      ) : setup ? (
+        <form onSubmit={enable} className="space-y-2">
+          <p className="text-sm opacity-70">Scan the code with your authenticator app, then enter the code it shows.</p>
+          <img src={setup.qrCode} alt="QR code for your authenticator app" className="w-48 h-48" />
+          <p className="text-xs opacity-70 break-all">
+            Or enter this key: <span className="font-mono">{setup.secret}</span>
+          </p>
+          <input
+            className="input input-bordered w-full"
+            placeholder="6-digit code"
+            autoComplete="one-time-code"
+            inputMode="numeric"
+            value={code}
+            onChange={(e) => setCode(e.target.value)}
+            required
+          />
+          <button className="btn btn-primary btn-sm" disabled={saving}>Turn on</button>
+        </form>
+      ) : (
+        <form onSubmit={start} className="space-y-2">
+          <p className="text-sm opacity-70">Off. Ask for a code from an authenticator app when signing in.</p>
+          <CurrentPasswordInput value={password} onChange={setPassword} />
+          <button className="btn btn-primary btn-sm" disabled={saving}>Set up</button>
+        </form>
+      )}
+      {msg && <div className={`text-sm ${msg.ok ? "text-success" : "text-error"}`}>{msg.text}</div>}
+    </div>
+  );
+};
+
+export default TwoFactorCard;
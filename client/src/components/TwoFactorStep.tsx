@@ -0,0 +1,65 @@
+import React, { useState } from "react";
+import { BASE_URL } from "../App";
+import type { AuthResponse } from "../types/Auth";
+
+interface Props {
+  challenge: string;
+  onSignedIn: (ar: AuthResponse) => void;
+  // Called when the challenge expired and the sign in has to start over
+  onExpired: (error: string) => void;
+}
+
+// TwoFactorStep asks for the authenticator code of a sign in that returned
+// a two-factor challenge instead of tokens.
+const TwoFactorStep: React.FC<Props> = ({ challenge, onSignedIn, onExpired }) => {
+  const [code, setCode] = useState("");
+  const [loading, setLoading] = useState(false);
+  const [error, setError] = useState<string | null>(null);
+
+  const verify = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setLoading(true);
+    setError(null);
+    try {
+      const res = await fetch(`${BASE_URL}/auth/2fa/verify`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ challengeToken: challenge, code: code.trim() }),
+      });
+      const data = await res.json().catch(() => null);
+      if (res.status === 401) {
+        onExpired(data?.error || "Sign in failed");
+        return;
+      }
+      if (!res.ok) throw new Error(data?.error || "Sign in failed");
+      onSignedIn(data as AuthResponse);
+    } catch (e: any) {
+      setError(e?.message || "Sign in failed");
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <form onSubmit={verify} className="space-y-3">
+      {error && <div className="alert alert-error">{error}</div>}
+      <p className="text-sm opacity-80">
+        Enter the 6-digit code from your authenticator app, or one of your recovery codes.
+      </p>
+      <input
+        className="input input-bordered w-full"
+        placeholder="Code"
+        autoComplete="one-time-code"
+        autoFocus
+        value={code}
+        onChange={(e) => setCode(e.target.value)}
+        required
+      />
+      <button className="btn btn-primary w-full" disabled={loading}>
+        {loading ? "Verifying..." : "Verify"}
+      </button>
+    </form>
+  );
+};
+
+export default TwoFactorStep;
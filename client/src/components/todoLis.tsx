@@ -1,4 +1,4 @@
-import React, { useState, useEffect, useMemo } from "react";
+import React, { useState, useEffect } from "react";
 import type { Todo } from "../types/Todo";
 import TodoItem from "./TodoItem";
 import ConfirmModal from "./ConfirmModal";
@@ -8,7 +8,6 @@ import Pagination from "./Pagination";
 import TodoInput from "./todoForm";
 import { BASE_URL } from "../App";
 import { useAuth } from "../hooks/useAuth";
-import { getWishlistIds } from "../utils/wishlistCache";
 
 interface TodoListProps {
   starredOnly?: boolean;
@@ -20,12 +19,63 @@ const TodoList: React.FC<TodoListProps> = ({ starredOnly = false }) => {
   const [error, setError] = useState<string | null>(null);
   const [search, setSearch] = useState("");
   const [debouncedSearch, setDebouncedSearch] = useState("");
+  // Set when the server rejects the filter expression, pointing at the bad token
+  const [queryError, setQueryError] = useState<{
+    error: string;
+    token: string;
+    position: number;
+  } | null>(null);
   const [status, setStatus] = useState<"all" | "active" | "completed">("all");
   const [priority, setPriority] = useState<string>("");
+  // Server-side sort order, see the `sort` parameter of GET /api/todos
   const [sort, setSort] = useState<
-    "createdDesc" | "createdAsc" | "dueAsc" | "dueDesc"
-  >("createdDesc");
+    "relevance" | "-createdAt" | "createdAt" | "dueDate" | "-dueDate" | "-priority" | "-updatedAt"
+  >("-createdAt");
   const { token } = useAuth();
+  // Server-side pagination: cursors[i] fetches page i + 1 (null for the first page)
+  const PAGE_SIZE = 10;
+  const [page, setPage] = useState(1);
+  const [cursors, setCursors] = useState<(string | null)[]>([null]);
+  const [nextCursor, setNextCursor] = useState<string | null>(null);
+  const [total, setTotal] = useState(0);
+  // Saved views (built-in smart views first); when one is selected it replaces the filters below
+  const [views, setViews] = useState<{ _id: string; name: string; builtin?: boolean }[]>([]);
+  const [viewId, setViewId] = useState("");
+
+  useEffect(() => {
+    if (!token) {
+      setViews([]);
+      setViewId("");
+      return;
+    }
+    fetch(`${BASE_URL}/views`, { headers: { Authorization: `Bearer ${token}` } })
+      .then((res) => (res.ok ? res.json() : { items: [] }))
+      .then((data) => setViews(data?.items || []))
+      .catch((err) => console.error("Error fetching views:", err));
+  }, [token]);
+
+  const handleSaveView = async () => {
+    const name = window.prompt("Name this view");
+    if (!name || !name.trim() || !token) return;
+    const filter: Record<string, string> = {};
+    if (status !== "all") filter.status = status;
+    if (priority) filter.priority = priority;
+    if (debouncedSearch) filter.q = debouncedSearch;
+    try {
+      const res = await fetch(`${BASE_URL}/views`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json", Authorization: `Bearer ${token}` },
+        body: JSON.stringify({ name, filter, sort: sort === "relevance" && !debouncedSearch ? "" : sort }),
+      });
+      const data = await res.json();
+      if (!res.ok) throw new Error(data?.error || "Failed to save view");
+      setViews((prev) => [...prev, data]);
+      setViewId(data._id);
+    } catch (err: any) {
+      console.error("Error saving view:", err);
+      setError(err?.message || "Failed to save view");
+    }
+  };
 
   // debounce search to reduce API calls while typing
   useEffect(() => {
@@ -33,16 +83,12 @@ const TodoList: React.FC<TodoListProps> = ({ starredOnly = false }) => {
     return () => clearTimeout(t);
   }, [search]);
 
+  // Filters change the result set, so restart from the first page
   useEffect(() => {
-    fetchTodos();
-  }, [debouncedSearch, status, priority]);
-
-  // Refetch when wishlist cache changes
-  useEffect(() => {
-    const onWishlist = () => setTodos((prev) => [...prev]);
-    window.addEventListener("wishlist-updated", onWishlist);
-    return () => window.removeEventListener("wishlist-updated", onWishlist);
-  }, []);
+    setPage(1);
+    setCursors([null]);
+    fetchTodos(null);
+  }, [debouncedSearch, status, priority, sort, viewId, token]);
 
   // Keep lists in sync: refetch when a todo is mutated elsewhere
   useEffect(() => {
@@ -51,18 +97,37 @@ const TodoList: React.FC<TodoListProps> = ({ starredOnly = false }) => {
     return () => window.removeEventListener("todos-refetch", handler);
   }, []);
 
-  const fetchTodos = async () => {
+  const fetchTodos = async (cursor: string | null = cursors[page - 1] ?? null) => {
+    if (!token) {
+      // Todos, and the wishlist, are only listed for a signed-in user
+      setTodos([]);
+      setNextCursor(null);
+      setTotal(0);
+      setIsLoading(false);
+      return;
+    }
     try {
       setIsLoading(true);
       setError(null);
 
       const params = new URLSearchParams();
-      if (debouncedSearch) params.set("search", debouncedSearch);
+      // The search box takes filter expressions, e.g. `priority:high due<7d milk`
+      if (debouncedSearch) params.set("q", debouncedSearch);
       if (status !== "all") params.set("status", status);
       if (priority) params.set("priority", priority);
-
-      const qs = params.toString();
-      const url = qs ? `${BASE_URL}/todos?${qs}` : `${BASE_URL}/todos`;
+      // Best match only applies to searches; without one the server's default order is used
+      if (sort !== "relevance" || debouncedSearch) params.set("sort", sort);
+      params.set("limit", String(PAGE_SIZE));
+      if (cursor) params.set("cursor", cursor);
+
+      // The wishlist takes the same parameters, limited to the user's starred todos
+      let url = `${BASE_URL}/${starredOnly ? "wishlist" : "todos"}?${params.toString()}`;
+      if (viewId) {
+        // A view carries its own filters and sort
+        const viewParams = new URLSearchParams({ limit: String(PAGE_SIZE) });
+        if (cursor) viewParams.set("cursor", cursor);
+        url = `${BASE_URL}/views/${viewId}/todos?${viewParams.toString()}`;
+      }
       console.log("Fetching todos from:", url);
 
       const response = await fetch(
@@ -71,6 +136,17 @@ const TodoList: React.FC<TodoListProps> = ({ starredOnly = false }) => {
       );
       console.log("Response status:", response.status);
 
+      if (response.status === 400) {
+        const body = await response.json();
+        if (body?.token !== undefined) {
+          setQueryError(body);
+          setTodos([]);
+          setNextCursor(null);
+          setTotal(0);
+          return;
+        }
+        throw new Error(body?.error || `HTTP error! status: ${response.status}`);
+      }
       if (!response.ok) {
         throw new Error(`HTTP error! status: ${response.status}`);
       }
@@ -78,7 +154,10 @@ const TodoList: React.FC<TodoListProps> = ({ starredOnly = false }) => {
       const data = await response.json();
       console.log("Raw response data:", data);
 
-      setTodos(data || []);
+      setQueryError(null);
+      setTodos(data?.items || []);
+      setNextCursor(data?.nextCursor || null);
+      setTotal(data?.total || 0);
     } catch (err: any) {
       console.error("Error fetching todos:", err);
       setError(
@@ -89,77 +168,26 @@ const TodoList: React.FC<TodoListProps> = ({ starredOnly = false }) => {
     }
   };
 
-  // Client-side fallback filtering to ensure UX even if server doesn't filter
-  const visibleTodos = useMemo(() => {
-    let list = [...todos];
-    const q = debouncedSearch.toLowerCase();
-    if (q) list = list.filter((t) => t.body.toLowerCase().includes(q));
-    if (status !== "all") {
-      list = list.filter((t) =>
-        status === "completed" ? t.completed : !t.completed
-      );
-    }
-    if (priority)
-      list = list.filter(
-        (t) => (t.priority || "").toLowerCase() === priority.toLowerCase()
-      );
-    if (starredOnly) {
-      // server truth (starredBy contains current user) first, then overlay client cache as fallback
-      const currentUserId = token
-        ? JSON.parse(localStorage.getItem("auth_user") || "{}")?._id
-        : null;
-      const cached = getWishlistIds(currentUserId);
-      list = list.filter((t) => {
-        const serverStarredBy = Array.isArray((t as any).starredBy)
-          ? (t as any).starredBy
-          : [];
-        // Only include items where the current user is in starredBy or where the
-        // per-user wishlist cache marks it. Do not use the global `starred`
-        // boolean because it is not per-user and can make other users' stars appear.
-        return (
-          (currentUserId && serverStarredBy.includes(currentUserId)) ||
-          cached.has(t._id)
-        );
-      });
-    }
-    // sorting
-    list.sort((a, b) => {
-      const ca = a.createdAt ? new Date(a.createdAt).getTime() : 0;
-      const cb = b.createdAt ? new Date(b.createdAt).getTime() : 0;
-      const byCreated = () => cb - ca;
-      const byCreatedAsc = () => ca - cb;
-      const da = a.dueDate
-        ? new Date(a.dueDate).getTime()
-        : Number.POSITIVE_INFINITY;
-      const db = b.dueDate
-        ? new Date(b.dueDate).getTime()
-        : Number.POSITIVE_INFINITY;
-      if (sort === "createdDesc") return byCreated();
-      if (sort === "createdAsc") return byCreatedAsc();
-      if (sort === "dueAsc") return da - db;
-      return db - da; // dueDesc
-    });
-    return list;
-  }, [todos, debouncedSearch, status, priority, sort, starredOnly]);
+  // Filtering, search and sorting are done by the server
+  const visibleTodos = todos;
   // pagination
-  const [page, setPage] = useState(1);
-  const PAGE_SIZE = 10;
-  const totalPages = Math.max(1, Math.ceil(visibleTodos.length / PAGE_SIZE));
-
-  // Reset page when filters/search change
-  useEffect(() => {
-    setPage(1);
-  }, [debouncedSearch, status, priority, starredOnly]);
-
-  // Clamp page if visibleTodos length shrinks
-  useEffect(() => {
-    if (page > totalPages) setPage(totalPages);
-  }, [page, totalPages]);
-
-  const pagedTodos = visibleTodos.slice(
-    (page - 1) * PAGE_SIZE,
-    page * PAGE_SIZE
-  );
+  const totalPages = Math.max(1, Math.ceil(total / PAGE_SIZE));
+  const pagedTodos = visibleTodos;
+
+  const handlePageChange = (p: number) => {
+    if (p === page) return;
+    if (p > page) {
+      // Only the next page is reachable forward: its cursor came with this page
+      if (!nextCursor) return;
+      const next = [...cursors.slice(0, page), nextCursor];
+      setCursors(next);
+      setPage(page + 1);
+      fetchTodos(nextCursor);
+    } else {
+      setPage(p);
+      fetchTodos(cursors[p - 1] ?? null);
+    }
+  };
   const handleAddTodo = async (payload: {
     body: string;
     priority?: string;
@@ -188,6 +216,7 @@ const TodoList: React.FC<TodoListProps> = ({ starredOnly = false }) => {
       console.log("New todo created:", newTodo);
 
       setTodos((prev) => [...prev, newTodo]);
+      setTotal((n) => n + 1);
     } catch (err) {
       console.error("Error adding todo:", err);
       throw err;
@@ -265,6 +294,7 @@ const TodoList: React.FC<TodoListProps> = ({ starredOnly = false }) => {
 
       // Update local state
       setTodos((prev) => prev.filter((todo) => todo._id !== id));
+      setTotal((n) => Math.max(0, n - 1));
     } catch (err) {
       console.error("Error deleting todo:", err);
       alert("Failed to delete todo");
@@ -281,30 +311,36 @@ const TodoList: React.FC<TodoListProps> = ({ starredOnly = false }) => {
     try {
       console.log("handleClearCompleted: starting", { token });
       // Fetch completed todos from server to ensure we remove all completed tasks
-      const params = new URLSearchParams();
-      params.set("status", "completed");
-      const url = `${BASE_URL}/todos?${params.toString()}`;
-      const res = await fetch(
-        url,
-        token ? { headers: { Authorization: `Bearer ${token}` } } : undefined
-      );
-      if (!res.ok) {
-        console.error(
-          "handleClearCompleted: fetch completed failed",
-          res.status
+      const completed: Todo[] = [];
+      let cursor: string | null = null;
+      do {
+        const params = new URLSearchParams();
+        params.set("status", "completed");
+        params.set("limit", "100");
+        if (cursor) params.set("cursor", cursor);
+        const url = `${BASE_URL}/todos?${params.toString()}`;
+        const res = await fetch(
+          url,
+          token ? { headers: { Authorization: `Bearer ${token}` } } : undefined
         );
-        throw new Error(`Failed to fetch completed todos: ${res.status}`);
-      }
-      const payload = await res.json();
-      const completed: Todo[] = Array.isArray(payload) ? payload : [];
-      console.log("handleClearCompleted: fetched completed payload", payload);
+        if (!res.ok) {
+          console.error(
+            "handleClearCompleted: fetch completed failed",
+            res.status
+          );
+          throw new Error(`Failed to fetch completed todos: ${res.status}`);
+        }
+        const payload = await res.json();
+        completed.push(...(payload?.items || []));
+        cursor = payload?.nextCursor || null;
+      } while (cursor);
       console.log(
         "handleClearCompleted: fetched completed count",
         completed.length
       );
       if (!completed || completed.length === 0) {
         // ensure UI is synced
-        await fetchTodos();
+        await fetchTodos(null);
         return;
       }
 
@@ -338,7 +374,9 @@ const TodoList: React.FC<TodoListProps> = ({ starredOnly = false }) => {
 
       console.log(`handleClearCompleted: deleted=${deleted} failed=${failed}`);
       // refresh authoritative list
-      await fetchTodos();
+      setPage(1);
+      setCursors([null]);
+      await fetchTodos(null);
       if (deleted > 0 && failed === 0) {
         console.log(`Cleared ${deleted} completed tasks`);
       } else if (deleted > 0 && failed > 0) {
@@ -376,7 +414,7 @@ const TodoList: React.FC<TodoListProps> = ({ starredOnly = false }) => {
               <span>{error}</span>
               <button
                 className="bg-red-400/20 hover:bg-red-400/30 text-red-100 px-3 py-1 rounded-md"
-                onClick={fetchTodos}
+                onClick={() => fetchTodos()}
               >
                 Retry
               </button>
@@ -385,13 +423,52 @@ const TodoList: React.FC<TodoListProps> = ({ starredOnly = false }) => {
         )}
         {/* Filters */}
         <div className="max-w-4xl mx-auto mb-6">
-          <div className="grid grid-cols-1 md:grid-cols-12 gap-3">
-            <input
-              placeholder="Search..."
-              value={search}
-              onChange={(e) => setSearch(e.target.value)}
-              className="md:col-span-6 bg-base-100 text-base-content border border-base-300 rounded-xl px-4 py-3"
-            />
+          {token && !starredOnly && views.length > 0 && (
+            <div className="flex gap-3 mb-3">
+              <select
+                value={viewId}
+                onChange={(e) => setViewId(e.target.value)}
+                className="flex-1 bg-base-100 text-base-content border border-base-300 rounded-xl px-4 py-3 cursor-pointer"
+              >
+                <option value="">All todos</option>
+                {views.map((v) => (
+                  <option key={v._id} value={v._id}>
+                    {v.name}
+                  </option>
+                ))}
+              </select>
+              {!viewId && (
+                <button className="btn btn-outline rounded-xl" onClick={handleSaveView}>
+                  Save view
+                </button>
+              )}
+            </div>
+          )}
+          <fieldset
+            disabled={!!viewId}
+            className={`grid grid-cols-1 md:grid-cols-12 gap-3 ${viewId ? "opacity-50" : ""}`}
+          >
+            <div className="md:col-span-6">
+              <input
+                placeholder='Search... e.g. priority:high due<7d "exact phrase"'
+                value={search}
+                onChange={(e) => setSearch(e.target.value)}
+                className={`w-full bg-base-100 text-base-content border rounded-xl px-4 py-3 ${
+                  queryError ? "border-error" : "border-base-300"
+                }`}
+              />
+              {queryError && (
+                <p className="text-error text-sm mt-1">
+                  {queryError.error}
+                  {queryError.token && (
+                    <>
+                      {" "}
+                      at <code>{queryError.token}</code>
+                    </>
+                  )}
+                </p>
+              )}
+            </div>
             <div className="md:col-span-3 relative">
               <select
                 value={status}
@@ -458,10 +535,13 @@ const TodoList: React.FC<TodoListProps> = ({ starredOnly = false }) => {
                 onChange={(e) => setSort(e.target.value as any)}
                 className="w-full bg-base-100 text-base-content border border-base-300 rounded-xl px-4 py-3 pr-10 appearance-none cursor-pointer"
               >
-                <option value="createdDesc">Newest</option>
-                <option value="createdAsc">Oldest</option>
-                <option value="dueAsc">Due soon</option>
-                <option value="dueDesc">Due last</option>
+                <option value="relevance">Best match</option>
+                <option value="-createdAt">Newest</option>
+                <option value="createdAt">Oldest</option>
+                <option value="dueDate">Due soon</option>
+                <option value="-dueDate">Due last</option>
+                <option value="-priority">Priority</option>
+                <option value="-updatedAt">Recently updated</option>
               </select>
               <svg
                 width="20"
@@ -482,7 +562,7 @@ const TodoList: React.FC<TodoListProps> = ({ starredOnly = false }) => {
                 />
               </svg>
             </div>
-          </div>
+          </fieldset>
           {isLoading && null}
         </div>
 
@@ -542,7 +622,7 @@ const TodoList: React.FC<TodoListProps> = ({ starredOnly = false }) => {
             <div className="grid grid-cols-1 md:grid-cols-3 gap-6">
               <div className="bg-base-100 border border-base-300 rounded-xl p-6 text-center">
                 <div className="text-3xl font-bold text-base-content mb-2">
-                  {visibleTodos.length}
+                  {total}
                 </div>
                 <div className="text-base-content/60 text-sm uppercase tracking-wide">
                   Total Tasks
@@ -592,7 +672,7 @@ const TodoList: React.FC<TodoListProps> = ({ starredOnly = false }) => {
         <Pagination
           page={page}
           totalPages={totalPages}
-          onPageChange={setPage}
+          onPageChange={handlePageChange}
         />
       </div>
     </div>
@@ -1,9 +1,74 @@
 import { useEffect, useState } from "react";
+import { BASE_URL } from "../App";
 import type { User } from "../types/Auth";
 
 const TOKEN_KEY = "auth_token";
+const REFRESH_KEY = "auth_refresh_token";
 const USER_KEY = "auth_user";
 const AUTH_EVENT = "auth-updated";
+// Renew the access token this long before it expires
+const REFRESH_MARGIN_MS = 60_000;
+
+// tokenExpiry reads the exp claim of a JWT, in milliseconds.
+function tokenExpiry(token: string): number {
+  try {
+    const payload = JSON.parse(atob(token.split(".")[1].replace(/-/g, "+").replace(/_/g, "/")));
+    return typeof payload.exp === "number" ? payload.exp * 1000 : 0;
+  } catch {
+    return 0;
+  }
+}
+
+function clearAuth() {
+  localStorage.removeItem(TOKEN_KEY);
+  localStorage.removeItem(REFRESH_KEY);
+  localStorage.removeItem(USER_KEY);
+  window.dispatchEvent(new CustomEvent(AUTH_EVENT));
+}
+
+// refreshSession trades the stored refresh token for new tokens. Tabs share
+// the tokens, and a refresh token only works once, so tabs take turns and
+// skip the call when another tab has just refreshed.
+async function refreshSession(): Promise<void> {
+  const run = async () => {
+    const refreshToken = localStorage.getItem(REFRESH_KEY);
+    const token = localStorage.getItem(TOKEN_KEY);
+    if (!refreshToken || (token && tokenExpiry(token) - Date.now() > REFRESH_MARGIN_MS)) return;
+    try {
+      const res = await fetch(`${BASE_URL}/auth/refresh`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ refreshToken }),
+      });
+      if (res.status === 401) {
+        // Expired, revoked or reused: sign in again
+        clearAuth();
+        return;
+      }
+      if (!res.ok) return;
+      const data = await res.json();
+      localStorage.setItem(TOKEN_KEY, data.token);
+      localStorage.setItem(REFRESH_KEY, data.refreshToken);
+      window.dispatchEvent(new CustomEvent(AUTH_EVENT));
+    } catch (err) {
+      console.error("Error refreshing session:", err);
+    }
+  };
+  if (navigator.locks) {
+    await navigator.locks.request("auth-refresh", run);
+  } else {
+    await run();
+  }
+}
+
+let refreshTimer: ReturnType<typeof setTimeout> | undefined;
+
+function scheduleRefresh(token: string | null) {
+  clearTimeout(refreshTimer);
+  if (!token || !localStorage.getItem(REFRESH_KEY)) return;
+  const delay = Math.max(tokenExpiry(token) - Date.now() - REFRESH_MARGIN_MS, 0);
+  refreshTimer = setTimeout(refreshSession, delay);
+}
 
 export function useAuth() {
   const [token, setToken] = useState<string | null>(null);
@@ -15,6 +80,7 @@ export function useAuth() {
       const u = localStorage.getItem(USER_KEY);
       setToken(t);
       setUser(u ? JSON.parse(u) : null);
+      scheduleRefresh(t);
     };
     // Initial load
     sync();
@@ -23,7 +89,7 @@ export function useAuth() {
     window.addEventListener(AUTH_EVENT, handler as EventListener);
     // Also listen to cross-tab storage events
     const storageHandler = (e: StorageEvent) => {
-      if (e.key === TOKEN_KEY || e.key === USER_KEY || e.key === null) sync();
+      if (e.key === TOKEN_KEY || e.key === REFRESH_KEY || e.key === USER_KEY || e.key === null) sync();
     };
     window.addEventListener("storage", storageHandler);
     return () => {
@@ -32,22 +98,27 @@ export function useAuth() {
     };
   }, []);
 
-  const login = (t: string, u: User) => {
+  const login = (t: string, u: User, refreshToken?: string) => {
     setToken(t);
     setUser(u);
     localStorage.setItem(TOKEN_KEY, t);
+    if (refreshToken) localStorage.setItem(REFRESH_KEY, refreshToken);
     localStorage.setItem(USER_KEY, JSON.stringify(u));
     // Notify other hook instances in this tab
     window.dispatchEvent(new CustomEvent(AUTH_EVENT));
   };
 
   const logout = () => {
+    // Revoke the tokens server-side too; signing out locally does not wait for it
+    const current = localStorage.getItem(TOKEN_KEY);
+    if (current) {
+      fetch(`${BASE_URL}/auth/logout`, { method: "POST", headers: { Authorization: `Bearer ${current}` } })
+        .catch((err) => console.error("Error logging out:", err));
+    }
     setToken(null);
     setUser(null);
-    localStorage.removeItem(TOKEN_KEY);
-    localStorage.removeItem(USER_KEY);
-    // Notify other hook instances in this tab
-    window.dispatchEvent(new CustomEvent(AUTH_EVENT));
+    // Also notifies other hook instances in this tab
+    clearAuth();
   };
 
   return { token, user, login, logout };
@@ -7,6 +7,10 @@ import { createBrowserRouter, RouterProvider } from "react-router-dom";
 import SignUpPage from "./pages/SignUpPage.tsx";
 import ProfilePage from "./pages/ProfilePage";
 import WishlistPage from "./pages/WishlistPage";
+import ResetPasswordPage from "./pages/ResetPasswordPage";
+import VerifyEmailPage from "./pages/VerifyEmailPage";
+import MagicLoginPage from "./pages/MagicLoginPage";
+import OidcCallbackPage from "./pages/OidcCallbackPage";
 
 const queryClient = new QueryClient();
 
@@ -15,6 +19,10 @@ const router = createBrowserRouter([
   { path: "/signup", element: <SignUpPage /> },
   { path: "/profile", element: <ProfilePage /> },
   { path: "/wishlist", element: <WishlistPage /> },
+  { path: "/reset-password", element: <ResetPasswordPage /> },
+  { path: "/verify-email", element: <VerifyEmailPage /> },
+  { path: "/magic-login", element: <MagicLoginPage /> },
+  { path: "/oidc/callback", element: <OidcCallbackPage /> },
 ]);
 createRoot(document.getElementById("root")!).render(
   <StrictMode>
@@ -0,0 +1,72 @@
+import React, { useEffect, useRef, useState } from "react";
+import { BASE_URL } from "../App";
+import NavBar from "../components/nav";
+import TwoFactorStep from "../components/TwoFactorStep";
+import { useAuth } from "../hooks/useAuth";
+import type { AuthResponse, TwoFactorChallenge } from "../types/Auth";
+import { useNavigate, useSearchParams } from "react-router-dom";
+
+// Landing page of the link in the sign-in email. Accounts with two-factor
+// authentication still enter a code here.
+const MagicLoginPage: React.FC = () => {
+  const [params] = useSearchParams();
+  const token = params.get("token") || "";
+  const { login } = useAuth();
+  const navigate = useNavigate();
+  const [challenge, setChallenge] = useState<string | null>(null);
+  const [error, setError] = useState<string | null>(null);
+  // StrictMode runs effects twice, and the link only works once
+  const started = useRef(false);
+
+  const finish = (ar: AuthResponse) => {
+    login(ar.token, ar.user, ar.refreshToken);
+    navigate("/");
+  };
+
+  useEffect(() => {
+    if (started.current) return;
+    started.current = true;
+    (async () => {
+      try {
+        const res = await fetch(`${BASE_URL}/auth/magic/verify`, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ token }),
+        });
+        const data = await res.json().catch(() => null);
+        if (!res.ok) throw new Error(data?.error || "Could not sign in");
+        if ((data as TwoFactorChallenge).twoFactorRequired) {
+          setChallenge((data as TwoFactorChallenge).challengeToken);
+          return;
+        }
+        finish(data as AuthResponse);
+      } catch (e: any) {
+        setError(e?.message || "Could not sign in");
+      }
+    })();
+  }, [token]);
+
+  return (
+    <div className="min-h-screen bg-base-200">
+      <NavBar />
+      <div className="container mx-auto px-4 py-8">
+        <div className="max-w-md mx-auto bg-base-100 border border-slate-600/30 rounded-xl p-6">
+          <h1 className="text-2xl font-bold mb-4">Sign in</h1>
+          {error ? (
+            <div className="alert alert-error">{error}. You can ask for a new link from the sign in dialog.</div>
+          ) : challenge ? (
+            <TwoFactorStep
+              challenge={challenge}
+              onSignedIn={finish}
+              onExpired={(msg) => { setChallenge(null); setError(msg); }}
+            />
+          ) : (
+            <p className="opacity-80">Signing in...</p>
+          )}
+        </div>
+      </div>
+    </div>
+  );
+};
+
+export default MagicLoginPage;
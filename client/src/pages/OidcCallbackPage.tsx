@@ -0,0 +1,77 @@
+import React, { useEffect, useRef, useState } from "react";
+import { BASE_URL } from "../App";
+import NavBar from "../components/nav";
+import TwoFactorStep from "../components/TwoFactorStep";
+import { useAuth } from "../hooks/useAuth";
+import type { AuthResponse, TwoFactorChallenge } from "../types/Auth";
+import { useNavigate, useSearchParams } from "react-router-dom";
+
+// Where an OpenID Connect provider sends the browser back after sign in.
+// The code is only posted if the state is the one this browser started
+// with, so a crafted link cannot sign it in to someone else's account.
+const OidcCallbackPage: React.FC = () => {
+  const [params] = useSearchParams();
+  const { login } = useAuth();
+  const navigate = useNavigate();
+  const [challenge, setChallenge] = useState<string | null>(null);
+  const [error, setError] = useState<string | null>(null);
+  // StrictMode runs effects twice, and the code only works once
+  const started = useRef(false);
+
+  const finish = (ar: AuthResponse) => {
+    login(ar.token, ar.user, ar.refreshToken);
+    navigate("/");
+  };
+
+  useEffect(() => {
+    if (started.current) return;
+    started.current = true;
+    const state = params.get("state") || "";
+    const expected = sessionStorage.getItem("oidc_state");
+    sessionStorage.removeItem("oidc_state");
+    (async () => {
+      try {
+        if (params.get("error")) throw new Error(params.get("error_description") || "Sign in was cancelled");
+        if (!state || state !== expected) throw new Error("This sign in was not started in this browser");
+        const res = await fetch(`${BASE_URL}/auth/oidc/callback`, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ state, code: params.get("code") || "" }),
+        });
+        const data = await res.json().catch(() => null);
+        if (!res.ok) throw new Error(data?.error || "Could not sign in");
+        if ((data as TwoFactorChallenge).twoFactorRequired) {
+          setChallenge((data as TwoFactorChallenge).challengeToken);
+          return;
+        }
+        finish(data as AuthResponse);
+      } catch (e: any) {
+        setError(e?.message || "Could not sign in");
+      }
+    })();
+  }, [params]);
+
+  return (
+    <div className="min-h-screen bg-base-200">
+      <NavBar />
+      <div className="container mx-auto px-4 py-8">
+        <div className="max-w-md mx-auto bg-base-100 border border-slate-600/30 rounded-xl p-6">
+          <h1 className="text-2xl font-bold mb-4">Sign in</h1>
+          {error ? (
+            <div className="alert alert-error">{error}</div>
+          ) : challenge ? (
+            <TwoFactorStep
+              challenge={challenge}
+              onSignedIn={finish}
+              onExpired={(msg) => { setChallenge(null); setError(msg); }}
+            />
+          ) : (
+            <p className="opacity-80">Signing in...</p>
+          )}
+        </div>
+      </div>
+    </div>
+  );
+};
+
+export default OidcCallbackPage;
@@ -1,6 +1,13 @@
 import React, { useState } from "react";
 import { useAuth } from "../hooks/useAuth";
 import BackButton from "../components/BackButton";
+import SessionsCard from "../components/SessionsCard";
+import AccountSecurityCard from "../components/AccountSecurityCard";
+import TwoFactorCard from "../components/TwoFactorCard";
+import PasskeysCard from "../components/PasskeysCard";
+import LinkedAccountsCard from "../components/LinkedAccountsCard";
+import ApiTokensCard from "../components/ApiTokensCard";
+import EmailVerificationBanner from "../components/EmailVerificationBanner";
 import { BASE_URL } from "../App";
 
 const ProfilePage: React.FC = () => {
@@ -95,6 +102,7 @@ const ProfilePage: React.FC = () => {
       <div className="max-w-xl mx-auto mb-2">
         <BackButton to="/" />
       </div>
+      {user && user.emailVerified === false && <EmailVerificationBanner />}
       {!user ? (
         <div className="max-w-xl mx-auto bg-base-100 border border-base-300 rounded-xl p-6">
           <h2 className="text-2xl font-bold mb-2">Profile</h2>
@@ -222,6 +230,12 @@ const ProfilePage: React.FC = () => {
           </div>
         </div>
       )}
+      {user && <AccountSecurityCard />}
+      {user && <TwoFactorCard />}
+      {user && <PasskeysCard />}
+      {user && <LinkedAccountsCard />}
+      {user && <ApiTokensCard />}
+      {user && <SessionsCard />}
     </div>
   );
 };
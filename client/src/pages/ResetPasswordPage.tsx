@@ -0,0 +1,88 @@
+import React, { useState } from "react";
+import { BASE_URL } from "../App";
+import NavBar from "../components/nav";
+import { useNavigate, useSearchParams } from "react-router-dom";
+
+// Landing page of the link in the password reset email.
+const ResetPasswordPage: React.FC = () => {
+  const navigate = useNavigate();
+  const [params] = useSearchParams();
+  const token = params.get("token") || "";
+  const [password, setPassword] = useState("");
+  const [confirm, setConfirm] = useState("");
+  const [loading, setLoading] = useState(false);
+  const [error, setError] = useState<string | null>(null);
+  const [success, setSuccess] = useState<string | null>(null);
+
+  const submit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError(null);
+    if (password.length < 6) {
+      setError("Password must be at least 6 characters");
+      return;
+    }
+    if (password !== confirm) {
+      setError("Passwords do not match");
+      return;
+    }
+    setLoading(true);
+    try {
+      const res = await fetch(`${BASE_URL}/auth/reset-password`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ token, password }),
+      });
+      const data = await res.json().catch(() => null);
+      if (!res.ok) throw new Error(data?.error || "Could not reset the password");
+      setSuccess("Password changed. Please sign in with your new password.");
+      window.dispatchEvent(new CustomEvent("open-login-modal"));
+      navigate("/", { replace: true });
+    } catch (e: any) {
+      setError(e?.message || "Could not reset the password");
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <div className="min-h-screen bg-base-200">
+      <NavBar />
+      <div className="container mx-auto px-4 py-8">
+        <div className="max-w-md mx-auto bg-base-100 border border-slate-600/30 rounded-xl p-6">
+          <h1 className="text-2xl font-bold mb-4">Choose a new password</h1>
+          {!token ? (
+            <div className="alert alert-error">This reset link is incomplete. Request a new one from the sign in dialog.</div>
+          ) : (
+            <form onSubmit={submit} className="space-y-3">
+              <input
+                className="input input-bordered w-full"
+                placeholder="New password"
+                type="password"
+                value={password}
+                onChange={(e) => { setPassword(e.target.value); if (error) setError(null); }}
+                required
+                minLength={6}
+              />
+              <input
+                className="input input-bordered w-full"
+                placeholder="Repeat new password"
+                type="password"
+                value={confirm}
+                onChange={(e) => { setConfirm(e.target.value); if (error) setError(null); }}
+                required
+                minLength={6}
+              />
+              <button className="btn btn-primary w-full" disabled={loading}>
+                {loading ? "Saving..." : "Set password"}
+              </button>
+            </form>
+          )}
+          {error && <div className="alert alert-error mt-3">{error}</div>}
+          {success && <div className="alert alert-success mt-3">{success}</div>}
+        </div>
+      </div>
+    </div>
+  );
+};
+
+export default ResetPasswordPage;
@@ -0,0 +1,63 @@
+import React, { useEffect, useRef, useState } from "react";
+import { BASE_URL } from "../App";
+import NavBar from "../components/nav";
+import { useSearchParams } from "react-router-dom";
+
+// Landing page of the link in the verification email.
+const VerifyEmailPage: React.FC = () => {
+  const [params] = useSearchParams();
+  const token = params.get("token") || "";
+  const [status, setStatus] = useState<"verifying" | "verified" | "failed">("verifying");
+  const [error, setError] = useState<string | null>(null);
+  // StrictMode runs effects twice, and the link only works once
+  const started = useRef(false);
+
+  useEffect(() => {
+    if (started.current) return;
+    started.current = true;
+    (async () => {
+      try {
+        const res = await fetch(`${BASE_URL}/auth/verify-email`, {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify({ token }),
+        });
+        const data = await res.json().catch(() => null);
+        if (!res.ok) throw new Error(data?.error || "Could not verify the email");
+        // Update the signed-in user, if this browser has one
+        const storedRaw = localStorage.getItem("auth_user");
+        if (storedRaw && data?.user) {
+          const stored = JSON.parse(storedRaw);
+          if (stored._id === data.user._id) {
+            localStorage.setItem("auth_user", JSON.stringify({ ...stored, ...data.user }));
+            window.dispatchEvent(new CustomEvent("auth-updated"));
+          }
+        }
+        setStatus("verified");
+      } catch (e: any) {
+        setError(e?.message || "Could not verify the email");
+        setStatus("failed");
+      }
+    })();
+  }, [token]);
+
+  return (
+    <div className="min-h-screen bg-base-200">
+      <NavBar />
+      <div className="container mx-auto px-4 py-8">
+        <div className="max-w-md mx-auto bg-base-100 border border-slate-600/30 rounded-xl p-6">
+          <h1 className="text-2xl font-bold mb-4">Email verification</h1>
+          {status === "verifying" && <p className="opacity-80">Verifying...</p>}
+          {status === "verified" && <div className="alert alert-success">Thanks, your email is verified.</div>}
+          {status === "failed" && (
+            <div className="alert alert-error">
+              {error}. You can ask for a new link on your profile page.
+            </div>
+          )}
+        </div>
+      </div>
+    </div>
+  );
+};
+
+export default VerifyEmailPage;
@@ -0,0 +1,65 @@
+// Helpers for the passkey ceremonies. The server sends WebAuthn options with
+// binary fields as base64url strings and expects the browser's answer in the
+// same form.
+
+const toBuffer = (s: string): ArrayBuffer => {
+  const b64 = s.replace(/-/g, "+").replace(/_/g, "/").padEnd(Math.ceil(s.length / 4) * 4, "=");
+  return Uint8Array.from(atob(b64), (c) => c.charCodeAt(0)).buffer;
+};
+
+const toBase64url = (b: ArrayBuffer): string =>
+  btoa(String.fromCharCode(...new Uint8Array(b))).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+
+export const passkeysSupported = () => typeof window !== "undefined" && !!window.PublicKeyCredential;
+
+// createPasskey runs navigator.credentials.create with the options from
+// /auth/passkeys/register/begin.
+export async function createPasskey(options: any) {
+  const pk = options.publicKey;
+  const cred = (await navigator.credentials.create({
+    publicKey: {
+      ...pk,
+      challenge: toBuffer(pk.challenge),
+      user: { ...pk.user, id: toBuffer(pk.user.id) },
+      excludeCredentials: (pk.excludeCredentials || []).map((c: any) => ({ ...c, id: toBuffer(c.id) })),
+    },
+  })) as PublicKeyCredential | null;
+  if (!cred) throw new Error("No passkey was created");
+  const res = cred.response as AuthenticatorAttestationResponse;
+  return {
+    id: cred.id,
+    rawId: toBase64url(cred.rawId),
+    type: cred.type,
+    response: {
+      clientDataJSON: toBase64url(res.clientDataJSON),
+      attestationObject: toBase64url(res.attestationObject),
+      transports: res.getTransports ? res.getTransports() : [],
+    },
+  };
+}
+
+// getPasskey runs navigator.credentials.get with the options from
+// /auth/passkeys/login/begin.
+export async function getPasskey(options: any) {
+  const pk = options.publicKey;
+  const cred = (await navigator.credentials.get({
+    publicKey: {
+      ...pk,
+      challenge: toBuffer(pk.challenge),
+      allowCredentials: (pk.allowCredentials || []).map((c: any) => ({ ...c, id: toBuffer(c.id) })),
+    },
+  })) as PublicKeyCredential | null;
+  if (!cred) throw new Error("No passkey was chosen");
+  const res = cred.response as AuthenticatorAssertionResponse;
+  return {
+    id: cred.id,
+    rawId: toBase64url(cred.rawId),
+    type: cred.type,
+    response: {
+      clientDataJSON: toBase64url(res.clientDataJSON),
+      authenticatorData: toBase64url(res.authenticatorData),
+      signature: toBase64url(res.signature),
+      userHandle: res.userHandle ? toBase64url(res.userHandle) : null,
+    },
+  };
+}
@@ -4,11 +4,68 @@ export interface User {
   username?: string;
   avatar?: string;
   email: string;
+  emailVerified?: boolean;
+  twoFactor?: boolean;
+  // Users who signed up through a provider have no password until they set
+  // one; they confirm changes with a code from /auth/reauth-code instead.
+  hasPassword?: boolean;
   createdAt?: string;
   updatedAt?: string;
 }
 
 export interface AuthResponse {
   token: string;
+  refreshToken?: string;
   user: User;
 }
+
+// Login answer for users with two-factor authentication: the challenge token
+// and a code go to /auth/2fa/verify, which returns the AuthResponse.
+export interface TwoFactorChallenge {
+  twoFactorRequired: true;
+  challengeToken: string;
+  expiresIn: number;
+}
+
+export interface Passkey {
+  _id: string;
+  name: string;
+  synced: boolean;
+  createdAt: string;
+  lastUsedAt?: string;
+}
+
+// An account at an OpenID Connect provider the user signs in with.
+export interface Identity {
+  _id: string;
+  provider: string;
+  email: string;
+  createdAt: string;
+  lastUsedAt?: string;
+}
+
+export interface OidcProvider {
+  id: string;
+  name: string;
+}
+
+// A personal access token; the token itself is only returned on creation.
+export interface ApiToken {
+  _id: string;
+  name: string;
+  scopes: string[];
+  hint: string;
+  createdAt: string;
+  expiresAt: string;
+  lastUsedAt?: string;
+}
+
+export interface Session {
+  _id: string;
+  device: string;
+  ip: string;
+  createdAt: string;
+  lastSeenAt: string;
+  expiresAt: string;
+  current: boolean;
+}
@@ -1,12 +1,18 @@
 export interface Todo {
   _id: string;
   body: string;
+  tags?: string[];
+  notes?: string;
   completed: boolean;
   starred?: boolean;
-  starredBy?: string[]; // array of user IDs who starred this todo
+  starredByMe?: boolean; // whether the current user starred this todo
+  starCount?: number; // how many users starred it
   priority?: "low" | "medium" | "high" | string;
   dueDate?: string | null; // ISO string from backend
   createdAt?: string;
   updatedAt?: string;
   completedAt?: string | null;
+  // Present on search results: relevance and the matched fragments, HTML-escaped with <mark> around matches
+  score?: number;
+  highlights?: { field: "body" | "tags" | "notes"; fragment: string }[];
 }
\ No newline at end of file
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Access tokens and sign-in links are signed with Ed25519 (EdDSA) or RSA
+// (RS256) keys kept as PKCS#8 PEM files in JWT_KEYS_DIR, one key per file
+// named <kid>.pem. An optional "Activate-At" PEM header delays a key's use
+// for signing, so that services verifying tokens can fetch it from
+// /.well-known/jwks.json first. The newest active key signs; older keys keep
+// verifying the tokens they signed until they are pruned.
+
+// activateAtHeader is the PEM header holding a key's activation time.
+const activateAtHeader = "Activate-At"
+
+type signingKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	Private    crypto.Signer
+	ActivateAt time.Time
+}
+
+// keySet holds the signing keys sorted by activation time, oldest first.
+type keySet struct {
+	mu   sync.RWMutex
+	keys []*signingKey
+}
+
+var jwtKeys keySet
+
+func (s *keySet) set(keys []*signingKey) {
+	sortKeys(keys)
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+}
+
+// signer returns the newest key active at now, or nil when there is none.
+func (s *keySet) signer(now time.Time) *signingKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		if !s.keys[i].ActivateAt.After(now) {
+			return s.keys[i]
+		}
+	}
+	return nil
+}
+
+func (s *keySet) lookup(kid string) *signingKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range s.keys {
+		if k.ID == kid {
+			return k
+		}
+	}
+	return nil
+}
+
+func (s *keySet) all() []*signingKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*signingKey(nil), s.keys...)
+}
+
+func sortKeys(keys []*signingKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].ActivateAt.Equal(keys[j].ActivateAt) {
+			return keys[i].ActivateAt.Before(keys[j].ActivateAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+}
+
+// jwtKeyFunc finds the key that verifies token by its kid header.
+func jwtKeyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key := jwtKeys.lookup(kid)
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("key %q does not sign with %s", kid, token.Method.Alg())
+	}
+	return key.Private.Public(), nil
+}
+
+// signJWT signs claims with the key active at now.
+func signJWT(claims jwt.Claims, now time.Time) (string, error) {
+	key := jwtKeys.signer(now)
+	if key == nil {
+		return "", fmt.Errorf("no active signing key")
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// signedTokenTTL is how long the tokens a key signs live: access tokens and
+// sign-in links. A key keeps verifying for that long after it stops signing.
+func signedTokenTTL() time.Duration {
+	return max(accessTokenTTL(), magicLinkTTL())
+}
+
+// loadKeyDir reads every <kid>.pem private key in dir.
+func loadKeyDir(dir string) ([]*signingKey, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*signingKey, 0, len(paths))
+	for _, path := range paths {
+		key, err := loadKeyFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	sortKeys(keys)
+	return keys, nil
+}
+
+func loadKeyFile(path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("not a PKCS#8 PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key := &signingKey{ID: strings.TrimSuffix(filepath.Base(path), ".pem")}
+	switch k := parsed.(type) {
+	case ed25519.PrivateKey:
+		key.Method, key.Private = jwt.SigningMethodEdDSA, k
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must have at least 2048 bits")
+		}
+		key.Method, key.Private = jwt.SigningMethodRS256, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T; use Ed25519 or RSA", parsed)
+	}
+	if v, ok := block.Headers[activateAtHeader]; ok {
+		if key.ActivateAt, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, fmt.Errorf("invalid %s header: %w", activateAtHeader, err)
+		}
+	}
+	return key, nil
+}
+
+// generateKey creates a key for alg ("EdDSA" or "RS256") that activates at
+// activateAt, named after a new ObjectID so kids sort by creation.
+func generateKey(alg string, activateAt time.Time) (*signingKey, error) {
+	key := &signingKey{ID: primitive.NewObjectID().Hex(), ActivateAt: activateAt.UTC().Truncate(time.Second)}
+	var err error
+	switch alg {
+	case "EdDSA":
+		key.Method = jwt.SigningMethodEdDSA
+		_, key.Private, err = ed25519.GenerateKey(rand.Reader)
+	case "RS256":
+		key.Method = jwt.SigningMethodRS256
+		key.Private, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q; use EdDSA or RS256", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func writeKeyFile(dir string, key *signingKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return err
+	}
+	block := &pem.Block{
+		Type:    "PRIVATE KEY",
+		Headers: map[string]string{activateAtHeader: key.ActivateAt.Format(time.RFC3339)},
+		Bytes:   der,
+	}
+	// O_EXCL: never overwrite a key that may have signed tokens
+	f, err := os.OpenFile(filepath.Join(dir, key.ID+".pem"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := pem.Encode(f, block); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotateKeys adds a key to dir that activates after delay when the newest key
+// is older than every, or when there is none, and deletes keys that have not
+// signed anything for longer than signedTokenTTL. It returns the keys
+// left in dir.
+func rotateKeys(dir, alg string, now time.Time, every, delay time.Duration) ([]*signingKey, error) {
+	keys, err := loadKeyDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 || !now.Before(keys[len(keys)-1].ActivateAt.Add(every)) {
+		if len(keys) == 0 {
+			// Nothing can sign until the first key is active
+			delay = 0
+		}
+		key, err := generateKey(alg, now.Add(delay))
+		if err != nil {
+			return nil, err
+		}
+		if err := writeKeyFile(dir, key); err != nil {
+			return nil, err
+		}
+		log.Printf("signing key %s created, active from %s", key.ID, key.ActivateAt.Format(time.RFC3339))
+		keys = append(keys, key)
+	}
+	// A key retires when the next one activates
+	var kept []*signingKey
+	for i, k := range keys {
+		if i+1 < len(keys) && keys[i+1].ActivateAt.Add(signedTokenTTL()).Before(now) {
+			if err := os.Remove(filepath.Join(dir, k.ID+".pem")); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			log.Printf("signing key %s retired", k.ID)
+			continue
+		}
+		kept = append(kept, k)
+	}
+	return kept, nil
+}
+
+// initJWTKeys loads the signing keys. Without JWT_KEYS_DIR it makes up a key
+// that lasts until the server stops, except in production where that would
+// sign everyone out on every restart.
+func initJWTKeys() error {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		if os.Getenv("ENV") == "production" {
+			return errors.New("JWT_KEYS_DIR must be set in production; create a key with the keys rotate command")
+		}
+		log.Println("JWT_KEYS_DIR is not set; signing tokens with a temporary key")
+		key, err := generateKey("EdDSA", time.Time{})
+		if err != nil {
+			return err
+		}
+		jwtKeys.set([]*signingKey{key})
+		return nil
+	}
+	keys, err := loadKeyDir(dir)
+	if err != nil {
+		return err
+	}
+	jwtKeys.set(keys)
+	if jwtKeys.signer(time.Now()) == nil {
+		return fmt.Errorf("no active signing key in %s; create one with the keys rotate command", dir)
+	}
+	return nil
+}
+
+// watchJWTKeys picks up keys added to or removed from JWT_KEYS_DIR, and
+// rotates them itself when JWT_KEY_ROTATION is set.
+func watchJWTKeys(stop <-chan struct{}) {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		return
+	}
+	every := envDuration("JWT_KEY_ROTATION", 0)
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		var keys []*signingKey
+		var err error
+		if every > 0 {
+			keys, err = rotateKeys(dir, envOr("JWT_KEY_ALG", "EdDSA"), time.Now().UTC(), every, keyActivationDelay())
+		} else {
+			keys, err = loadKeyDir(dir)
+		}
+		if err != nil {
+			log.Println("Error loading signing keys:", err)
+			continue
+		}
+		if len(keys) == 0 {
+			log.Println("Error loading signing keys: none left in", dir)
+			continue
+		}
+		jwtKeys.set(keys)
+	}
+}
+
+// keyActivationDelay is how long a new key is published before it signs,
+// longer than jwksHandler lets clients cache the key set.
+func keyActivationDelay() time.Duration {
+	return envDuration("JWT_KEY_ACTIVATION_DELAY", 10*time.Minute)
+}
+
+// jwk is the public half of a signing key in JWK form (RFC 7517, RFC 8037).
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+func publicJWK(key *signingKey) jwk {
+	j := jwk{Kid: key.ID, Alg: key.Method.Alg(), Use: "sig"}
+	switch pub := key.Private.Public().(type) {
+	case ed25519.PublicKey:
+		j.Kty, j.Crv, j.X = "OKP", "Ed25519", base64.RawURLEncoding.EncodeToString(pub)
+	case *rsa.PublicKey:
+		j.Kty = "RSA"
+		j.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		j.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	}
+	return j
+}
+
+// jwksHandler publishes every key, including ones not active yet, so other
+// services can verify access tokens.
+func jwksHandler(c *fiber.Ctx) error {
+	keys := jwtKeys.all()
+	set := make([]jwk, len(keys))
+	for i, k := range keys {
+		set[i] = publicJWK(k)
+	}
+	c.Set("Cache-Control", "public, max-age=300")
+	return c.JSON(fiber.Map{"keys": set})
+}
+
+const keysUsage = `usage:
+  keys list
+  keys rotate [-alg EdDSA|RS256] [-every duration] [-activate-in duration]`
+
+// runKeysCommand manages the keys in JWT_KEYS_DIR, e.g. from cron when the
+// servers do not rotate keys themselves.
+func runKeysCommand(args []string) error {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		return errors.New("JWT_KEYS_DIR is not set")
+	}
+	if len(args) == 0 {
+		return errors.New(keysUsage)
+	}
+	fs := flag.NewFlagSet("keys "+args[0], flag.ContinueOnError)
+	alg := fs.String("alg", envOr("JWT_KEY_ALG", "EdDSA"), "algorithm of the new key: EdDSA or RS256")
+	every := fs.Duration("every", 0, "only add a key when the newest one is older than this")
+	activateIn := fs.Duration("activate-in", keyActivationDelay(), "how long the new key is published before it signs")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	switch args[0] {
+	case "list":
+		keys, err := loadKeyDir(dir)
+		if err != nil {
+			return err
+		}
+		signer := &keySet{keys: keys}
+		for i, k := range keys {
+			state := "retired"
+			switch {
+			case k.ActivateAt.After(now):
+				state = "pending"
+			case k == signer.signer(now):
+				state = "signing"
+			case i+1 < len(keys) && keys[i+1].ActivateAt.Add(signedTokenTTL()).After(now):
+				state = "verifying"
+			}
+			fmt.Printf("%s  %-5s  %s  %s\n", k.ID, k.Method.Alg(), k.ActivateAt.Format(time.RFC3339), state)
+		}
+		return nil
+	case "rotate":
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+		_, err := rotateKeys(dir, *alg, now, *every, *activateIn)
+		return err
+	}
+	return errors.New(keysUsage)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// useKeys signs and verifies tokens with keys until the test ends.
+func useKeys(t *testing.T, keys ...*signingKey) {
+	t.Helper()
+	old := jwtKeys.all()
+	t.Cleanup(func() { jwtKeys.set(old) })
+	jwtKeys.set(keys)
+}
+
+// newTestKey makes an Ed25519 key that activates at activateAt.
+func newTestKey(t *testing.T, activateAt time.Time) *signingKey {
+	t.Helper()
+	key, err := generateKey("EdDSA", activateAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestInitJWTKeysLoadsTheKeysDir(t *testing.T) {
+	// initJWTKeys replaces the keys; put them back afterwards
+	useKeys(t, jwtKeys.all()...)
+	dir := t.TempDir()
+	t.Setenv("JWT_KEYS_DIR", dir)
+	now := time.Now().UTC()
+
+	if err := initJWTKeys(); err == nil || !strings.Contains(err.Error(), "no active signing key") {
+		t.Errorf("empty dir: %v", err)
+	}
+	active := newTestKey(t, now.Add(-time.Hour))
+	pending := newTestKey(t, now.Add(time.Hour))
+	for _, k := range []*signingKey{pending, active} {
+		if err := writeKeyFile(dir, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := initJWTKeys(); err != nil {
+		t.Fatal(err)
+	}
+	keys := jwtKeys.all()
+	if len(keys) != 2 || keys[0].ID != active.ID || !keys[1].ActivateAt.Equal(pending.ActivateAt) {
+		t.Errorf("loaded %+v", keys)
+	}
+	// The pending key is published but does not sign yet
+	if signer := jwtKeys.signer(now); signer == nil || signer.ID != active.ID {
+		t.Errorf("signer %+v, want %s", signer, active.ID)
+	}
+	if signer := jwtKeys.signer(now.Add(2 * time.Hour)); signer == nil || signer.ID != pending.ID {
+		t.Errorf("signer once the pending key is active: %+v", signer)
+	}
+	if err := writeKeyFile(dir, active); err == nil {
+		t.Error("overwrote an existing key")
+	}
+}
+
+func TestLoadKeyFileRefusesBadKeys(t *testing.T) {
+	dir := t.TempDir()
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallRSA, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name string, key interface{}, headers map[string]string) string {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name+".pem")
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Headers: headers, Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	key, err := loadKeyFile(write("plain", edKey, nil))
+	if err != nil || key.ID != "plain" || key.Method != jwt.SigningMethodEdDSA || !key.ActivateAt.IsZero() {
+		t.Errorf("key without Activate-At: %+v, %v", key, err)
+	}
+	for name, path := range map[string]string{
+		"bad Activate-At": write("soon", edKey, map[string]string{activateAtHeader: "soon"}),
+		"small RSA key":   write("small", smallRSA, nil),
+	} {
+		if _, err := loadKeyFile(path); err == nil {
+			t.Errorf("%s: loaded", name)
+		}
+	}
+}
+
+func TestRotateKeysAddsAndRetiresKeys(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	every, delay := 24*time.Hour, 10*time.Minute
+
+	keys, err := rotateKeys(dir, "EdDSA", now, every, delay)
+	if err != nil || len(keys) != 1 || !keys[0].ActivateAt.Equal(now) {
+		t.Fatalf("first key: %+v, %v", keys, err)
+	}
+	first := keys[0].ID
+	if keys, err := rotateKeys(dir, "EdDSA", now.Add(time.Hour), every, delay); err != nil || len(keys) != 1 {
+		t.Errorf("before the key is due: %+v, %v", keys, err)
+	}
+
+	next := now.Add(every)
+	keys, err = rotateKeys(dir, "EdDSA", next, every, delay)
+	if err != nil || len(keys) != 2 || keys[0].ID != first || !keys[1].ActivateAt.Equal(next.Add(delay)) {
+		t.Fatalf("second key: %+v, %v", keys, err)
+	}
+	// The old key verifies the tokens it signed for as long as they live
+	if keys, err := rotateKeys(dir, "EdDSA", next.Add(delay+signedTokenTTL()), every, delay); err != nil || len(keys) != 2 {
+		t.Errorf("while old tokens live: %+v, %v", keys, err)
+	}
+	keys, err = rotateKeys(dir, "EdDSA", next.Add(delay+signedTokenTTL()+time.Second), every, delay)
+	if err != nil || len(keys) != 1 || keys[0].ID == first {
+		t.Errorf("after old tokens expire: %+v, %v", keys, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, first+".pem")); !os.IsNotExist(err) {
+		t.Errorf("retired key file: %v", err)
+	}
+}
+
+func TestJWKSHandlerPublishesEveryKey(t *testing.T) {
+	app, _ := newTestApp(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed := newTestKey(t, time.Now().Add(-time.Hour))
+	rs := &signingKey{ID: "rsa", Method: jwt.SigningMethodRS256, Private: rsaKey, ActivateAt: time.Now().Add(time.Hour)}
+	useKeys(t, ed, rs)
+
+	res, err := app.Test(httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 || res.Header.Get("Cache-Control") != "public, max-age=300" {
+		t.Errorf("status %d, Cache-Control %q", res.StatusCode, res.Header.Get("Cache-Control"))
+	}
+	data, _ := io.ReadAll(res.Body)
+	var set struct{ Keys []jwk }
+	if err := json.Unmarshal(data, &set); err != nil || len(set.Keys) != 2 {
+		t.Fatalf("%s: %v", data, err)
+	}
+
+	got := set.Keys[0]
+	x, _ := base64.RawURLEncoding.DecodeString(got.X)
+	if got.Kid != ed.ID || got.Kty != "OKP" || got.Crv != "Ed25519" || got.Alg != "EdDSA" || got.Use != "sig" ||
+		!ed25519.PublicKey(x).Equal(ed.Private.Public()) {
+		t.Errorf("Ed25519 key: %+v", got)
+	}
+	got = set.Keys[1]
+	n, _ := base64.RawURLEncoding.DecodeString(got.N)
+	e, _ := base64.RawURLEncoding.DecodeString(got.E)
+	if got.Kid != "rsa" || got.Kty != "RSA" || got.Alg != "RS256" ||
+		new(big.Int).SetBytes(n).Cmp(rsaKey.N) != 0 || new(big.Int).SetBytes(e).Int64() != int64(rsaKey.E) {
+		t.Errorf("RSA key: %+v", got)
+	}
+	if strings.Contains(string(data), `"d"`) {
+		t.Errorf("private key published: %s", data)
+	}
+}
+
+func TestTokenFromAReplacedKeyStillVerifies(t *testing.T) {
+	old := newTestKey(t, time.Now().Add(-time.Hour))
+	useKeys(t, old)
+	token, err := generateToken("user", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current := newTestKey(t, time.Now().Add(-time.Minute))
+	jwtKeys.set([]*signingKey{old, current})
+	if claims, err := parseToken(token); err != nil || claims.UserID != "user" {
+		t.Errorf("token of the replaced key: %+v, %v", claims, err)
+	}
+	fresh, err := generateToken("user", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(fresh, &AuthClaims{})
+	if err != nil || parsed.Header["kid"] != current.ID {
+		t.Errorf("new tokens signed with %v, want %s: %v", parsed.Header["kid"], current.ID, err)
+	}
+
+	// Once the key is pruned its tokens no longer verify
+	jwtKeys.set([]*signingKey{current})
+	if _, err := parseToken(token); err == nil {
+		t.Error("token of a pruned key verified")
+	}
+}
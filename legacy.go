@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Todos created before auth have no owner. Until every one of them has been
+// given to a user or archived every account can read them; once none are
+// left the server can run with LEGACY_TODOS=strict.
+
+// checkLegacyTodos refuses to start in strict mode while live ownerless
+// todos remain, since strict mode would hide them from everyone.
+func checkLegacyTodos(ctx context.Context) error {
+	if legacyTodosMode != legacyStrict {
+		return nil
+	}
+	page, err := store.ListTodos(ctx, TodoQuery{TodoFilter: TodoFilter{Ownerless: true}, Limit: 1})
+	if err != nil {
+		return err
+	}
+	if page.Total > 0 {
+		return fmt.Errorf("%d ownerless todos left; assign or archive them with the legacy command before using LEGACY_TODOS=strict", page.Total)
+	}
+	return nil
+}
+
+// adminEmails lists the users allowed to use the /api/admin routes.
+func adminEmails() []string {
+	var emails []string
+	for _, e := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			emails = append(emails, e)
+		}
+	}
+	return emails
+}
+
+// adminMiddleware lets through users listed in ADMIN_EMAILS. It runs after
+// authMiddleware.
+func adminMiddleware(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	user, err := store.GetUserByID(c.Context(), userID)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if user == nil || !containsString(adminEmails(), user.Email) {
+		return c.Status(403).JSON(fiber.Map{"error": "Admin access required"})
+	}
+	return c.Next()
+}
+
+// listLegacyTodosHandler lists the ownerless todos, or the archived ones with
+// archived=true, paginated like GET /api/todos.
+func listLegacyTodosHandler(c *fiber.Ctx) error {
+	q := TodoQuery{TodoFilter: TodoFilter{Ownerless: true, Archived: c.QueryBool("archived")}}
+	if msg := parsePage(c, &q, ""); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	return listTodos(c, q)
+}
+
+// legacyTodosPayload selects the ownerless todos to assign or archive.
+type legacyTodosPayload struct {
+	IDs   []string `json:"ids"`
+	All   bool     `json:"all"`
+	Email string   `json:"email"`
+}
+
+// todoIDs parses the selection; nil means all ownerless todos. It returns
+// an error message when the selection is invalid.
+func (p *legacyTodosPayload) todoIDs() ([]primitive.ObjectID, string) {
+	if p.All == (len(p.IDs) > 0) {
+		return nil, "Give either ids or all"
+	}
+	ids, err := parseTodoIDs(p.IDs)
+	if err != nil {
+		return nil, "Invalid todo ID"
+	}
+	return ids, ""
+}
+
+func parseTodoIDs(hexIDs []string) ([]primitive.ObjectID, error) {
+	if len(hexIDs) == 0 {
+		return nil, nil
+	}
+	ids := make([]primitive.ObjectID, len(hexIDs))
+	for i, hex := range hexIDs {
+		id, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid todo id %q", hex)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+func assignLegacyTodosHandler(c *fiber.Ctx) error {
+	var payload legacyTodosPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	ids, msg := payload.todoIDs()
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	user, err := store.GetUserByEmail(c.Context(), strings.ToLower(strings.TrimSpace(payload.Email)))
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		return err
+	}
+	n, err := store.ClaimOwnerlessTodos(c.Context(), ids, &user.ID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"updated": n})
+}
+
+func archiveLegacyTodosHandler(c *fiber.Ctx) error {
+	var payload legacyTodosPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	ids, msg := payload.todoIDs()
+	if msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	n, err := store.ClaimOwnerlessTodos(c.Context(), ids, nil, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"updated": n})
+}
+
+const legacyUsage = `usage:
+  legacy list [-archived]
+  legacy assign -email user@example.com (-all | todo-id...)
+  legacy archive (-all | todo-id...)`
+
+// runLegacyCommand is the command-line counterpart of the /api/admin/legacy-todos
+// routes, for servers that have no admin account yet.
+func runLegacyCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New(legacyUsage)
+	}
+	fs := flag.NewFlagSet("legacy "+args[0], flag.ContinueOnError)
+	archived := fs.Bool("archived", false, "list archived todos instead")
+	email := fs.String("email", "", "email of the user to assign the todos to")
+	all := fs.Bool("all", false, "select every ownerless todo")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	switch args[0] {
+	case "list":
+		// Page through rather than loading every todo at once
+		q := TodoQuery{TodoFilter: TodoFilter{Ownerless: true, Archived: *archived}, Limit: maxTodoLimit}
+		for {
+			page, err := store.ListTodos(ctx, q)
+			if err != nil {
+				return err
+			}
+			for _, t := range page.Items {
+				fmt.Printf("%s  %s  %s\n", t.ID.Hex(), t.CreatedAt.Format(time.DateOnly), t.Body)
+			}
+			if page.Next == nil {
+				fmt.Printf("%d todos\n", page.Total)
+				return nil
+			}
+			q.After = page.Next
+		}
+	case "assign", "archive":
+		if *all == (fs.NArg() > 0) {
+			return errors.New(legacyUsage)
+		}
+		ids, err := parseTodoIDs(fs.Args())
+		if err != nil {
+			return err
+		}
+		var owner *primitive.ObjectID
+		if args[0] == "assign" {
+			user, err := store.GetUserByEmail(ctx, strings.ToLower(*email))
+			if err != nil {
+				return fmt.Errorf("user %q: %w", *email, err)
+			}
+			owner = &user.ID
+		}
+		n, err := store.ClaimOwnerlessTodos(ctx, ids, owner, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d todos updated\n", n)
+		return nil
+	}
+	return errors.New(legacyUsage)
+}
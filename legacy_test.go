@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestAdminRoutesNeedAnAdmin(t *testing.T) {
+	t.Setenv("ADMIN_EMAILS", " other@example.com, Admin@example.com")
+	app, _ := newTestApp(t)
+	admin, _ := register(t, app, "Admin", "admin@example.com")
+	bob, _ := register(t, app, "Bob", "bob@example.com")
+	for _, c := range []struct {
+		name, token string
+		want        int
+	}{
+		{"anonymous", "", 401},
+		{"not an admin", bob, 403},
+		{"admin", admin, 200},
+	} {
+		if status, res := request(t, app, "GET", "/api/admin/legacy-todos", c.token, nil); status != c.want {
+			t.Errorf("%s: status %d, want %d: %v", c.name, status, c.want, res)
+		}
+	}
+}
+
+func TestAssignAndArchiveLegacyTodos(t *testing.T) {
+	t.Setenv("ADMIN_EMAILS", "admin@example.com")
+	app, _ := newTestApp(t)
+	admin, _ := register(t, app, "Admin", "admin@example.com")
+	alice, _ := register(t, app, "Alice", "alice@example.com")
+	first := seedTodo(t, "first", "", 1)
+	seedTodo(t, "second", "", 2)
+	seedTodo(t, "third", "", 3)
+
+	for _, c := range []struct {
+		path    string
+		payload fiber.Map
+		want    int
+	}{
+		{"assign", fiber.Map{"email": "alice@example.com"}, 400},
+		{"assign", fiber.Map{"email": "alice@example.com", "all": true, "ids": []string{first.ID.Hex()}}, 400},
+		{"assign", fiber.Map{"email": "alice@example.com", "ids": []string{"nonsense"}}, 400},
+		{"assign", fiber.Map{"email": "nobody@example.com", "all": true}, 404},
+		{"archive", fiber.Map{}, 400},
+	} {
+		if status, res := request(t, app, "POST", "/api/admin/legacy-todos/"+c.path, admin, c.payload); status != c.want {
+			t.Errorf("%s %v: status %d, want %d: %v", c.path, c.payload, status, c.want, res)
+		}
+	}
+
+	status, res := request(t, app, "POST", "/api/admin/legacy-todos/assign", admin, fiber.Map{"email": " Alice@example.com ", "ids": []string{first.ID.Hex()}})
+	if status != 200 || res["updated"] != float64(1) {
+		t.Fatalf("assign: %d %v", status, res)
+	}
+	if status, res := request(t, app, "PATCH", "/api/todos/"+first.ID.Hex(), alice, fiber.Map{"body": "mine"}); status != 200 {
+		t.Errorf("assigned todo: %d %v", status, res)
+	}
+	status, res = request(t, app, "GET", "/api/admin/legacy-todos", admin, nil)
+	if got := bodies(t, res); status != 200 || !equalStrings(got, []string{"third", "second"}) {
+		t.Errorf("left after assigning: %d %v", status, got)
+	}
+
+	status, res = request(t, app, "POST", "/api/admin/legacy-todos/archive", admin, fiber.Map{"all": true})
+	if status != 200 || res["updated"] != float64(2) {
+		t.Fatalf("archive: %d %v", status, res)
+	}
+	status, res = request(t, app, "GET", "/api/admin/legacy-todos", admin, nil)
+	if got := bodies(t, res); status != 200 || len(got) != 0 {
+		t.Errorf("left after archiving: %d %v", status, got)
+	}
+	status, res = request(t, app, "GET", "/api/admin/legacy-todos?archived=true", admin, nil)
+	if got := bodies(t, res); status != 200 || !equalStrings(got, []string{"third", "second"}) {
+		t.Errorf("archived: %d %v", status, got)
+	}
+	// Archived todos are hidden from everyone else
+	status, res = request(t, app, "GET", "/api/todos", alice, nil)
+	if got := bodies(t, res); status != 200 || !equalStrings(got, []string{"mine"}) {
+		t.Errorf("alice's listing: %d %v", status, got)
+	}
+}
+
+func TestCheckLegacyTodosRefusesStrictMode(t *testing.T) {
+	newTestApp(t)
+	ctx := context.Background()
+	todo := seedTodo(t, "shared", "", 1)
+	if err := checkLegacyTodos(ctx); err != nil {
+		t.Errorf("shared mode: %v", err)
+	}
+
+	legacyTodosMode = legacyStrict
+	t.Cleanup(func() { legacyTodosMode = legacyShared })
+	if err := checkLegacyTodos(ctx); err == nil || !strings.Contains(err.Error(), "1 ownerless todos left") {
+		t.Errorf("strict mode with an ownerless todo: %v", err)
+	}
+	if _, err := store.ClaimOwnerlessTodos(ctx, nil, nil, todo.CreatedAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkLegacyTodos(ctx); err != nil {
+		t.Errorf("strict mode once archived: %v", err)
+	}
+}
+
+// captureStdout returns what run prints.
+func captureStdout(t *testing.T, run func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- string(out)
+	}()
+	defer func() { os.Stdout = stdout }()
+	run()
+	w.Close()
+	return <-done
+}
+
+func TestLegacyListCommandPages(t *testing.T) {
+	newTestApp(t)
+	n := maxTodoLimit + 5
+	for i := 0; i < n; i++ {
+		seedTodo(t, "old", "", i%60)
+	}
+	out := captureStdout(t, func() {
+		if err := runLegacyCommand(context.Background(), []string{"list"}); err != nil {
+			t.Error(err)
+		}
+	})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != n+1 || lines[n] != fmt.Sprintf("%d todos", n) {
+		t.Errorf("listed %d lines, last %q", len(lines), lines[len(lines)-1])
+	}
+}
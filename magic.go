@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A sign-in link carries a token signed like an access token, whose audience
+// tells the two apart. It names the user and the email it was sent to and
+// expires after magicLinkTTL. Using it counts a rate limit hit under
+// magic-used:<jti>, which keeps it from working twice.
+const magicLinkAudience = "magic-link"
+
+type magicLinkClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// Sign-in links may be requested for an email once a minute, and five times
+// until an hour passes without a request. Requests are counted as rate limit
+// hits under magic:<email>, whether or not the email has an account.
+const (
+	magicLinkInterval = time.Minute
+	magicLinkLimit    = 5
+	magicLinkWindow   = time.Hour
+)
+
+func magicLinkTTL() time.Duration {
+	return envDuration("MAGIC_LINK_EXPIRES_IN", 15*time.Minute)
+}
+
+// signMagicLink returns the token of a sign-in link for user.
+func signMagicLink(user *User, now time.Time) (string, error) {
+	return signJWT(&magicLinkClaims{
+		Email: user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        primitive.NewObjectID().Hex(),
+			Issuer:    "project-go",
+			Subject:   user.ID.Hex(),
+			Audience:  jwt.ClaimStrings{magicLinkAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(magicLinkTTL())),
+		},
+	}, now)
+}
+
+// parseMagicLink checks the signature, audience and expiry of a sign-in
+// link's token.
+func parseMagicLink(s string) (*magicLinkClaims, error) {
+	token, err := jwt.ParseWithClaims(s, &magicLinkClaims{}, jwtKeyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithAudience(magicLinkAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(*magicLinkClaims)
+	if !ok || !token.Valid || claims.ID == "" {
+		return nil, fmt.Errorf("invalid sign-in link")
+	}
+	return claims, nil
+}
+
+// magicLinkWait returns how long to wait before another link may be mailed
+// to email.
+func magicLinkWait(c *fiber.Ctx, email string, now time.Time) (time.Duration, error) {
+	found, err := store.GetRateLimits(c.Context(), []string{"magic:" + email})
+	if err != nil || len(found) == 0 || !found[0].ExpiresAt.After(now) {
+		return 0, err
+	}
+	a := found[0]
+	if a.Hits >= magicLinkLimit {
+		return a.ExpiresAt.Sub(now), nil
+	}
+	return a.LastHitAt.Add(magicLinkInterval).Sub(now), nil
+}
+
+// requestMagicLinkHandler mails a link that signs the user in without a
+// password. Like forgotPasswordHandler it answers the same whether or not
+// the email belongs to an account.
+func requestMagicLinkHandler(c *fiber.Ctx) error {
+	var payload struct {
+		Email string `json:"email"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	email := strings.ToLower(strings.TrimSpace(payload.Email))
+	if !emailRegex.MatchString(email) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid email"})
+	}
+	now := time.Now().UTC()
+	wait, err := magicLinkWait(c, email, now)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		seconds := int((wait + time.Second - 1) / time.Second)
+		c.Set(fiber.HeaderRetryAfter, fmt.Sprint(seconds))
+		return c.Status(429).JSON(fiber.Map{"error": "Too many sign-in links requested", "retryAfter": seconds})
+	}
+	if _, err := store.HitRateLimit(c.Context(), "magic:"+email, now, magicLinkWindow); err != nil {
+		return err
+	}
+	user, err := store.GetUserByEmail(c.Context(), email)
+	if err == ErrNotFound {
+		return c.JSON(fiber.Map{"success": true})
+	}
+	if err != nil {
+		return err
+	}
+	token, err := signMagicLink(user, now)
+	if err != nil {
+		return err
+	}
+	ttl := magicLinkTTL()
+	link := appURL("/magic-login?token=" + url.QueryEscape(token))
+	sendMailAsync(Mail{
+		To:      user.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Hi %s,\n\nTo sign in, open\n\n%s\n\n"+
+			"The link works once and expires in %d minutes. If you did not ask for it, you can ignore this email.\n",
+			user.Name, link, int(ttl.Minutes())),
+	})
+	return c.JSON(fiber.Map{"success": true})
+}
+
+// verifyMagicLinkHandler signs in with the token of a magic link, returning
+// what loginHandler returns. Opening the link proves the user owns the
+// email, so it also verifies it.
+func verifyMagicLinkHandler(c *fiber.Ctx) error {
+	var payload struct {
+		Token string `json:"token"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	now := time.Now().UTC()
+	claims, err := parseMagicLink(payload.Token)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid or expired sign-in link"})
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid or expired sign-in link"})
+	}
+	user, err := store.GetUserByID(c.Context(), userID)
+	if err == nil && user.Email != claims.Email {
+		err = ErrTokenUsed
+	}
+	if err == nil {
+		var use *RateLimit
+		use, err = store.HitRateLimit(c.Context(), "magic-used:"+claims.ID, now, claims.ExpiresAt.Sub(now))
+		if err == nil && use.Hits > 1 {
+			err = ErrTokenUsed
+		}
+	}
+	if err != nil {
+		if err == ErrNotFound || err == ErrTokenUsed {
+			return c.Status(401).JSON(fiber.Map{"error": "Invalid or expired sign-in link"})
+		}
+		return err
+	}
+	if user.EmailVerifiedAt == nil {
+		verifiedAt := &now
+		user, err = store.UpdateUser(c.Context(), user.ID, UserUpdate{EmailVerifiedAt: &verifiedAt, UpdatedAt: now})
+		if err != nil {
+			return err
+		}
+	}
+	// The link stands in for the password only
+	if user.TOTPEnabledAt != nil {
+		return startLoginChallenge(c, user, now)
+	}
+	token, refreshToken, err := startSession(c, user.ID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{
+		"token":        token,
+		"refreshToken": refreshToken,
+		"user":         userJSON(user),
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var magicLinkRegex = regexp.MustCompile(`/magic-login\?token=(\S+)`)
+
+// magicLinkToken returns the token of the last sign-in link mailed to email.
+func magicLinkToken(t *testing.T, mail *testMailer, email string) string {
+	t.Helper()
+	m := magicLinkRegex.FindStringSubmatch(mail.waitFor(t, email, "sign-in link").Body)
+	if m == nil {
+		t.Fatal("no link in the sign-in mail")
+	}
+	token, err := url.QueryUnescape(m[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestMagicLinkSignsInOnce(t *testing.T) {
+	app, mail := newTestApp(t)
+	_, alice := register(t, app, "Alice", "alice@example.com")
+	if status, res := request(t, app, "POST", "/api/auth/magic", "", fiber.Map{"email": "Alice@example.com"}); status != 200 {
+		t.Fatalf("request: %d %v", status, res)
+	}
+	token := magicLinkToken(t, mail, "alice@example.com")
+
+	status, res := request(t, app, "POST", "/api/auth/magic/verify", "", fiber.Map{"token": token})
+	if status != 200 || res["token"] == nil {
+		t.Fatalf("verify: %d %v", status, res)
+	}
+	user := res["user"].(map[string]interface{})
+	if user["_id"] != alice["_id"] || user["emailVerified"] != true {
+		t.Errorf("signed in as %v", user)
+	}
+	if status, res := request(t, app, "POST", "/api/auth/magic/verify", "", fiber.Map{"token": token}); status != 401 {
+		t.Errorf("second use: status %d, want 401: %v", status, res)
+	}
+}
+
+func TestMagicLinkRequestsAreLimited(t *testing.T) {
+	app, _ := newTestApp(t)
+	register(t, app, "Alice", "alice@example.com")
+	for _, email := range []string{"alice@example.com", "nobody@example.com"} {
+		if status, res := request(t, app, "POST", "/api/auth/magic", "", fiber.Map{"email": email}); status != 200 {
+			t.Fatalf("%s: %d %v", email, status, res)
+		}
+		status, res := request(t, app, "POST", "/api/auth/magic", "", fiber.Map{"email": email})
+		if status != 429 || res["retryAfter"] == nil {
+			t.Errorf("%s again within a minute: %d %v", email, status, res)
+		}
+	}
+}
+
+func TestMagicLinkIsASignedShortLivedToken(t *testing.T) {
+	app, mail := newTestApp(t)
+	access, _ := register(t, app, "Alice", "alice@example.com")
+	if status, res := request(t, app, "POST", "/api/auth/magic", "", fiber.Map{"email": "alice@example.com"}); status != 200 {
+		t.Fatalf("request: %d %v", status, res)
+	}
+	token := magicLinkToken(t, mail, "alice@example.com")
+	claims, err := parseMagicLink(token)
+	if err != nil || claims.Email != "alice@example.com" || claims.ExpiresAt.Sub(claims.IssuedAt.Time) != magicLinkTTL() {
+		t.Fatalf("claims %+v, %v", claims, err)
+	}
+
+	user, err := store.GetUserByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	expired, err := signJWT(&magicLinkClaims{Email: user.Email, RegisteredClaims: jwt.RegisteredClaims{
+		ID: "expired", Subject: user.ID.Hex(), Audience: jwt.ClaimStrings{magicLinkAudience},
+		IssuedAt: jwt.NewNumericDate(past), ExpiresAt: jwt.NewNumericDate(past.Add(magicLinkTTL())),
+	}}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, bad := range map[string]string{
+		"an access token": access,
+		"a changed token": token[:len(token)-2] + "AA",
+		"an expired link": expired,
+	} {
+		if status, res := request(t, app, "POST", "/api/auth/magic/verify", "", fiber.Map{"token": bad}); status != 401 {
+			t.Errorf("%s: %d %v", name, status, res)
+		}
+	}
+	// Nor does the link work as an access token
+	if status, res := request(t, app, "GET", "/api/auth/me", token, nil); status != 401 {
+		t.Errorf("link as an access token: %d %v", status, res)
+	}
+}
+
+func TestMagicLinkStopsWorkingWhenTheEmailChanges(t *testing.T) {
+	app, mail := newTestApp(t)
+	access, _ := register(t, app, "Alice", "alice@example.com")
+	if status, res := request(t, app, "POST", "/api/auth/magic", "", fiber.Map{"email": "alice@example.com"}); status != 200 {
+		t.Fatalf("request: %d %v", status, res)
+	}
+	token := magicLinkToken(t, mail, "alice@example.com")
+	if status, res := request(t, app, "POST", "/api/auth/change-email", access, fiber.Map{"email": "new@example.com", "password": "secret1"}); status != 200 {
+		t.Fatalf("change email: %d %v", status, res)
+	}
+	if status, res := request(t, app, "POST", "/api/auth/magic/verify", "", fiber.Map{"token": token}); status != 401 {
+		t.Errorf("link to the old email: %d %v", status, res)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Mail is a plain-text email.
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers mail to users.
+type Mailer interface {
+	Send(ctx context.Context, m Mail) error
+}
+
+var mailer Mailer
+
+// newMailer sends mail over SMTP when SMTP_HOST is set, and otherwise saves
+// it to MAIL_OUTBOX_DIR so development needs no mail server.
+func newMailer() Mailer {
+	from := envOr("MAIL_FROM", "no-reply@localhost")
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		return &smtpMailer{
+			host:     host,
+			addr:     host + ":" + envOr("SMTP_PORT", "587"),
+			username: os.Getenv("SMTP_USERNAME"),
+			password: os.Getenv("SMTP_PASSWORD"),
+			from:     from,
+		}
+	}
+	dir := envOr("MAIL_OUTBOX_DIR", "outbox")
+	if os.Getenv("ENV") == "production" {
+		log.Println("SMTP_HOST is not set; mail is only saved to", dir)
+	}
+	return &outboxMailer{dir: dir, from: from}
+}
+
+// formatMail renders m as an RFC 5322 message.
+func formatMail(from string, m Mail, now time.Time) ([]byte, error) {
+	if strings.ContainsAny(m.To+m.Subject, "\r\n") {
+		return nil, errors.New("mail header contains a line break")
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	return b.Bytes(), nil
+}
+
+// smtpMailer sends mail through an SMTP server, upgrading the connection
+// with STARTTLS when the server offers it.
+type smtpMailer struct {
+	host, addr         string
+	username, password string
+	from               string
+}
+
+func (s *smtpMailer) Send(ctx context.Context, m Mail) error {
+	msg, err := formatMail(s.from, m, time.Now())
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+	return smtp.SendMail(s.addr, auth, s.from, []string{m.To}, msg)
+}
+
+// outboxMailer writes each mail to a .eml file in dir instead of sending it.
+type outboxMailer struct {
+	dir  string
+	from string
+}
+
+func (o *outboxMailer) Send(ctx context.Context, m Mail) error {
+	now := time.Now()
+	msg, err := formatMail(o.from, m, now)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(o.dir, 0o700); err != nil {
+		return err
+	}
+	path := filepath.Join(o.dir, now.UTC().Format("20060102T150405")+"-"+primitive.NewObjectID().Hex()+".eml")
+	if err := os.WriteFile(path, msg, 0o600); err != nil {
+		return err
+	}
+	log.Printf("mail to %s saved to %s", m.To, path)
+	return nil
+}
+
+// sendMailAsync sends m in the background, so that how long delivery takes
+// does not reveal to the caller whether an account exists.
+func sendMailAsync(m Mail) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := mailer.Send(ctx, m); err != nil {
+			log.Printf("Error sending %q to %s: %v", m.Subject, m.To, err)
+		}
+	}()
+}
+
+// appURL links to a page of the web client.
+func appURL(path string) string {
+	return strings.TrimRight(envOr("APP_URL", "http://localhost:5173"), "/") + path
+}
+
+// securityNotice tells user about a change to their account's security. undo
+// says how to revert the change, e.g. "remove it on your profile page", and
+// may be empty; either way the mail points at a password reset in case the
+// change was not theirs.
+func securityNotice(user *User, subject, change, undo string) Mail {
+	advice := "reset your password right away"
+	if undo != "" {
+		advice = undo + " and reset your password"
+	}
+	return Mail{
+		To:      user.Email,
+		Subject: subject,
+		Body:    fmt.Sprintf("Hi %s,\n\n%s\n\nIf this was not you, %s at\n\n%s\n", user.Name, change, advice, appURL("/")),
+	}
+}
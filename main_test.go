@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	if err := initJWTKeys(); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// testMailer keeps the mail it is asked to send.
+type testMailer struct {
+	mu   sync.Mutex
+	sent []Mail
+}
+
+func (m *testMailer) Send(ctx context.Context, mail Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, mail)
+	return nil
+}
+
+// waitFor returns the latest mail to to whose subject contains subject.
+// Mail is sent in the background, so it waits for it a little.
+func (m *testMailer) waitFor(t *testing.T, to, subject string) Mail {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		m.mu.Lock()
+		for i := len(m.sent) - 1; i >= 0; i-- {
+			if mail := m.sent[i]; mail.To == to && strings.Contains(mail.Subject, subject) {
+				m.mu.Unlock()
+				return mail
+			}
+		}
+		m.mu.Unlock()
+	}
+	t.Fatalf("no mail %q to %s", subject, to)
+	return Mail{}
+}
+
+// newTestApp points the store and the mailer at fresh in-memory ones and
+// returns the app.
+func newTestApp(t *testing.T) (*fiber.App, *testMailer) {
+	t.Helper()
+	store = newMemoryStore()
+	mail := &testMailer{}
+	mailer = mail
+	return newApp(), mail
+}
+
+// request sends body as JSON, unless it is nil, with token as the bearer
+// token, unless it is empty. It returns the status and the decoded JSON
+// object the app answered with, if any.
+func request(t *testing.T, app *fiber.App, method, path, token string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var out map[string]interface{}
+	_ = json.NewDecoder(res.Body).Decode(&out)
+	return res.StatusCode, out
+}
+
+// register signs up a user with the password "secret1" and returns their
+// access token and user.
+func register(t *testing.T, app *fiber.App, name, email string) (string, map[string]interface{}) {
+	t.Helper()
+	status, res := request(t, app, "POST", "/api/auth/register", "", fiber.Map{"name": name, "email": email, "password": "secret1"})
+	if status != 201 {
+		t.Fatalf("register %s: %d %v", email, status, res)
+	}
+	return res["token"].(string), res["user"].(map[string]interface{})
+}
+
+// items returns the items of a listing response.
+func items(t *testing.T, res map[string]interface{}) []map[string]interface{} {
+	t.Helper()
+	raw, ok := res["items"].([]interface{})
+	if !ok {
+		t.Fatalf("no items in %v", res)
+	}
+	out := make([]map[string]interface{}, len(raw))
+	for i, item := range raw {
+		out[i] = item.(map[string]interface{})
+	}
+	return out
+}
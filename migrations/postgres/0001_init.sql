@@ -0,0 +1,42 @@
+-- Users, todos and the per-user starredBy wishlist.
+-- Ids are the hex form of the ObjectIDs used by the Mongo backend so records
+-- can move between backends unchanged.
+
+CREATE TABLE users (
+    id            text PRIMARY KEY,
+    name          text NOT NULL,
+    username      text NOT NULL DEFAULT '',
+    avatar        text NOT NULL DEFAULT '',
+    email         text NOT NULL,
+    password_hash text NOT NULL,
+    created_at    timestamptz NOT NULL,
+    updated_at    timestamptz NOT NULL,
+    CONSTRAINT users_email_key UNIQUE (email)
+);
+
+CREATE TABLE todos (
+    id           text PRIMARY KEY,
+    body         text NOT NULL,
+    completed    boolean NOT NULL DEFAULT false,
+    starred      boolean NOT NULL DEFAULT false,
+    priority     text NOT NULL DEFAULT '',
+    due_date     timestamptz,
+    created_at   timestamptz NOT NULL,
+    updated_at   timestamptz NOT NULL,
+    completed_at timestamptz,
+    -- NULL for todos created before auth
+    owner_id     text REFERENCES users (id) ON DELETE SET NULL
+);
+
+-- Listing is always newest first, either across all todos or scoped to an owner.
+CREATE INDEX todos_created_at_idx ON todos (created_at DESC, id DESC);
+CREATE INDEX todos_owner_created_at_idx ON todos (owner_id, created_at DESC, id DESC);
+
+CREATE TABLE todo_stars (
+    todo_id    text NOT NULL REFERENCES todos (id) ON DELETE CASCADE,
+    user_id    text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    starred_at timestamptz NOT NULL,
+    PRIMARY KEY (todo_id, user_id)
+);
+
+CREATE INDEX todo_stars_user_idx ON todo_stars (user_id);
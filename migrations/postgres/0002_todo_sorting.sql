@@ -0,0 +1,15 @@
+-- Server-side sorting. priority_rank orders priorities by importance
+-- (high > medium > low > none) instead of alphabetically.
+
+ALTER TABLE todos ADD COLUMN priority_rank smallint NOT NULL GENERATED ALWAYS AS (
+    CASE priority WHEN 'high' THEN 3 WHEN 'medium' THEN 2 WHEN 'low' THEN 1 ELSE 0 END
+) STORED;
+
+-- One index per sort order a listing resumes from, scoped to the owner.
+-- Nullable dates sort NULLS LAST in both directions, so each index matches
+-- the direction the client uses most: due soonest first, completed most
+-- recently first. Other orders scan these backwards.
+CREATE INDEX todos_owner_updated_at_idx ON todos (owner_id, updated_at DESC, id DESC);
+CREATE INDEX todos_owner_due_date_idx ON todos (owner_id, due_date ASC NULLS LAST, id ASC);
+CREATE INDEX todos_owner_completed_at_idx ON todos (owner_id, completed_at DESC NULLS LAST, id DESC);
+CREATE INDEX todos_owner_priority_rank_idx ON todos (owner_id, priority_rank DESC, id DESC);
@@ -0,0 +1,11 @@
+-- Full-text search over body, tags and notes. search_terms holds the words
+-- and word prefixes of all three, computed by the server (todoSearchTerms)
+-- so every backend splits text into words the same way. Rows stored before
+-- this migration are filled in on startup.
+
+ALTER TABLE todos
+    ADD COLUMN tags text[] NOT NULL DEFAULT '{}',
+    ADD COLUMN notes text NOT NULL DEFAULT '',
+    ADD COLUMN search_terms text[];
+
+CREATE INDEX todos_search_terms_idx ON todos USING gin (search_terms);
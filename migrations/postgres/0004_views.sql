@@ -0,0 +1,14 @@
+-- Saved views: named todo filters per user. The filter is stored as the same
+-- JSON document the API exchanges (ViewFilter).
+
+CREATE TABLE views (
+    id         text PRIMARY KEY,
+    owner_id   text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name       text NOT NULL,
+    filter     jsonb NOT NULL DEFAULT '{}',
+    sort       text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL,
+    updated_at timestamptz NOT NULL
+);
+
+CREATE INDEX views_owner_idx ON views (owner_id, id);
@@ -0,0 +1,6 @@
+-- Ownerless todos (created before auth) can be archived instead of being
+-- given to a user. Archived todos are hidden from every listing.
+
+ALTER TABLE todos ADD COLUMN archived_at timestamptz;
+
+CREATE INDEX todos_ownerless_idx ON todos (created_at DESC, id DESC) WHERE owner_id IS NULL;
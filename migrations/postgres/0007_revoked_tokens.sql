@@ -0,0 +1,10 @@
+-- Revoked access tokens, keyed by jti or by "user:<id>" for every token of a
+-- user. Rows are only needed until the tokens expire and are deleted then.
+
+CREATE TABLE revoked_tokens (
+    key        text PRIMARY KEY,
+    revoked_at timestamptz NOT NULL,
+    expires_at timestamptz NOT NULL
+);
+
+CREATE INDEX revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);
@@ -0,0 +1,14 @@
+-- Signed-in devices. A session's id is the family_id of its refresh tokens.
+
+CREATE TABLE sessions (
+    id           text PRIMARY KEY,
+    user_id      text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    device       text NOT NULL DEFAULT '',
+    ip           text NOT NULL DEFAULT '',
+    created_at   timestamptz NOT NULL,
+    last_seen_at timestamptz NOT NULL,
+    expires_at   timestamptz NOT NULL
+);
+
+CREATE INDEX sessions_user_idx ON sessions (user_id, last_seen_at DESC);
+CREATE INDEX sessions_expires_at_idx ON sessions (expires_at);
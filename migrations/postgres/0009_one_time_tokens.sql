@@ -0,0 +1,16 @@
+-- Single-use tokens mailed to users, such as password reset links, stored as
+-- SHA-256 hashes.
+
+CREATE TABLE one_time_tokens (
+    id         text PRIMARY KEY,
+    user_id    text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    purpose    text NOT NULL,
+    token_hash text NOT NULL,
+    created_at timestamptz NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at    timestamptz,
+    CONSTRAINT one_time_tokens_token_hash_key UNIQUE (token_hash)
+);
+
+CREATE INDEX one_time_tokens_user_idx ON one_time_tokens (user_id, purpose);
+CREATE INDEX one_time_tokens_expires_at_idx ON one_time_tokens (expires_at);
@@ -0,0 +1,9 @@
+-- Email verification: when each user proved they own their address, and the
+-- address a one-time token was sent to.
+
+ALTER TABLE users ADD COLUMN email_verified_at timestamptz;
+
+-- Users who signed up before email verification existed count as verified
+UPDATE users SET email_verified_at = created_at;
+
+ALTER TABLE one_time_tokens ADD COLUMN email text NOT NULL DEFAULT '';
@@ -0,0 +1,22 @@
+-- Failed login counters per account and IP address, and the audit log of
+-- security events such as lockouts.
+
+CREATE TABLE login_attempts (
+    key             text PRIMARY KEY,
+    failures        integer NOT NULL,
+    last_failure_at timestamptz NOT NULL,
+    expires_at      timestamptz NOT NULL
+);
+
+CREATE INDEX login_attempts_expires_at_idx ON login_attempts (expires_at);
+
+-- user_id has no foreign key: entries outlive deleted users
+CREATE TABLE audit_log (
+    id         text PRIMARY KEY,
+    action     text NOT NULL,
+    user_id    text,
+    subject    text NOT NULL,
+    ip         text NOT NULL DEFAULT '',
+    details    text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL
+);
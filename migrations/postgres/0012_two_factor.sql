@@ -0,0 +1,8 @@
+-- TOTP two-factor authentication: the authenticator key, when 2FA was turned
+-- on, the last time step accepted and hashes of the unused recovery codes.
+
+ALTER TABLE users
+    ADD COLUMN totp_secret     text NOT NULL DEFAULT '',
+    ADD COLUMN totp_enabled_at timestamptz,
+    ADD COLUMN totp_last_step  bigint NOT NULL DEFAULT 0,
+    ADD COLUMN recovery_codes  text[] NOT NULL DEFAULT '{}';
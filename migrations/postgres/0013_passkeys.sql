@@ -0,0 +1,25 @@
+-- Passkeys (WebAuthn credentials). Passkey ceremonies keep their state in
+-- one_time_tokens, where a login challenge has no user yet.
+
+CREATE TABLE passkeys (
+    id               text PRIMARY KEY,
+    user_id          text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name             text NOT NULL,
+    credential_id    bytea NOT NULL,
+    public_key       bytea NOT NULL,
+    attestation_type text NOT NULL,
+    transports       text[] NOT NULL DEFAULT '{}',
+    aaguid           bytea,
+    sign_count       bigint NOT NULL DEFAULT 0,
+    backup_eligible  boolean NOT NULL DEFAULT false,
+    backup_state     boolean NOT NULL DEFAULT false,
+    created_at       timestamptz NOT NULL,
+    last_used_at     timestamptz,
+    CONSTRAINT passkeys_credential_id_key UNIQUE (credential_id)
+);
+
+CREATE INDEX passkeys_user_idx ON passkeys (user_id);
+
+ALTER TABLE one_time_tokens
+    ALTER COLUMN user_id DROP NOT NULL,
+    ADD COLUMN data text NOT NULL DEFAULT '';
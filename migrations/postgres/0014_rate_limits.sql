@@ -0,0 +1,7 @@
+-- login_attempts also limits magic links now, so it counts hits rather than
+-- failures.
+
+ALTER TABLE login_attempts RENAME TO rate_limits;
+ALTER TABLE rate_limits RENAME COLUMN failures TO hits;
+ALTER TABLE rate_limits RENAME COLUMN last_failure_at TO last_hit_at;
+ALTER INDEX login_attempts_expires_at_idx RENAME TO rate_limits_expires_at_idx;
@@ -0,0 +1,14 @@
+-- Accounts at OpenID Connect providers that users sign in with.
+
+CREATE TABLE identities (
+    id           text PRIMARY KEY,
+    user_id      text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    provider     text NOT NULL,
+    subject      text NOT NULL,
+    email        text NOT NULL DEFAULT '',
+    created_at   timestamptz NOT NULL,
+    last_used_at timestamptz,
+    CONSTRAINT identities_provider_subject_key UNIQUE (provider, subject)
+);
+
+CREATE INDEX identities_user_idx ON identities (user_id);
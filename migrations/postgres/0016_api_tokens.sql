@@ -0,0 +1,16 @@
+-- Personal access tokens for scripts. Only a hash of each token is stored.
+
+CREATE TABLE api_tokens (
+    id           text PRIMARY KEY,
+    user_id      text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name         text NOT NULL,
+    scopes       text[] NOT NULL DEFAULT '{}',
+    token_hash   text NOT NULL UNIQUE,
+    hint         text NOT NULL DEFAULT '',
+    created_at   timestamptz NOT NULL,
+    expires_at   timestamptz NOT NULL,
+    last_used_at timestamptz
+);
+
+CREATE INDEX api_tokens_user_idx ON api_tokens (user_id);
+CREATE INDEX api_tokens_expires_at_idx ON api_tokens (expires_at);
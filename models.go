@@ -9,15 +9,18 @@ import (
 type Todo struct {
 	ID          primitive.ObjectID  `json:"_id" bson:"_id,omitempty"`
 	Body        string              `json:"body" bson:"body"`
+	Tags        []string            `json:"tags,omitempty" bson:"tags,omitempty"`
+	Notes       string              `json:"notes,omitempty" bson:"notes,omitempty"`
 	Completed   bool                `json:"completed" bson:"completed"`
 	Starred     bool                `json:"starred,omitempty" bson:"starred,omitempty"`
-	StarredBy   []primitive.ObjectID `json:"starredBy,omitempty" bson:"starredBy,omitempty"`
+	StarredBy   []primitive.ObjectID `json:"-" bson:"starredBy,omitempty"`
 	Priority    string              `json:"priority,omitempty" bson:"priority,omitempty"`
 	DueDate     *time.Time          `json:"dueDate,omitempty" bson:"dueDate,omitempty"`
 	CreatedAt   time.Time           `json:"createdAt" bson:"createdAt"`
 	UpdatedAt   time.Time           `json:"updatedAt" bson:"updatedAt"`
 	CompletedAt *time.Time          `json:"completedAt,omitempty" bson:"completedAt,omitempty"`
 	OwnerID     *primitive.ObjectID `json:"ownerId,omitempty" bson:"ownerId,omitempty"`
+	ArchivedAt  *time.Time          `json:"archivedAt,omitempty" bson:"archivedAt,omitempty"`
 }
 
 type User struct {
@@ -29,4 +32,174 @@ type User struct {
 	PasswordHash string             `json:"-" bson:"passwordHash"`
 	CreatedAt    time.Time          `json:"createdAt" bson:"createdAt"`
 	UpdatedAt    time.Time          `json:"updatedAt" bson:"updatedAt"`
+	// EmailVerifiedAt is when the user proved they own Email; nil until then.
+	// It is stored even when nil: users from before email verification lack
+	// it, and the stores count them as verified.
+	EmailVerifiedAt *time.Time `json:"-" bson:"emailVerifiedAt"`
+	// TOTPSecret is the base32 key shared with the user's authenticator app.
+	// 2FA setup stores it, but logins only ask for a code once TOTPEnabledAt
+	// is set.
+	TOTPSecret    string     `json:"-" bson:"totpSecret,omitempty"`
+	TOTPEnabledAt *time.Time `json:"-" bson:"totpEnabledAt,omitempty"`
+	// TOTPLastStep is the time step of the last code accepted; codes of that
+	// step or earlier are refused, so each code works once.
+	TOTPLastStep int64 `json:"-" bson:"totpLastStep,omitempty"`
+	// RecoveryCodes holds hashes of the unused recovery codes.
+	RecoveryCodes []string `json:"-" bson:"recoveryCodes,omitempty"`
+}
+
+// RefreshToken is a long-lived credential that /api/auth/refresh trades for a
+// new access token. Each refresh replaces it with a new token of the same
+// family, which starts at login. Only a hash of the token is stored.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userId"`
+	FamilyID  primitive.ObjectID `bson:"familyId"`
+	TokenHash string             `bson:"tokenHash"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+	// UsedAt is set once the token has been traded for a new one.
+	UsedAt    *time.Time `bson:"usedAt,omitempty"`
+	RevokedAt *time.Time `bson:"revokedAt,omitempty"`
+}
+
+// Session is one signed-in device. Its ID is the family of the refresh
+// tokens issued since that login.
+type Session struct {
+	ID         primitive.ObjectID `json:"_id" bson:"_id"`
+	UserID     primitive.ObjectID `json:"-" bson:"userId"`
+	Device     string             `json:"device" bson:"device"`
+	IP         string             `json:"ip" bson:"ip"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+	LastSeenAt time.Time          `json:"lastSeenAt" bson:"lastSeenAt"`
+	// ExpiresAt is when the session's current refresh token expires.
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+}
+
+// RevokedToken marks access tokens as revoked until they would have expired
+// anyway: one token by its jti, or every token of a session (see
+// sessionRevocationKey).
+type RevokedToken struct {
+	Key       string    `bson:"_id"`
+	RevokedAt time.Time `bson:"revokedAt"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// OneTimeToken is a single-use secret mailed to a user, such as a password
+// reset link. Only a hash of the token is stored.
+type OneTimeToken struct {
+	ID primitive.ObjectID `bson:"_id,omitempty"`
+	// UserID is zero for tokens that are not tied to a user yet, such as
+	// the challenge of a passkey login.
+	UserID    primitive.ObjectID `bson:"userId"`
+	Purpose   string             `bson:"purpose"`
+	TokenHash string             `bson:"tokenHash"`
+	CreatedAt time.Time          `bson:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+	UsedAt    *time.Time         `bson:"usedAt,omitempty"`
+	// Email is the address the token was sent to.
+	Email string `bson:"email,omitempty"`
+	// Data holds state of the token's purpose, e.g. a passkey ceremony.
+	Data string `bson:"data,omitempty"`
+}
+
+// Passkey is a WebAuthn credential a user can log in with. The private key
+// stays on the user's authenticator.
+type Passkey struct {
+	ID     primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	UserID primitive.ObjectID `json:"-" bson:"userId"`
+	// Name tells the user's passkeys apart, e.g. "Chrome on macOS".
+	Name         string `json:"name" bson:"name"`
+	CredentialID []byte `json:"-" bson:"credentialId"`
+	// PublicKey is COSE encoded.
+	PublicKey       []byte   `json:"-" bson:"publicKey"`
+	AttestationType string   `json:"-" bson:"attestationType"`
+	Transports      []string `json:"-" bson:"transports,omitempty"`
+	AAGUID          []byte   `json:"-" bson:"aaguid,omitempty"`
+	// SignCount is the authenticator's signature counter at the last login.
+	// Authenticators that do not count always report zero.
+	SignCount      uint32     `json:"-" bson:"signCount"`
+	BackupEligible bool       `json:"-" bson:"backupEligible"`
+	BackupState    bool       `json:"synced" bson:"backupState"`
+	CreatedAt      time.Time  `json:"createdAt" bson:"createdAt"`
+	LastUsedAt     *time.Time `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+}
+
+// Identity links a user to their account at an OpenID Connect provider.
+type Identity struct {
+	ID     primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	UserID primitive.ObjectID `json:"-" bson:"userId"`
+	// Provider is the provider's id in OIDC_PROVIDERS, and Subject the
+	// user's "sub" there, which stays the same when their email changes.
+	Provider string `json:"provider" bson:"provider"`
+	Subject  string `json:"-" bson:"subject"`
+	// Email is the email the provider gave at the last login.
+	Email      string     `json:"email" bson:"email"`
+	CreatedAt  time.Time  `json:"createdAt" bson:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+}
+
+// APIToken is a personal access token a user made for scripts. It only
+// opens the routes of its scopes. Only a hash of the token is stored.
+type APIToken struct {
+	ID        primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"-" bson:"userId"`
+	Name      string             `json:"name" bson:"name"`
+	Scopes    []string           `json:"scopes" bson:"scopes"`
+	TokenHash string             `json:"-" bson:"tokenHash"`
+	// Hint is the start of the token, to tell tokens apart.
+	Hint       string     `json:"hint" bson:"hint"`
+	CreatedAt  time.Time  `json:"createdAt" bson:"createdAt"`
+	ExpiresAt  time.Time  `json:"expiresAt" bson:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+}
+
+// RateLimit counts recent hits of something limited, named by Key: failed
+// logins of an account or IP address (see loginAttemptKeys), magic links
+// sent to an email address and the uses of each link, or password resets
+// asked for an email or from an IP address.
+type RateLimit struct {
+	Key       string    `bson:"_id"`
+	Hits      int       `bson:"hits"`
+	LastHitAt time.Time `bson:"lastHitAt"`
+	// ExpiresAt is when the hits are forgotten.
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// AuditEntry records a security event, such as an account being locked.
+type AuditEntry struct {
+	ID     primitive.ObjectID  `json:"_id" bson:"_id,omitempty"`
+	Action string              `json:"action" bson:"action"`
+	UserID *primitive.ObjectID `json:"userId,omitempty" bson:"userId,omitempty"`
+	// Subject is what the event is about, e.g. an email or an IP address.
+	Subject   string    `json:"subject" bson:"subject"`
+	IP        string    `json:"ip,omitempty" bson:"ip,omitempty"`
+	Details   string    `json:"details,omitempty" bson:"details,omitempty"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// View is a named todo filter saved by a user.
+type View struct {
+	ID        primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	OwnerID   primitive.ObjectID `json:"ownerId" bson:"ownerId"`
+	Name      string             `json:"name" bson:"name"`
+	Filter    ViewFilter         `json:"filter" bson:"filter"`
+	Sort      string             `json:"sort,omitempty" bson:"sort,omitempty"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// ViewFilter is what a View selects. Fields take the values of the matching
+// q clauses; due bounds like "today" or "7d" are resolved each time the view
+// is listed.
+type ViewFilter struct {
+	Status    string `json:"status,omitempty" bson:"status,omitempty"`
+	Priority  string `json:"priority,omitempty" bson:"priority,omitempty"`
+	Search    string `json:"search,omitempty" bson:"search,omitempty"`
+	DueAfter  string `json:"dueAfter,omitempty" bson:"dueAfter,omitempty"`
+	DueBefore string `json:"dueBefore,omitempty" bson:"dueBefore,omitempty"`
+	NoDueDate bool   `json:"noDueDate,omitempty" bson:"noDueDate,omitempty"`
+	Starred   bool   `json:"starred,omitempty" bson:"starred,omitempty"`
+	// Query is a q expression for anything the other fields cannot express.
+	Query string `json:"q,omitempty" bson:"q,omitempty"`
 }
@@ -0,0 +1,633 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Users can sign in with the OpenID Connect providers named in
+// OIDC_PROVIDERS, e.g. "google,work". Each is configured by
+// OIDC_<ID>_ISSUER, OIDC_<ID>_CLIENT_ID and OIDC_<ID>_CLIENT_SECRET, and
+// optionally OIDC_<ID>_NAME and OIDC_<ID>_SCOPES. The login uses the
+// authorization code flow with PKCE; the provider redirects the browser to
+// OIDC_REDIRECT_URL, whose page posts the code to /api/auth/oidc/callback.
+
+const (
+	purposeOIDCLogin = "oidc_login"
+	oidcLoginTTL     = 10 * time.Minute
+	// Endpoints found by discovery are fetched again after a day, and the
+	// keys when a token is signed by an unknown one, at most once a minute.
+	oidcDiscoveryTTL   = 24 * time.Hour
+	oidcKeysMinRefresh = time.Minute
+)
+
+var (
+	oidcProviders   map[string]*oidcProvider
+	oidcProviderIDs []string
+	oidcHTTPClient  = &http.Client{Timeout: 10 * time.Second}
+)
+
+type oidcProvider struct {
+	ID           string
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       string
+
+	mu           sync.Mutex
+	discovery    *oidcDiscovery
+	discoveredAt time.Time
+	keys         []jwk
+	keysAt       time.Time
+}
+
+// oidcDiscovery is the part of a provider's discovery document the login
+// needs (OpenID Connect Discovery 1.0, section 3).
+type oidcDiscovery struct {
+	Issuer                   string   `json:"issuer"`
+	AuthorizationEndpoint    string   `json:"authorization_endpoint"`
+	TokenEndpoint            string   `json:"token_endpoint"`
+	JWKSURI                  string   `json:"jwks_uri"`
+	IDTokenSigningAlgs       []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethods []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// loadOIDCProviders reads the providers in OIDC_PROVIDERS. Their discovery
+// documents are only fetched when first needed, so a provider that is down
+// does not stop the server.
+func loadOIDCProviders() (map[string]*oidcProvider, []string, error) {
+	providers := map[string]*oidcProvider{}
+	var ids []string
+	for _, id := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if id == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(id, "-", "_")) + "_"
+		p := &oidcProvider{
+			ID:           id,
+			Name:         envOr(prefix+"NAME", id),
+			Issuer:       strings.TrimRight(os.Getenv(prefix+"ISSUER"), "/"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       envOr(prefix+"SCOPES", "openid email profile"),
+		}
+		if p.Issuer == "" || p.ClientID == "" {
+			return nil, nil, fmt.Errorf("OIDC provider %q needs %sISSUER and %sCLIENT_ID", id, prefix, prefix)
+		}
+		if _, ok := providers[id]; ok {
+			return nil, nil, fmt.Errorf("OIDC provider %q is listed twice", id)
+		}
+		providers[id] = p
+		ids = append(ids, id)
+	}
+	return providers, ids, nil
+}
+
+func oidcRedirectURL() string {
+	return envOr("OIDC_REDIRECT_URL", appURL("/oidc/callback"))
+}
+
+// oidcGetJSON fetches url and decodes its JSON body into v.
+func oidcGetJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
+
+// discover returns the provider's endpoints. The document must name the
+// configured issuer, so a misconfigured URL cannot hand logins to another.
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil && time.Since(p.discoveredAt) < oidcDiscoveryTTL {
+		return p.discovery, nil
+	}
+	var d oidcDiscovery
+	if err := oidcGetJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(d.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("discovery document of %s names issuer %q", p.Issuer, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document of %s lacks endpoints", p.Issuer)
+	}
+	p.discovery, p.discoveredAt = &d, time.Now()
+	return &d, nil
+}
+
+// publicKey returns the key the provider signed a token with, fetching the
+// key set again when kid is not in it.
+func (p *oidcProvider) publicKey(ctx context.Context, d *oidcDiscovery, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k := findJWK(p.keys, kid); k != nil {
+		return k.publicKey()
+	}
+	if time.Since(p.keysAt) < oidcKeysMinRefresh {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := oidcGetJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	p.keys, p.keysAt = set.Keys, time.Now()
+	if k := findJWK(p.keys, kid); k != nil {
+		return k.publicKey()
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// findJWK returns the signing key named kid. Tokens without a kid may only
+// come from a provider with a single signing key.
+func findJWK(keys []jwk, kid string) *jwk {
+	var found *jwk
+	for i := range keys {
+		if keys[i].Use != "" && keys[i].Use != "sig" {
+			continue
+		}
+		if kid != "" {
+			if keys[i].Kid == kid {
+				return &keys[i]
+			}
+			continue
+		}
+		if found != nil {
+			return nil
+		}
+		found = &keys[i]
+	}
+	return found
+}
+
+// publicKey decodes j's RSA, EC or Ed25519 public key.
+func (j jwk) publicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch j.Kty {
+	case "RSA":
+		n, err := decode(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(j.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		var check ecdh.Curve
+		switch j.Crv {
+		case "P-256":
+			curve, check = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, check = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, check = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("invalid EC key")
+		}
+		// ecdh refuses points that are not on the curve
+		if _, err := check.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if j.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid OKP key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+}
+
+// oidcClaims are the ID token claims the login uses.
+type oidcClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string      `json:"nonce"`
+	AuthorizedParty   string      `json:"azp"`
+	Email             string      `json:"email"`
+	EmailVerified     interface{} `json:"email_verified"`
+	Name              string      `json:"name"`
+	PreferredUsername string      `json:"preferred_username"`
+}
+
+// emailVerified reports whether the provider vouches for Email. Some
+// providers send the claim as a string.
+func (c *oidcClaims) emailVerified() bool {
+	switch v := c.EmailVerified.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// oidcSigningAlgs are the ID token algorithms accepted; "none" and the
+// HMAC ones, keyed with the client secret, are not.
+var oidcSigningAlgs = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// verifyIDToken checks the signature and claims of an ID token the token
+// endpoint returned for the login that sent nonce.
+func (p *oidcProvider) verifyIDToken(ctx context.Context, d *oidcDiscovery, raw, nonce string) (*oidcClaims, error) {
+	var algs []string
+	for _, alg := range d.IDTokenSigningAlgs {
+		for _, ok := range oidcSigningAlgs {
+			if alg == ok {
+				algs = append(algs, alg)
+			}
+		}
+	}
+	if len(algs) == 0 {
+		algs = []string{"RS256"}
+	}
+	var claims oidcClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.publicKey(ctx, d, kid)
+	},
+		jwt.WithValidMethods(algs),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	if (len(claims.Audience) > 1 || claims.AuthorizedParty != "") && claims.AuthorizedParty != p.ClientID {
+		return nil, fmt.Errorf("ID token was issued to %q", claims.AuthorizedParty)
+	}
+	return &claims, nil
+}
+
+// exchangeCode trades an authorization code for the ID token.
+func (p *oidcProvider) exchangeCode(ctx context.Context, d *oidcDiscovery, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {oidcRedirectURL()},
+		"code_verifier": {verifier},
+		"client_id":     {p.ClientID},
+	}
+	// client_secret_basic is the default; use client_secret_post only for
+	// providers that do not support it
+	basic := len(d.TokenEndpointAuthMethods) == 0
+	for _, m := range d.TokenEndpointAuthMethods {
+		basic = basic || m == "client_secret_basic"
+	}
+	if !basic && p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if basic && p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	res, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("token endpoint: %s: %v", res.Status, err)
+	}
+	if res.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("token endpoint: %s: %s %s", res.Status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token endpoint returned no ID token")
+	}
+	return body.IDToken, nil
+}
+
+// oidcLogin is the state of a login kept until the provider redirects back.
+type oidcLogin struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+func listOIDCProvidersHandler(c *fiber.Ctx) error {
+	items := []fiber.Map{}
+	for _, id := range oidcProviderIDs {
+		items = append(items, fiber.Map{"id": id, "name": oidcProviders[id].Name})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// beginOIDCLoginHandler returns the provider URL to send the browser to.
+// The client keeps the returned state, and only posts a code back if the
+// redirect carries the same one, so a link cannot sign it in to someone
+// else's account.
+func beginOIDCLoginHandler(c *fiber.Ctx) error {
+	p, ok := oidcProviders[c.Params("provider")]
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Unknown provider"})
+	}
+	d, err := p.discover(c.Context())
+	if err != nil {
+		log.Printf("oidc %s: %v", p.ID, err)
+		return c.Status(502).JSON(fiber.Map{"error": p.Name + " is not reachable"})
+	}
+	state, stateHash, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	nonce, _, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	verifier, _, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(oidcLogin{Provider: p.ID, Nonce: nonce, Verifier: verifier})
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	err = store.CreateOneTimeToken(c.Context(), &OneTimeToken{
+		Purpose:   purposeOIDCLogin,
+		TokenHash: stateHash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(oidcLoginTTL),
+		Data:      string(data),
+	})
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", oidcRedirectURL())
+	q.Set("scope", p.Scopes)
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return c.JSON(fiber.Map{"url": u.String(), "state": state})
+}
+
+// oidcCallbackHandler finishes the login with the code the provider
+// redirected back with, returning what loginHandler returns. A provider
+// account not seen before is linked to the user with the same email, or
+// gets a new user, but only if the provider verified the email.
+func oidcCallbackHandler(c *fiber.Ctx) error {
+	var payload struct {
+		State string `json:"state"`
+		Code  string `json:"code"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	now := time.Now().UTC()
+	pending, err := store.GetOneTimeToken(c.Context(), purposeOIDCLogin, hashSecretToken(payload.State))
+	if err == ErrNotFound || (err == nil && !now.Before(pending.ExpiresAt)) {
+		err = ErrTokenUsed
+	}
+	if err == nil {
+		err = store.UseOneTimeToken(c.Context(), pending.ID, now)
+	}
+	if err != nil {
+		if err == ErrTokenUsed {
+			return c.Status(401).JSON(fiber.Map{"error": "Invalid or expired sign-in attempt"})
+		}
+		return err
+	}
+	var login oidcLogin
+	if err := json.Unmarshal([]byte(pending.Data), &login); err != nil {
+		return err
+	}
+	p, ok := oidcProviders[login.Provider]
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid or expired sign-in attempt"})
+	}
+	d, err := p.discover(c.Context())
+	if err != nil {
+		log.Printf("oidc %s: %v", p.ID, err)
+		return c.Status(502).JSON(fiber.Map{"error": p.Name + " is not reachable"})
+	}
+	rawIDToken, err := p.exchangeCode(c.Context(), d, payload.Code, login.Verifier)
+	var claims *oidcClaims
+	if err == nil {
+		claims, err = p.verifyIDToken(c.Context(), d, rawIDToken, login.Nonce)
+	}
+	if err != nil {
+		log.Printf("oidc %s: %v", p.ID, err)
+		return c.Status(401).JSON(fiber.Map{"error": "Sign-in with " + p.Name + " failed"})
+	}
+	email := strings.ToLower(strings.TrimSpace(claims.Email))
+
+	var user *User
+	identity, err := store.GetIdentity(c.Context(), p.ID, claims.Subject)
+	switch {
+	case err == nil:
+		if user, err = store.GetUserByID(c.Context(), identity.UserID); err != nil {
+			return err
+		}
+		if err := store.UseIdentity(c.Context(), identity.ID, email, now); err != nil {
+			return err
+		}
+	case err != ErrNotFound:
+		return err
+	case !claims.emailVerified() || !emailRegex.MatchString(email):
+		return c.Status(403).JSON(fiber.Map{"error": p.Name + " did not confirm your email address"})
+	default:
+		if user, err = linkOIDCIdentity(c, p, claims, email, now); user == nil {
+			return err
+		}
+	}
+	// The provider stands in for the password only
+	if user.TOTPEnabledAt != nil {
+		return startLoginChallenge(c, user, now)
+	}
+	token, refreshToken, err := startSession(c, user.ID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{
+		"token":        token,
+		"refreshToken": refreshToken,
+		"user":         userJSON(user),
+	})
+}
+
+// linkOIDCIdentity links a new provider account to the user with its
+// verified email, or creates that user. An account whose owner never
+// verified the email is not linked, as whoever registered it may not own
+// the address. Like currentUser it sends the error response itself and
+// returns a nil user then.
+func linkOIDCIdentity(c *fiber.Ctx, p *oidcProvider, claims *oidcClaims, email string, now time.Time) (*User, error) {
+	action := "oidc_linked"
+	user, err := store.GetUserByEmail(c.Context(), email)
+	switch {
+	case err == ErrNotFound:
+		name := strings.TrimSpace(claims.Name)
+		if len(name) < 2 {
+			name = strings.TrimSpace(claims.PreferredUsername)
+		}
+		if len(name) < 2 {
+			name = email[:strings.IndexByte(email, '@')]
+		}
+		user = newUser(name, claims.PreferredUsername, email, "", now)
+		user.EmailVerifiedAt = &now
+		if err := store.CreateUser(c.Context(), user); err != nil {
+			if err == ErrDuplicateEmail {
+				return nil, c.Status(409).JSON(fiber.Map{"error": "Email already registered"})
+			}
+			return nil, err
+		}
+		action = "oidc_signup"
+	case err != nil:
+		return nil, err
+	case user.EmailVerifiedAt == nil:
+		return nil, c.Status(409).JSON(fiber.Map{
+			"error": "An account with this email exists. Sign in with your password and verify your email first, then " + p.Name + " can be used too",
+		})
+	}
+	err = store.CreateIdentity(c.Context(), &Identity{
+		UserID:     user.ID,
+		Provider:   p.ID,
+		Subject:    claims.Subject,
+		Email:      email,
+		CreatedAt:  now,
+		LastUsedAt: &now,
+	})
+	if err != nil {
+		if err == ErrDuplicateIdentity {
+			return nil, c.Status(409).JSON(fiber.Map{"error": "This " + p.Name + " account is already linked"})
+		}
+		return nil, err
+	}
+	recordAudit(c.Context(), AuditEntry{Action: action, UserID: &user.ID, Subject: user.Email, IP: c.IP(), Details: p.ID, CreatedAt: now})
+	if action == "oidc_linked" {
+		sendMailAsync(securityNotice(user, p.Name+" sign-in was linked to your account",
+			"You can now sign in with "+p.Name+".", "remove it on your profile page"))
+	}
+	return user, nil
+}
+
+func listIdentitiesHandler(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	identities, err := store.ListIdentities(c.Context(), userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"items": identities})
+}
+
+func deleteIdentityHandler(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Linked account not found"})
+	}
+	user, err := store.GetUserByID(c.Context(), userID)
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		return err
+	}
+	if ok, err := keepsLoginMethod(c.Context(), user, id); !ok {
+		if err != nil {
+			return err
+		}
+		return lastLoginMethod(c)
+	}
+	if err := store.DeleteIdentity(c.Context(), userID, id); err != nil {
+		if err == ErrNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Linked account not found"})
+		}
+		return err
+	}
+	return c.Status(200).JSON(fiber.Map{"success": true})
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testIssuer is an OpenID provider with discovery, a key set and a token
+// endpoint. Tests authorize a login with the claims the ID token should
+// carry, as a user signing in at the provider would.
+type testIssuer struct {
+	*httptest.Server
+	key ed25519.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]testAuthorization
+}
+
+type testAuthorization struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{key: key, codes: map[string]testAuthorization{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                iss.URL,
+			"authorization_endpoint":                iss.URL + "/authorize",
+			"token_endpoint":                        iss.URL + "/token",
+			"jwks_uri":                              iss.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"EdDSA"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		x := base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []jwk{{Kty: "OKP", Crv: "Ed25519", X: x, Kid: "test", Alg: "EdDSA", Use: "sig"}},
+		})
+	})
+	mux.HandleFunc("/token", iss.token)
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+// token answers the code exchange, checking the client and the PKCE
+// verifier, and signs the ID token.
+func (iss *testIssuer) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	iss.mu.Lock()
+	auth, ok := iss.codes[r.PostFormValue("code")]
+	delete(iss.codes, r.PostFormValue("code"))
+	iss.mu.Unlock()
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if id != "client" || secret != "secret" || !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != auth.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{"iss": iss.URL, "aud": "client", "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}
+	for k, v := range auth.claims {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = "test"
+	signed, err := token.SignedString(iss.key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+// authorize returns the code the provider would redirect back with after
+// the user signed in at authURL. The ID token carries the nonce of the
+// login, unless claims has another, and claims.
+func (iss *testIssuer) authorize(t *testing.T, authURL string, claims jwt.MapClaims) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != "client" || q.Get("code_challenge_method") != "S256" || q.Get("redirect_uri") != oidcRedirectURL() {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+	code, _, err := newSecretToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	withNonce := jwt.MapClaims{"nonce": q.Get("nonce")}
+	for k, v := range claims {
+		withNonce[k] = v
+	}
+	iss.mu.Lock()
+	iss.codes[code] = testAuthorization{challenge: q.Get("code_challenge"), claims: withNonce}
+	iss.mu.Unlock()
+	return code
+}
+
+func newOIDCTestApp(t *testing.T) (*fiber.App, *testMailer, *testIssuer) {
+	t.Helper()
+	app, mail := newTestApp(t)
+	iss := newTestIssuer(t)
+	oidcProviders = map[string]*oidcProvider{"test": {
+		ID: "test", Name: "Test", Issuer: iss.URL, ClientID: "client", ClientSecret: "secret", Scopes: "openid email profile",
+	}}
+	oidcProviderIDs = []string{"test"}
+	t.Cleanup(func() { oidcProviders, oidcProviderIDs = nil, nil })
+	return app, mail, iss
+}
+
+// oidcSignIn signs in at the test provider as the user the claims describe.
+func oidcSignIn(t *testing.T, app *fiber.App, iss *testIssuer, claims jwt.MapClaims) (int, map[string]interface{}) {
+	t.Helper()
+	status, res := request(t, app, "POST", "/api/auth/oidc/test/begin", "", nil)
+	if status != 200 {
+		t.Fatalf("begin: %d %v", status, res)
+	}
+	code := iss.authorize(t, res["url"].(string), claims)
+	return request(t, app, "POST", "/api/auth/oidc/callback", "", fiber.Map{"state": res["state"], "code": code})
+}
+
+func TestOIDCSignUpCreatesAUserWithoutAPassword(t *testing.T) {
+	app, _, iss := newOIDCTestApp(t)
+	claims := jwt.MapClaims{"sub": "olivia-1", "email": "Olivia@example.com", "email_verified": true, "name": "Olivia"}
+	status, res := oidcSignIn(t, app, iss, claims)
+	if status != 200 {
+		t.Fatalf("sign-up: %d %v", status, res)
+	}
+	user := res["user"].(map[string]interface{})
+	if user["email"] != "olivia@example.com" || user["name"] != "Olivia" || user["emailVerified"] != true || user["hasPassword"] != false {
+		t.Errorf("unexpected user %v", user)
+	}
+	if n := auditCount(t, "oidc_signup"); n != 1 {
+		t.Errorf("%d oidc_signup audit entries, want 1", n)
+	}
+
+	// The provider account signs in to the same user, even with a new email
+	claims["email"] = "olivia@work.example.com"
+	status, res = oidcSignIn(t, app, iss, claims)
+	if status != 200 || res["user"].(map[string]interface{})["_id"] != user["_id"] {
+		t.Errorf("second sign-in: %d %v", status, res)
+	}
+	status, res = request(t, app, "GET", "/api/auth/identities", res["token"].(string), nil)
+	if found := items(t, res); status != 200 || len(found) != 1 || found[0]["email"] != "olivia@work.example.com" {
+		t.Errorf("identities: %d %v", status, res)
+	}
+}
+
+func TestOIDCLinksAVerifiedEmail(t *testing.T) {
+	app, mail, iss := newOIDCTestApp(t)
+	_, alice := register(t, app, "Alice", "alice@example.com")
+	stored, err := store.GetUserByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	verifiedAt := &now
+	if _, err := store.UpdateUser(context.Background(), stored.ID, UserUpdate{EmailVerifiedAt: &verifiedAt, UpdatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	status, res := oidcSignIn(t, app, iss, jwt.MapClaims{"sub": "alice-1", "email": "alice@example.com", "email_verified": "true"})
+	if status != 200 || res["user"].(map[string]interface{})["_id"] != alice["_id"] {
+		t.Fatalf("sign-in: %d %v", status, res)
+	}
+	mail.waitFor(t, "alice@example.com", "Test sign-in was linked")
+	if n := auditCount(t, "oidc_linked"); n != 1 {
+		t.Errorf("%d oidc_linked audit entries, want 1", n)
+	}
+}
+
+func TestOIDCRefusesUnverifiedEmails(t *testing.T) {
+	app, _, iss := newOIDCTestApp(t)
+	for _, claims := range []jwt.MapClaims{
+		{"sub": "mallory-1", "email": "alice@example.com", "email_verified": false},
+		{"sub": "mallory-2", "email": "alice@example.com"},
+	} {
+		if status, res := oidcSignIn(t, app, iss, claims); status != 403 {
+			t.Errorf("%v: status %d, want 403: %v", claims, status, res)
+		}
+	}
+	if _, err := store.GetUserByEmail(context.Background(), "alice@example.com"); err != ErrNotFound {
+		t.Errorf("unverified email created a user: %v", err)
+	}
+
+	// Nor is a provider account linked to a local account that never
+	// verified its email
+	register(t, app, "Alice", "alice@example.com")
+	if status, res := oidcSignIn(t, app, iss, jwt.MapClaims{"sub": "alice-1", "email": "alice@example.com", "email_verified": true}); status != 409 {
+		t.Errorf("unverified local account: status %d, want 409: %v", status, res)
+	}
+}
+
+func TestOIDCCallbackRefusesReplaysAndWrongNonces(t *testing.T) {
+	app, _, iss := newOIDCTestApp(t)
+	claims := jwt.MapClaims{"sub": "olivia-1", "email": "olivia@example.com", "email_verified": true}
+	status, res := request(t, app, "POST", "/api/auth/oidc/test/begin", "", nil)
+	if status != 200 {
+		t.Fatalf("begin: %d %v", status, res)
+	}
+	state := res["state"]
+	code := iss.authorize(t, res["url"].(string), claims)
+	if status, res := request(t, app, "POST", "/api/auth/oidc/callback", "", fiber.Map{"state": state, "code": code}); status != 200 {
+		t.Fatalf("callback: %d %v", status, res)
+	}
+	code = iss.authorize(t, res["url"].(string), claims)
+	if status, res := request(t, app, "POST", "/api/auth/oidc/callback", "", fiber.Map{"state": state, "code": code}); status != 401 {
+		t.Errorf("state used again: status %d, want 401: %v", status, res)
+	}
+
+	// An ID token minted for another login
+	status, res = request(t, app, "POST", "/api/auth/oidc/test/begin", "", nil)
+	if status != 200 {
+		t.Fatalf("begin: %d %v", status, res)
+	}
+	claims["nonce"] = "another login's"
+	code = iss.authorize(t, res["url"].(string), claims)
+	if status, res := request(t, app, "POST", "/api/auth/oidc/callback", "", fiber.Map{"state": res["state"], "code": code}); status != 401 {
+		t.Errorf("wrong nonce: status %d, want 401: %v", status, res)
+	}
+}
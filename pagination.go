@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultTodoLimit = 20
+	maxTodoLimit     = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// cursorToken is the JSON inside an opaque cursor handed to clients. It
+// records the sort order so a cursor cannot be replayed against another one.
+type cursorToken struct {
+	Sort  string          `json:"s"`
+	Value json.RawMessage `json:"v"`
+	ID    string          `json:"id"`
+}
+
+// encodeCursor turns a store cursor into the opaque string returned as nextCursor.
+func encodeCursor(cur *TodoCursor, sortBy TodoSort) string {
+	value, _ := json.Marshal(cur.Value)
+	raw, _ := json.Marshal(cursorToken{Sort: sortBy.String(), Value: value, ID: cur.ID.Hex()})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor parses a cursor previously produced by encodeCursor for the same sort order.
+func decodeCursor(s string, sortBy TodoSort) (*TodoCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var tok cursorToken
+	if err := json.Unmarshal(raw, &tok); err != nil || tok.Sort != sortBy.String() {
+		return nil, errInvalidCursor
+	}
+	id, err := primitive.ObjectIDFromHex(tok.ID)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	cur := &TodoCursor{ID: id}
+	if string(tok.Value) == "null" {
+		if !sortBy.nullable() {
+			return nil, errInvalidCursor
+		}
+		return cur, nil
+	}
+	switch sortBy.Field {
+	case relevanceSort.Field:
+		var score float64
+		if err := json.Unmarshal(tok.Value, &score); err != nil {
+			return nil, errInvalidCursor
+		}
+		cur.Value = score
+	case "priority":
+		var rank int
+		if err := json.Unmarshal(tok.Value, &rank); err != nil {
+			return nil, errInvalidCursor
+		}
+		cur.Value = rank
+	default:
+		var t time.Time
+		if err := json.Unmarshal(tok.Value, &t); err != nil {
+			return nil, errInvalidCursor
+		}
+		cur.Value = t
+	}
+	return cur, nil
+}
+
+// parseTodoSort parses the sort query parameter: a field name, prefixed with
+// "-" for descending order, or "relevance". Empty means defaultTodoSort.
+func parseTodoSort(s string) (TodoSort, bool) {
+	if s == "" {
+		return defaultTodoSort, true
+	}
+	if s == relevanceSort.Field {
+		return relevanceSort, true
+	}
+	sortBy := TodoSort{Field: strings.TrimPrefix(s, "-"), Desc: strings.HasPrefix(s, "-")}
+	for _, f := range todoSortFields {
+		if f == sortBy.Field {
+			return sortBy, true
+		}
+	}
+	return TodoSort{}, false
+}
+
+// parsePage reads the sort, limit and cursor query parameters into q. It
+// returns a non-empty message when they are invalid. Without a sort
+// parameter, listings use defaultSort, and searches sort by relevance when
+// that is empty too.
+func parsePage(c *fiber.Ctx, q *TodoQuery, defaultSort string) string {
+	sortParam := c.Query("sort", defaultSort)
+	sortBy, ok := parseTodoSort(sortParam)
+	if !ok {
+		return "Sort must be relevance or one of " + strings.Join(todoSortFields, ", ") + ", optionally prefixed with -"
+	}
+	if sortBy == relevanceSort && q.Search == nil {
+		return "Sort by relevance requires a search"
+	}
+	if sortParam == "" && q.Search != nil {
+		sortBy = relevanceSort
+	}
+	q.Sort = sortBy
+	q.Limit = defaultTodoLimit
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return "Limit must be a positive integer"
+		}
+		if n > maxTodoLimit {
+			n = maxTodoLimit
+		}
+		q.Limit = n
+	}
+	if s := c.Query("cursor"); s != "" {
+		cur, err := decodeCursor(s, q.Sort)
+		if err != nil {
+			return "Invalid cursor"
+		}
+		q.After = cur
+	}
+	return ""
+}
+
+// pageResponse is the envelope returned by paginated listings.
+func pageResponse(page *TodoPage, sortBy TodoSort) fiber.Map {
+	var next interface{}
+	if page.Next != nil {
+		next = encodeCursor(page.Next, sortBy)
+	}
+	return fiber.Map{
+		"items":      page.Items,
+		"nextCursor": next,
+		"total":      page.Total,
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Passkey ceremonies are kept as one-time tokens under their challenge
+// between the begin and finish requests.
+const (
+	purposePasskeyRegistration = "passkey_registration"
+	purposePasskeyLogin        = "passkey_login"
+	passkeyCeremonyTTL         = 5 * time.Minute
+)
+
+var webAuthn *webauthn.WebAuthn
+
+// newWebAuthn configures the relying party. Passkeys are bound to
+// WEBAUTHN_RP_ID, which defaults to the host of APP_URL, and only work from
+// the origins in WEBAUTHN_ORIGINS (default APP_URL).
+func newWebAuthn() (*webauthn.WebAuthn, error) {
+	origins := strings.Split(os.Getenv("WEBAUTHN_ORIGINS"), ",")
+	if os.Getenv("WEBAUTHN_ORIGINS") == "" {
+		origins = []string{appURL("")}
+	}
+	for i := range origins {
+		origins[i] = strings.TrimRight(strings.TrimSpace(origins[i]), "/")
+	}
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		u, err := url.Parse(origins[0])
+		if err != nil || u.Hostname() == "" {
+			return nil, fmt.Errorf("cannot derive WEBAUTHN_RP_ID from %q", origins[0])
+		}
+		rpID = u.Hostname()
+	}
+	return webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: envOr("WEBAUTHN_RP_NAME", totpIssuer()),
+		RPOrigins:     origins,
+	})
+}
+
+// credential converts p for the webauthn package.
+func (p Passkey) credential() webauthn.Credential {
+	transports := make([]protocol.AuthenticatorTransport, len(p.Transports))
+	for i, t := range p.Transports {
+		transports[i] = protocol.AuthenticatorTransport(t)
+	}
+	return webauthn.Credential{
+		ID:              p.CredentialID,
+		PublicKey:       p.PublicKey,
+		AttestationType: p.AttestationType,
+		Transport:       transports,
+		Flags:           webauthn.CredentialFlags{BackupEligible: p.BackupEligible, BackupState: p.BackupState},
+		Authenticator:   webauthn.Authenticator{AAGUID: p.AAGUID, SignCount: p.SignCount},
+	}
+}
+
+// passkeyUser is a user and their passkeys as the webauthn package sees
+// them. The user handle is the user's ID.
+type passkeyUser struct {
+	user     *User
+	passkeys []Passkey
+}
+
+func (u passkeyUser) WebAuthnID() []byte          { return u.user.ID[:] }
+func (u passkeyUser) WebAuthnName() string        { return u.user.Email }
+func (u passkeyUser) WebAuthnDisplayName() string { return u.user.Name }
+
+func (u passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.passkeys))
+	for i, p := range u.passkeys {
+		credentials[i] = p.credential()
+	}
+	return credentials
+}
+
+func loadPasskeyUser(ctx context.Context, userID primitive.ObjectID) (passkeyUser, error) {
+	user, err := store.GetUserByID(ctx, userID)
+	if err != nil {
+		return passkeyUser{}, err
+	}
+	passkeys, err := store.ListPasskeys(ctx, userID)
+	if err != nil {
+		return passkeyUser{}, err
+	}
+	return passkeyUser{user: user, passkeys: passkeys}, nil
+}
+
+// saveCeremony stores the state of a ceremony until the browser answers its
+// challenge. userID is zero for logins.
+func saveCeremony(ctx context.Context, purpose string, userID primitive.ObjectID, session *webauthn.SessionData) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	return store.CreateOneTimeToken(ctx, &OneTimeToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashSecretToken(session.Challenge),
+		CreatedAt: now,
+		ExpiresAt: now.Add(passkeyCeremonyTTL),
+		Data:      string(data),
+	})
+}
+
+// takeCeremony uses up the ceremony whose challenge the browser answered.
+// An unknown, used or expired challenge returns ErrTokenUsed.
+func takeCeremony(ctx context.Context, purpose, challenge string, now time.Time) (*OneTimeToken, *webauthn.SessionData, error) {
+	ceremony, err := store.GetOneTimeToken(ctx, purpose, hashSecretToken(challenge))
+	if err == ErrNotFound || (err == nil && !now.Before(ceremony.ExpiresAt)) {
+		err = ErrTokenUsed
+	}
+	if err == nil {
+		err = store.UseOneTimeToken(ctx, ceremony.ID, now)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(ceremony.Data), &session); err != nil {
+		return nil, nil, err
+	}
+	return ceremony, &session, nil
+}
+
+// beginPasskeyRegistrationHandler returns the options for
+// navigator.credentials.create. Passkeys must be discoverable and verify the
+// user, since they replace both the email and the password. A passkey signs
+// in without a second factor, so adding one takes the password, and a code
+// when 2FA is on, like turning 2FA off does.
+func beginPasskeyRegistrationHandler(c *fiber.Ctx) error {
+	var payload struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	user, err := currentUser(c, payload.Password)
+	if user == nil {
+		return err
+	}
+	if user.TOTPEnabledAt != nil {
+		if ok, err := checkSecondFactor(c, user, payload.Code, time.Now().UTC()); !ok {
+			return err
+		}
+	}
+	userID := user.ID
+	u, err := loadPasskeyUser(c.Context(), userID)
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		return err
+	}
+	requireResidentKey := true
+	creation, session, err := webAuthn.BeginRegistration(u,
+		webauthn.WithExclusions(webauthn.Credentials(u.WebAuthnCredentials()).CredentialDescriptors()),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			RequireResidentKey: &requireResidentKey,
+			UserVerification:   protocol.VerificationRequired,
+		}))
+	if err != nil {
+		return err
+	}
+	if err := saveCeremony(c.Context(), purposePasskeyRegistration, userID, session); err != nil {
+		return err
+	}
+	return c.JSON(creation)
+}
+
+// finishPasskeyRegistrationHandler verifies the new credential and saves it.
+func finishPasskeyRegistrationHandler(c *fiber.Ctx) error {
+	var payload struct {
+		Name       string          `json:"name"`
+		Credential json.RawMessage `json:"credential"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(payload.Credential)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid passkey response"})
+	}
+	now := time.Now().UTC()
+	ceremony, session, err := takeCeremony(c.Context(), purposePasskeyRegistration, parsed.Response.CollectedClientData.Challenge, now)
+	if err == nil && ceremony.UserID != userID {
+		err = ErrTokenUsed
+	}
+	if err != nil {
+		if err == ErrTokenUsed {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid or expired passkey challenge"})
+		}
+		return err
+	}
+	u, err := loadPasskeyUser(c.Context(), userID)
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		return err
+	}
+	credential, err := webAuthn.CreateCredential(u, *session, parsed)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Passkey could not be verified"})
+	}
+	name := strings.TrimSpace(payload.Name)
+	if name == "" {
+		name = deviceLabel(c.Get("User-Agent"))
+	}
+	if len(name) > 100 {
+		return c.Status(400).JSON(fiber.Map{"error": "Name is too long"})
+	}
+	passkey := &Passkey{
+		UserID:          userID,
+		Name:            name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		CreatedAt:       now,
+	}
+	for _, t := range credential.Transport {
+		passkey.Transports = append(passkey.Transports, string(t))
+	}
+	if err := store.CreatePasskey(c.Context(), passkey); err != nil {
+		if err == ErrDuplicatePasskey {
+			return c.Status(409).JSON(fiber.Map{"error": "Passkey already registered"})
+		}
+		return err
+	}
+	recordAudit(c.Context(), AuditEntry{Action: "passkey_added", UserID: &userID, Subject: u.user.Email, IP: c.IP(),
+		Details: passkey.Name, CreatedAt: now})
+	sendMailAsync(securityNotice(u.user, "A passkey was added to your account",
+		fmt.Sprintf("A passkey named %q was just added to your account. It can be used to sign in without a password.", passkey.Name),
+		"remove it on your profile page"))
+	return c.Status(201).JSON(passkey)
+}
+
+// beginPasskeyLoginHandler returns the options for navigator.credentials.get.
+// No email is needed: the browser offers the passkeys it has for this site.
+func beginPasskeyLoginHandler(c *fiber.Ctx) error {
+	assertion, session, err := webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return err
+	}
+	if err := saveCeremony(c.Context(), purposePasskeyLogin, primitive.NilObjectID, session); err != nil {
+		return err
+	}
+	return c.JSON(assertion)
+}
+
+// finishPasskeyLoginHandler verifies the assertion and signs the passkey's
+// user in, issuing the same tokens as loginHandler. A passkey both proves
+// possession and verifies the user, so 2FA does not ask for a code.
+func finishPasskeyLoginHandler(c *fiber.Ctx) error {
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(c.Body())
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid passkey response"})
+	}
+	now := time.Now().UTC()
+	_, session, err := takeCeremony(c.Context(), purposePasskeyLogin, parsed.Response.CollectedClientData.Challenge, now)
+	if err != nil {
+		if err == ErrTokenUsed {
+			return c.Status(401).JSON(fiber.Map{"error": "Invalid or expired passkey challenge"})
+		}
+		return err
+	}
+	var passkey *Passkey
+	user, credential, err := webAuthn.ValidatePasskeyLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		p, err := store.GetPasskeyByCredentialID(c.Context(), rawID)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(userHandle, p.UserID[:]) {
+			return nil, ErrNotFound
+		}
+		passkey = p
+		return loadPasskeyUser(c.Context(), p.UserID)
+	}, *session, parsed)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Passkey login failed"})
+	}
+	u := user.(passkeyUser)
+	if credential.Authenticator.CloneWarning {
+		recordAudit(c.Context(), AuditEntry{Action: "passkey_clone_warning", UserID: &u.user.ID, Subject: u.user.Email, IP: c.IP(),
+			Details:   fmt.Sprintf("%s: sign count %d after %d", passkey.Name, parsed.Response.AuthenticatorData.Counter, passkey.SignCount),
+			CreatedAt: now})
+		return c.Status(401).JSON(fiber.Map{"error": "Passkey login failed"})
+	}
+	if err := store.UsePasskey(c.Context(), passkey.ID, credential.Authenticator.SignCount, credential.Flags.BackupState, now); err != nil {
+		return err
+	}
+	token, refreshToken, err := startSession(c, u.user.ID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{
+		"token":        token,
+		"refreshToken": refreshToken,
+		"user":         userJSON(u.user),
+	})
+}
+
+func listPasskeysHandler(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	passkeys, err := store.ListPasskeys(c.Context(), userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"items": passkeys})
+}
+
+func deletePasskeyHandler(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Passkey not found"})
+	}
+	user, err := store.GetUserByID(c.Context(), userID)
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		return err
+	}
+	if ok, err := keepsLoginMethod(c.Context(), user, id); !ok {
+		if err != nil {
+			return err
+		}
+		return lastLoginMethod(c)
+	}
+	if err := store.DeletePasskey(c.Context(), userID, id); err != nil {
+		if err == ErrNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Passkey not found"})
+		}
+		return err
+	}
+	return c.Status(200).JSON(fiber.Map{"success": true})
+}
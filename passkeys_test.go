@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/gofiber/fiber/v2"
+)
+
+// softAuthenticator is a passkey in software: a P-256 key that answers
+// challenges the way a browser and its authenticator do, with "none"
+// attestation.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	counter      uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, credentialID: id}
+}
+
+var b64 = base64.RawURLEncoding
+
+// publicKeyOptions returns the publicKey member of the options a begin
+// route answered with.
+func publicKeyOptions(t *testing.T, res map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	options, ok := res["publicKey"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("no publicKey options in %v", res)
+	}
+	return options
+}
+
+func (a *softAuthenticator) clientData(typ string, options map[string]interface{}) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      typ,
+		"challenge": options["challenge"].(string),
+		"origin":    webAuthn.Config.RPOrigins[0],
+	})
+	return data
+}
+
+// authenticatorData is the rpIdHash, the flags (user present and verified,
+// plus attested credential data when there is some) and the counter.
+func (a *softAuthenticator) authenticatorData(attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(webAuthn.Config.RPID))
+	flags := byte(0x01 | 0x04)
+	if attested != nil {
+		flags |= 0x40
+	}
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+	return append(data, attested...)
+}
+
+// create answers the options of register/begin with a new credential.
+func (a *softAuthenticator) create(t *testing.T, options map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	user := options["user"].(map[string]interface{})
+	handle, err := b64.DecodeString(user["id"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.userHandle = handle
+	coseKey, err := webauthncbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, coseKey...)
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authenticatorData(attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]interface{}{
+		"id":    b64.EncodeToString(a.credentialID),
+		"rawId": b64.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(a.clientData("webauthn.create", options)),
+			"attestationObject": b64.EncodeToString(attestation),
+		},
+	}
+}
+
+// get answers the options of login/begin, signing with the credential.
+func (a *softAuthenticator) get(t *testing.T, options map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	a.counter++
+	clientData := a.clientData("webauthn.get", options)
+	authData := a.authenticatorData(nil)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]interface{}{
+		"id":    b64.EncodeToString(a.credentialID),
+		"rawId": b64.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(clientData),
+			"authenticatorData": b64.EncodeToString(authData),
+			"signature":         b64.EncodeToString(signature),
+			"userHandle":        b64.EncodeToString(a.userHandle),
+		},
+	}
+}
+
+func newPasskeyTestApp(t *testing.T) (*fiber.App, *testMailer) {
+	t.Helper()
+	app, mail := newTestApp(t)
+	var err error
+	if webAuthn, err = newWebAuthn(); err != nil {
+		t.Fatal(err)
+	}
+	return app, mail
+}
+
+// addPasskey registers a passkey of a for the user of token.
+func addPasskey(t *testing.T, app *fiber.App, token string, a *softAuthenticator, begin fiber.Map) (int, map[string]interface{}) {
+	t.Helper()
+	status, res := request(t, app, "POST", "/api/auth/passkeys/register/begin", token, begin)
+	if status != 200 {
+		return status, res
+	}
+	credential := a.create(t, publicKeyOptions(t, res))
+	return request(t, app, "POST", "/api/auth/passkeys/register/finish", token, fiber.Map{"name": "Test key", "credential": credential})
+}
+
+func passkeyLogin(t *testing.T, app *fiber.App, a *softAuthenticator) (int, map[string]interface{}) {
+	t.Helper()
+	status, res := request(t, app, "POST", "/api/auth/passkeys/login/begin", "", nil)
+	if status != 200 {
+		t.Fatalf("login begin: %d %v", status, res)
+	}
+	return request(t, app, "POST", "/api/auth/passkeys/login/finish", "", a.get(t, publicKeyOptions(t, res)))
+}
+
+func TestPasskeyRegisterAndSignIn(t *testing.T) {
+	app, mail := newPasskeyTestApp(t)
+	token, alice := register(t, app, "Alice", "alice@example.com")
+	a := newSoftAuthenticator(t)
+
+	status, passkey := addPasskey(t, app, token, a, fiber.Map{"password": "secret1"})
+	if status != 201 || passkey["name"] != "Test key" {
+		t.Fatalf("register: %d %v", status, passkey)
+	}
+	mail.waitFor(t, "alice@example.com", "A passkey was added")
+	if n := auditCount(t, "passkey_added"); n != 1 {
+		t.Errorf("%d passkey_added audit entries, want 1", n)
+	}
+	status, res := request(t, app, "GET", "/api/auth/passkeys", token, nil)
+	if status != 200 || len(items(t, res)) != 1 {
+		t.Errorf("list: %d %v", status, res)
+	}
+
+	status, res = passkeyLogin(t, app, a)
+	if status != 200 || res["user"].(map[string]interface{})["_id"] != alice["_id"] {
+		t.Fatalf("login: %d %v", status, res)
+	}
+	status, me := request(t, app, "GET", "/api/auth/me", res["token"].(string), nil)
+	if status != 200 || me["_id"] != alice["_id"] {
+		t.Errorf("me with the passkey session: %d %v", status, me)
+	}
+}
+
+func TestPasskeyRegistrationNeedsThePassword(t *testing.T) {
+	app, _ := newPasskeyTestApp(t)
+	token, _ := register(t, app, "Alice", "alice@example.com")
+	for _, body := range []fiber.Map{{}, {"password": "wrong"}} {
+		if status, res := request(t, app, "POST", "/api/auth/passkeys/register/begin", token, body); status != 400 {
+			t.Errorf("%v: status %d, want 400: %v", body, status, res)
+		}
+	}
+}
+
+func TestPasskeyRegistrationNeedsACodeWithTwoFactor(t *testing.T) {
+	app, _ := newPasskeyTestApp(t)
+	token, _ := register(t, app, "Alice", "alice@example.com")
+	recoveryCodes := enableTwoFactor(t, app, token)
+
+	status, res := request(t, app, "POST", "/api/auth/passkeys/register/begin", token, fiber.Map{"password": "secret1"})
+	if status != 400 {
+		t.Errorf("without a code: status %d, want 400: %v", status, res)
+	}
+	status, res = addPasskey(t, app, token, newSoftAuthenticator(t), fiber.Map{"password": "secret1", "code": recoveryCodes[0]})
+	if status != 201 {
+		t.Errorf("with a recovery code: %d %v", status, res)
+	}
+}
+
+func TestPasskeyRegistrationChallengeWorksOnce(t *testing.T) {
+	app, _ := newPasskeyTestApp(t)
+	token, _ := register(t, app, "Alice", "alice@example.com")
+	status, res := request(t, app, "POST", "/api/auth/passkeys/register/begin", token, fiber.Map{"password": "secret1"})
+	if status != 200 {
+		t.Fatalf("begin: %d %v", status, res)
+	}
+	options := publicKeyOptions(t, res)
+	body := fiber.Map{"credential": newSoftAuthenticator(t).create(t, options)}
+	if status, res := request(t, app, "POST", "/api/auth/passkeys/register/finish", token, body); status != 201 {
+		t.Fatalf("finish: %d %v", status, res)
+	}
+	body = fiber.Map{"credential": newSoftAuthenticator(t).create(t, options)}
+	if status, res := request(t, app, "POST", "/api/auth/passkeys/register/finish", token, body); status != 400 {
+		t.Errorf("second finish: status %d, want 400: %v", status, res)
+	}
+}
+
+func TestPasskeyLoginRefusesReplayAndClones(t *testing.T) {
+	app, _ := newPasskeyTestApp(t)
+	token, _ := register(t, app, "Alice", "alice@example.com")
+	a := newSoftAuthenticator(t)
+	if status, res := addPasskey(t, app, token, a, fiber.Map{"password": "secret1"}); status != 201 {
+		t.Fatalf("register: %d %v", status, res)
+	}
+
+	status, res := request(t, app, "POST", "/api/auth/passkeys/login/begin", "", nil)
+	if status != 200 {
+		t.Fatalf("login begin: %d %v", status, res)
+	}
+	assertion := a.get(t, publicKeyOptions(t, res))
+	if status, res := request(t, app, "POST", "/api/auth/passkeys/login/finish", "", assertion); status != 200 {
+		t.Fatalf("login: %d %v", status, res)
+	}
+	if status, res := request(t, app, "POST", "/api/auth/passkeys/login/finish", "", assertion); status != 401 {
+		t.Errorf("replayed assertion: status %d, want 401: %v", status, res)
+	}
+
+	// A copy of the key whose counter went back
+	a.counter = 0
+	if status, res := passkeyLogin(t, app, a); status != 401 {
+		t.Errorf("cloned key: status %d, want 401: %v", status, res)
+	}
+	if n := auditCount(t, "passkey_clone_warning"); n != 1 {
+		t.Errorf("%d clone warning audit entries, want 1", n)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const purposePasswordReset = "password_reset"
+
+// Reset links may be requested for an email once a minute, and five times
+// until an hour passes without a request; an IP address may ask twenty
+// times. Requests are counted as rate limit hits under reset:<email> and
+// reset-ip:<ip>, whether or not the email has an account.
+const (
+	passwordResetInterval = time.Minute
+	passwordResetLimit    = 5
+	passwordResetIPLimit  = 20
+	passwordResetWindow   = time.Hour
+)
+
+func passwordResetTTL() time.Duration {
+	return envDuration("PASSWORD_RESET_EXPIRES_IN", time.Hour)
+}
+
+// passwordResetWait returns how long to wait before another reset link may
+// be requested under the email's key and the IP address's key.
+func passwordResetWait(c *fiber.Ctx, emailKey, ipKey string, now time.Time) (time.Duration, error) {
+	found, err := store.GetRateLimits(c.Context(), []string{emailKey, ipKey})
+	if err != nil {
+		return 0, err
+	}
+	var wait time.Duration
+	for _, a := range found {
+		if !a.ExpiresAt.After(now) {
+			continue
+		}
+		switch {
+		case a.Key == emailKey && a.Hits >= passwordResetLimit, a.Key == ipKey && a.Hits >= passwordResetIPLimit:
+			wait = max(wait, a.ExpiresAt.Sub(now))
+		case a.Key == emailKey:
+			wait = max(wait, a.LastHitAt.Add(passwordResetInterval).Sub(now))
+		}
+	}
+	return wait, nil
+}
+
+// forgotPasswordHandler mails a password reset link. It answers the same
+// whether or not the email belongs to an account.
+func forgotPasswordHandler(c *fiber.Ctx) error {
+	var payload struct {
+		Email string `json:"email"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	email := strings.ToLower(strings.TrimSpace(payload.Email))
+	if !emailRegex.MatchString(email) {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid email"})
+	}
+	now := time.Now().UTC()
+	emailKey, ipKey := "reset:"+email, "reset-ip:"+c.IP()
+	wait, err := passwordResetWait(c, emailKey, ipKey, now)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		seconds := int((wait + time.Second - 1) / time.Second)
+		c.Set(fiber.HeaderRetryAfter, fmt.Sprint(seconds))
+		return c.Status(429).JSON(fiber.Map{"error": "Too many password resets requested", "retryAfter": seconds})
+	}
+	for _, key := range []string{emailKey, ipKey} {
+		if _, err := store.HitRateLimit(c.Context(), key, now, passwordResetWindow); err != nil {
+			return err
+		}
+	}
+	user, err := store.GetUserByEmail(c.Context(), email)
+	if err == ErrNotFound {
+		return c.JSON(fiber.Map{"success": true})
+	}
+	if err != nil {
+		return err
+	}
+	token, hash, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	ttl := passwordResetTTL()
+	err = store.CreateOneTimeToken(c.Context(), &OneTimeToken{
+		UserID:    user.ID,
+		Purpose:   purposePasswordReset,
+		TokenHash: hash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+	link := appURL("/reset-password?token=" + url.QueryEscape(token))
+	sendMailAsync(Mail{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. To choose a new one, open\n\n%s\n\n"+
+			"The link works once and expires in %d minutes. If you did not ask for it, you can ignore this email.\n",
+			user.Name, link, int(ttl.Minutes())),
+	})
+	return c.JSON(fiber.Map{"success": true})
+}
+
+// resetPasswordHandler sets a new password with a token from a reset link and
+// signs the user out everywhere.
+func resetPasswordHandler(c *fiber.Ctx) error {
+	var payload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	if len(payload.Password) < 6 {
+		return c.Status(400).JSON(fiber.Map{"error": "Password must be at least 6 characters"})
+	}
+	now := time.Now().UTC()
+	rt, err := store.GetOneTimeToken(c.Context(), purposePasswordReset, hashSecretToken(payload.Token))
+	if err == nil && (rt.UsedAt != nil || !now.Before(rt.ExpiresAt)) {
+		err = ErrTokenUsed
+	}
+	if err == nil {
+		err = store.UseOneTimeToken(c.Context(), rt.ID, now)
+	}
+	if err != nil {
+		if err == ErrNotFound || err == ErrTokenUsed {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid or expired reset token"})
+		}
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(payload.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	passwordHash := string(hash)
+	if _, err := store.UpdateUser(c.Context(), rt.UserID, UserUpdate{PasswordHash: &passwordHash, UpdatedAt: now}); err != nil {
+		if err == ErrNotFound {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid or expired reset token"})
+		}
+		return err
+	}
+	// Older links must not undo the new password
+	if err := store.UseUserOneTimeTokens(c.Context(), rt.UserID, purposePasswordReset, now); err != nil {
+		return err
+	}
+	if err := signOutEverywhere(c.Context(), rt.UserID, now); err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"success": true})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestForgotPasswordRequestsAreLimited(t *testing.T) {
+	app, mail := newTestApp(t)
+	register(t, app, "Alice", "alice@example.com")
+	forgot := func(email string) (int, map[string]interface{}) {
+		return request(t, app, "POST", "/api/auth/forgot-password", "", fiber.Map{"email": email})
+	}
+
+	hits := 0
+	for _, email := range []string{"alice@example.com", "nobody@example.com"} {
+		if status, res := forgot(email); status != 200 {
+			t.Fatalf("%s: %d %v", email, status, res)
+		}
+		hits++
+		if status, res := forgot(email); status != 429 || res["retryAfter"] == nil {
+			t.Errorf("%s again within a minute: %d %v", email, status, res)
+		}
+	}
+	mail.waitFor(t, "alice@example.com", "Reset your password")
+
+	// Other emails from the same IP address, until it runs out
+	for ; hits < passwordResetIPLimit; hits++ {
+		email := fmt.Sprintf("user%d@example.com", hits)
+		if status, res := forgot(email); status != 200 {
+			t.Fatalf("%s: %d %v", email, status, res)
+		}
+	}
+	if status, res := forgot("another@example.com"); status != 429 || res["retryAfter"] == nil {
+		t.Errorf("over the IP address's limit: %d %v", status, res)
+	}
+}
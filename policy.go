@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// todoAction is something a caller can do to a todo.
+type todoAction string
+
+const (
+	todoRead   todoAction = "read"
+	todoUpdate todoAction = "update"
+	todoDelete todoAction = "delete"
+	todoStar   todoAction = "star"
+)
+
+// todoPolicies decide whether user may perform an action on a todo. user is
+// nil for anonymous callers.
+var todoPolicies = map[todoAction]func(user *primitive.ObjectID, todo *Todo) bool{
+	todoRead:   canReadTodo,
+	todoUpdate: canChangeTodo,
+	todoDelete: canChangeTodo,
+	todoStar:   canStarTodo,
+}
+
+// Modes for todos created before auth, which have no owner.
+const (
+	// legacyShared lets every signed-in user read them, but not change them,
+	// until they are claimed or archived.
+	legacyShared = "shared"
+	// legacyStrict serves them to no one; see checkLegacyTodos.
+	legacyStrict = "strict"
+)
+
+var legacyTodosMode = legacyShared
+
+// isTodoOwner reports whether user owns todo. Ownerless todos have no owner.
+func isTodoOwner(user *primitive.ObjectID, todo *Todo) bool {
+	return user != nil && todo.OwnerID != nil && *todo.OwnerID == *user
+}
+
+// Live ownerless todos are readable by every signed-in user unless
+// legacyTodosMode is strict.
+func canReadTodo(user *primitive.ObjectID, todo *Todo) bool {
+	if todo.OwnerID == nil {
+		return user != nil && legacyTodosMode == legacyShared && todo.ArchivedAt == nil
+	}
+	return isTodoOwner(user, todo)
+}
+
+// Only the owner changes a todo; ownerless ones are read-only until an admin
+// assigns them.
+func canChangeTodo(user *primitive.ObjectID, todo *Todo) bool {
+	return isTodoOwner(user, todo)
+}
+
+// Starring only needs read access, but anonymous callers have no wishlist.
+func canStarTodo(user *primitive.ObjectID, todo *Todo) bool {
+	return user != nil && canReadTodo(user, todo)
+}
+
+// readableTodos restricts f to the todos user may read, the listing
+// counterpart of canReadTodo. It returns false when there are none.
+func readableTodos(f *TodoFilter, user *primitive.ObjectID) bool {
+	if user == nil {
+		return false
+	}
+	f.ViewerID = user
+	if legacyTodosMode == legacyStrict {
+		f.OwnerID = user
+	}
+	return true
+}
+
+var (
+	errInvalidTodoID = errors.New("invalid todo id")
+	errForbidden     = errors.New("forbidden")
+)
+
+// authorizeTodo loads the todo named by the :id parameter and checks that the
+// caller may perform action on it. Errors are meant for todoAccessError.
+func authorizeTodo(c *fiber.Ctx, action todoAction) (*Todo, *primitive.ObjectID, error) {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, nil, errInvalidTodoID
+	}
+	var user *primitive.ObjectID
+	if oid, ok := sessionUserID(c); ok {
+		user = &oid
+	}
+	todo, err := store.GetTodo(c.Context(), id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !todoPolicies[action](user, todo) {
+		return nil, nil, errForbidden
+	}
+	return todo, user, nil
+}
+
+// todoAccessError sends the response for an error from authorizeTodo or from
+// the store call that follows it.
+func todoAccessError(c *fiber.Ctx, err error) error {
+	switch err {
+	case errInvalidTodoID:
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid todo ID"})
+	case ErrNotFound:
+		return c.Status(404).JSON(fiber.Map{"error": "Todo not found"})
+	case errForbidden:
+		// Provide a reason to help clients and debugging tools distinguish ownership rejections
+		return c.Status(403).JSON(fiber.Map{"error": "Forbidden", "reason": "ownership_mismatch", "message": "You are not the owner of this item"})
+	}
+	return err
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Filter expressions for the q parameter of GET /api/todos, e.g.
+//
+//	priority:high due<7d -completed starred:me "exact phrase"
+//
+// An expression is a list of whitespace-separated clauses that must all hold.
+// A leading "-" negates a clause. Clauses are:
+//
+//	priority:high,medium   priority is one of low, medium, high or none
+//	status:active          status is active or completed; is: also takes starred
+//	completed, active      shorthands for status:completed and status:active
+//	starred, starred:me    starred by the signed-in user
+//	tag:groceries          has the tag (case-insensitive)
+//	due<7d, due>=2025-01-31, due:today, due:none
+//	                       due date compared to a date (YYYY-MM-DD, today,
+//	                       tomorrow, yesterday), a time relative to now (7d,
+//	                       -2w, 12h) or now; due:none/due:any test for one
+//	anything else          search text; quote it to search for a field name
+//
+// parseTodoExpr turns the expression into a list of typed clauses and
+// (*TodoExpr).apply compiles those into a TodoFilter.
+
+// QueryError reports an invalid expression and the token responsible, so
+// clients can point at it.
+type QueryError struct {
+	Message string
+	Token   string
+	// Pos is the offset of Token in the expression, in characters.
+	Pos int
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("%s (%q at %d)", e.Message, e.Token, e.Pos)
+}
+
+// exprToken is one whitespace-separated clause of an expression.
+type exprToken struct {
+	Text string
+	Pos  int // in characters
+}
+
+func (t exprToken) errorf(format string, args ...interface{}) *QueryError {
+	return &QueryError{Message: fmt.Sprintf(format, args...), Token: t.Text, Pos: t.Pos}
+}
+
+// TodoExpr is a parsed filter expression.
+type TodoExpr struct {
+	Clauses []ExprClause
+}
+
+// ExprClause is one clause of a TodoExpr. Each kind of clause is its own type.
+type ExprClause interface {
+	token() exprToken
+}
+
+type (
+	// TextClause is search text: a word, a prefix ("mil*") or a quoted phrase.
+	TextClause struct {
+		tok     exprToken
+		Negated bool
+		Text    string // as written, quotes included, see parseSearchQuery
+	}
+	// PriorityClause matches todos whose priority is one of Values ("" for none).
+	PriorityClause struct {
+		tok     exprToken
+		Negated bool
+		Values  []string
+	}
+	// CompletedClause matches completed or active todos.
+	CompletedClause struct {
+		tok       exprToken
+		Completed bool
+	}
+	// StarredClause matches todos starred by the viewer.
+	StarredClause struct {
+		tok     exprToken
+		Negated bool
+	}
+	// TagClause matches todos with the tag.
+	TagClause struct {
+		tok     exprToken
+		Negated bool
+		Tag     string
+	}
+	// DueClause compares the due date with [From, To). From == To for a
+	// single instant such as "7d".
+	DueClause struct {
+		tok      exprToken
+		Op       string // ":", "<", "<=", ">" or ">="
+		From, To time.Time
+	}
+	// HasDueClause matches todos with or without a due date.
+	HasDueClause struct {
+		tok exprToken
+		Has bool
+	}
+)
+
+func (c *TextClause) token() exprToken      { return c.tok }
+func (c *PriorityClause) token() exprToken  { return c.tok }
+func (c *CompletedClause) token() exprToken { return c.tok }
+func (c *StarredClause) token() exprToken   { return c.tok }
+func (c *TagClause) token() exprToken       { return c.tok }
+func (c *DueClause) token() exprToken       { return c.tok }
+func (c *HasDueClause) token() exprToken    { return c.tok }
+
+// lexTodoExpr splits s on whitespace outside double quotes.
+func lexTodoExpr(s string) ([]exprToken, *QueryError) {
+	var (
+		tokens  []exprToken
+		cur     []rune
+		start   int
+		inQuote bool
+		quoteAt int
+	)
+	flush := func() {
+		if len(cur) > 0 {
+			tokens = append(tokens, exprToken{Text: string(cur), Pos: start})
+			cur = cur[:0]
+		}
+	}
+	for i, r := range []rune(s) {
+		if unicode.IsSpace(r) && !inQuote {
+			flush()
+			continue
+		}
+		if len(cur) == 0 {
+			start = i
+		}
+		if r == '"' {
+			inQuote = !inQuote
+			quoteAt = i
+		}
+		cur = append(cur, r)
+	}
+	if inQuote {
+		return nil, &QueryError{Message: "Unterminated quote", Token: string(cur), Pos: quoteAt}
+	}
+	flush()
+	return tokens, nil
+}
+
+// parseTodoExpr parses a filter expression; now anchors relative dates.
+func parseTodoExpr(s string, now time.Time) (*TodoExpr, error) {
+	tokens, qerr := lexTodoExpr(s)
+	if qerr != nil {
+		return nil, qerr
+	}
+	expr := &TodoExpr{}
+	for _, tok := range tokens {
+		clause, qerr := parseExprClause(tok, now)
+		if qerr != nil {
+			return nil, qerr
+		}
+		expr.Clauses = append(expr.Clauses, clause)
+	}
+	return expr, nil
+}
+
+func parseExprClause(tok exprToken, now time.Time) (ExprClause, *QueryError) {
+	text := tok.Text
+	negated := len(text) > 1 && text[0] == '-'
+	if negated {
+		text = text[1:]
+	}
+	field, op, value := splitExprField(text)
+	if field == "" {
+		switch strings.ToLower(text) {
+		case "completed", "active":
+			return &CompletedClause{tok: tok, Completed: strings.EqualFold(text, "completed") != negated}, nil
+		case "starred":
+			return &StarredClause{tok: tok, Negated: negated}, nil
+		}
+		return &TextClause{tok: tok, Negated: negated, Text: text}, nil
+	}
+	if op != ":" && op != "=" && field != "due" {
+		return nil, tok.errorf("Use %s:value", field)
+	}
+	value = strings.Trim(value, `"`)
+	if value == "" {
+		return nil, tok.errorf("Missing value for %s", field)
+	}
+	lower := strings.ToLower(value)
+	switch field {
+	case "priority":
+		c := &PriorityClause{tok: tok, Negated: negated}
+		for _, v := range strings.Split(lower, ",") {
+			switch v {
+			case "low", "medium", "high":
+				c.Values = append(c.Values, v)
+			case "none":
+				c.Values = append(c.Values, "")
+			default:
+				return nil, tok.errorf("Priority must be low, medium, high or none")
+			}
+		}
+		return c, nil
+	case "status", "is":
+		switch lower {
+		case "active", "completed":
+			return &CompletedClause{tok: tok, Completed: (lower == "completed") != negated}, nil
+		case "starred":
+			if field == "is" {
+				return &StarredClause{tok: tok, Negated: negated}, nil
+			}
+		}
+		if field == "is" {
+			return nil, tok.errorf("is must be active, completed or starred")
+		}
+		return nil, tok.errorf("Status must be active or completed")
+	case "starred":
+		if lower != "me" {
+			return nil, tok.errorf("Only starred:me is supported")
+		}
+		return &StarredClause{tok: tok, Negated: negated}, nil
+	case "tag":
+		return &TagClause{tok: tok, Negated: negated, Tag: value}, nil
+	case "due":
+		if lower == "none" || lower == "any" {
+			if op != ":" && op != "=" {
+				return nil, tok.errorf("Use due:%s", lower)
+			}
+			return &HasDueClause{tok: tok, Has: (lower == "any") != negated}, nil
+		}
+		if negated {
+			return nil, tok.errorf("Due date comparisons cannot be negated, use the opposite comparison")
+		}
+		from, to, ok := parseExprTime(lower, now)
+		if !ok {
+			return nil, tok.errorf("Due date must be YYYY-MM-DD, today, tomorrow, yesterday, now or a relative time like 7d, -2w or 12h")
+		}
+		if (op == ":" || op == "=") && from.Equal(to) {
+			return nil, tok.errorf("Compare times with < or >, e.g. due<%s", value)
+		}
+		return &DueClause{tok: tok, Op: op, From: from, To: to}, nil
+	}
+	return nil, tok.errorf("Unknown field %q, quote it to search for it", field)
+}
+
+// splitExprField splits "field<op>value" into its parts. field is empty when
+// text is not a field clause: no operator, or something other than letters
+// before it (a phrase, a time like 10:30).
+func splitExprField(text string) (field, op, value string) {
+	i := strings.IndexAny(text, `:<>="`)
+	if i <= 0 || text[i] == '"' {
+		return "", "", ""
+	}
+	for _, r := range text[:i] {
+		if !unicode.IsLetter(r) {
+			return "", "", ""
+		}
+	}
+	op = text[i : i+1]
+	if (op == "<" || op == ">") && strings.HasPrefix(text[i+1:], "=") {
+		op += "="
+	}
+	return strings.ToLower(text[:i]), op, text[i+len(op):]
+}
+
+// parseExprTime resolves a date or time in an expression to [from, to): a
+// whole day for dates, a single instant otherwise. Days are UTC days, like
+// the due dates the client stores.
+func parseExprTime(s string, now time.Time) (from, to time.Time, ok bool) {
+	today := now.UTC().Truncate(24 * time.Hour)
+	day := func(d time.Time) (time.Time, time.Time, bool) { return d, d.AddDate(0, 0, 1), true }
+	switch s {
+	case "now":
+		return now, now, true
+	case "today":
+		return day(today)
+	case "tomorrow":
+		return day(today.AddDate(0, 0, 1))
+	case "yesterday":
+		return day(today.AddDate(0, 0, -1))
+	}
+	if d, err := time.Parse("2006-01-02", s); err == nil {
+		return day(d)
+	}
+	if len(s) < 2 {
+		return time.Time{}, time.Time{}, false
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	var t time.Time
+	switch s[len(s)-1] {
+	case 'h':
+		t = now.Add(time.Duration(n) * time.Hour)
+	case 'd':
+		t = now.AddDate(0, 0, n)
+	case 'w':
+		t = now.AddDate(0, 0, 7*n)
+	default:
+		return time.Time{}, time.Time{}, false
+	}
+	return t, t, true
+}
+
+// apply compiles e into f, on top of whatever f already holds. viewer is the
+// signed-in user, needed for starred clauses.
+func (e *TodoExpr) apply(f *TodoFilter, viewer *primitive.ObjectID) error {
+	var search []string
+	var completedBy, priorityBy, hasDueBy, dueBy exprToken
+	if f.Search != nil {
+		search = append(search, f.Search.Raw)
+	}
+	for _, clause := range e.Clauses {
+		tok := clause.token()
+		switch c := clause.(type) {
+		case *TextClause:
+			if c.Negated {
+				return tok.errorf("Excluding search text is not supported")
+			}
+			search = append(search, c.Text)
+		case *PriorityClause:
+			if c.Negated {
+				f.ExcludePriorities = append(f.ExcludePriorities, c.Values...)
+				break
+			}
+			if f.Priorities == nil {
+				f.Priorities, priorityBy = c.Values, tok
+				break
+			}
+			var both []string
+			for _, p := range c.Values {
+				if containsString(f.Priorities, p) {
+					both = append(both, p)
+				}
+			}
+			if len(both) == 0 {
+				return tok.errorf("Conflicts with %s", exprSource(priorityBy, "the priority parameter"))
+			}
+			f.Priorities = both
+		case *CompletedClause:
+			if f.Completed != nil && *f.Completed != c.Completed {
+				return tok.errorf("Conflicts with %s", exprSource(completedBy, "the status parameter"))
+			}
+			completed := c.Completed
+			f.Completed, completedBy = &completed, tok
+		case *StarredClause:
+			if viewer == nil {
+				return tok.errorf("Sign in to filter by starred todos")
+			}
+			if c.Negated {
+				f.NotStarredBy = viewer
+			} else {
+				f.StarredBy = viewer
+			}
+		case *TagClause:
+			if c.Negated {
+				f.ExcludeTags = append(f.ExcludeTags, c.Tag)
+			} else {
+				f.Tags = append(f.Tags, c.Tag)
+			}
+		case *HasDueClause:
+			if f.HasDueDate != nil && *f.HasDueDate != c.Has {
+				return tok.errorf("Conflicts with an earlier due clause")
+			}
+			has := c.Has
+			f.HasDueDate, hasDueBy = &has, tok
+		case *DueClause:
+			var after, before *time.Time
+			switch c.Op {
+			case ":", "=":
+				after, before = &c.From, &c.To
+			case "<":
+				before = &c.From
+			case "<=":
+				before = &c.To
+			case ">":
+				after = &c.To
+			case ">=":
+				after = &c.From
+			}
+			dueBy = tok
+			if after != nil && (f.DueAfter == nil || after.After(*f.DueAfter)) {
+				f.DueAfter = after
+			}
+			if before != nil && (f.DueBefore == nil || before.Before(*f.DueBefore)) {
+				f.DueBefore = before
+			}
+		}
+	}
+	if f.HasDueDate != nil && !*f.HasDueDate && (f.DueAfter != nil || f.DueBefore != nil) {
+		// Report the clause of this expression; the other may come from a
+		// parameter applied earlier
+		if hasDueBy.Text == "" {
+			return dueBy.errorf("Conflicts with %s", exprSource(hasDueBy, "the noDueDate parameter"))
+		}
+		return hasDueBy.errorf("Conflicts with %s", exprSource(dueBy, "the dueAfter and dueBefore parameters"))
+	}
+	f.Search = parseSearchQuery(strings.Join(search, " "))
+	return nil
+}
+
+// exprSource names where an earlier restriction came from in error messages.
+func exprSource(tok exprToken, param string) string {
+	if tok.Text == "" {
+		return param
+	}
+	return fmt.Sprintf("%q", tok.Text)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
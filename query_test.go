@@ -0,0 +1,163 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var queryTestNow = time.Date(2025, 3, 10, 15, 30, 0, 0, time.UTC)
+
+// compileTodoExpr parses s and applies it on top of f.
+func compileTodoExpr(s string, f TodoFilter, viewer *primitive.ObjectID) (TodoFilter, error) {
+	expr, err := parseTodoExpr(s, queryTestNow)
+	if err == nil {
+		err = expr.apply(&f, viewer)
+	}
+	return f, err
+}
+
+func TestParseTodoExprClauses(t *testing.T) {
+	for _, c := range []struct {
+		expr string
+		want ExprClause
+	}{
+		{"completed", &CompletedClause{Completed: true}},
+		{"-completed", &CompletedClause{Completed: false}},
+		{"-status:active", &CompletedClause{Completed: true}},
+		{"due:none", &HasDueClause{Has: false}},
+		{"-due:none", &HasDueClause{Has: true}},
+		{"-due:any", &HasDueClause{Has: false}},
+		{"-priority:low,none", &PriorityClause{Negated: true, Values: []string{"low", ""}}},
+		{"-tag:Work", &TagClause{Negated: true, Tag: "Work"}},
+		{"-is:starred", &StarredClause{Negated: true}},
+		{`priority:"high"`, &PriorityClause{Values: []string{"high"}}},
+		// Quoted field names, and things that only look like fields, are search text
+		{`"priority:high"`, &TextClause{Text: `"priority:high"`}},
+		{"10:30", &TextClause{Text: "10:30"}},
+		{"-", &TextClause{Text: "-"}},
+	} {
+		expr, err := parseTodoExpr(c.expr, queryTestNow)
+		if err != nil || len(expr.Clauses) != 1 {
+			t.Errorf("%s: %+v, %v", c.expr, expr, err)
+			continue
+		}
+		got := expr.Clauses[0]
+		if tok := got.token(); tok.Text != c.expr || tok.Pos != 0 {
+			t.Errorf("%s: token %+v", c.expr, tok)
+		}
+		// Compare without the token
+		switch c := got.(type) {
+		case *TextClause:
+			c.tok = exprToken{}
+		case *PriorityClause:
+			c.tok = exprToken{}
+		case *CompletedClause:
+			c.tok = exprToken{}
+		case *StarredClause:
+			c.tok = exprToken{}
+		case *TagClause:
+			c.tok = exprToken{}
+		case *HasDueClause:
+			c.tok = exprToken{}
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: %+v, want %+v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestTodoExprDueComparisons(t *testing.T) {
+	day := func(y int, m time.Month, d int) *time.Time {
+		t := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	at := func(d time.Duration) *time.Time {
+		t := queryTestNow.Add(d)
+		return &t
+	}
+	for _, c := range []struct {
+		expr          string
+		after, before *time.Time
+	}{
+		{"due<7d", nil, at(7 * 24 * time.Hour)},
+		{"due<=2025-01-31", nil, day(2025, 2, 1)},
+		{"due>today", day(2025, 3, 11), nil},
+		{"due>=-2w", at(-14 * 24 * time.Hour), nil},
+		{"due>12h", at(12 * time.Hour), nil},
+		{"due<now", nil, at(0)},
+		{"due:tomorrow", day(2025, 3, 11), day(2025, 3, 12)},
+		{"due=yesterday", day(2025, 3, 9), day(2025, 3, 10)},
+		// The tightest bounds win
+		{"due>=2025-03-01 due>2025-03-04 due<30d due<=2025-03-20", day(2025, 3, 5), day(2025, 3, 21)},
+	} {
+		f, err := compileTodoExpr(c.expr, TodoFilter{}, nil)
+		if err != nil || !reflect.DeepEqual(f.DueAfter, c.after) || !reflect.DeepEqual(f.DueBefore, c.before) {
+			t.Errorf("%s: after %v before %v, %v; want after %v before %v", c.expr, f.DueAfter, f.DueBefore, err, c.after, c.before)
+		}
+	}
+}
+
+func TestTodoExprCombines(t *testing.T) {
+	viewer := primitive.NewObjectID()
+	f, err := compileTodoExpr(`priority:high,medium -priority:medium tag:home -tag:work starred "oat milk"`,
+		TodoFilter{Search: parseSearchQuery("eggs")}, &viewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f.Priorities, []string{"high", "medium"}) || !reflect.DeepEqual(f.ExcludePriorities, []string{"medium"}) ||
+		!reflect.DeepEqual(f.Tags, []string{"home"}) || !reflect.DeepEqual(f.ExcludeTags, []string{"work"}) ||
+		f.StarredBy == nil || *f.StarredBy != viewer {
+		t.Errorf("filter: %+v", f)
+	}
+	if f.Search == nil || f.Search.Raw != `eggs "oat milk"` {
+		t.Errorf("search: %+v", f.Search)
+	}
+	// A second priority clause narrows the first
+	if f, err := compileTodoExpr("priority:high,medium priority:medium,low", TodoFilter{}, nil); err != nil || !reflect.DeepEqual(f.Priorities, []string{"medium"}) {
+		t.Errorf("narrowed priorities: %v, %v", f.Priorities, err)
+	}
+}
+
+func TestTodoExprErrors(t *testing.T) {
+	completed, active, noDue := true, false, false
+	tomorrow := queryTestNow.AddDate(0, 0, 1)
+	for _, c := range []struct {
+		expr   string
+		filter TodoFilter
+		want   QueryError
+	}{
+		{`milk "oat`, TodoFilter{}, QueryError{"Unterminated quote", `"oat`, 5}},
+		{`café due:"soon`, TodoFilter{}, QueryError{"Unterminated quote", `due:"soon`, 9}},
+		{"café priority:urgent", TodoFilter{}, QueryError{"Priority must be low, medium, high or none", "priority:urgent", 5}},
+		{"milk colour:red", TodoFilter{}, QueryError{`Unknown field "colour", quote it to search for it`, "colour:red", 5}},
+		{"priority<high", TodoFilter{}, QueryError{"Use priority:value", "priority<high", 0}},
+		{"tag:", TodoFilter{}, QueryError{"Missing value for tag", "tag:", 0}},
+		{"status:done", TodoFilter{}, QueryError{"Status must be active or completed", "status:done", 0}},
+		{"starred:you", TodoFilter{}, QueryError{"Only starred:me is supported", "starred:you", 0}},
+		{"starred", TodoFilter{}, QueryError{"Sign in to filter by starred todos", "starred", 0}},
+		{"-milk", TodoFilter{}, QueryError{"Excluding search text is not supported", "-milk", 0}},
+		{"due<soon", TodoFilter{}, QueryError{"Due date must be YYYY-MM-DD, today, tomorrow, yesterday, now or a relative time like 7d, -2w or 12h", "due<soon", 0}},
+		{"-due<7d", TodoFilter{}, QueryError{"Due date comparisons cannot be negated, use the opposite comparison", "-due<7d", 0}},
+		{"due:7d", TodoFilter{}, QueryError{"Compare times with < or >, e.g. due<7d", "due:7d", 0}},
+		{"due<none", TodoFilter{}, QueryError{"Use due:none", "due<none", 0}},
+
+		{"active completed", TodoFilter{}, QueryError{`Conflicts with "active"`, "completed", 7}},
+		{"milk completed", TodoFilter{Completed: &active}, QueryError{"Conflicts with the status parameter", "completed", 5}},
+		{"-completed", TodoFilter{Completed: &completed}, QueryError{"Conflicts with the status parameter", "-completed", 0}},
+		{"priority:low,high priority:medium", TodoFilter{}, QueryError{`Conflicts with "priority:low,high"`, "priority:medium", 18}},
+		{"priority:low", TodoFilter{Priorities: []string{"high"}}, QueryError{"Conflicts with the priority parameter", "priority:low", 0}},
+		{"due:any due:none", TodoFilter{}, QueryError{"Conflicts with an earlier due clause", "due:none", 8}},
+		{"due:none milk due<7d", TodoFilter{}, QueryError{`Conflicts with "due<7d"`, "due:none", 0}},
+		{"milk due:none", TodoFilter{DueBefore: &tomorrow}, QueryError{"Conflicts with the dueAfter and dueBefore parameters", "due:none", 5}},
+		{"milk due<7d", TodoFilter{HasDueDate: &noDue}, QueryError{"Conflicts with the noDueDate parameter", "due<7d", 5}},
+	} {
+		_, err := compileTodoExpr(c.expr, c.filter, nil)
+		qerr, ok := err.(*QueryError)
+		if !ok || *qerr != c.want {
+			t.Errorf("%s: %v, want %+v", c.expr, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newSecretToken returns a random token to hand to the client and the hash to
+// store in its place.
+func newSecretToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashSecretToken(token), nil
+}
+
+func hashSecretToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// envDuration reads a duration such as "15m" from the environment, falling
+// back to def when it is unset or invalid.
+func envDuration(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+func refreshTokenTTL() time.Duration {
+	return envDuration("REFRESH_TOKEN_EXPIRES_IN", 30*24*time.Hour)
+}
+
+// issueTokens signs an access token for userID and creates the next refresh
+// token of familyID.
+func issueTokens(ctx context.Context, userID, familyID primitive.ObjectID) (access, refresh string, err error) {
+	access, err = generateToken(userID.Hex(), familyID.Hex())
+	if err != nil {
+		return "", "", err
+	}
+	refresh, hash, err := newSecretToken()
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now().UTC()
+	err = store.CreateRefreshToken(ctx, &RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(refreshTokenTTL()),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return access, refresh, nil
+}
+
+// refreshHandler trades a refresh token for a new access token and a new
+// refresh token. Each refresh token works once: presenting a used one means
+// it was copied, so every token of its family is revoked and the user has to
+// sign in again.
+func refreshHandler(c *fiber.Ctx) error {
+	var payload struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := c.BodyParser(&payload); err != nil || payload.RefreshToken == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Missing refresh token"})
+	}
+	now := time.Now().UTC()
+	rt, err := store.GetRefreshTokenByHash(c.Context(), hashSecretToken(payload.RefreshToken))
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Status(401).JSON(fiber.Map{"error": "Invalid refresh token"})
+		}
+		return err
+	}
+	if rt.RevokedAt != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid refresh token"})
+	}
+	if !now.Before(rt.ExpiresAt) {
+		return c.Status(401).JSON(fiber.Map{"error": "Refresh token expired"})
+	}
+	if err := store.UseRefreshToken(c.Context(), rt.ID, now); err != nil {
+		if err != ErrTokenUsed {
+			return err
+		}
+		log.Printf("refresh token reuse: user=%s family=%s; revoking family", rt.UserID.Hex(), rt.FamilyID.Hex())
+		if err := store.RevokeRefreshTokenFamily(c.Context(), rt.FamilyID, now); err != nil {
+			return err
+		}
+		return c.Status(401).JSON(fiber.Map{"error": "Refresh token reused"})
+	}
+	if err := touchSession(c, rt, now); err != nil {
+		return err
+	}
+	access, refresh, err := issueTokens(c.Context(), rt.UserID, rt.FamilyID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"token": access, "refreshToken": refresh})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// isTokenRevoked reports whether the token was revoked by logout or by
+// ending its session. Revoking by session rather than by time lets a user
+// sign in again right after logout-all: iat only has second precision.
+func isTokenRevoked(ctx context.Context, claims *AuthClaims) (bool, error) {
+	var keys []string
+	if claims.ID != "" {
+		keys = append(keys, claims.ID)
+	}
+	if claims.SessionID != "" {
+		keys = append(keys, sessionRevocationKey(claims.SessionID))
+	}
+	if len(keys) == 0 {
+		return false, nil
+	}
+	entries, err := store.GetRevokedTokens(ctx, keys)
+	if err != nil {
+		return false, err
+	}
+	return len(entries) > 0, nil
+}
+
+// logoutHandler revokes the access token it is called with and ends its
+// session.
+func logoutHandler(c *fiber.Ctx) error {
+	claims := c.Locals("claims").(*AuthClaims)
+	now := time.Now().UTC()
+	if claims.ID != "" && claims.ExpiresAt != nil {
+		err := store.RevokeToken(c.Context(), RevokedToken{Key: claims.ID, RevokedAt: now, ExpiresAt: claims.ExpiresAt.Time})
+		if err != nil {
+			return err
+		}
+	}
+	if sessionID, err := primitive.ObjectIDFromHex(claims.SessionID); err == nil {
+		if err := endSession(c.Context(), sessionID, now); err != nil {
+			return err
+		}
+	}
+	return c.Status(200).JSON(fiber.Map{"success": true})
+}
+
+// logoutAllHandler signs the user out everywhere.
+func logoutAllHandler(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	if err := signOutEverywhere(c.Context(), userID, time.Now().UTC()); err != nil {
+		return err
+	}
+	return c.Status(200).JSON(fiber.Map{"success": true})
+}
+
+// signOutEverywhere ends every session of the user, which revokes their
+// refresh and access tokens, and deletes their API tokens.
+func signOutEverywhere(ctx context.Context, userID primitive.ObjectID, now time.Time) error {
+	if err := signOutOtherSessions(ctx, userID, primitive.NilObjectID, now); err != nil {
+		return err
+	}
+	if err := store.DeleteUserSessions(ctx, userID); err != nil {
+		return err
+	}
+	return store.DeleteUserAPITokens(ctx, userID)
+}
+
+// collectExpiredTokens deletes revocations, refresh tokens, sessions,
+// one-time tokens, API tokens and rate limits that have expired, every
+// interval until ctx is done.
+func collectExpiredTokens(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now().UTC()
+		if err := store.DeleteExpiredRevocations(ctx, now); err != nil {
+			log.Println("Error deleting expired revocations:", err)
+		}
+		if err := store.DeleteExpiredRefreshTokens(ctx, now); err != nil {
+			log.Println("Error deleting expired refresh tokens:", err)
+		}
+		if err := store.DeleteExpiredSessions(ctx, now); err != nil {
+			log.Println("Error deleting expired sessions:", err)
+		}
+		if err := store.DeleteExpiredOneTimeTokens(ctx, now); err != nil {
+			log.Println("Error deleting expired one-time tokens:", err)
+		}
+		if err := store.DeleteExpiredAPITokens(ctx, now); err != nil {
+			log.Println("Error deleting expired API tokens:", err)
+		}
+		if err := store.DeleteExpiredRateLimits(ctx, now); err != nil {
+			log.Println("Error deleting expired rate limits:", err)
+		}
+	}
+}
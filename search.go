@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Full-text search over a todo's body, tags and notes.
+//
+// Text is split into words made of letters and digits; everything else is a
+// separator. A query is a list of clauses that must all match:
+//
+//	milk            the word "milk"
+//	mil*            any word starting with "mil" (at least minPrefixLen letters)
+//	"buy oat milk"  the words in this order, next to each other, in one field
+//
+// Stores only select candidate todos (using their own indexes); ranking and
+// highlighting are done here so every backend returns the same results.
+
+const (
+	// minPrefixLen is the shortest prefix a "word*" clause matches on; shorter
+	// ones are treated as whole words.
+	minPrefixLen = 2
+	// maxIndexedPrefixLen bounds the prefixes stores index per word.
+	maxIndexedPrefixLen = 15
+	// highlightWindow is the approximate length, in bytes, of a highlight fragment.
+	highlightWindow = 160
+	// maxRelevanceCandidates bounds how many matches a relevance listing
+	// fetches and scores.
+	maxRelevanceCandidates = 1000
+)
+
+// searchFieldWeights ranks matches in the body above tags, and tags above notes.
+var searchFieldWeights = map[string]float64{"body": 3, "tags": 2, "notes": 1}
+
+// SearchTerm is one clause of a SearchQuery.
+type SearchTerm struct {
+	// Words holds the lowercased words of the clause; more than one means a phrase.
+	Words []string
+	// Prefix makes the last word match any word it is a prefix of.
+	Prefix bool
+}
+
+func (t SearchTerm) phrase() bool { return len(t.Words) > 1 }
+
+// SearchQuery is a parsed search string.
+type SearchQuery struct {
+	Raw   string
+	Terms []SearchTerm
+}
+
+// SearchHighlight is a fragment of a matched field. Fragment is HTML-escaped
+// text with the matched words wrapped in <mark></mark>.
+type SearchHighlight struct {
+	Field    string `json:"field"`
+	Fragment string `json:"fragment"`
+}
+
+// searchToken is a word of a text and its byte offsets in that text.
+type searchToken struct {
+	Word       string
+	Start, End int
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// tokenize splits text into lowercased words.
+func tokenize(text string) []searchToken {
+	var tokens []searchToken
+	start := -1
+	for i, r := range text {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, searchToken{Word: strings.ToLower(text[start:i]), Start: start, End: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, searchToken{Word: strings.ToLower(text[start:]), Start: start, End: len(text)})
+	}
+	return tokens
+}
+
+func tokenWords(text string) []string {
+	var words []string
+	for _, t := range tokenize(text) {
+		words = append(words, t.Word)
+	}
+	return words
+}
+
+// parseSearchQuery parses a search string. It returns nil when the string
+// contains no words. An unterminated quote runs to the end of the string.
+func parseSearchQuery(s string) *SearchQuery {
+	q := &SearchQuery{Raw: s}
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == ' ' || s[i] == '\t':
+			i++
+		case s[i] == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			var inner string
+			if end < 0 {
+				inner, i = s[i+1:], len(s)
+			} else {
+				inner, i = s[i+1:i+1+end], i+end+2
+			}
+			if words := tokenWords(inner); len(words) > 0 {
+				q.Terms = append(q.Terms, SearchTerm{Words: words})
+			}
+		default:
+			end := strings.IndexAny(s[i:], " \t\"")
+			if end < 0 {
+				end = len(s) - i
+			}
+			raw := s[i : i+end]
+			i += end
+			words := tokenWords(raw)
+			if len(words) == 0 {
+				continue
+			}
+			// "e-mail" is a phrase of "e" and "mail", as the text would be split
+			term := SearchTerm{Words: words, Prefix: strings.HasSuffix(raw, "*")}
+			if term.Prefix && utf8.RuneCountInString(words[len(words)-1]) < minPrefixLen {
+				term.Prefix = false
+			}
+			q.Terms = append(q.Terms, term)
+		}
+	}
+	if len(q.Terms) == 0 {
+		return nil
+	}
+	return q
+}
+
+// searchField is one searchable piece of text of a todo. Each tag is its own
+// field so phrases never span two tags.
+type searchField struct {
+	Name   string
+	Text   string
+	Tokens []searchToken
+}
+
+func todoSearchFields(todo *Todo) []searchField {
+	fields := []searchField{{Name: "body", Text: todo.Body}}
+	for _, tag := range todo.Tags {
+		fields = append(fields, searchField{Name: "tags", Text: tag})
+	}
+	if todo.Notes != "" {
+		fields = append(fields, searchField{Name: "notes", Text: todo.Notes})
+	}
+	for i := range fields {
+		fields[i].Tokens = tokenize(fields[i].Text)
+	}
+	return fields
+}
+
+// todoSearchTerms lists the index entries a store keeps for todo: every word,
+// plus "p:"-prefixed prefixes of each word for prefix clauses.
+func todoSearchTerms(todo *Todo) []string {
+	seen := map[string]bool{}
+	terms := []string{}
+	add := func(t string) {
+		if !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	for _, f := range todoSearchFields(todo) {
+		for _, tok := range f.Tokens {
+			add(tok.Word)
+			runes := []rune(tok.Word)
+			for n := minPrefixLen; n <= len(runes) && n <= maxIndexedPrefixLen; n++ {
+				add("p:" + string(runes[:n]))
+			}
+		}
+	}
+	return terms
+}
+
+// indexTerms lists the index entries every todo matching t must have, see todoSearchTerms.
+func (t SearchTerm) indexTerms() []string {
+	terms := make([]string, 0, len(t.Words))
+	for i, w := range t.Words {
+		if t.Prefix && i == len(t.Words)-1 {
+			if runes := []rune(w); len(runes) > maxIndexedPrefixLen {
+				w = string(runes[:maxIndexedPrefixLen])
+			}
+			w = "p:" + w
+		}
+		terms = append(terms, w)
+	}
+	return terms
+}
+
+// matchAt reports whether t matches tokens starting at index i.
+func (t SearchTerm) matchAt(tokens []searchToken, i int) bool {
+	if i+len(t.Words) > len(tokens) {
+		return false
+	}
+	for j, w := range t.Words {
+		tok := tokens[i+j].Word
+		if t.Prefix && j == len(t.Words)-1 {
+			if !strings.HasPrefix(tok, w) {
+				return false
+			}
+		} else if tok != w {
+			return false
+		}
+	}
+	return true
+}
+
+// matches returns the byte spans in f where t matches.
+func (t SearchTerm) matches(f searchField) [][2]int {
+	var spans [][2]int
+	for i := range f.Tokens {
+		if t.matchAt(f.Tokens, i) {
+			spans = append(spans, [2]int{f.Tokens[i].Start, f.Tokens[i+len(t.Words)-1].End})
+		}
+	}
+	return spans
+}
+
+// Match reports whether every clause of q matches somewhere in todo.
+func (q *SearchQuery) Match(todo *Todo) bool {
+	fields := todoSearchFields(todo)
+	for _, term := range q.Terms {
+		found := false
+		for _, f := range fields {
+			if len(term.matches(f)) > 0 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Score ranks todo against q, BM25-style: repeated matches add less and
+// less, matches in short fields weigh more than in long ones, phrases weigh
+// more than single words and prefixes less. Zero means no match.
+func (q *SearchQuery) Score(todo *Todo) float64 {
+	const k1, b, avgLen = 1.2, 0.75, 12.0
+	fields := todoSearchFields(todo)
+	score := 0.0
+	for _, term := range q.Terms {
+		termScore := 0.0
+		for _, f := range fields {
+			tf := float64(len(term.matches(f)))
+			if tf == 0 {
+				continue
+			}
+			norm := 1 - b + b*float64(len(f.Tokens))/avgLen
+			termScore += searchFieldWeights[f.Name] * tf * (k1 + 1) / (tf + k1*norm)
+		}
+		if termScore == 0 {
+			return 0
+		}
+		if term.phrase() {
+			termScore *= 1 + 0.5*float64(len(term.Words)-1)
+		}
+		if term.Prefix {
+			termScore *= 0.8
+		}
+		score += termScore
+	}
+	// Round so scores survive a JSON round trip in cursors unchanged
+	return math.Round(score*1e6) / 1e6
+}
+
+// Highlights returns one fragment per field of todo that q matches.
+func (q *SearchQuery) Highlights(todo *Todo) []SearchHighlight {
+	var highlights []SearchHighlight
+	for _, f := range todoSearchFields(todo) {
+		var spans [][2]int
+		for _, term := range q.Terms {
+			spans = append(spans, term.matches(f)...)
+		}
+		if len(spans) == 0 {
+			continue
+		}
+		highlights = append(highlights, SearchHighlight{Field: f.Name, Fragment: highlightFragment(f.Text, spans)})
+	}
+	return highlights
+}
+
+// highlightFragment cuts a window of text around the first span and marks
+// every span inside it.
+func highlightFragment(text string, spans [][2]int) string {
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	start, end := 0, len(text)
+	if len(text) > highlightWindow {
+		start = spans[0][0] - highlightWindow/4
+		if start < 0 {
+			start = 0
+		}
+		end = start + highlightWindow
+		if end > len(text) {
+			end, start = len(text), len(text)-highlightWindow
+		}
+		// Do not cut a multi-byte character in half
+		for start > 0 && !utf8.RuneStart(text[start]) {
+			start--
+		}
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end++
+		}
+	}
+	var sb strings.Builder
+	if start > 0 {
+		sb.WriteString("…")
+	}
+	pos := start
+	for _, sp := range spans {
+		if sp[0] < pos || sp[1] > end {
+			continue // overlaps the previous mark or falls outside the window
+		}
+		sb.WriteString(html.EscapeString(text[pos:sp[0]]))
+		sb.WriteString("<mark>")
+		sb.WriteString(html.EscapeString(text[sp[0]:sp[1]]))
+		sb.WriteString("</mark>")
+		pos = sp[1]
+	}
+	sb.WriteString(html.EscapeString(text[pos:end]))
+	if end < len(text) {
+		sb.WriteString("…")
+	}
+	return sb.String()
+}
+
+// relevanceSort orders search results best match first. Stores cannot sort
+// by it; listTodosByRelevance does.
+var relevanceSort = TodoSort{Field: "relevance", Desc: true}
+
+// listTodosByRelevance pages through the todos matching q.Search, best match
+// first. Scores are computed in Go, so the matches are fetched from the
+// store; only the newest maxRelevanceCandidates of them are ranked, and
+// older ones are left out of the listing though Total still counts them.
+func listTodosByRelevance(ctx context.Context, q TodoQuery) (*TodoPage, error) {
+	candidates := q
+	candidates.Sort, candidates.After, candidates.Limit = defaultTodoSort, nil, maxRelevanceCandidates
+	res, err := store.ListTodos(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+	todos := res.Items
+	scores := make(map[primitive.ObjectID]float64, len(todos))
+	for i := range todos {
+		scores[todos[i].ID] = q.Search.Score(&todos[i])
+	}
+	sort.SliceStable(todos, func(i, j int) bool {
+		return compareTodoPositions(scores[todos[i].ID], todos[i].ID, scores[todos[j].ID], todos[j].ID, relevanceSort) < 0
+	})
+	page := &TodoPage{Items: []Todo{}, Total: res.Total}
+	start := 0
+	if q.After != nil {
+		start = sort.Search(len(todos), func(i int) bool {
+			return compareTodoPositions(scores[todos[i].ID], todos[i].ID, q.After.Value, q.After.ID, relevanceSort) > 0
+		})
+	}
+	end := len(todos)
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+		last := todos[end-1]
+		page.Next = &TodoCursor{Value: scores[last.ID], ID: last.ID}
+	}
+	page.Items = append(page.Items, todos[start:end]...)
+	return page, nil
+}
+
+// todoSearchResult is a todo in a search listing, with its matched fragments.
+type todoSearchResult struct {
+	todoJSON
+	Score      float64           `json:"score,omitempty"`
+	Highlights []SearchHighlight `json:"highlights"`
+}
+
+func searchResults(todos []Todo, q *SearchQuery, viewer *primitive.ObjectID) []todoSearchResult {
+	results := make([]todoSearchResult, len(todos))
+	for i := range todos {
+		results[i] = todoSearchResult{todoJSON: newTodoJSON(&todos[i], viewer), Score: q.Score(&todos[i]), Highlights: q.Highlights(&todos[i])}
+	}
+	return results
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("Buy oat-milk, 2x! Café crème")
+	want := []searchToken{
+		{"buy", 0, 3}, {"oat", 4, 7}, {"milk", 8, 12}, {"2x", 14, 16},
+		{"café", 18, 23}, {"crème", 24, 30},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokenize: %+v, want %+v", got, want)
+	}
+	if got := tokenize(" -- "); got != nil {
+		t.Errorf("no words: %+v", got)
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	for _, c := range []struct {
+		search string
+		want   []SearchTerm
+	}{
+		{"milk", []SearchTerm{{Words: []string{"milk"}}}},
+		{"Mil*", []SearchTerm{{Words: []string{"mil"}, Prefix: true}}},
+		// Too short to be a prefix
+		{"m*", []SearchTerm{{Words: []string{"m"}}}},
+		{`"Buy oat milk" eggs`, []SearchTerm{{Words: []string{"buy", "oat", "milk"}}, {Words: []string{"eggs"}}}},
+		{`eggs "oat milk`, []SearchTerm{{Words: []string{"eggs"}}, {Words: []string{"oat", "milk"}}}},
+		{"e-mail", []SearchTerm{{Words: []string{"e", "mail"}}}},
+		{"oat-mi*", []SearchTerm{{Words: []string{"oat", "mi"}, Prefix: true}}},
+		{`"" - *`, nil},
+	} {
+		q := parseSearchQuery(c.search)
+		if c.want == nil {
+			if q != nil {
+				t.Errorf("%q: %+v, want nil", c.search, q.Terms)
+			}
+			continue
+		}
+		if q == nil || q.Raw != c.search || !reflect.DeepEqual(q.Terms, c.want) {
+			t.Errorf("%q: %+v, want %+v", c.search, q, c.want)
+		}
+	}
+}
+
+func TestTodoSearchTerms(t *testing.T) {
+	got := todoSearchTerms(&Todo{Body: "Oat milk", Tags: []string{"oat"}, Notes: "é"})
+	want := []string{"oat", "p:oa", "p:oat", "milk", "p:mi", "p:mil", "p:milk", "é"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("terms: %v, want %v", got, want)
+	}
+
+	long := "abcdefghijklmnopqrst"
+	terms := todoSearchTerms(&Todo{Body: long})
+	if len(terms) != 1+maxIndexedPrefixLen-minPrefixLen+1 || terms[len(terms)-1] != "p:"+long[:maxIndexedPrefixLen] {
+		t.Errorf("long word: %v", terms)
+	}
+	// A longer prefix clause looks up the longest indexed prefix
+	if got := parseSearchQuery(long + "*").Terms[0].indexTerms(); !reflect.DeepEqual(got, []string{"p:" + long[:maxIndexedPrefixLen]}) {
+		t.Errorf("index terms of a long prefix: %v", got)
+	}
+}
+
+func TestSearchQueryScore(t *testing.T) {
+	score := func(search string, todo Todo) float64 {
+		q := parseSearchQuery(search)
+		s := q.Score(&todo)
+		if q.Match(&todo) != (s > 0) {
+			t.Errorf("%q on %+v: Match disagrees with score %v", search, todo, s)
+		}
+		return s
+	}
+	inBody := score("milk", Todo{Body: "milk"})
+	inTags := score("milk", Todo{Body: "eggs", Tags: []string{"milk"}})
+	inNotes := score("milk", Todo{Body: "eggs", Notes: "milk"})
+	if !(inBody > inTags && inTags > inNotes && inNotes > 0) {
+		t.Errorf("field weights: body %v, tags %v, notes %v", inBody, inTags, inNotes)
+	}
+	if prefix := score("mil*", Todo{Body: "milk"}); prefix <= 0 || prefix >= inBody {
+		t.Errorf("prefix %v, want below the whole word %v", prefix, inBody)
+	}
+	if short := score("milk", Todo{Body: "milk and some other words to buy"}); short >= inBody {
+		t.Errorf("longer body %v, want below %v", short, inBody)
+	}
+
+	for _, c := range []struct {
+		search string
+		todo   Todo
+	}{
+		{"milk", Todo{Body: "milky way"}},
+		{"milk eggs", Todo{Body: "milk"}},
+		{`"oat milk"`, Todo{Body: "milk oat"}},
+		// Phrases do not span two tags
+		{`"oat milk"`, Todo{Body: "x", Tags: []string{"oat", "milk"}}},
+	} {
+		if s := score(c.search, c.todo); s != 0 {
+			t.Errorf("%q on %+v: score %v, want 0", c.search, c.todo, s)
+		}
+	}
+}
+
+func TestSearchQueryHighlights(t *testing.T) {
+	for _, c := range []struct {
+		search string
+		todo   Todo
+		want   []SearchHighlight
+	}{
+		{"milk", Todo{Body: "Buy <oat> milk", Tags: []string{"milk"}, Notes: "none"}, []SearchHighlight{
+			{"body", "Buy &lt;oat&gt; <mark>milk</mark>"},
+			{"tags", "<mark>milk</mark>"},
+		}},
+		{`bre* "oat milk"`, Todo{Body: "Oat milk and bread, oat bran"}, []SearchHighlight{
+			{"body", "<mark>Oat milk</mark> and <mark>bread</mark>, oat bran"},
+		}},
+		{"milk", Todo{Body: strings.Repeat("word ", 60) + "milk" + strings.Repeat(" word", 60)}, []SearchHighlight{
+			{"body", "…" + strings.Repeat("word ", 8) + "<mark>milk</mark>" + strings.Repeat(" word", 23) + " …"},
+		}},
+		{"eggs", Todo{Body: "milk"}, nil},
+	} {
+		got := parseSearchQuery(c.search).Highlights(&c.todo)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q on %q: %+v, want %+v", c.search, c.todo.Body, got, c.want)
+		}
+	}
+}
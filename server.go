@@ -2,53 +2,97 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/joho/godotenv"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var collection *mongo.Collection
-var usersCollection *mongo.Collection
-
 func run() {
 	fmt.Println("Hello, World!")
 
 	if os.Getenv("ENV") != "production" {
+		// A missing .env is fine when everything comes from the environment,
+		// e.g. STORE_BACKEND=memory for a local demo.
 		if err := godotenv.Load(".env"); err != nil {
-			log.Fatal("Error loading .env file", err)
+			log.Println("Error loading .env file", err)
 		}
 	}
 
-	MONGO_URI := os.Getenv("MONGO_URI")
-	clientOptions := options.Client().ApplyURI(MONGO_URI)
-	client, err := mongo.Connect(context.Background(), clientOptions)
+	cfg := storeConfig{MongoURI: os.Getenv("MONGO_URI"), PostgresURL: os.Getenv("DATABASE_URL")}
+	flag.StringVar(&cfg.Backend, "store", os.Getenv("STORE_BACKEND"), "storage backend: mongo, memory, bolt or postgres")
+	flag.StringVar(&cfg.BoltPath, "db", os.Getenv("BOLT_PATH"), "database file for the bolt backend")
+	flag.StringVar(&legacyTodosMode, "legacy-todos", envOr("LEGACY_TODOS", legacyShared), "how to treat todos created before auth: shared or strict")
+	flag.StringVar(&unverifiedUsersPolicy, "unverified-users", envOr("UNVERIFIED_USERS", unverifiedAllow), "what users with an unverified email may do: allow, read-only or block")
+	flag.Parse()
+	if flag.Arg(0) == "keys" {
+		if err := runKeysCommand(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if legacyTodosMode != legacyShared && legacyTodosMode != legacyStrict {
+		log.Fatalf("unknown legacy todos mode %q", legacyTodosMode)
+	}
+	switch unverifiedUsersPolicy {
+	case unverifiedAllow, unverifiedReadOnly, unverifiedBlock:
+	default:
+		log.Fatalf("unknown unverified users policy %q", unverifiedUsersPolicy)
+	}
+
+	s, err := openStore(context.Background(), cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer client.Disconnect(context.Background())
+	defer s.Close(context.Background())
+	store = s
 
-	if err := client.Ping(context.Background(), nil); err != nil {
+	if flag.Arg(0) == "legacy" {
+		if err := runLegacyCommand(context.Background(), flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := checkLegacyTodos(context.Background()); err != nil {
 		log.Fatal(err)
 	}
-	fmt.Println("Connected to MongoDB ATLAS")
+	if err := initJWTKeys(); err != nil {
+		log.Fatal(err)
+	}
+	mailer = newMailer()
+	if webAuthn, err = newWebAuthn(); err != nil {
+		log.Fatal(err)
+	}
+	if oidcProviders, oidcProviderIDs, err = loadOIDCProviders(); err != nil {
+		log.Fatal(err)
+	}
+	stopKeys := make(chan struct{})
+	defer close(stopKeys)
+	go watchJWTKeys(stopKeys)
+	gcCtx, stopGC := context.WithCancel(context.Background())
+	defer stopGC()
+	go collectExpiredTokens(gcCtx, envDuration("TOKEN_GC_INTERVAL", 10*time.Minute))
 
-	db := client.Database("golang_db")
-	collection = db.Collection("todos")
-	usersCollection = db.Collection("users")
+	app := newApp()
 
-	// Ensure unique index on email
-	_, _ = usersCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
-		Keys:    bson.D{{Key: "email", Value: 1}},
-		Options: options.Index().SetUnique(true),
-	})
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "4000"
+	}
+	if os.Getenv("ENV") == "production" {
+		app.Static("/", "./client/dist")
+	}
+	log.Fatal(app.Listen("0.0.0.0:" + port))
+}
 
+// newApp sets up the routes. The store and the other globals run prepares
+// must be set before it serves requests.
+func newApp() *fiber.App {
 	app := fiber.New(fiber.Config{
 		// Allow larger JSON bodies so base64/data-URL avatars can be uploaded.
 		// Default may be too small for images encoded as data URLs.
@@ -67,26 +111,78 @@ func run() {
 	}))
 
 	app.Get("/api/health", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	app.Get("/.well-known/jwks.json", jwksHandler)
 
 	// Auth routes
 	app.Post("/api/auth/register", registerHandler)
 	app.Post("/api/auth/login", loginHandler)
+	app.Post("/api/auth/refresh", refreshHandler)
+	app.Post("/api/auth/logout", authMiddleware, logoutHandler)
+	app.Post("/api/auth/logout-all", authMiddleware, logoutAllHandler)
+	app.Post("/api/auth/forgot-password", forgotPasswordHandler)
+	app.Post("/api/auth/reset-password", resetPasswordHandler)
+	app.Post("/api/auth/verify-email", verifyEmailHandler)
+	app.Post("/api/auth/resend-verification", authMiddleware, resendVerificationHandler)
+	app.Get("/api/auth/sessions", authMiddleware, listSessionsHandler)
+	app.Delete("/api/auth/sessions/:id", authMiddleware, deleteSessionHandler)
 	app.Get("/api/auth/me", authMiddleware, meHandler)
 	app.Patch("/api/auth/me", authMiddleware, updateMeHandler)
+	app.Post("/api/auth/change-password", authMiddleware, changePasswordHandler)
+	app.Post("/api/auth/change-email", authMiddleware, changeEmailHandler)
+	app.Post("/api/auth/reauth-code", authMiddleware, sendReauthCodeHandler)
+	app.Post("/api/auth/magic", requestMagicLinkHandler)
+	app.Post("/api/auth/magic/verify", verifyMagicLinkHandler)
+	app.Post("/api/auth/2fa/verify", verifyLoginHandler)
+	app.Post("/api/auth/2fa/setup", authMiddleware, setupTwoFactorHandler)
+	app.Post("/api/auth/2fa/enable", authMiddleware, enableTwoFactorHandler)
+	app.Post("/api/auth/2fa/disable", authMiddleware, disableTwoFactorHandler)
+	app.Post("/api/auth/2fa/recovery-codes", authMiddleware, recoveryCodesHandler)
+	app.Post("/api/auth/passkeys/login/begin", beginPasskeyLoginHandler)
+	app.Post("/api/auth/passkeys/login/finish", finishPasskeyLoginHandler)
+	app.Get("/api/auth/passkeys", authMiddleware, listPasskeysHandler)
+	app.Post("/api/auth/passkeys/register/begin", authMiddleware, beginPasskeyRegistrationHandler)
+	app.Post("/api/auth/passkeys/register/finish", authMiddleware, finishPasskeyRegistrationHandler)
+	app.Delete("/api/auth/passkeys/:id", authMiddleware, deletePasskeyHandler)
+	app.Get("/api/auth/oidc/providers", listOIDCProvidersHandler)
+	app.Post("/api/auth/oidc/callback", oidcCallbackHandler)
+	app.Post("/api/auth/oidc/:provider/begin", beginOIDCLoginHandler)
+	app.Get("/api/auth/identities", authMiddleware, listIdentitiesHandler)
+	app.Delete("/api/auth/identities/:id", authMiddleware, deleteIdentityHandler)
+	app.Get("/api/auth/tokens", authMiddleware, listAPITokensHandler)
+	app.Post("/api/auth/tokens", authMiddleware, createAPITokenHandler)
+	app.Delete("/api/auth/tokens/:id", authMiddleware, deleteAPITokenHandler)
 
-	// Todo routes
-	app.Get("/api/todos", getTodos)
-	app.Post("/api/todos", authMiddleware, createTodo)
-	app.Patch("/api/todos/:id", authMiddleware, updateTodo)
-	app.Patch("/api/todos/:id/star", authMiddleware, toggleStarred)
-	app.Delete("/api/todos/:id", authMiddleware, deleteTodos)
+	// Todo routes. Users with an unverified email may be limited by
+	// unverifiedUsersPolicy. Personal access tokens need the route's scope.
+	canRead, canWrite := requireVerifiedEmail(false), requireVerifiedEmail(true)
+	readScope, writeScope := tokenScope(scopeTodosRead), tokenScope(scopeTodosWrite)
+	app.Get("/api/todos", readScope, optionalAuth, getTodos)
+	app.Post("/api/todos", writeScope, authMiddleware, canWrite, createTodo)
+	app.Patch("/api/todos/:id", writeScope, authMiddleware, canWrite, updateTodo)
+	app.Patch("/api/todos/:id/star", writeScope, authMiddleware, canWrite, toggleStarred)
+	app.Delete("/api/todos/:id", writeScope, authMiddleware, canWrite, deleteTodos)
+	app.Get("/api/wishlist", readScope, authMiddleware, canRead, getWishlist)
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "4000"
-	}
-	if os.Getenv("ENV") == "production" {
-		app.Static("/", "./client/dist")
+	// Saved views
+	app.Get("/api/views", authMiddleware, canRead, listViewsHandler)
+	app.Post("/api/views", authMiddleware, canWrite, createViewHandler)
+	app.Get("/api/views/:id", authMiddleware, canRead, getViewHandler)
+	app.Patch("/api/views/:id", authMiddleware, canWrite, updateViewHandler)
+	app.Delete("/api/views/:id", authMiddleware, canWrite, deleteViewHandler)
+	app.Get("/api/views/:id/todos", authMiddleware, canRead, viewTodosHandler)
+
+	// Admin: todos created before auth
+	app.Get("/api/admin/legacy-todos", authMiddleware, adminMiddleware, listLegacyTodosHandler)
+	app.Post("/api/admin/legacy-todos/assign", authMiddleware, adminMiddleware, assignLegacyTodosHandler)
+	app.Post("/api/admin/legacy-todos/archive", authMiddleware, adminMiddleware, archiveLegacyTodosHandler)
+	app.Get("/api/admin/audit", authMiddleware, adminMiddleware, listAuditHandler)
+	return app
+}
+
+// envOr returns the environment variable key, or def when it is unset.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
 	}
-	log.Fatal(app.Listen("0.0.0.0:" + port))
+	return def
 }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// sessionRevocationKey is the RevokedToken key that revokes every access
+// token of a session.
+func sessionRevocationKey(sessionID string) string {
+	return "session:" + sessionID
+}
+
+// deviceLabel describes the browser and OS of a User-Agent, e.g. "Firefox on
+// Linux". It only tells devices apart for the sessions list and is no
+// substitute for a real parser.
+func deviceLabel(ua string) string {
+	browser := ""
+	for _, b := range []struct{ token, name string }{
+		// Order matters: Edge and Opera also claim to be Chrome, which claims to be Safari
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"},
+		{"Safari/", "Safari"}, {"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	os := ""
+	for _, o := range []struct{ token, name string }{
+		{"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Android", "Android"}, {"Windows", "Windows"},
+		{"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			os = o.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	}
+	return "Unknown device"
+}
+
+// startSession records a new login from the request's device and issues its
+// first tokens.
+func startSession(c *fiber.Ctx, userID primitive.ObjectID) (access, refresh string, err error) {
+	now := time.Now().UTC()
+	session := &Session{
+		ID:         primitive.NewObjectID(),
+		UserID:     userID,
+		Device:     deviceLabel(c.Get("User-Agent")),
+		IP:         c.IP(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(refreshTokenTTL()),
+	}
+	if err := store.CreateSession(c.Context(), session); err != nil {
+		return "", "", err
+	}
+	return issueTokens(c.Context(), userID, session.ID)
+}
+
+// touchSession records a refresh of rt's session. Logins from before
+// sessions existed get one now.
+func touchSession(c *fiber.Ctx, rt *RefreshToken, now time.Time) error {
+	expiresAt := now.Add(refreshTokenTTL())
+	err := store.TouchSession(c.Context(), rt.FamilyID, c.IP(), now, expiresAt)
+	if err != ErrNotFound {
+		return err
+	}
+	return store.CreateSession(c.Context(), &Session{
+		ID:         rt.FamilyID,
+		UserID:     rt.UserID,
+		Device:     deviceLabel(c.Get("User-Agent")),
+		IP:         c.IP(),
+		CreatedAt:  rt.CreatedAt,
+		LastSeenAt: now,
+		ExpiresAt:  expiresAt,
+	})
+}
+
+// endSession deletes a session and revokes its refresh and access tokens.
+func endSession(ctx context.Context, sessionID primitive.ObjectID, now time.Time) error {
+	if err := store.DeleteSession(ctx, sessionID); err != nil && err != ErrNotFound {
+		return err
+	}
+	if err := store.RevokeRefreshTokenFamily(ctx, sessionID, now); err != nil {
+		return err
+	}
+	return store.RevokeToken(ctx, RevokedToken{
+		Key:       sessionRevocationKey(sessionID.Hex()),
+		RevokedAt: now,
+		ExpiresAt: now.Add(accessTokenTTL()),
+	})
+}
+
+// sessionJSON is a session as GET /api/auth/sessions returns it.
+type sessionJSON struct {
+	Session
+	// Current marks the session of the token making the request.
+	Current bool `json:"current"`
+}
+
+func listSessionsHandler(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*AuthClaims)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Authentication required"})
+	}
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	sessions, err := store.ListSessions(c.Context(), userID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	items := make([]sessionJSON, len(sessions))
+	for i, s := range sessions {
+		items[i] = sessionJSON{Session: s, Current: s.ID.Hex() == claims.SessionID}
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// deleteSessionHandler signs out one device. Sessions of other users are
+// reported as not found.
+func deleteSessionHandler(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Session not found"})
+	}
+	session, err := store.GetSession(c.Context(), id)
+	if err == nil && session.UserID != userID {
+		err = ErrNotFound
+	}
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "Session not found"})
+		}
+		return err
+	}
+	if err := endSession(c.Context(), session.ID, time.Now().UTC()); err != nil {
+		return err
+	}
+	return c.Status(200).JSON(fiber.Map{"success": true})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestListAndRevokeSessions(t *testing.T) {
+	app, _ := newTestApp(t)
+	status, res := request(t, app, "POST", "/api/auth/register", "",
+		fiber.Map{"name": "Alice", "email": "alice@example.com", "password": "secret1"})
+	if status != 201 {
+		t.Fatalf("register: %d %v", status, res)
+	}
+	first, firstRefresh := res["token"].(string), res["refreshToken"].(string)
+	status, res = login(t, app, "alice@example.com", "secret1")
+	if status != 200 {
+		t.Fatalf("login: %d %v", status, res)
+	}
+	second := res["token"].(string)
+	bob, _ := register(t, app, "Bob", "bob@example.com")
+
+	status, res = request(t, app, "GET", "/api/auth/sessions", second, nil)
+	sessions := items(t, res)
+	if status != 200 || len(sessions) != 2 {
+		t.Fatalf("list: %d %v", status, sessions)
+	}
+	var other string
+	for _, s := range sessions {
+		if s["current"] != true {
+			other = s["_id"].(string)
+		}
+	}
+	if other == "" || sessions[0]["current"] == sessions[1]["current"] {
+		t.Fatalf("want exactly one current session: %v", sessions)
+	}
+
+	for _, c := range []struct {
+		name, token, id string
+	}{
+		{"another user's session", bob, other},
+		{"a malformed id", second, "nonsense"},
+	} {
+		if status, res := request(t, app, "DELETE", "/api/auth/sessions/"+c.id, c.token, nil); status != 404 {
+			t.Errorf("%s: status %d, want 404: %v", c.name, status, res)
+		}
+	}
+	if status, res := request(t, app, "DELETE", "/api/auth/sessions/"+other, second, nil); status != 200 {
+		t.Fatalf("revoke: %d %v", status, res)
+	}
+	if status, res := request(t, app, "GET", "/api/auth/me", first, nil); status != 401 {
+		t.Errorf("revoked session's access token: %d %v", status, res)
+	}
+	if status, res := request(t, app, "POST", "/api/auth/refresh", "", fiber.Map{"refreshToken": firstRefresh}); status != 401 {
+		t.Errorf("revoked session's refresh token: %d %v", status, res)
+	}
+	status, res = request(t, app, "GET", "/api/auth/sessions", second, nil)
+	if sessions := items(t, res); status != 200 || len(sessions) != 1 || sessions[0]["current"] != true {
+		t.Errorf("list after revoking: %d %v", status, sessions)
+	}
+	if status, res := request(t, app, "DELETE", "/api/auth/sessions/"+other, second, nil); status != 404 {
+		t.Errorf("revoke twice: %d %v", status, res)
+	}
+}
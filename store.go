@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNotFound is returned by a store when the requested record does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicateEmail is returned when creating a user whose email is already taken.
+var ErrDuplicateEmail = errors.New("email already registered")
+
+// ErrDuplicatePasskey is returned when adding a passkey whose credential ID
+// is already registered.
+var ErrDuplicatePasskey = errors.New("passkey already registered")
+
+// ErrDuplicateIdentity is returned when linking a provider account that is
+// already linked to a user.
+var ErrDuplicateIdentity = errors.New("identity already linked")
+
+// ErrTokenUsed is returned when a single-use token is used a second time.
+var ErrTokenUsed = errors.New("token already used")
+
+// TodoFilter describes which todos a listing returns.
+type TodoFilter struct {
+	// ViewerID restricts the listing to the viewer's todos plus ownerless ones
+	// (created before auth). Nil means no ownership restriction.
+	ViewerID *primitive.ObjectID
+	// OwnerID keeps only the todos OwnerID owns.
+	OwnerID *primitive.ObjectID
+	// Ownerless keeps only todos without an owner.
+	Ownerless bool
+	// Archived lists archived todos instead of live ones. Only ownerless
+	// todos are ever archived.
+	Archived bool
+	// Search matches against body, tags and notes; nil matches everything.
+	Search *SearchQuery
+	// Completed keeps only completed (true) or active (false) todos.
+	Completed *bool
+	// Priorities keeps todos with one of the listed priorities ("" for
+	// none); ExcludePriorities drops them.
+	Priorities        []string
+	ExcludePriorities []string
+	// DueAfter <= dueDate < DueBefore; todos without a due date never match a bound.
+	DueAfter, DueBefore *time.Time
+	// HasDueDate keeps todos with (true) or without (false) a due date.
+	HasDueDate *bool
+	// StarredBy keeps the todos a user starred; NotStarredBy drops them.
+	StarredBy, NotStarredBy *primitive.ObjectID
+	// Tags keeps todos with every listed tag and ExcludeTags drops todos with
+	// any; both ignore case.
+	Tags, ExcludeTags []string
+}
+
+// TodoSort selects the order of a todo listing.
+type TodoSort struct {
+	Field string // one of todoSortFields
+	Desc  bool
+}
+
+// todoSortFields are the fields a listing can be sorted by.
+var todoSortFields = []string{"createdAt", "updatedAt", "dueDate", "priority", "completedAt"}
+
+var defaultTodoSort = TodoSort{Field: "createdAt", Desc: true}
+
+// String renders s the way the sort query parameter spells it, e.g. "-createdAt".
+func (s TodoSort) String() string {
+	if s.Desc {
+		return "-" + s.Field
+	}
+	return s.Field
+}
+
+// nullable reports whether todos may have no value for the sort field.
+// Such todos always come last, whatever the direction.
+func (s TodoSort) nullable() bool {
+	return s.Field == "dueDate" || s.Field == "completedAt"
+}
+
+// priorityRank orders priorities by importance rather than alphabetically.
+func priorityRank(priority string) int {
+	switch priority {
+	case "high":
+		return 3
+	case "medium":
+		return 2
+	case "low":
+		return 1
+	}
+	return 0
+}
+
+// todoSortValue returns the value todo is sorted by: a time.Time for date
+// fields, an int rank for priority, or nil when the todo has no value.
+func todoSortValue(todo *Todo, field string) interface{} {
+	switch field {
+	case "updatedAt":
+		return todo.UpdatedAt
+	case "dueDate":
+		if todo.DueDate == nil {
+			return nil
+		}
+		return *todo.DueDate
+	case "completedAt":
+		if todo.CompletedAt == nil {
+			return nil
+		}
+		return *todo.CompletedAt
+	case "priority":
+		return priorityRank(todo.Priority)
+	}
+	return todo.CreatedAt
+}
+
+// TodoCursor marks the last todo of a page; the next page starts right after it.
+type TodoCursor struct {
+	// Value is the sort value of that todo, as returned by todoSortValue.
+	Value interface{}
+	ID    primitive.ObjectID
+}
+
+// TodoQuery is a filtered, sorted and paginated todo listing.
+type TodoQuery struct {
+	TodoFilter
+	Sort  TodoSort // zero value means defaultTodoSort
+	After *TodoCursor
+	Limit int // 0 means no limit
+}
+
+// TodoPage is one page of a todo listing.
+type TodoPage struct {
+	Items []Todo
+	// Total counts every todo matching the filter, across all pages.
+	Total int64
+	// Next is nil on the last page.
+	Next *TodoCursor
+}
+
+// TodoUpdate lists the fields to change on a todo; nil fields are left untouched.
+type TodoUpdate struct {
+	Body        *string
+	Tags        *[]string
+	Notes       *string
+	Completed   *bool
+	CompletedAt **time.Time
+	Starred     *bool
+	Priority    *string
+	DueDate     **time.Time
+	UpdatedAt   time.Time
+}
+
+// UserUpdate lists the profile fields to change on a user; nil fields are left untouched.
+type UserUpdate struct {
+	Name     *string
+	Username *string
+	// Email must stay unique; a clash returns ErrDuplicateEmail.
+	Email *string
+	// Avatar set to an empty string removes the avatar.
+	Avatar          *string
+	PasswordHash    *string
+	EmailVerifiedAt **time.Time
+	TOTPSecret      *string
+	TOTPEnabledAt   **time.Time
+	RecoveryCodes   *[]string
+	UpdatedAt       time.Time
+}
+
+// ViewUpdate lists the fields to change on a view; nil fields are left untouched.
+type ViewUpdate struct {
+	Name      *string
+	Filter    *ViewFilter
+	Sort      *string
+	UpdatedAt time.Time
+}
+
+type TodoStore interface {
+	// ListTodos returns a page of the todos matching q in q.Sort order.
+	ListTodos(ctx context.Context, q TodoQuery) (*TodoPage, error)
+	GetTodo(ctx context.Context, id primitive.ObjectID) (*Todo, error)
+	// CreateTodo inserts todo and sets its ID.
+	CreateTodo(ctx context.Context, todo *Todo) error
+	UpdateTodo(ctx context.Context, id primitive.ObjectID, update TodoUpdate) error
+	// SetTodoStarred adds userID to (or removes it from) the todo's starredBy list.
+	SetTodoStarred(ctx context.Context, id, userID primitive.ObjectID, starred bool, at time.Time) error
+	DeleteTodo(ctx context.Context, id primitive.ObjectID) error
+	// ClaimOwnerlessTodos gives live ownerless todos to owner, or archives
+	// them when owner is nil. ids limits the change to those todos; nil
+	// means all of them. It returns how many todos changed.
+	ClaimOwnerlessTodos(ctx context.Context, ids []primitive.ObjectID, owner *primitive.ObjectID, at time.Time) (int64, error)
+}
+
+type UserStore interface {
+	// CreateUser inserts user and sets its ID. Emails are unique; a clash
+	// returns ErrDuplicateEmail.
+	CreateUser(ctx context.Context, user *User) error
+	GetUserByID(ctx context.Context, id primitive.ObjectID) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	// UpdateUser applies update and returns the updated user.
+	UpdateUser(ctx context.Context, id primitive.ObjectID, update UserUpdate) (*User, error)
+	// UseTOTPStep records step as the last TOTP step accepted for the user.
+	// It returns ErrTokenUsed unless step is later than the previous one.
+	UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) error
+	// UseRecoveryCode removes a recovery code hash from the user. It returns
+	// ErrTokenUsed when the user has no such code.
+	UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) error
+}
+
+type ViewStore interface {
+	// ListViews returns the views owned by ownerID, oldest first.
+	ListViews(ctx context.Context, ownerID primitive.ObjectID) ([]View, error)
+	GetView(ctx context.Context, id primitive.ObjectID) (*View, error)
+	// CreateView inserts view and sets its ID.
+	CreateView(ctx context.Context, view *View) error
+	// UpdateView applies update and returns the updated view.
+	UpdateView(ctx context.Context, id primitive.ObjectID, update ViewUpdate) (*View, error)
+	DeleteView(ctx context.Context, id primitive.ObjectID) error
+}
+
+type RefreshTokenStore interface {
+	CreateRefreshToken(ctx context.Context, token *RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*RefreshToken, error)
+	// UseRefreshToken sets the token's UsedAt. It returns ErrTokenUsed if
+	// it was already set, so only one of two concurrent refreshes succeeds.
+	UseRefreshToken(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	// RevokeRefreshTokenFamily revokes every token of the family.
+	RevokeRefreshTokenFamily(ctx context.Context, familyID primitive.ObjectID, at time.Time) error
+	// RevokeUserRefreshTokens revokes every token of the user except those of
+	// keepFamily, unless it is the zero ObjectID.
+	RevokeUserRefreshTokens(ctx context.Context, userID, keepFamily primitive.ObjectID, at time.Time) error
+	// DeleteExpiredRefreshTokens removes tokens that expired before now.
+	DeleteExpiredRefreshTokens(ctx context.Context, now time.Time) error
+}
+
+type SessionStore interface {
+	CreateSession(ctx context.Context, session *Session) error
+	// ListSessions returns the user's sessions that expire after now, most
+	// recently seen first.
+	ListSessions(ctx context.Context, userID primitive.ObjectID, now time.Time) ([]Session, error)
+	GetSession(ctx context.Context, id primitive.ObjectID) (*Session, error)
+	// TouchSession records activity on a session.
+	TouchSession(ctx context.Context, id primitive.ObjectID, ip string, at, expiresAt time.Time) error
+	DeleteSession(ctx context.Context, id primitive.ObjectID) error
+	DeleteUserSessions(ctx context.Context, userID primitive.ObjectID) error
+	// DeleteExpiredSessions removes sessions that expired before now.
+	DeleteExpiredSessions(ctx context.Context, now time.Time) error
+}
+
+type OneTimeTokenStore interface {
+	CreateOneTimeToken(ctx context.Context, token *OneTimeToken) error
+	// GetOneTimeToken returns the token for purpose whose hash is hash.
+	GetOneTimeToken(ctx context.Context, purpose, hash string) (*OneTimeToken, error)
+	// RecentOneTimeTokens returns the user's tokens for purpose created after
+	// since, newest first.
+	RecentOneTimeTokens(ctx context.Context, userID primitive.ObjectID, purpose string, since time.Time) ([]OneTimeToken, error)
+	// UseOneTimeToken sets the token's UsedAt. It returns ErrTokenUsed if it
+	// was already set.
+	UseOneTimeToken(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	// UseUserOneTimeTokens marks every unused token of the user for purpose
+	// as used.
+	UseUserOneTimeTokens(ctx context.Context, userID primitive.ObjectID, purpose string, at time.Time) error
+	// DeleteExpiredOneTimeTokens removes tokens that expired before now.
+	DeleteExpiredOneTimeTokens(ctx context.Context, now time.Time) error
+}
+
+type PasskeyStore interface {
+	// CreatePasskey inserts passkey and sets its ID. Credential IDs are
+	// unique; a clash returns ErrDuplicatePasskey.
+	CreatePasskey(ctx context.Context, passkey *Passkey) error
+	// ListPasskeys returns the user's passkeys, oldest first.
+	ListPasskeys(ctx context.Context, userID primitive.ObjectID) ([]Passkey, error)
+	GetPasskeyByCredentialID(ctx context.Context, credentialID []byte) (*Passkey, error)
+	// UsePasskey records a login with the passkey.
+	UsePasskey(ctx context.Context, id primitive.ObjectID, signCount uint32, backupState bool, at time.Time) error
+	// DeletePasskey removes a passkey of the user; another user's passkey
+	// returns ErrNotFound.
+	DeletePasskey(ctx context.Context, userID, id primitive.ObjectID) error
+}
+
+type IdentityStore interface {
+	// CreateIdentity inserts identity and sets its ID. Each provider account
+	// links to one user; a clash returns ErrDuplicateIdentity.
+	CreateIdentity(ctx context.Context, identity *Identity) error
+	GetIdentity(ctx context.Context, provider, subject string) (*Identity, error)
+	// ListIdentities returns the user's identities, oldest first.
+	ListIdentities(ctx context.Context, userID primitive.ObjectID) ([]Identity, error)
+	// UseIdentity records a login with the identity and the email it came with.
+	UseIdentity(ctx context.Context, id primitive.ObjectID, email string, at time.Time) error
+	// DeleteIdentity unlinks an identity of the user; another user's identity
+	// returns ErrNotFound.
+	DeleteIdentity(ctx context.Context, userID, id primitive.ObjectID) error
+}
+
+type APITokenStore interface {
+	// CreateAPIToken inserts token and sets its ID.
+	CreateAPIToken(ctx context.Context, token *APIToken) error
+	GetAPITokenByHash(ctx context.Context, hash string) (*APIToken, error)
+	// ListAPITokens returns the user's tokens, newest first.
+	ListAPITokens(ctx context.Context, userID primitive.ObjectID) ([]APIToken, error)
+	// UseAPIToken records a request made with the token.
+	UseAPIToken(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	// DeleteAPIToken removes a token of the user; another user's token
+	// returns ErrNotFound.
+	DeleteAPIToken(ctx context.Context, userID, id primitive.ObjectID) error
+	// DeleteUserAPITokens removes every token of the user.
+	DeleteUserAPITokens(ctx context.Context, userID primitive.ObjectID) error
+	// DeleteExpiredAPITokens removes tokens that expired before now.
+	DeleteExpiredAPITokens(ctx context.Context, now time.Time) error
+}
+
+type RateLimitStore interface {
+	// HitRateLimit counts a hit for key at at and returns the updated count.
+	// Hits of an expired record are forgotten first; the record then expires
+	// at at+window.
+	HitRateLimit(ctx context.Context, key string, at time.Time, window time.Duration) (*RateLimit, error)
+	// GetRateLimits returns the records stored under any of keys.
+	GetRateLimits(ctx context.Context, keys []string) ([]RateLimit, error)
+	ResetRateLimit(ctx context.Context, key string) error
+	// DeleteExpiredRateLimits removes records that expired before now.
+	DeleteExpiredRateLimits(ctx context.Context, now time.Time) error
+}
+
+type AuditStore interface {
+	// CreateAuditEntry inserts entry and sets its ID.
+	CreateAuditEntry(ctx context.Context, entry *AuditEntry) error
+	// ListAuditEntries returns up to limit entries, newest first.
+	ListAuditEntries(ctx context.Context, limit int) ([]AuditEntry, error)
+}
+
+type RevocationStore interface {
+	// RevokeToken records r, replacing any entry with the same key.
+	RevokeToken(ctx context.Context, r RevokedToken) error
+	// GetRevokedTokens returns the entries stored under any of keys.
+	GetRevokedTokens(ctx context.Context, keys []string) ([]RevokedToken, error)
+	// DeleteExpiredRevocations removes entries that expired before now.
+	DeleteExpiredRevocations(ctx context.Context, now time.Time) error
+}
+
+// cursorFor returns the cursor pointing just past todo in a listing sorted by sortBy.
+func cursorFor(todo *Todo, sortBy TodoSort) *TodoCursor {
+	return &TodoCursor{Value: todoSortValue(todo, sortBy.Field), ID: todo.ID}
+}
+
+// compareTodoPositions orders two (sort value, id) positions the way a
+// listing sorted by sortBy returns them: by value, then by id in the same
+// direction, with todos lacking a value last.
+func compareTodoPositions(av interface{}, aid primitive.ObjectID, bv interface{}, bid primitive.ObjectID, sortBy TodoSort) int {
+	if (av == nil) != (bv == nil) {
+		if av == nil {
+			return 1
+		}
+		return -1
+	}
+	c := 0
+	switch a := av.(type) {
+	case time.Time:
+		c = a.Compare(bv.(time.Time))
+	case int:
+		c = cmp.Compare(a, bv.(int))
+	case float64:
+		c = cmp.Compare(a, bv.(float64))
+	}
+	if c == 0 {
+		c = bytes.Compare(aid[:], bid[:])
+	}
+	if sortBy.Desc {
+		c = -c
+	}
+	return c
+}
+
+// sortTodos orders todos in place by sortBy. Stores that sort in Go use it.
+func sortTodos(todos []Todo, sortBy TodoSort) {
+	sort.Slice(todos, func(i, j int) bool {
+		vi, vj := todoSortValue(&todos[i], sortBy.Field), todoSortValue(&todos[j], sortBy.Field)
+		return compareTodoPositions(vi, todos[i].ID, vj, todos[j].ID, sortBy) < 0
+	})
+}
+
+// pageTodos sorts the already filtered todos by q.Sort and applies q's
+// cursor and limit. Stores that filter in Go use it.
+func pageTodos(todos []Todo, q TodoQuery) *TodoPage {
+	sortBy := q.Sort
+	if sortBy.Field == "" {
+		sortBy = defaultTodoSort
+	}
+	sortTodos(todos, sortBy)
+	page := &TodoPage{Items: []Todo{}, Total: int64(len(todos))}
+	start := 0
+	if q.After != nil {
+		start = sort.Search(len(todos), func(i int) bool {
+			v := todoSortValue(&todos[i], sortBy.Field)
+			return compareTodoPositions(v, todos[i].ID, q.After.Value, q.After.ID, sortBy) > 0
+		})
+	}
+	end := len(todos)
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+		page.Next = cursorFor(&todos[end-1], sortBy)
+	}
+	page.Items = append(page.Items, todos[start:end]...)
+	return page
+}
+
+// Store is the persistence layer used by the HTTP handlers.
+type Store interface {
+	TodoStore
+	UserStore
+	ViewStore
+	RefreshTokenStore
+	SessionStore
+	OneTimeTokenStore
+	RevocationStore
+	PasskeyStore
+	IdentityStore
+	APITokenStore
+	RateLimitStore
+	AuditStore
+	Close(ctx context.Context) error
+}
+
+var store Store
+
+// storeConfig selects and configures the storage backend.
+type storeConfig struct {
+	Backend     string // "mongo" (default), "memory", "bolt" or "postgres"
+	MongoURI    string
+	BoltPath    string
+	PostgresURL string
+}
+
+// openStore connects the storage backend selected by cfg.
+func openStore(ctx context.Context, cfg storeConfig) (Store, error) {
+	switch cfg.Backend {
+	case "", "mongo":
+		s, err := newMongoStore(ctx, cfg.MongoURI)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println("Connected to MongoDB ATLAS")
+		return s, nil
+	case "memory":
+		fmt.Println("Using in-memory store; data is lost when the server stops")
+		return newMemoryStore(), nil
+	case "bolt":
+		path := cfg.BoltPath
+		if path == "" {
+			path = "todos.db"
+		}
+		s, err := newBoltStore(path)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println("Using embedded bolt store at", path)
+		return s, nil
+	case "postgres":
+		s, err := newPostgresStore(ctx, cfg.PostgresURL)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println("Connected to PostgreSQL")
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", cfg.Backend)
+	}
+}
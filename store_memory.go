@@ -0,0 +1,1082 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStore keeps everything in process memory. It is meant for tests and
+// local demos; all data is lost when the server stops.
+type memoryStore struct {
+	mu    sync.RWMutex
+	todos map[primitive.ObjectID]*Todo
+	users map[primitive.ObjectID]*User
+	views map[primitive.ObjectID]*View
+	// refreshTokens is keyed by token hash
+	refreshTokens map[string]*RefreshToken
+	revokedTokens map[string]RevokedToken
+	sessions      map[primitive.ObjectID]*Session
+	oneTimeTokens map[primitive.ObjectID]*OneTimeToken
+	passkeys      map[primitive.ObjectID]*Passkey
+	identities    map[primitive.ObjectID]*Identity
+	apiTokens     map[primitive.ObjectID]*APIToken
+	rateLimits    map[string]RateLimit
+	auditEntries  []AuditEntry
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		todos: map[primitive.ObjectID]*Todo{},
+		users: map[primitive.ObjectID]*User{},
+		views: map[primitive.ObjectID]*View{},
+
+		refreshTokens: map[string]*RefreshToken{},
+		revokedTokens: map[string]RevokedToken{},
+		sessions:      map[primitive.ObjectID]*Session{},
+		oneTimeTokens: map[primitive.ObjectID]*OneTimeToken{},
+		passkeys:      map[primitive.ObjectID]*Passkey{},
+		identities:    map[primitive.ObjectID]*Identity{},
+		apiTokens:     map[primitive.ObjectID]*APIToken{},
+		rateLimits:    map[string]RateLimit{},
+	}
+}
+
+func (s *memoryStore) Close(ctx context.Context) error { return nil }
+
+// cloneTodo copies t so callers never share slices or pointers with the store.
+func cloneTodo(t *Todo) *Todo {
+	c := *t
+	if t.Tags != nil {
+		c.Tags = append([]string{}, t.Tags...)
+	}
+	if t.StarredBy != nil {
+		c.StarredBy = append([]primitive.ObjectID{}, t.StarredBy...)
+	}
+	if t.DueDate != nil {
+		d := *t.DueDate
+		c.DueDate = &d
+	}
+	if t.CompletedAt != nil {
+		d := *t.CompletedAt
+		c.CompletedAt = &d
+	}
+	if t.OwnerID != nil {
+		o := *t.OwnerID
+		c.OwnerID = &o
+	}
+	if t.ArchivedAt != nil {
+		d := *t.ArchivedAt
+		c.ArchivedAt = &d
+	}
+	return &c
+}
+
+// todoMatcher evaluates a TodoFilter in Go, mirroring mongoTodoFilter.
+type todoMatcher struct {
+	filter TodoFilter
+}
+
+func newTodoMatcher(f TodoFilter) *todoMatcher {
+	return &todoMatcher{filter: f}
+}
+
+func (m *todoMatcher) match(t *Todo) bool {
+	f := m.filter
+	// Ownership: the viewer's todos plus ownerless ones
+	if f.ViewerID != nil && t.OwnerID != nil && *t.OwnerID != *f.ViewerID {
+		return false
+	}
+	if f.OwnerID != nil && (t.OwnerID == nil || *t.OwnerID != *f.OwnerID) {
+		return false
+	}
+	if f.Ownerless && t.OwnerID != nil {
+		return false
+	}
+	if (t.ArchivedAt != nil) != f.Archived {
+		return false
+	}
+	if f.Search != nil && !f.Search.Match(t) {
+		return false
+	}
+	if f.Completed != nil && t.Completed != *f.Completed {
+		return false
+	}
+	if f.Priorities != nil && !containsString(f.Priorities, t.Priority) {
+		return false
+	}
+	if containsString(f.ExcludePriorities, t.Priority) {
+		return false
+	}
+	if f.HasDueDate != nil && (t.DueDate != nil) != *f.HasDueDate {
+		return false
+	}
+	if f.DueAfter != nil && (t.DueDate == nil || t.DueDate.Before(*f.DueAfter)) {
+		return false
+	}
+	if f.DueBefore != nil && (t.DueDate == nil || !t.DueDate.Before(*f.DueBefore)) {
+		return false
+	}
+	if f.StarredBy != nil && !isStarredBy(t, *f.StarredBy) {
+		return false
+	}
+	if f.NotStarredBy != nil && isStarredBy(t, *f.NotStarredBy) {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !hasTag(t, tag) {
+			return false
+		}
+	}
+	for _, tag := range f.ExcludeTags {
+		if hasTag(t, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func isStarredBy(t *Todo, userID primitive.ObjectID) bool {
+	for _, id := range t.StarredBy {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTag(t *Todo, tag string) bool {
+	for _, v := range t.Tags {
+		if strings.EqualFold(v, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) ListTodos(ctx context.Context, q TodoQuery) (*TodoPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m := newTodoMatcher(q.TodoFilter)
+	todos := []Todo{}
+	for _, t := range s.todos {
+		if m.match(t) {
+			todos = append(todos, *cloneTodo(t))
+		}
+	}
+	return pageTodos(todos, q), nil
+}
+
+func (s *memoryStore) GetTodo(ctx context.Context, id primitive.ObjectID) (*Todo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.todos[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return cloneTodo(t), nil
+}
+
+func (s *memoryStore) CreateTodo(ctx context.Context, todo *Todo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if todo.ID.IsZero() {
+		todo.ID = primitive.NewObjectID()
+	}
+	s.todos[todo.ID] = cloneTodo(todo)
+	return nil
+}
+
+// applyTodoUpdate applies u to t in place.
+func applyTodoUpdate(t *Todo, u TodoUpdate) {
+	t.UpdatedAt = u.UpdatedAt
+	if u.Body != nil {
+		t.Body = *u.Body
+	}
+	if u.Tags != nil {
+		t.Tags = *u.Tags
+	}
+	if u.Notes != nil {
+		t.Notes = *u.Notes
+	}
+	if u.Completed != nil {
+		t.Completed = *u.Completed
+	}
+	if u.CompletedAt != nil {
+		t.CompletedAt = *u.CompletedAt
+	}
+	if u.Starred != nil {
+		t.Starred = *u.Starred
+	}
+	if u.Priority != nil {
+		t.Priority = *u.Priority
+	}
+	if u.DueDate != nil {
+		t.DueDate = *u.DueDate
+	}
+}
+
+// applyTodoStarred adds userID to (or removes it from) t.StarredBy in place.
+func applyTodoStarred(t *Todo, userID primitive.ObjectID, starred bool, at time.Time) {
+	t.UpdatedAt = at
+	if starred {
+		t.Starred = true
+		for _, id := range t.StarredBy {
+			if id == userID {
+				return
+			}
+		}
+		t.StarredBy = append(t.StarredBy, userID)
+		return
+	}
+	kept := []primitive.ObjectID{}
+	for _, id := range t.StarredBy {
+		if id != userID {
+			kept = append(kept, id)
+		}
+	}
+	t.StarredBy = kept
+}
+
+func (s *memoryStore) UpdateTodo(ctx context.Context, id primitive.ObjectID, u TodoUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.todos[id]
+	if !ok {
+		return ErrNotFound
+	}
+	applyTodoUpdate(t, cloneUpdate(u))
+	return nil
+}
+
+// cloneUpdate copies the slices and time pointers in u so the store never aliases caller memory.
+func cloneUpdate(u TodoUpdate) TodoUpdate {
+	if u.Tags != nil {
+		tags := append([]string{}, *u.Tags...)
+		u.Tags = &tags
+	}
+	if u.DueDate != nil && *u.DueDate != nil {
+		d := **u.DueDate
+		p := &d
+		u.DueDate = &p
+	}
+	if u.CompletedAt != nil && *u.CompletedAt != nil {
+		d := **u.CompletedAt
+		p := &d
+		u.CompletedAt = &p
+	}
+	return u
+}
+
+func (s *memoryStore) SetTodoStarred(ctx context.Context, id, userID primitive.ObjectID, starred bool, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.todos[id]
+	if !ok {
+		return ErrNotFound
+	}
+	applyTodoStarred(t, userID, starred, at)
+	return nil
+}
+
+func (s *memoryStore) DeleteTodo(ctx context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.todos[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.todos, id)
+	return nil
+}
+
+// claimOwnerlessTodo applies ClaimOwnerlessTodos to t in place and reports
+// whether t changed. ids holds the ids to claim, nil for all.
+func claimOwnerlessTodo(t *Todo, ids map[primitive.ObjectID]bool, owner *primitive.ObjectID, at time.Time) bool {
+	if t.OwnerID != nil || t.ArchivedAt != nil || (ids != nil && !ids[t.ID]) {
+		return false
+	}
+	if owner != nil {
+		o := *owner
+		t.OwnerID = &o
+	} else {
+		a := at
+		t.ArchivedAt = &a
+	}
+	t.UpdatedAt = at
+	return true
+}
+
+// idSet turns ids into a set for claimOwnerlessTodo, keeping nil as nil.
+func idSet(ids []primitive.ObjectID) map[primitive.ObjectID]bool {
+	if ids == nil {
+		return nil
+	}
+	set := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+func (s *memoryStore) ClaimOwnerlessTodos(ctx context.Context, ids []primitive.ObjectID, owner *primitive.ObjectID, at time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set := idSet(ids)
+	var n int64
+	for _, t := range s.todos {
+		if claimOwnerlessTodo(t, set, owner, at) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *memoryStore) CreateUser(ctx context.Context, user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Same guarantee as the unique index on users.email
+	for _, u := range s.users {
+		if u.Email == user.Email {
+			return ErrDuplicateEmail
+		}
+	}
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	c := *user
+	s.users[user.ID] = &c
+	return nil
+}
+
+func (s *memoryStore) GetUserByID(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *u
+	return &c, nil
+}
+
+func (s *memoryStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		if u.Email == email {
+			c := *u
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// applyUserUpdate applies u to user in place.
+func applyUserUpdate(user *User, u UserUpdate) {
+	user.UpdatedAt = u.UpdatedAt
+	if u.Name != nil {
+		user.Name = *u.Name
+	}
+	if u.Username != nil {
+		user.Username = *u.Username
+	}
+	if u.Email != nil {
+		user.Email = *u.Email
+	}
+	if u.Avatar != nil {
+		user.Avatar = *u.Avatar
+	}
+	if u.PasswordHash != nil {
+		user.PasswordHash = *u.PasswordHash
+	}
+	if u.EmailVerifiedAt != nil {
+		user.EmailVerifiedAt = *u.EmailVerifiedAt
+	}
+	if u.TOTPSecret != nil {
+		user.TOTPSecret = *u.TOTPSecret
+	}
+	if u.TOTPEnabledAt != nil {
+		user.TOTPEnabledAt = *u.TOTPEnabledAt
+	}
+	if u.RecoveryCodes != nil {
+		user.RecoveryCodes = append([]string{}, *u.RecoveryCodes...)
+	}
+}
+
+// useTOTPStep and useRecoveryCode are shared by the memory and bolt stores.
+func useTOTPStep(user *User, step int64) error {
+	if step <= user.TOTPLastStep {
+		return ErrTokenUsed
+	}
+	user.TOTPLastStep = step
+	return nil
+}
+
+func useRecoveryCode(user *User, hash string) error {
+	for i, h := range user.RecoveryCodes {
+		if h == hash {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+			return nil
+		}
+	}
+	return ErrTokenUsed
+}
+
+func (s *memoryStore) UpdateUser(ctx context.Context, id primitive.ObjectID, u UserUpdate) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if u.Email != nil {
+		for _, other := range s.users {
+			if other.ID != id && other.Email == *u.Email {
+				return nil, ErrDuplicateEmail
+			}
+		}
+	}
+	applyUserUpdate(user, u)
+	c := *user
+	return &c, nil
+}
+
+func (s *memoryStore) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	return useTOTPStep(user, step)
+}
+
+func (s *memoryStore) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	return useRecoveryCode(user, hash)
+}
+
+func (s *memoryStore) ListViews(ctx context.Context, ownerID primitive.ObjectID) ([]View, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	views := []View{}
+	for _, v := range s.views {
+		if v.OwnerID == ownerID {
+			views = append(views, *v)
+		}
+	}
+	sortViews(views)
+	return views, nil
+}
+
+// sortViews orders views oldest first, as ListViews returns them.
+func sortViews(views []View) {
+	sort.Slice(views, func(i, j int) bool {
+		return bytes.Compare(views[i].ID[:], views[j].ID[:]) < 0
+	})
+}
+
+func (s *memoryStore) GetView(ctx context.Context, id primitive.ObjectID) (*View, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.views[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *v
+	return &c, nil
+}
+
+func (s *memoryStore) CreateView(ctx context.Context, view *View) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if view.ID.IsZero() {
+		view.ID = primitive.NewObjectID()
+	}
+	c := *view
+	s.views[view.ID] = &c
+	return nil
+}
+
+// applyViewUpdate applies u to view in place.
+func applyViewUpdate(view *View, u ViewUpdate) {
+	view.UpdatedAt = u.UpdatedAt
+	if u.Name != nil {
+		view.Name = *u.Name
+	}
+	if u.Filter != nil {
+		view.Filter = *u.Filter
+	}
+	if u.Sort != nil {
+		view.Sort = *u.Sort
+	}
+}
+
+func (s *memoryStore) UpdateView(ctx context.Context, id primitive.ObjectID, u ViewUpdate) (*View, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	view, ok := s.views[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	applyViewUpdate(view, u)
+	c := *view
+	return &c, nil
+}
+
+func (s *memoryStore) DeleteView(ctx context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.views[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.views, id)
+	return nil
+}
+
+func (s *memoryStore) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	c := *token
+	s.refreshTokens[token.TokenHash] = &c
+	return nil
+}
+
+func (s *memoryStore) GetRefreshTokenByHash(ctx context.Context, hash string) (*RefreshToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.refreshTokens[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *t
+	return &c, nil
+}
+
+func (s *memoryStore) UseRefreshToken(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.refreshTokens {
+		if t.ID == id {
+			if t.UsedAt != nil {
+				return ErrTokenUsed
+			}
+			t.UsedAt = &at
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *memoryStore) RevokeRefreshTokenFamily(ctx context.Context, familyID primitive.ObjectID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.refreshTokens {
+		if t.FamilyID == familyID && t.RevokedAt == nil {
+			t.RevokedAt = &at
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) RevokeUserRefreshTokens(ctx context.Context, userID, keepFamily primitive.ObjectID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.refreshTokens {
+		if t.UserID == userID && t.FamilyID != keepFamily && t.RevokedAt == nil {
+			t.RevokedAt = &at
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteExpiredRefreshTokens(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, t := range s.refreshTokens {
+		if t.ExpiresAt.Before(now) {
+			delete(s.refreshTokens, hash)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) RevokeToken(ctx context.Context, r RevokedToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokedTokens[r.Key] = r
+	return nil
+}
+
+func (s *memoryStore) GetRevokedTokens(ctx context.Context, keys []string) ([]RevokedToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	found := []RevokedToken{}
+	for _, key := range keys {
+		if r, ok := s.revokedTokens[key]; ok {
+			found = append(found, r)
+		}
+	}
+	return found, nil
+}
+
+func (s *memoryStore) DeleteExpiredRevocations(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, r := range s.revokedTokens {
+		if r.ExpiresAt.Before(now) {
+			delete(s.revokedTokens, key)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) CreateSession(ctx context.Context, session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *session
+	s.sessions[session.ID] = &c
+	return nil
+}
+
+func (s *memoryStore) ListSessions(ctx context.Context, userID primitive.ObjectID, now time.Time) ([]Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sessions := []Session{}
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.ExpiresAt.After(now) {
+			sessions = append(sessions, *session)
+		}
+	}
+	sortSessions(sessions)
+	return sessions, nil
+}
+
+// sortSessions orders sessions most recently seen first, as ListSessions returns them.
+func sortSessions(sessions []Session) {
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+}
+
+func (s *memoryStore) GetSession(ctx context.Context, id primitive.ObjectID) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *session
+	return &c, nil
+}
+
+func (s *memoryStore) TouchSession(ctx context.Context, id primitive.ObjectID, ip string, at, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	session.IP, session.LastSeenAt, session.ExpiresAt = ip, at, expiresAt
+	return nil
+}
+
+func (s *memoryStore) DeleteSession(ctx context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *memoryStore) DeleteUserSessions(ctx context.Context, userID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteExpiredSessions(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, session := range s.sessions {
+		if session.ExpiresAt.Before(now) {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) CreateOneTimeToken(ctx context.Context, token *OneTimeToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	c := *token
+	s.oneTimeTokens[token.ID] = &c
+	return nil
+}
+
+func (s *memoryStore) GetOneTimeToken(ctx context.Context, purpose, hash string) (*OneTimeToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.oneTimeTokens {
+		if t.Purpose == purpose && t.TokenHash == hash {
+			c := *t
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memoryStore) RecentOneTimeTokens(ctx context.Context, userID primitive.ObjectID, purpose string, since time.Time) ([]OneTimeToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tokens := []OneTimeToken{}
+	for _, t := range s.oneTimeTokens {
+		if t.UserID == userID && t.Purpose == purpose && t.CreatedAt.After(since) {
+			tokens = append(tokens, *t)
+		}
+	}
+	sortOneTimeTokens(tokens)
+	return tokens, nil
+}
+
+// sortOneTimeTokens orders tokens newest first.
+func sortOneTimeTokens(tokens []OneTimeToken) {
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.After(tokens[j].CreatedAt) })
+}
+
+func (s *memoryStore) UseOneTimeToken(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.oneTimeTokens[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if t.UsedAt != nil {
+		return ErrTokenUsed
+	}
+	t.UsedAt = &at
+	return nil
+}
+
+func (s *memoryStore) UseUserOneTimeTokens(ctx context.Context, userID primitive.ObjectID, purpose string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.oneTimeTokens {
+		if t.UserID == userID && t.Purpose == purpose && t.UsedAt == nil {
+			t.UsedAt = &at
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteExpiredOneTimeTokens(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, t := range s.oneTimeTokens {
+		if t.ExpiresAt.Before(now) {
+			delete(s.oneTimeTokens, id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) CreatePasskey(ctx context.Context, passkey *Passkey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.passkeys {
+		if bytes.Equal(p.CredentialID, passkey.CredentialID) {
+			return ErrDuplicatePasskey
+		}
+	}
+	if passkey.ID.IsZero() {
+		passkey.ID = primitive.NewObjectID()
+	}
+	c := *passkey
+	s.passkeys[c.ID] = &c
+	return nil
+}
+
+func (s *memoryStore) ListPasskeys(ctx context.Context, userID primitive.ObjectID) ([]Passkey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	passkeys := []Passkey{}
+	for _, p := range s.passkeys {
+		if p.UserID == userID {
+			passkeys = append(passkeys, *p)
+		}
+	}
+	sort.Slice(passkeys, func(i, j int) bool { return passkeys[i].ID.Hex() < passkeys[j].ID.Hex() })
+	return passkeys, nil
+}
+
+func (s *memoryStore) GetPasskeyByCredentialID(ctx context.Context, credentialID []byte) (*Passkey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, p := range s.passkeys {
+		if bytes.Equal(p.CredentialID, credentialID) {
+			c := *p
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memoryStore) UsePasskey(ctx context.Context, id primitive.ObjectID, signCount uint32, backupState bool, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.passkeys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	p.SignCount, p.BackupState, p.LastUsedAt = signCount, backupState, &at
+	return nil
+}
+
+func (s *memoryStore) DeletePasskey(ctx context.Context, userID, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.passkeys[id]
+	if !ok || p.UserID != userID {
+		return ErrNotFound
+	}
+	delete(s.passkeys, id)
+	return nil
+}
+
+func (s *memoryStore) CreateIdentity(ctx context.Context, identity *Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, i := range s.identities {
+		if i.Provider == identity.Provider && i.Subject == identity.Subject {
+			return ErrDuplicateIdentity
+		}
+	}
+	if identity.ID.IsZero() {
+		identity.ID = primitive.NewObjectID()
+	}
+	c := *identity
+	s.identities[c.ID] = &c
+	return nil
+}
+
+func (s *memoryStore) GetIdentity(ctx context.Context, provider, subject string) (*Identity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, i := range s.identities {
+		if i.Provider == provider && i.Subject == subject {
+			c := *i
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memoryStore) ListIdentities(ctx context.Context, userID primitive.ObjectID) ([]Identity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	identities := []Identity{}
+	for _, i := range s.identities {
+		if i.UserID == userID {
+			identities = append(identities, *i)
+		}
+	}
+	sort.Slice(identities, func(a, b int) bool { return identities[a].ID.Hex() < identities[b].ID.Hex() })
+	return identities, nil
+}
+
+func (s *memoryStore) UseIdentity(ctx context.Context, id primitive.ObjectID, email string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.identities[id]
+	if !ok {
+		return ErrNotFound
+	}
+	i.Email, i.LastUsedAt = email, &at
+	return nil
+}
+
+func (s *memoryStore) DeleteIdentity(ctx context.Context, userID, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.identities[id]
+	if !ok || i.UserID != userID {
+		return ErrNotFound
+	}
+	delete(s.identities, id)
+	return nil
+}
+
+func (s *memoryStore) CreateAPIToken(ctx context.Context, token *APIToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	c := *token
+	c.Scopes = append([]string(nil), token.Scopes...)
+	s.apiTokens[c.ID] = &c
+	return nil
+}
+
+func (s *memoryStore) GetAPITokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.apiTokens {
+		if t.TokenHash == hash {
+			c := *t
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *memoryStore) ListAPITokens(ctx context.Context, userID primitive.ObjectID) ([]APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tokens := []APIToken{}
+	for _, t := range s.apiTokens {
+		if t.UserID == userID {
+			tokens = append(tokens, *t)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID.Hex() > tokens[j].ID.Hex() })
+	return tokens, nil
+}
+
+func (s *memoryStore) UseAPIToken(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.apiTokens[id]
+	if !ok {
+		return ErrNotFound
+	}
+	t.LastUsedAt = &at
+	return nil
+}
+
+func (s *memoryStore) DeleteAPIToken(ctx context.Context, userID, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.apiTokens[id]
+	if !ok || t.UserID != userID {
+		return ErrNotFound
+	}
+	delete(s.apiTokens, id)
+	return nil
+}
+
+func (s *memoryStore) DeleteUserAPITokens(ctx context.Context, userID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, t := range s.apiTokens {
+		if t.UserID == userID {
+			delete(s.apiTokens, id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteExpiredAPITokens(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, t := range s.apiTokens {
+		if t.ExpiresAt.Before(now) {
+			delete(s.apiTokens, id)
+		}
+	}
+	return nil
+}
+
+// countHit returns a with one more hit at at, forgetting its hits first if
+// it expired.
+func countHit(a RateLimit, key string, at time.Time, window time.Duration) RateLimit {
+	if !a.ExpiresAt.After(at) {
+		a = RateLimit{}
+	}
+	a.Key = key
+	a.Hits++
+	a.LastHitAt = at
+	a.ExpiresAt = at.Add(window)
+	return a
+}
+
+func (s *memoryStore) HitRateLimit(ctx context.Context, key string, at time.Time, window time.Duration) (*RateLimit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := countHit(s.rateLimits[key], key, at, window)
+	s.rateLimits[key] = a
+	return &a, nil
+}
+
+func (s *memoryStore) GetRateLimits(ctx context.Context, keys []string) ([]RateLimit, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	found := []RateLimit{}
+	for _, key := range keys {
+		if a, ok := s.rateLimits[key]; ok {
+			found = append(found, a)
+		}
+	}
+	return found, nil
+}
+
+func (s *memoryStore) ResetRateLimit(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.rateLimits, key)
+	return nil
+}
+
+func (s *memoryStore) DeleteExpiredRateLimits(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, a := range s.rateLimits {
+		if a.ExpiresAt.Before(now) {
+			delete(s.rateLimits, key)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) CreateAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	s.auditEntries = append(s.auditEntries, *entry)
+	return nil
+}
+
+func (s *memoryStore) ListAuditEntries(ctx context.Context, limit int) ([]AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := []AuditEntry{}
+	for i := len(s.auditEntries) - 1; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, s.auditEntries[i])
+	}
+	return entries, nil
+}
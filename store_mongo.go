@@ -0,0 +1,1090 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoStore struct {
+	client *mongo.Client
+	todos  *mongo.Collection
+	users  *mongo.Collection
+	views  *mongo.Collection
+
+	refreshTokens *mongo.Collection
+	revokedTokens *mongo.Collection
+	sessions      *mongo.Collection
+	oneTimeTokens *mongo.Collection
+	passkeys      *mongo.Collection
+	identities    *mongo.Collection
+	apiTokens     *mongo.Collection
+	rateLimits    *mongo.Collection
+	audit         *mongo.Collection
+}
+
+func newMongoStore(ctx context.Context, uri string) (*mongoStore, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		_ = client.Disconnect(ctx)
+		return nil, err
+	}
+	db := client.Database("golang_db")
+	s := &mongoStore{
+		client: client,
+		todos:  db.Collection("todos"),
+		users:  db.Collection("users"),
+		views:  db.Collection("views"),
+
+		refreshTokens: db.Collection("refreshTokens"),
+		revokedTokens: db.Collection("revokedTokens"),
+		sessions:      db.Collection("sessions"),
+		oneTimeTokens: db.Collection("oneTimeTokens"),
+		passkeys:      db.Collection("passkeys"),
+		identities:    db.Collection("identities"),
+		apiTokens:     db.Collection("apiTokens"),
+		rateLimits:    db.Collection("rateLimits"),
+		audit:         db.Collection("audit"),
+	}
+	// Ensure unique index on email
+	_, _ = s.users.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	// Todos stored before sorting by priority existed have no priorityRank yet
+	_, _ = s.todos.UpdateMany(ctx, bson.M{"priorityRank": bson.M{"$exists": false}}, bson.A{
+		bson.M{"$set": bson.M{"priorityRank": bson.M{"$switch": bson.M{
+			"branches": bson.A{
+				bson.M{"case": bson.M{"$eq": bson.A{"$priority", "high"}}, "then": 3},
+				bson.M{"case": bson.M{"$eq": bson.A{"$priority", "medium"}}, "then": 2},
+				bson.M{"case": bson.M{"$eq": bson.A{"$priority", "low"}}, "then": 1},
+			},
+			"default": 0,
+		}}}},
+	})
+	// Users who signed up before email verification existed count as verified
+	_, _ = s.users.UpdateMany(ctx, bson.M{"emailVerifiedAt": bson.M{"$exists": false}}, bson.A{
+		bson.M{"$set": bson.M{"emailVerifiedAt": "$createdAt"}},
+	})
+	// Likewise for searchTerms, which only Go can compute
+	if err := s.backfillSearchTerms(ctx); err != nil {
+		_ = client.Disconnect(ctx)
+		return nil, err
+	}
+	// Every sort order resumes from a (field, _id) cursor, either across all
+	// todos or within one owner's; the ownerless/anonymous case only needs
+	// the default order.
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "searchTerms", Value: 1}}},
+		// Wishlist listings
+		{Keys: bson.D{{Key: "starredBy", Value: 1}, {Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}},
+	}
+	for _, field := range todoSortFields {
+		indexes = append(indexes, mongo.IndexModel{Keys: bson.D{
+			{Key: "ownerId", Value: 1}, {Key: mongoSortField(field), Value: -1}, {Key: "_id", Value: -1},
+		}})
+	}
+	_, _ = s.todos.Indexes().CreateMany(ctx, indexes)
+	_, _ = s.views.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "ownerId", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	_, _ = s.refreshTokens.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "familyId", Value: 1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+		// MongoDB deletes expired tokens itself
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	_, _ = s.revokedTokens.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	_, _ = s.sessions.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "lastSeenAt", Value: -1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	_, _ = s.oneTimeTokens.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "purpose", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	_, _ = s.passkeys.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "credentialId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+	})
+	_, _ = s.identities.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+	})
+	_, _ = s.apiTokens.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	_, _ = s.rateLimits.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	// Rate limits were kept in loginAttempts; they only last minutes
+	_ = db.Collection("loginAttempts").Drop(ctx)
+	return s, nil
+}
+
+func (s *mongoStore) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
+
+// backfillSearchTerms indexes todos stored before full-text search existed.
+func (s *mongoStore) backfillSearchTerms(ctx context.Context) error {
+	todos, err := s.findTodos(ctx, bson.M{"searchTerms": bson.M{"$exists": false}}, nil)
+	if err != nil {
+		return err
+	}
+	for i := range todos {
+		_, err := s.todos.UpdateOne(ctx, bson.M{"_id": todos[i].ID}, bson.M{"$set": bson.M{"searchTerms": todoSearchTerms(&todos[i])}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mongoTodoFilter(f TodoFilter) bson.M {
+	filter := bson.M{}
+	if f.ViewerID != nil {
+		filter["$or"] = bson.A{
+			bson.M{"ownerId": *f.ViewerID},
+			bson.M{"ownerId": bson.M{"$exists": false}},
+			bson.M{"ownerId": nil},
+		}
+	}
+	if f.OwnerID != nil {
+		filter["ownerId"] = *f.OwnerID
+	}
+	if f.Ownerless {
+		// Matches a missing ownerId as well as null
+		filter["ownerId"] = nil
+	}
+	if f.Archived {
+		filter["archivedAt"] = bson.M{"$ne": nil}
+	} else {
+		filter["archivedAt"] = nil
+	}
+	var conds bson.A
+	if f.Search != nil {
+		conds = append(conds, mongoSearchConds(f.Search)...)
+	}
+	if f.Completed != nil {
+		filter["completed"] = *f.Completed
+	}
+	if f.Priorities != nil {
+		conds = append(conds, bson.M{"priority": bson.M{"$in": mongoPriorities(f.Priorities)}})
+	}
+	if len(f.ExcludePriorities) > 0 {
+		conds = append(conds, bson.M{"priority": bson.M{"$nin": mongoPriorities(f.ExcludePriorities)}})
+	}
+	if f.HasDueDate != nil {
+		if *f.HasDueDate {
+			conds = append(conds, bson.M{"dueDate": bson.M{"$ne": nil}})
+		} else {
+			conds = append(conds, bson.M{"dueDate": nil})
+		}
+	}
+	if f.DueAfter != nil {
+		conds = append(conds, bson.M{"dueDate": bson.M{"$gte": *f.DueAfter}})
+	}
+	if f.DueBefore != nil {
+		conds = append(conds, bson.M{"dueDate": bson.M{"$lt": *f.DueBefore}})
+	}
+	if f.StarredBy != nil {
+		conds = append(conds, bson.M{"starredBy": *f.StarredBy})
+	}
+	if f.NotStarredBy != nil {
+		conds = append(conds, bson.M{"starredBy": bson.M{"$ne": *f.NotStarredBy}})
+	}
+	for _, tag := range f.Tags {
+		conds = append(conds, bson.M{"tags": mongoTagRegex(tag)})
+	}
+	for _, tag := range f.ExcludeTags {
+		conds = append(conds, bson.M{"tags": bson.M{"$not": mongoTagRegex(tag)}})
+	}
+	if len(conds) > 0 {
+		filter["$and"] = conds
+	}
+	return filter
+}
+
+// mongoPriorities lists the stored values for priorities, where "" (none)
+// also covers todos saved without a priority field.
+func mongoPriorities(priorities []string) bson.A {
+	values := bson.A{}
+	for _, p := range priorities {
+		values = append(values, p)
+		if p == "" {
+			values = append(values, nil)
+		}
+	}
+	return values
+}
+
+// mongoTagRegex matches a tag exactly, ignoring case.
+func mongoTagRegex(tag string) primitive.Regex {
+	return primitive.Regex{Pattern: "^" + regexp.QuoteMeta(tag) + "$", Options: "i"}
+}
+
+// mongoSearchConds selects the todos matching q. searchTerms narrows them
+// down by index; phrases and prefixes longer than the indexed ones are then
+// checked with a regular expression over the searched fields.
+func mongoSearchConds(q *SearchQuery) bson.A {
+	var terms []string
+	for _, t := range q.Terms {
+		terms = append(terms, t.indexTerms()...)
+	}
+	conds := bson.A{bson.M{"searchTerms": bson.M{"$all": terms}}}
+	for _, t := range q.Terms {
+		if !t.phrase() && !(t.Prefix && utf8.RuneCountInString(t.Words[len(t.Words)-1]) > maxIndexedPrefixLen) {
+			continue
+		}
+		words := make([]string, len(t.Words))
+		for i, w := range t.Words {
+			words[i] = regexp.QuoteMeta(w)
+		}
+		pattern := `(?<![\p{L}\p{N}])` + strings.Join(words, `[^\p{L}\p{N}]+`)
+		if !t.Prefix {
+			pattern += `(?![\p{L}\p{N}])`
+		}
+		re := bson.M{"$regex": pattern, "$options": "i"}
+		conds = append(conds, bson.M{"$or": bson.A{bson.M{"body": re}, bson.M{"tags": re}, bson.M{"notes": re}}})
+	}
+	return conds
+}
+
+// mongoSortField maps a TodoSort field to the document field holding its value.
+func mongoSortField(field string) string {
+	if field == "priority" {
+		return "priorityRank"
+	}
+	return field
+}
+
+// findTodos runs a find and decodes every returned document.
+func (s *mongoStore) findTodos(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]Todo, error) {
+	cursor, err := s.todos.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	todos := []Todo{}
+	for cursor.Next(ctx) {
+		var todo Todo
+		if err := cursor.Decode(&todo); err != nil {
+			return nil, err
+		}
+		todos = append(todos, todo)
+	}
+	return todos, cursor.Err()
+}
+
+// ListTodos pages through todos with the sort field set first and, for
+// nullable fields, those without it afterwards. Each phase is a plain
+// indexed range scan on (field, _id) or _id, instead of an aggregation
+// computing a null-last key for every document.
+func (s *mongoStore) ListTodos(ctx context.Context, q TodoQuery) (*TodoPage, error) {
+	base := mongoTodoFilter(q.TodoFilter)
+	total, err := s.todos.CountDocuments(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	sortBy := q.Sort
+	if sortBy.Field == "" {
+		sortBy = defaultTodoSort
+	}
+	field := mongoSortField(sortBy.Field)
+	dir, op := 1, "$gt"
+	if sortBy.Desc {
+		dir, op = -1, "$lt"
+	}
+	page := &TodoPage{Items: []Todo{}, Total: total}
+
+	// Fetch one extra document to learn whether another page follows
+	if q.After == nil || q.After.Value != nil {
+		conds := bson.A{base}
+		if sortBy.nullable() {
+			conds = append(conds, bson.M{field: bson.M{"$ne": nil}})
+		}
+		if q.After != nil {
+			conds = append(conds, bson.M{"$or": bson.A{
+				bson.M{field: bson.M{op: q.After.Value}},
+				bson.M{field: q.After.Value, "_id": bson.M{op: q.After.ID}},
+			}})
+		}
+		opts := options.Find().SetSort(bson.D{{Key: field, Value: dir}, {Key: "_id", Value: dir}})
+		if q.Limit > 0 {
+			opts.SetLimit(int64(q.Limit) + 1)
+		}
+		todos, err := s.findTodos(ctx, bson.M{"$and": conds}, opts)
+		if err != nil {
+			return nil, err
+		}
+		page.Items = todos
+	}
+	if sortBy.nullable() && (q.Limit <= 0 || len(page.Items) <= q.Limit) {
+		conds := bson.A{base, bson.M{field: nil}}
+		if q.After != nil && q.After.Value == nil {
+			conds = append(conds, bson.M{"_id": bson.M{op: q.After.ID}})
+		}
+		opts := options.Find().SetSort(bson.D{{Key: "_id", Value: dir}})
+		if q.Limit > 0 {
+			opts.SetLimit(int64(q.Limit + 1 - len(page.Items)))
+		}
+		todos, err := s.findTodos(ctx, bson.M{"$and": conds}, opts)
+		if err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, todos...)
+	}
+	if q.Limit > 0 && len(page.Items) > q.Limit {
+		page.Items = page.Items[:q.Limit]
+		page.Next = cursorFor(&page.Items[q.Limit-1], sortBy)
+	}
+	return page, nil
+}
+
+func (s *mongoStore) GetTodo(ctx context.Context, id primitive.ObjectID) (*Todo, error) {
+	var todo Todo
+	if err := s.todos.FindOne(ctx, bson.M{"_id": id}).Decode(&todo); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &todo, nil
+}
+
+// mongoTodoDoc is the stored form of a Todo. priorityRank is denormalized
+// from priority so sorting by importance can use an index, and searchTerms
+// from body, tags and notes so search can.
+type mongoTodoDoc struct {
+	*Todo        `bson:",inline"`
+	PriorityRank int      `bson:"priorityRank"`
+	SearchTerms  []string `bson:"searchTerms"`
+}
+
+func (s *mongoStore) CreateTodo(ctx context.Context, todo *Todo) error {
+	doc := mongoTodoDoc{Todo: todo, PriorityRank: priorityRank(todo.Priority), SearchTerms: todoSearchTerms(todo)}
+	res, err := s.todos.InsertOne(ctx, doc)
+	if err != nil {
+		return err
+	}
+	todo.ID = res.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (s *mongoStore) UpdateTodo(ctx context.Context, id primitive.ObjectID, u TodoUpdate) error {
+	toSet := bson.M{"updatedAt": u.UpdatedAt}
+	if u.Body != nil {
+		toSet["body"] = *u.Body
+	}
+	if u.Tags != nil {
+		toSet["tags"] = *u.Tags
+	}
+	if u.Notes != nil {
+		toSet["notes"] = *u.Notes
+	}
+	if u.Body != nil || u.Tags != nil || u.Notes != nil {
+		// searchTerms covers all three fields, so rebuild it from the updated todo
+		todo, err := s.GetTodo(ctx, id)
+		if err != nil {
+			return err
+		}
+		applyTodoUpdate(todo, u)
+		toSet["searchTerms"] = todoSearchTerms(todo)
+	}
+	if u.Completed != nil {
+		toSet["completed"] = *u.Completed
+	}
+	if u.CompletedAt != nil {
+		toSet["completedAt"] = *u.CompletedAt
+	}
+	if u.Starred != nil {
+		toSet["starred"] = *u.Starred
+	}
+	if u.Priority != nil {
+		toSet["priority"] = *u.Priority
+		toSet["priorityRank"] = priorityRank(*u.Priority)
+	}
+	if u.DueDate != nil {
+		toSet["dueDate"] = *u.DueDate
+	}
+	res, err := s.todos.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": toSet})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) SetTodoStarred(ctx context.Context, id, userID primitive.ObjectID, starred bool, at time.Time) error {
+	var update bson.M
+	if starred {
+		update = bson.M{"$addToSet": bson.M{"starredBy": userID}, "$set": bson.M{"starred": true, "updatedAt": at}}
+	} else {
+		update = bson.M{"$pull": bson.M{"starredBy": userID}, "$set": bson.M{"updatedAt": at}}
+	}
+	res, err := s.todos.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) DeleteTodo(ctx context.Context, id primitive.ObjectID) error {
+	res, err := s.todos.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) ClaimOwnerlessTodos(ctx context.Context, ids []primitive.ObjectID, owner *primitive.ObjectID, at time.Time) (int64, error) {
+	filter := bson.M{"ownerId": nil, "archivedAt": nil}
+	if ids != nil {
+		filter["_id"] = bson.M{"$in": ids}
+	}
+	set := bson.M{"updatedAt": at}
+	if owner != nil {
+		set["ownerId"] = *owner
+	} else {
+		set["archivedAt"] = at
+	}
+	res, err := s.todos.UpdateMany(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+func (s *mongoStore) CreateUser(ctx context.Context, user *User) error {
+	res, err := s.users.InsertOne(ctx, user)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrDuplicateEmail
+		}
+		return err
+	}
+	user.ID = res.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (s *mongoStore) findUser(ctx context.Context, filter bson.M) (*User, error) {
+	var user User
+	if err := s.users.FindOne(ctx, filter).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *mongoStore) GetUserByID(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	return s.findUser(ctx, bson.M{"_id": id})
+}
+
+func (s *mongoStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	return s.findUser(ctx, bson.M{"email": email})
+}
+
+func (s *mongoStore) UpdateUser(ctx context.Context, id primitive.ObjectID, u UserUpdate) (*User, error) {
+	toSet := bson.M{"updatedAt": u.UpdatedAt}
+	if u.Name != nil {
+		toSet["name"] = *u.Name
+	}
+	if u.Username != nil {
+		toSet["username"] = *u.Username
+	}
+	if u.Email != nil {
+		toSet["email"] = *u.Email
+	}
+	if u.Avatar != nil {
+		// allow setting avatar to null/empty to remove
+		if *u.Avatar == "" {
+			toSet["avatar"] = nil
+		} else {
+			toSet["avatar"] = *u.Avatar
+		}
+	}
+	if u.PasswordHash != nil {
+		toSet["passwordHash"] = *u.PasswordHash
+	}
+	if u.EmailVerifiedAt != nil {
+		toSet["emailVerifiedAt"] = *u.EmailVerifiedAt
+	}
+	if u.TOTPSecret != nil {
+		toSet["totpSecret"] = *u.TOTPSecret
+	}
+	if u.TOTPEnabledAt != nil {
+		toSet["totpEnabledAt"] = *u.TOTPEnabledAt
+	}
+	if u.RecoveryCodes != nil {
+		toSet["recoveryCodes"] = *u.RecoveryCodes
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var user User
+	if err := s.users.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": toSet}, opts).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		// E11000 from the unique index on email
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrDuplicateEmail
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *mongoStore) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) error {
+	// $not also matches users that have no last step yet
+	filter := bson.M{"_id": id, "totpLastStep": bson.M{"$not": bson.M{"$gte": step}}}
+	res, err := s.users.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"totpLastStep": step}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrTokenUsed
+	}
+	return nil
+}
+
+func (s *mongoStore) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) error {
+	res, err := s.users.UpdateOne(ctx, bson.M{"_id": id, "recoveryCodes": hash}, bson.M{"$pull": bson.M{"recoveryCodes": hash}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrTokenUsed
+	}
+	return nil
+}
+
+func (s *mongoStore) ListViews(ctx context.Context, ownerID primitive.ObjectID) ([]View, error) {
+	cursor, err := s.views.Find(ctx, bson.M{"ownerId": ownerID}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	views := []View{}
+	if err := cursor.All(ctx, &views); err != nil {
+		return nil, err
+	}
+	return views, nil
+}
+
+func (s *mongoStore) GetView(ctx context.Context, id primitive.ObjectID) (*View, error) {
+	var view View
+	if err := s.views.FindOne(ctx, bson.M{"_id": id}).Decode(&view); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &view, nil
+}
+
+func (s *mongoStore) CreateView(ctx context.Context, view *View) error {
+	res, err := s.views.InsertOne(ctx, view)
+	if err != nil {
+		return err
+	}
+	view.ID = res.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (s *mongoStore) UpdateView(ctx context.Context, id primitive.ObjectID, u ViewUpdate) (*View, error) {
+	toSet := bson.M{"updatedAt": u.UpdatedAt}
+	if u.Name != nil {
+		toSet["name"] = *u.Name
+	}
+	if u.Filter != nil {
+		toSet["filter"] = *u.Filter
+	}
+	if u.Sort != nil {
+		toSet["sort"] = *u.Sort
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var view View
+	if err := s.views.FindOneAndUpdate(ctx, bson.M{"_id": id}, bson.M{"$set": toSet}, opts).Decode(&view); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &view, nil
+}
+
+func (s *mongoStore) DeleteView(ctx context.Context, id primitive.ObjectID) error {
+	res, err := s.views.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	_, err := s.refreshTokens.InsertOne(ctx, token)
+	return err
+}
+
+func (s *mongoStore) GetRefreshTokenByHash(ctx context.Context, hash string) (*RefreshToken, error) {
+	var token RefreshToken
+	if err := s.refreshTokens.FindOne(ctx, bson.M{"tokenHash": hash}).Decode(&token); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *mongoStore) UseRefreshToken(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	res, err := s.refreshTokens.UpdateOne(ctx, bson.M{"_id": id, "usedAt": nil}, bson.M{"$set": bson.M{"usedAt": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		n, err := s.refreshTokens.CountDocuments(ctx, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+		return ErrTokenUsed
+	}
+	return nil
+}
+
+func (s *mongoStore) RevokeRefreshTokenFamily(ctx context.Context, familyID primitive.ObjectID, at time.Time) error {
+	_, err := s.refreshTokens.UpdateMany(ctx, bson.M{"familyId": familyID, "revokedAt": nil}, bson.M{"$set": bson.M{"revokedAt": at}})
+	return err
+}
+
+func (s *mongoStore) RevokeUserRefreshTokens(ctx context.Context, userID, keepFamily primitive.ObjectID, at time.Time) error {
+	filter := bson.M{"userId": userID, "revokedAt": nil}
+	if !keepFamily.IsZero() {
+		filter["familyId"] = bson.M{"$ne": keepFamily}
+	}
+	_, err := s.refreshTokens.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revokedAt": at}})
+	return err
+}
+
+// The TTL index removes expired tokens too, but only about once a minute.
+func (s *mongoStore) DeleteExpiredRefreshTokens(ctx context.Context, now time.Time) error {
+	_, err := s.refreshTokens.DeleteMany(ctx, bson.M{"expiresAt": bson.M{"$lt": now}})
+	return err
+}
+
+func (s *mongoStore) RevokeToken(ctx context.Context, r RevokedToken) error {
+	_, err := s.revokedTokens.ReplaceOne(ctx, bson.M{"_id": r.Key}, r, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *mongoStore) GetRevokedTokens(ctx context.Context, keys []string) ([]RevokedToken, error) {
+	cursor, err := s.revokedTokens.Find(ctx, bson.M{"_id": bson.M{"$in": keys}})
+	if err != nil {
+		return nil, err
+	}
+	found := []RevokedToken{}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+func (s *mongoStore) DeleteExpiredRevocations(ctx context.Context, now time.Time) error {
+	_, err := s.revokedTokens.DeleteMany(ctx, bson.M{"expiresAt": bson.M{"$lt": now}})
+	return err
+}
+
+func (s *mongoStore) CreateSession(ctx context.Context, session *Session) error {
+	_, err := s.sessions.InsertOne(ctx, session)
+	return err
+}
+
+func (s *mongoStore) ListSessions(ctx context.Context, userID primitive.ObjectID, now time.Time) ([]Session, error) {
+	filter := bson.M{"userId": userID, "expiresAt": bson.M{"$gt": now}}
+	cursor, err := s.sessions.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "lastSeenAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	sessions := []Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *mongoStore) GetSession(ctx context.Context, id primitive.ObjectID) (*Session, error) {
+	var session Session
+	if err := s.sessions.FindOne(ctx, bson.M{"_id": id}).Decode(&session); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *mongoStore) TouchSession(ctx context.Context, id primitive.ObjectID, ip string, at, expiresAt time.Time) error {
+	res, err := s.sessions.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"ip": ip, "lastSeenAt": at, "expiresAt": expiresAt}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) DeleteSession(ctx context.Context, id primitive.ObjectID) error {
+	res, err := s.sessions.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) DeleteUserSessions(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := s.sessions.DeleteMany(ctx, bson.M{"userId": userID})
+	return err
+}
+
+func (s *mongoStore) DeleteExpiredSessions(ctx context.Context, now time.Time) error {
+	_, err := s.sessions.DeleteMany(ctx, bson.M{"expiresAt": bson.M{"$lt": now}})
+	return err
+}
+
+func (s *mongoStore) CreateOneTimeToken(ctx context.Context, token *OneTimeToken) error {
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	_, err := s.oneTimeTokens.InsertOne(ctx, token)
+	return err
+}
+
+func (s *mongoStore) GetOneTimeToken(ctx context.Context, purpose, hash string) (*OneTimeToken, error) {
+	var token OneTimeToken
+	if err := s.oneTimeTokens.FindOne(ctx, bson.M{"tokenHash": hash, "purpose": purpose}).Decode(&token); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *mongoStore) RecentOneTimeTokens(ctx context.Context, userID primitive.ObjectID, purpose string, since time.Time) ([]OneTimeToken, error) {
+	filter := bson.M{"userId": userID, "purpose": purpose, "createdAt": bson.M{"$gt": since}}
+	cursor, err := s.oneTimeTokens.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	tokens := []OneTimeToken{}
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (s *mongoStore) UseOneTimeToken(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	res, err := s.oneTimeTokens.UpdateOne(ctx, bson.M{"_id": id, "usedAt": nil}, bson.M{"$set": bson.M{"usedAt": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		n, err := s.oneTimeTokens.CountDocuments(ctx, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrNotFound
+		}
+		return ErrTokenUsed
+	}
+	return nil
+}
+
+func (s *mongoStore) UseUserOneTimeTokens(ctx context.Context, userID primitive.ObjectID, purpose string, at time.Time) error {
+	_, err := s.oneTimeTokens.UpdateMany(ctx, bson.M{"userId": userID, "purpose": purpose, "usedAt": nil}, bson.M{"$set": bson.M{"usedAt": at}})
+	return err
+}
+
+func (s *mongoStore) DeleteExpiredOneTimeTokens(ctx context.Context, now time.Time) error {
+	_, err := s.oneTimeTokens.DeleteMany(ctx, bson.M{"expiresAt": bson.M{"$lt": now}})
+	return err
+}
+
+func (s *mongoStore) CreatePasskey(ctx context.Context, passkey *Passkey) error {
+	if passkey.ID.IsZero() {
+		passkey.ID = primitive.NewObjectID()
+	}
+	_, err := s.passkeys.InsertOne(ctx, passkey)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicatePasskey
+	}
+	return err
+}
+
+func (s *mongoStore) ListPasskeys(ctx context.Context, userID primitive.ObjectID) ([]Passkey, error) {
+	cursor, err := s.passkeys.Find(ctx, bson.M{"userId": userID}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	passkeys := []Passkey{}
+	if err := cursor.All(ctx, &passkeys); err != nil {
+		return nil, err
+	}
+	return passkeys, nil
+}
+
+func (s *mongoStore) GetPasskeyByCredentialID(ctx context.Context, credentialID []byte) (*Passkey, error) {
+	var p Passkey
+	if err := s.passkeys.FindOne(ctx, bson.M{"credentialId": credentialID}).Decode(&p); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *mongoStore) UsePasskey(ctx context.Context, id primitive.ObjectID, signCount uint32, backupState bool, at time.Time) error {
+	res, err := s.passkeys.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"signCount": signCount, "backupState": backupState, "lastUsedAt": at,
+	}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) DeletePasskey(ctx context.Context, userID, id primitive.ObjectID) error {
+	res, err := s.passkeys.DeleteOne(ctx, bson.M{"_id": id, "userId": userID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) CreateIdentity(ctx context.Context, identity *Identity) error {
+	if identity.ID.IsZero() {
+		identity.ID = primitive.NewObjectID()
+	}
+	_, err := s.identities.InsertOne(ctx, identity)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicateIdentity
+	}
+	return err
+}
+
+func (s *mongoStore) GetIdentity(ctx context.Context, provider, subject string) (*Identity, error) {
+	var i Identity
+	if err := s.identities.FindOne(ctx, bson.M{"provider": provider, "subject": subject}).Decode(&i); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &i, nil
+}
+
+func (s *mongoStore) ListIdentities(ctx context.Context, userID primitive.ObjectID) ([]Identity, error) {
+	cursor, err := s.identities.Find(ctx, bson.M{"userId": userID}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	identities := []Identity{}
+	if err := cursor.All(ctx, &identities); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (s *mongoStore) UseIdentity(ctx context.Context, id primitive.ObjectID, email string, at time.Time) error {
+	res, err := s.identities.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"email": email, "lastUsedAt": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) DeleteIdentity(ctx context.Context, userID, id primitive.ObjectID) error {
+	res, err := s.identities.DeleteOne(ctx, bson.M{"_id": id, "userId": userID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) CreateAPIToken(ctx context.Context, token *APIToken) error {
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	_, err := s.apiTokens.InsertOne(ctx, token)
+	return err
+}
+
+func (s *mongoStore) GetAPITokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	var token APIToken
+	if err := s.apiTokens.FindOne(ctx, bson.M{"tokenHash": hash}).Decode(&token); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *mongoStore) ListAPITokens(ctx context.Context, userID primitive.ObjectID) ([]APIToken, error) {
+	cursor, err := s.apiTokens.Find(ctx, bson.M{"userId": userID}, options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	tokens := []APIToken{}
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (s *mongoStore) UseAPIToken(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	res, err := s.apiTokens.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"lastUsedAt": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) DeleteAPIToken(ctx context.Context, userID, id primitive.ObjectID) error {
+	res, err := s.apiTokens.DeleteOne(ctx, bson.M{"_id": id, "userId": userID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) DeleteUserAPITokens(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := s.apiTokens.DeleteMany(ctx, bson.M{"userId": userID})
+	return err
+}
+
+func (s *mongoStore) DeleteExpiredAPITokens(ctx context.Context, now time.Time) error {
+	_, err := s.apiTokens.DeleteMany(ctx, bson.M{"expiresAt": bson.M{"$lt": now}})
+	return err
+}
+
+func (s *mongoStore) HitRateLimit(ctx context.Context, key string, at time.Time, window time.Duration) (*RateLimit, error) {
+	// One pipeline update, so concurrent hits on several servers all count
+	update := bson.A{bson.M{"$set": bson.M{
+		"hits": bson.M{"$cond": bson.A{
+			bson.M{"$gt": bson.A{"$expiresAt", at}},
+			bson.M{"$add": bson.A{"$hits", 1}},
+			1,
+		}},
+		"lastHitAt": at,
+		"expiresAt": at.Add(window),
+	}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var a RateLimit
+	if err := s.rateLimits.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (s *mongoStore) GetRateLimits(ctx context.Context, keys []string) ([]RateLimit, error) {
+	cursor, err := s.rateLimits.Find(ctx, bson.M{"_id": bson.M{"$in": keys}})
+	if err != nil {
+		return nil, err
+	}
+	found := []RateLimit{}
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+func (s *mongoStore) ResetRateLimit(ctx context.Context, key string) error {
+	_, err := s.rateLimits.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
+
+func (s *mongoStore) DeleteExpiredRateLimits(ctx context.Context, now time.Time) error {
+	_, err := s.rateLimits.DeleteMany(ctx, bson.M{"expiresAt": bson.M{"$lt": now}})
+	return err
+}
+
+func (s *mongoStore) CreateAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	_, err := s.audit.InsertOne(ctx, entry)
+	return err
+}
+
+func (s *mongoStore) ListAuditEntries(ctx context.Context, limit int) ([]AuditEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := s.audit.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	entries := []AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
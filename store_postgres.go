@@ -0,0 +1,1172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// postgresStore keeps todos and users in PostgreSQL. The schema lives in
+// migrations/postgres and is applied on startup by migratePostgres.
+type postgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func newPostgresStore(ctx context.Context, url string) (*postgresStore, error) {
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	if err := migratePostgres(ctx, pool); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	s := &postgresStore{pool: pool}
+	if err := s.backfillSearchTerms(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// backfillSearchTerms indexes todos stored before full-text search existed.
+func (s *postgresStore) backfillSearchTerms(ctx context.Context) error {
+	rows, err := s.pool.Query(ctx, "SELECT "+pgTodoColumns+" FROM todos t WHERE t.search_terms IS NULL")
+	if err != nil {
+		return err
+	}
+	todos, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*Todo, error) { return scanTodo(row) })
+	if err != nil {
+		return err
+	}
+	for _, todo := range todos {
+		_, err := s.pool.Exec(ctx, "UPDATE todos SET search_terms = $1 WHERE id = $2", todoSearchTerms(todo), todo.ID.Hex())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *postgresStore) Close(ctx context.Context) error {
+	s.pool.Close()
+	return nil
+}
+
+// pgArgs collects positional query arguments and hands out their placeholders.
+type pgArgs []interface{}
+
+func (a *pgArgs) add(v interface{}) string {
+	*a = append(*a, v)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+// pgObjectID converts a hex id column back into an ObjectID.
+func pgObjectID(hex string) primitive.ObjectID {
+	oid, _ := primitive.ObjectIDFromHex(hex)
+	return oid
+}
+
+const pgTodoColumns = `t.id, t.body, t.tags, t.notes, t.completed, t.starred, t.priority, t.due_date,
+	t.created_at, t.updated_at, t.completed_at, t.owner_id, t.archived_at,
+	ARRAY(SELECT s.user_id FROM todo_stars s WHERE s.todo_id = t.id ORDER BY s.starred_at, s.user_id)`
+
+func scanTodo(row pgx.Row) (*Todo, error) {
+	var (
+		todo      Todo
+		id        string
+		ownerID   *string
+		starredBy []string
+	)
+	err := row.Scan(&id, &todo.Body, &todo.Tags, &todo.Notes, &todo.Completed, &todo.Starred, &todo.Priority, &todo.DueDate,
+		&todo.CreatedAt, &todo.UpdatedAt, &todo.CompletedAt, &ownerID, &todo.ArchivedAt, &starredBy)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	todo.ID = pgObjectID(id)
+	if len(todo.Tags) == 0 {
+		todo.Tags = nil
+	}
+	todo.CreatedAt, todo.UpdatedAt = todo.CreatedAt.UTC(), todo.UpdatedAt.UTC()
+	if todo.DueDate != nil {
+		d := todo.DueDate.UTC()
+		todo.DueDate = &d
+	}
+	if todo.CompletedAt != nil {
+		d := todo.CompletedAt.UTC()
+		todo.CompletedAt = &d
+	}
+	if todo.ArchivedAt != nil {
+		d := todo.ArchivedAt.UTC()
+		todo.ArchivedAt = &d
+	}
+	if ownerID != nil {
+		oid := pgObjectID(*ownerID)
+		todo.OwnerID = &oid
+	}
+	if len(starredBy) > 0 {
+		todo.StarredBy = make([]primitive.ObjectID, len(starredBy))
+		for i, uid := range starredBy {
+			todo.StarredBy[i] = pgObjectID(uid)
+		}
+	}
+	return &todo, nil
+}
+
+// postgresTodoWhere translates a TodoFilter into a WHERE clause, mirroring mongoTodoFilter.
+func postgresTodoWhere(f TodoFilter, args *pgArgs) string {
+	conds := []string{"TRUE"}
+	if f.ViewerID != nil {
+		conds = append(conds, fmt.Sprintf("(t.owner_id = %s OR t.owner_id IS NULL)", args.add(f.ViewerID.Hex())))
+	}
+	if f.OwnerID != nil {
+		conds = append(conds, "t.owner_id = "+args.add(f.OwnerID.Hex()))
+	}
+	if f.Ownerless {
+		conds = append(conds, "t.owner_id IS NULL")
+	}
+	if f.Archived {
+		conds = append(conds, "t.archived_at IS NOT NULL")
+	} else {
+		conds = append(conds, "t.archived_at IS NULL")
+	}
+	if f.Search != nil {
+		conds = append(conds, postgresSearchConds(f.Search, args)...)
+	}
+	if f.Completed != nil {
+		conds = append(conds, "t.completed = "+args.add(*f.Completed))
+	}
+	if f.Priorities != nil {
+		conds = append(conds, "t.priority = ANY("+args.add(f.Priorities)+")")
+	}
+	if len(f.ExcludePriorities) > 0 {
+		conds = append(conds, "NOT t.priority = ANY("+args.add(f.ExcludePriorities)+")")
+	}
+	if f.HasDueDate != nil {
+		if *f.HasDueDate {
+			conds = append(conds, "t.due_date IS NOT NULL")
+		} else {
+			conds = append(conds, "t.due_date IS NULL")
+		}
+	}
+	if f.DueAfter != nil {
+		conds = append(conds, "t.due_date >= "+args.add(*f.DueAfter))
+	}
+	if f.DueBefore != nil {
+		conds = append(conds, "t.due_date < "+args.add(*f.DueBefore))
+	}
+	const starred = "EXISTS (SELECT 1 FROM todo_stars s WHERE s.todo_id = t.id AND s.user_id = %s)"
+	if f.StarredBy != nil {
+		conds = append(conds, fmt.Sprintf(starred, args.add(f.StarredBy.Hex())))
+	}
+	if f.NotStarredBy != nil {
+		conds = append(conds, "NOT "+fmt.Sprintf(starred, args.add(f.NotStarredBy.Hex())))
+	}
+	const tagged = "EXISTS (SELECT 1 FROM unnest(t.tags) tag WHERE lower(tag) = lower(%s))"
+	for _, tag := range f.Tags {
+		conds = append(conds, fmt.Sprintf(tagged, args.add(tag)))
+	}
+	for _, tag := range f.ExcludeTags {
+		conds = append(conds, "NOT "+fmt.Sprintf(tagged, args.add(tag)))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// postgresSearchConds selects the todos matching q, mirroring mongoSearchConds.
+func postgresSearchConds(q *SearchQuery, args *pgArgs) []string {
+	var terms []string
+	for _, t := range q.Terms {
+		terms = append(terms, t.indexTerms()...)
+	}
+	conds := []string{"t.search_terms @> " + args.add(terms)}
+	for _, t := range q.Terms {
+		if !t.phrase() && !(t.Prefix && utf8.RuneCountInString(t.Words[len(t.Words)-1]) > maxIndexedPrefixLen) {
+			continue
+		}
+		words := make([]string, len(t.Words))
+		for i, w := range t.Words {
+			words[i] = regexp.QuoteMeta(w)
+		}
+		pattern := `(^|[^[:alnum:]])` + strings.Join(words, `[^[:alnum:]]+`)
+		if !t.Prefix {
+			pattern += `([^[:alnum:]]|$)`
+		}
+		re := args.add(pattern)
+		conds = append(conds, fmt.Sprintf("(t.body ~* %s OR t.notes ~* %s OR EXISTS (SELECT 1 FROM unnest(t.tags) tag WHERE tag ~* %s))", re, re, re))
+	}
+	return conds
+}
+
+// pgSortColumns maps TodoSort fields to columns.
+var pgSortColumns = map[string]string{
+	"createdAt":   "t.created_at",
+	"updatedAt":   "t.updated_at",
+	"dueDate":     "t.due_date",
+	"priority":    "t.priority_rank",
+	"completedAt": "t.completed_at",
+}
+
+func (s *postgresStore) ListTodos(ctx context.Context, q TodoQuery) (*TodoPage, error) {
+	var args pgArgs
+	where := postgresTodoWhere(q.TodoFilter, &args)
+	page := &TodoPage{Items: []Todo{}}
+	if err := s.pool.QueryRow(ctx, "SELECT count(*) FROM todos t WHERE "+where, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+	sortBy := q.Sort
+	if sortBy.Field == "" {
+		sortBy = defaultTodoSort
+	}
+	col := pgSortColumns[sortBy.Field]
+	dir, op := "ASC", ">"
+	if sortBy.Desc {
+		dir, op = "DESC", "<"
+	}
+	if q.After != nil {
+		if q.After.Value != nil {
+			// Rows without a value sort after every row that has one
+			cond := fmt.Sprintf("(%s, t.id) %s (%s, %s)", col, op, args.add(q.After.Value), args.add(q.After.ID.Hex()))
+			if sortBy.nullable() {
+				cond = fmt.Sprintf("(%s OR %s IS NULL)", cond, col)
+			}
+			where += " AND " + cond
+		} else {
+			where += fmt.Sprintf(" AND %s IS NULL AND t.id %s %s", col, op, args.add(q.After.ID.Hex()))
+		}
+	}
+	query := fmt.Sprintf("SELECT %s FROM todos t WHERE %s ORDER BY %s %s NULLS LAST, t.id %s",
+		pgTodoColumns, where, col, dir, dir)
+	if q.Limit > 0 {
+		// Fetch one extra row to learn whether another page follows
+		query += " LIMIT " + args.add(q.Limit+1)
+	}
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, *todo)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if q.Limit > 0 && len(page.Items) > q.Limit {
+		page.Items = page.Items[:q.Limit]
+		page.Next = cursorFor(&page.Items[q.Limit-1], sortBy)
+	}
+	return page, nil
+}
+
+func (s *postgresStore) GetTodo(ctx context.Context, id primitive.ObjectID) (*Todo, error) {
+	return scanTodo(s.pool.QueryRow(ctx, "SELECT "+pgTodoColumns+" FROM todos t WHERE t.id = $1", id.Hex()))
+}
+
+func (s *postgresStore) CreateTodo(ctx context.Context, todo *Todo) error {
+	if todo.ID.IsZero() {
+		todo.ID = primitive.NewObjectID()
+	}
+	var ownerID *string
+	if todo.OwnerID != nil {
+		hex := todo.OwnerID.Hex()
+		ownerID = &hex
+	}
+	tags := todo.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	_, err := s.pool.Exec(ctx, `INSERT INTO todos
+		(id, body, tags, notes, search_terms, completed, starred, priority, due_date, created_at, updated_at, completed_at, owner_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+		todo.ID.Hex(), todo.Body, tags, todo.Notes, todoSearchTerms(todo), todo.Completed, todo.Starred, todo.Priority, todo.DueDate,
+		todo.CreatedAt, todo.UpdatedAt, todo.CompletedAt, ownerID)
+	return err
+}
+
+func (s *postgresStore) UpdateTodo(ctx context.Context, id primitive.ObjectID, u TodoUpdate) error {
+	var args pgArgs
+	sets := []string{"updated_at = " + args.add(u.UpdatedAt)}
+	if u.Body != nil {
+		sets = append(sets, "body = "+args.add(*u.Body))
+	}
+	if u.Tags != nil {
+		tags := *u.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		sets = append(sets, "tags = "+args.add(tags))
+	}
+	if u.Notes != nil {
+		sets = append(sets, "notes = "+args.add(*u.Notes))
+	}
+	if u.Body != nil || u.Tags != nil || u.Notes != nil {
+		// search_terms covers all three fields, so rebuild it from the updated todo
+		todo, err := s.GetTodo(ctx, id)
+		if err != nil {
+			return err
+		}
+		applyTodoUpdate(todo, u)
+		sets = append(sets, "search_terms = "+args.add(todoSearchTerms(todo)))
+	}
+	if u.Completed != nil {
+		sets = append(sets, "completed = "+args.add(*u.Completed))
+	}
+	if u.CompletedAt != nil {
+		sets = append(sets, "completed_at = "+args.add(*u.CompletedAt))
+	}
+	if u.Starred != nil {
+		sets = append(sets, "starred = "+args.add(*u.Starred))
+	}
+	if u.Priority != nil {
+		sets = append(sets, "priority = "+args.add(*u.Priority))
+	}
+	if u.DueDate != nil {
+		sets = append(sets, "due_date = "+args.add(*u.DueDate))
+	}
+	query := "UPDATE todos SET " + strings.Join(sets, ", ") + " WHERE id = " + args.add(id.Hex())
+	tag, err := s.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) SetTodoStarred(ctx context.Context, id, userID primitive.ObjectID, starred bool, at time.Time) error {
+	return pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		var set string
+		if starred {
+			set = "starred = true, updated_at = $2"
+		} else {
+			set = "updated_at = $2"
+		}
+		tag, err := tx.Exec(ctx, "UPDATE todos SET "+set+" WHERE id = $1", id.Hex(), at)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+		if starred {
+			_, err = tx.Exec(ctx, `INSERT INTO todo_stars (todo_id, user_id, starred_at) VALUES ($1, $2, $3)
+				ON CONFLICT (todo_id, user_id) DO NOTHING`, id.Hex(), userID.Hex(), at)
+		} else {
+			_, err = tx.Exec(ctx, "DELETE FROM todo_stars WHERE todo_id = $1 AND user_id = $2", id.Hex(), userID.Hex())
+		}
+		return err
+	})
+}
+
+func (s *postgresStore) DeleteTodo(ctx context.Context, id primitive.ObjectID) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM todos WHERE id = $1", id.Hex())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) ClaimOwnerlessTodos(ctx context.Context, ids []primitive.ObjectID, owner *primitive.ObjectID, at time.Time) (int64, error) {
+	var args pgArgs
+	set := "archived_at = " + args.add(at)
+	if owner != nil {
+		set = "owner_id = " + args.add(owner.Hex())
+	}
+	query := "UPDATE todos SET " + set + ", updated_at = " + args.add(at) + " WHERE owner_id IS NULL AND archived_at IS NULL"
+	if ids != nil {
+		hexIDs := make([]string, len(ids))
+		for i, id := range ids {
+			hexIDs[i] = id.Hex()
+		}
+		query += " AND id = ANY(" + args.add(hexIDs) + ")"
+	}
+	tag, err := s.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// isUniqueViolation reports whether err is a PostgreSQL unique_violation on constraint.
+func isUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint
+}
+
+// pgStrings keeps nil slices out of NOT NULL array columns.
+func pgStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+const pgUserColumns = "id, name, username, avatar, email, password_hash, created_at, updated_at, email_verified_at, " +
+	"totp_secret, totp_enabled_at, totp_last_step, recovery_codes"
+
+func scanUser(row pgx.Row) (*User, error) {
+	var (
+		user User
+		id   string
+	)
+	err := row.Scan(&id, &user.Name, &user.Username, &user.Avatar, &user.Email, &user.PasswordHash,
+		&user.CreatedAt, &user.UpdatedAt, &user.EmailVerifiedAt,
+		&user.TOTPSecret, &user.TOTPEnabledAt, &user.TOTPLastStep, &user.RecoveryCodes)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	user.ID = pgObjectID(id)
+	user.CreatedAt, user.UpdatedAt = user.CreatedAt.UTC(), user.UpdatedAt.UTC()
+	if user.EmailVerifiedAt != nil {
+		t := user.EmailVerifiedAt.UTC()
+		user.EmailVerifiedAt = &t
+	}
+	return &user, nil
+}
+
+func (s *postgresStore) CreateUser(ctx context.Context, user *User) error {
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	_, err := s.pool.Exec(ctx, "INSERT INTO users ("+pgUserColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)",
+		user.ID.Hex(), user.Name, user.Username, user.Avatar, user.Email, user.PasswordHash,
+		user.CreatedAt, user.UpdatedAt, user.EmailVerifiedAt,
+		user.TOTPSecret, user.TOTPEnabledAt, user.TOTPLastStep, pgStrings(user.RecoveryCodes))
+	if isUniqueViolation(err, "users_email_key") {
+		return ErrDuplicateEmail
+	}
+	return err
+}
+
+func (s *postgresStore) GetUserByID(ctx context.Context, id primitive.ObjectID) (*User, error) {
+	return scanUser(s.pool.QueryRow(ctx, "SELECT "+pgUserColumns+" FROM users WHERE id = $1", id.Hex()))
+}
+
+func (s *postgresStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	return scanUser(s.pool.QueryRow(ctx, "SELECT "+pgUserColumns+" FROM users WHERE email = $1", email))
+}
+
+func (s *postgresStore) UpdateUser(ctx context.Context, id primitive.ObjectID, u UserUpdate) (*User, error) {
+	var args pgArgs
+	sets := []string{"updated_at = " + args.add(u.UpdatedAt)}
+	if u.Name != nil {
+		sets = append(sets, "name = "+args.add(*u.Name))
+	}
+	if u.Username != nil {
+		sets = append(sets, "username = "+args.add(*u.Username))
+	}
+	if u.Email != nil {
+		sets = append(sets, "email = "+args.add(*u.Email))
+	}
+	if u.Avatar != nil {
+		sets = append(sets, "avatar = "+args.add(*u.Avatar))
+	}
+	if u.PasswordHash != nil {
+		sets = append(sets, "password_hash = "+args.add(*u.PasswordHash))
+	}
+	if u.EmailVerifiedAt != nil {
+		sets = append(sets, "email_verified_at = "+args.add(*u.EmailVerifiedAt))
+	}
+	if u.TOTPSecret != nil {
+		sets = append(sets, "totp_secret = "+args.add(*u.TOTPSecret))
+	}
+	if u.TOTPEnabledAt != nil {
+		sets = append(sets, "totp_enabled_at = "+args.add(*u.TOTPEnabledAt))
+	}
+	if u.RecoveryCodes != nil {
+		sets = append(sets, "recovery_codes = "+args.add(pgStrings(*u.RecoveryCodes)))
+	}
+	query := "UPDATE users SET " + strings.Join(sets, ", ") + " WHERE id = " + args.add(id.Hex()) +
+		" RETURNING " + pgUserColumns
+	user, err := scanUser(s.pool.QueryRow(ctx, query, args...))
+	if isUniqueViolation(err, "users_email_key") {
+		return nil, ErrDuplicateEmail
+	}
+	return user, err
+}
+
+func (s *postgresStore) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) error {
+	tag, err := s.pool.Exec(ctx, "UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2", id.Hex(), step)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTokenUsed
+	}
+	return nil
+}
+
+func (s *postgresStore) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) error {
+	tag, err := s.pool.Exec(ctx, "UPDATE users SET recovery_codes = array_remove(recovery_codes, $2) WHERE id = $1 AND $2 = ANY(recovery_codes)",
+		id.Hex(), hash)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTokenUsed
+	}
+	return nil
+}
+
+const pgViewColumns = "id, owner_id, name, filter, sort, created_at, updated_at"
+
+func scanView(row pgx.Row) (*View, error) {
+	var (
+		view    View
+		id      string
+		ownerID string
+	)
+	err := row.Scan(&id, &ownerID, &view.Name, &view.Filter, &view.Sort, &view.CreatedAt, &view.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	view.ID, view.OwnerID = pgObjectID(id), pgObjectID(ownerID)
+	view.CreatedAt, view.UpdatedAt = view.CreatedAt.UTC(), view.UpdatedAt.UTC()
+	return &view, nil
+}
+
+func (s *postgresStore) ListViews(ctx context.Context, ownerID primitive.ObjectID) ([]View, error) {
+	rows, err := s.pool.Query(ctx, "SELECT "+pgViewColumns+" FROM views WHERE owner_id = $1 ORDER BY id", ownerID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	views := []View{}
+	for rows.Next() {
+		view, err := scanView(rows)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, *view)
+	}
+	return views, rows.Err()
+}
+
+func (s *postgresStore) GetView(ctx context.Context, id primitive.ObjectID) (*View, error) {
+	return scanView(s.pool.QueryRow(ctx, "SELECT "+pgViewColumns+" FROM views WHERE id = $1", id.Hex()))
+}
+
+func (s *postgresStore) CreateView(ctx context.Context, view *View) error {
+	if view.ID.IsZero() {
+		view.ID = primitive.NewObjectID()
+	}
+	_, err := s.pool.Exec(ctx, "INSERT INTO views ("+pgViewColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		view.ID.Hex(), view.OwnerID.Hex(), view.Name, view.Filter, view.Sort, view.CreatedAt, view.UpdatedAt)
+	return err
+}
+
+func (s *postgresStore) UpdateView(ctx context.Context, id primitive.ObjectID, u ViewUpdate) (*View, error) {
+	var args pgArgs
+	sets := []string{"updated_at = " + args.add(u.UpdatedAt)}
+	if u.Name != nil {
+		sets = append(sets, "name = "+args.add(*u.Name))
+	}
+	if u.Filter != nil {
+		sets = append(sets, "filter = "+args.add(*u.Filter))
+	}
+	if u.Sort != nil {
+		sets = append(sets, "sort = "+args.add(*u.Sort))
+	}
+	query := "UPDATE views SET " + strings.Join(sets, ", ") + " WHERE id = " + args.add(id.Hex()) +
+		" RETURNING " + pgViewColumns
+	return scanView(s.pool.QueryRow(ctx, query, args...))
+}
+
+func (s *postgresStore) DeleteView(ctx context.Context, id primitive.ObjectID) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM views WHERE id = $1", id.Hex())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const pgRefreshTokenColumns = "id, user_id, family_id, token_hash, created_at, expires_at, used_at, revoked_at"
+
+func scanRefreshToken(row pgx.Row) (*RefreshToken, error) {
+	var (
+		token                RefreshToken
+		id, userID, familyID string
+	)
+	err := row.Scan(&id, &userID, &familyID, &token.TokenHash, &token.CreatedAt, &token.ExpiresAt, &token.UsedAt, &token.RevokedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	token.ID, token.UserID, token.FamilyID = pgObjectID(id), pgObjectID(userID), pgObjectID(familyID)
+	token.CreatedAt, token.ExpiresAt = token.CreatedAt.UTC(), token.ExpiresAt.UTC()
+	return &token, nil
+}
+
+func (s *postgresStore) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	_, err := s.pool.Exec(ctx, "INSERT INTO refresh_tokens ("+pgRefreshTokenColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		token.ID.Hex(), token.UserID.Hex(), token.FamilyID.Hex(), token.TokenHash, token.CreatedAt, token.ExpiresAt,
+		token.UsedAt, token.RevokedAt)
+	return err
+}
+
+func (s *postgresStore) GetRefreshTokenByHash(ctx context.Context, hash string) (*RefreshToken, error) {
+	return scanRefreshToken(s.pool.QueryRow(ctx, "SELECT "+pgRefreshTokenColumns+" FROM refresh_tokens WHERE token_hash = $1", hash))
+}
+
+func (s *postgresStore) UseRefreshToken(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	// A concurrent refresh waits for the row lock and then fails the used_at check
+	tag, err := s.pool.Exec(ctx, "UPDATE refresh_tokens SET used_at = $2 WHERE id = $1 AND used_at IS NULL", id.Hex(), at)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		var exists bool
+		if err := s.pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM refresh_tokens WHERE id = $1)", id.Hex()).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotFound
+		}
+		return ErrTokenUsed
+	}
+	return nil
+}
+
+func (s *postgresStore) RevokeRefreshTokenFamily(ctx context.Context, familyID primitive.ObjectID, at time.Time) error {
+	_, err := s.pool.Exec(ctx, "UPDATE refresh_tokens SET revoked_at = $2 WHERE family_id = $1 AND revoked_at IS NULL", familyID.Hex(), at)
+	return err
+}
+
+func (s *postgresStore) RevokeUserRefreshTokens(ctx context.Context, userID, keepFamily primitive.ObjectID, at time.Time) error {
+	// The zero ObjectID is no family, so it keeps nothing
+	_, err := s.pool.Exec(ctx, "UPDATE refresh_tokens SET revoked_at = $3 WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL",
+		userID.Hex(), keepFamily.Hex(), at)
+	return err
+}
+
+func (s *postgresStore) DeleteExpiredRefreshTokens(ctx context.Context, now time.Time) error {
+	_, err := s.pool.Exec(ctx, "DELETE FROM refresh_tokens WHERE expires_at < $1", now)
+	return err
+}
+
+func (s *postgresStore) RevokeToken(ctx context.Context, r RevokedToken) error {
+	_, err := s.pool.Exec(ctx, `INSERT INTO revoked_tokens (key, revoked_at, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET revoked_at = EXCLUDED.revoked_at, expires_at = EXCLUDED.expires_at`,
+		r.Key, r.RevokedAt, r.ExpiresAt)
+	return err
+}
+
+func (s *postgresStore) GetRevokedTokens(ctx context.Context, keys []string) ([]RevokedToken, error) {
+	rows, err := s.pool.Query(ctx, "SELECT key, revoked_at, expires_at FROM revoked_tokens WHERE key = ANY($1)", keys)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (RevokedToken, error) {
+		var r RevokedToken
+		err := row.Scan(&r.Key, &r.RevokedAt, &r.ExpiresAt)
+		r.RevokedAt, r.ExpiresAt = r.RevokedAt.UTC(), r.ExpiresAt.UTC()
+		return r, err
+	})
+}
+
+func (s *postgresStore) DeleteExpiredRevocations(ctx context.Context, now time.Time) error {
+	_, err := s.pool.Exec(ctx, "DELETE FROM revoked_tokens WHERE expires_at < $1", now)
+	return err
+}
+
+const pgSessionColumns = "id, user_id, device, ip, created_at, last_seen_at, expires_at"
+
+func scanSession(row pgx.Row) (*Session, error) {
+	var (
+		session    Session
+		id, userID string
+	)
+	err := row.Scan(&id, &userID, &session.Device, &session.IP, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	session.ID, session.UserID = pgObjectID(id), pgObjectID(userID)
+	session.CreatedAt, session.LastSeenAt, session.ExpiresAt = session.CreatedAt.UTC(), session.LastSeenAt.UTC(), session.ExpiresAt.UTC()
+	return &session, nil
+}
+
+func (s *postgresStore) CreateSession(ctx context.Context, session *Session) error {
+	_, err := s.pool.Exec(ctx, "INSERT INTO sessions ("+pgSessionColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		session.ID.Hex(), session.UserID.Hex(), session.Device, session.IP, session.CreatedAt, session.LastSeenAt, session.ExpiresAt)
+	return err
+}
+
+func (s *postgresStore) ListSessions(ctx context.Context, userID primitive.ObjectID, now time.Time) ([]Session, error) {
+	rows, err := s.pool.Query(ctx, "SELECT "+pgSessionColumns+" FROM sessions WHERE user_id = $1 AND expires_at > $2 ORDER BY last_seen_at DESC",
+		userID.Hex(), now)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Session, error) {
+		session, err := scanSession(row)
+		if err != nil {
+			return Session{}, err
+		}
+		return *session, nil
+	})
+}
+
+func (s *postgresStore) GetSession(ctx context.Context, id primitive.ObjectID) (*Session, error) {
+	return scanSession(s.pool.QueryRow(ctx, "SELECT "+pgSessionColumns+" FROM sessions WHERE id = $1", id.Hex()))
+}
+
+func (s *postgresStore) TouchSession(ctx context.Context, id primitive.ObjectID, ip string, at, expiresAt time.Time) error {
+	tag, err := s.pool.Exec(ctx, "UPDATE sessions SET ip = $2, last_seen_at = $3, expires_at = $4 WHERE id = $1", id.Hex(), ip, at, expiresAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) DeleteSession(ctx context.Context, id primitive.ObjectID) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM sessions WHERE id = $1", id.Hex())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) DeleteUserSessions(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := s.pool.Exec(ctx, "DELETE FROM sessions WHERE user_id = $1", userID.Hex())
+	return err
+}
+
+func (s *postgresStore) DeleteExpiredSessions(ctx context.Context, now time.Time) error {
+	_, err := s.pool.Exec(ctx, "DELETE FROM sessions WHERE expires_at < $1", now)
+	return err
+}
+
+const pgOneTimeTokenColumns = "id, user_id, purpose, token_hash, created_at, expires_at, used_at, email, data"
+
+func scanOneTimeToken(row pgx.Row) (*OneTimeToken, error) {
+	var (
+		token  OneTimeToken
+		id     string
+		userID *string
+	)
+	err := row.Scan(&id, &userID, &token.Purpose, &token.TokenHash, &token.CreatedAt, &token.ExpiresAt, &token.UsedAt, &token.Email, &token.Data)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	token.ID = pgObjectID(id)
+	if userID != nil {
+		token.UserID = pgObjectID(*userID)
+	}
+	token.CreatedAt, token.ExpiresAt = token.CreatedAt.UTC(), token.ExpiresAt.UTC()
+	return &token, nil
+}
+
+func (s *postgresStore) CreateOneTimeToken(ctx context.Context, token *OneTimeToken) error {
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	var userID *string
+	if !token.UserID.IsZero() {
+		hex := token.UserID.Hex()
+		userID = &hex
+	}
+	_, err := s.pool.Exec(ctx, "INSERT INTO one_time_tokens ("+pgOneTimeTokenColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		token.ID.Hex(), userID, token.Purpose, token.TokenHash, token.CreatedAt, token.ExpiresAt, token.UsedAt, token.Email, token.Data)
+	return err
+}
+
+func (s *postgresStore) GetOneTimeToken(ctx context.Context, purpose, hash string) (*OneTimeToken, error) {
+	return scanOneTimeToken(s.pool.QueryRow(ctx, "SELECT "+pgOneTimeTokenColumns+" FROM one_time_tokens WHERE token_hash = $1 AND purpose = $2",
+		hash, purpose))
+}
+
+func (s *postgresStore) RecentOneTimeTokens(ctx context.Context, userID primitive.ObjectID, purpose string, since time.Time) ([]OneTimeToken, error) {
+	rows, err := s.pool.Query(ctx, "SELECT "+pgOneTimeTokenColumns+` FROM one_time_tokens
+		WHERE user_id = $1 AND purpose = $2 AND created_at > $3 ORDER BY created_at DESC`, userID.Hex(), purpose, since)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (OneTimeToken, error) {
+		token, err := scanOneTimeToken(row)
+		if err != nil {
+			return OneTimeToken{}, err
+		}
+		return *token, nil
+	})
+}
+
+func (s *postgresStore) UseOneTimeToken(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	tag, err := s.pool.Exec(ctx, "UPDATE one_time_tokens SET used_at = $2 WHERE id = $1 AND used_at IS NULL", id.Hex(), at)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		var exists bool
+		if err := s.pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM one_time_tokens WHERE id = $1)", id.Hex()).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotFound
+		}
+		return ErrTokenUsed
+	}
+	return nil
+}
+
+func (s *postgresStore) UseUserOneTimeTokens(ctx context.Context, userID primitive.ObjectID, purpose string, at time.Time) error {
+	_, err := s.pool.Exec(ctx, "UPDATE one_time_tokens SET used_at = $3 WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL",
+		userID.Hex(), purpose, at)
+	return err
+}
+
+func (s *postgresStore) DeleteExpiredOneTimeTokens(ctx context.Context, now time.Time) error {
+	_, err := s.pool.Exec(ctx, "DELETE FROM one_time_tokens WHERE expires_at < $1", now)
+	return err
+}
+
+const pgPasskeyColumns = "id, user_id, name, credential_id, public_key, attestation_type, transports, aaguid, " +
+	"sign_count, backup_eligible, backup_state, created_at, last_used_at"
+
+func scanPasskey(row pgx.Row) (*Passkey, error) {
+	var (
+		p          Passkey
+		id, userID string
+		signCount  int64
+	)
+	err := row.Scan(&id, &userID, &p.Name, &p.CredentialID, &p.PublicKey, &p.AttestationType, &p.Transports, &p.AAGUID,
+		&signCount, &p.BackupEligible, &p.BackupState, &p.CreatedAt, &p.LastUsedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	p.ID, p.UserID = pgObjectID(id), pgObjectID(userID)
+	p.SignCount = uint32(signCount)
+	p.CreatedAt = p.CreatedAt.UTC()
+	return &p, nil
+}
+
+func (s *postgresStore) CreatePasskey(ctx context.Context, p *Passkey) error {
+	if p.ID.IsZero() {
+		p.ID = primitive.NewObjectID()
+	}
+	_, err := s.pool.Exec(ctx, "INSERT INTO passkeys ("+pgPasskeyColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)",
+		p.ID.Hex(), p.UserID.Hex(), p.Name, p.CredentialID, p.PublicKey, p.AttestationType, pgStrings(p.Transports), p.AAGUID,
+		int64(p.SignCount), p.BackupEligible, p.BackupState, p.CreatedAt, p.LastUsedAt)
+	if isUniqueViolation(err, "passkeys_credential_id_key") {
+		return ErrDuplicatePasskey
+	}
+	return err
+}
+
+func (s *postgresStore) ListPasskeys(ctx context.Context, userID primitive.ObjectID) ([]Passkey, error) {
+	rows, err := s.pool.Query(ctx, "SELECT "+pgPasskeyColumns+" FROM passkeys WHERE user_id = $1 ORDER BY id", userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Passkey, error) {
+		p, err := scanPasskey(row)
+		if err != nil {
+			return Passkey{}, err
+		}
+		return *p, nil
+	})
+}
+
+func (s *postgresStore) GetPasskeyByCredentialID(ctx context.Context, credentialID []byte) (*Passkey, error) {
+	return scanPasskey(s.pool.QueryRow(ctx, "SELECT "+pgPasskeyColumns+" FROM passkeys WHERE credential_id = $1", credentialID))
+}
+
+func (s *postgresStore) UsePasskey(ctx context.Context, id primitive.ObjectID, signCount uint32, backupState bool, at time.Time) error {
+	tag, err := s.pool.Exec(ctx, "UPDATE passkeys SET sign_count = $2, backup_state = $3, last_used_at = $4 WHERE id = $1",
+		id.Hex(), int64(signCount), backupState, at)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) DeletePasskey(ctx context.Context, userID, id primitive.ObjectID) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM passkeys WHERE id = $1 AND user_id = $2", id.Hex(), userID.Hex())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const pgIdentityColumns = "id, user_id, provider, subject, email, created_at, last_used_at"
+
+func scanIdentity(row pgx.Row) (*Identity, error) {
+	var (
+		i          Identity
+		id, userID string
+	)
+	if err := row.Scan(&id, &userID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt, &i.LastUsedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	i.ID, i.UserID = pgObjectID(id), pgObjectID(userID)
+	i.CreatedAt = i.CreatedAt.UTC()
+	return &i, nil
+}
+
+func (s *postgresStore) CreateIdentity(ctx context.Context, i *Identity) error {
+	if i.ID.IsZero() {
+		i.ID = primitive.NewObjectID()
+	}
+	_, err := s.pool.Exec(ctx, "INSERT INTO identities ("+pgIdentityColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		i.ID.Hex(), i.UserID.Hex(), i.Provider, i.Subject, i.Email, i.CreatedAt, i.LastUsedAt)
+	if isUniqueViolation(err, "identities_provider_subject_key") {
+		return ErrDuplicateIdentity
+	}
+	return err
+}
+
+func (s *postgresStore) GetIdentity(ctx context.Context, provider, subject string) (*Identity, error) {
+	return scanIdentity(s.pool.QueryRow(ctx, "SELECT "+pgIdentityColumns+" FROM identities WHERE provider = $1 AND subject = $2", provider, subject))
+}
+
+func (s *postgresStore) ListIdentities(ctx context.Context, userID primitive.ObjectID) ([]Identity, error) {
+	rows, err := s.pool.Query(ctx, "SELECT "+pgIdentityColumns+" FROM identities WHERE user_id = $1 ORDER BY id", userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Identity, error) {
+		i, err := scanIdentity(row)
+		if err != nil {
+			return Identity{}, err
+		}
+		return *i, nil
+	})
+}
+
+func (s *postgresStore) UseIdentity(ctx context.Context, id primitive.ObjectID, email string, at time.Time) error {
+	tag, err := s.pool.Exec(ctx, "UPDATE identities SET email = $2, last_used_at = $3 WHERE id = $1", id.Hex(), email, at)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) DeleteIdentity(ctx context.Context, userID, id primitive.ObjectID) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM identities WHERE id = $1 AND user_id = $2", id.Hex(), userID.Hex())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const pgAPITokenColumns = "id, user_id, name, scopes, token_hash, hint, created_at, expires_at, last_used_at"
+
+func scanAPIToken(row pgx.Row) (*APIToken, error) {
+	var (
+		t          APIToken
+		id, userID string
+	)
+	err := row.Scan(&id, &userID, &t.Name, &t.Scopes, &t.TokenHash, &t.Hint, &t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	t.ID, t.UserID = pgObjectID(id), pgObjectID(userID)
+	t.CreatedAt, t.ExpiresAt = t.CreatedAt.UTC(), t.ExpiresAt.UTC()
+	return &t, nil
+}
+
+func (s *postgresStore) CreateAPIToken(ctx context.Context, t *APIToken) error {
+	if t.ID.IsZero() {
+		t.ID = primitive.NewObjectID()
+	}
+	_, err := s.pool.Exec(ctx, "INSERT INTO api_tokens ("+pgAPITokenColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		t.ID.Hex(), t.UserID.Hex(), t.Name, pgStrings(t.Scopes), t.TokenHash, t.Hint, t.CreatedAt, t.ExpiresAt, t.LastUsedAt)
+	return err
+}
+
+func (s *postgresStore) GetAPITokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	return scanAPIToken(s.pool.QueryRow(ctx, "SELECT "+pgAPITokenColumns+" FROM api_tokens WHERE token_hash = $1", hash))
+}
+
+func (s *postgresStore) ListAPITokens(ctx context.Context, userID primitive.ObjectID) ([]APIToken, error) {
+	rows, err := s.pool.Query(ctx, "SELECT "+pgAPITokenColumns+" FROM api_tokens WHERE user_id = $1 ORDER BY id DESC", userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (APIToken, error) {
+		t, err := scanAPIToken(row)
+		if err != nil {
+			return APIToken{}, err
+		}
+		return *t, nil
+	})
+}
+
+func (s *postgresStore) UseAPIToken(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	tag, err := s.pool.Exec(ctx, "UPDATE api_tokens SET last_used_at = $2 WHERE id = $1", id.Hex(), at)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) DeleteAPIToken(ctx context.Context, userID, id primitive.ObjectID) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM api_tokens WHERE id = $1 AND user_id = $2", id.Hex(), userID.Hex())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *postgresStore) DeleteUserAPITokens(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := s.pool.Exec(ctx, "DELETE FROM api_tokens WHERE user_id = $1", userID.Hex())
+	return err
+}
+
+func (s *postgresStore) DeleteExpiredAPITokens(ctx context.Context, now time.Time) error {
+	_, err := s.pool.Exec(ctx, "DELETE FROM api_tokens WHERE expires_at < $1", now)
+	return err
+}
+
+func (s *postgresStore) HitRateLimit(ctx context.Context, key string, at time.Time, window time.Duration) (*RateLimit, error) {
+	var a RateLimit
+	err := s.pool.QueryRow(ctx, `INSERT INTO rate_limits (key, hits, last_hit_at, expires_at) VALUES ($1, 1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET
+			hits = CASE WHEN rate_limits.expires_at > EXCLUDED.last_hit_at THEN rate_limits.hits + 1 ELSE 1 END,
+			last_hit_at = EXCLUDED.last_hit_at,
+			expires_at = EXCLUDED.expires_at
+		RETURNING key, hits, last_hit_at, expires_at`, key, at, at.Add(window)).
+		Scan(&a.Key, &a.Hits, &a.LastHitAt, &a.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	a.LastHitAt, a.ExpiresAt = a.LastHitAt.UTC(), a.ExpiresAt.UTC()
+	return &a, nil
+}
+
+func (s *postgresStore) GetRateLimits(ctx context.Context, keys []string) ([]RateLimit, error) {
+	rows, err := s.pool.Query(ctx, "SELECT key, hits, last_hit_at, expires_at FROM rate_limits WHERE key = ANY($1)", keys)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (RateLimit, error) {
+		var a RateLimit
+		err := row.Scan(&a.Key, &a.Hits, &a.LastHitAt, &a.ExpiresAt)
+		a.LastHitAt, a.ExpiresAt = a.LastHitAt.UTC(), a.ExpiresAt.UTC()
+		return a, err
+	})
+}
+
+func (s *postgresStore) ResetRateLimit(ctx context.Context, key string) error {
+	_, err := s.pool.Exec(ctx, "DELETE FROM rate_limits WHERE key = $1", key)
+	return err
+}
+
+func (s *postgresStore) DeleteExpiredRateLimits(ctx context.Context, now time.Time) error {
+	_, err := s.pool.Exec(ctx, "DELETE FROM rate_limits WHERE expires_at < $1", now)
+	return err
+}
+
+func (s *postgresStore) CreateAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	var userID *string
+	if entry.UserID != nil {
+		hex := entry.UserID.Hex()
+		userID = &hex
+	}
+	_, err := s.pool.Exec(ctx, "INSERT INTO audit_log (id, action, user_id, subject, ip, details, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		entry.ID.Hex(), entry.Action, userID, entry.Subject, entry.IP, entry.Details, entry.CreatedAt)
+	return err
+}
+
+func (s *postgresStore) ListAuditEntries(ctx context.Context, limit int) ([]AuditEntry, error) {
+	rows, err := s.pool.Query(ctx, "SELECT id, action, user_id, subject, ip, details, created_at FROM audit_log ORDER BY id DESC LIMIT $1", limit)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (AuditEntry, error) {
+		var (
+			entry  AuditEntry
+			id     string
+			userID *string
+		)
+		if err := row.Scan(&id, &entry.Action, &userID, &entry.Subject, &entry.IP, &entry.Details, &entry.CreatedAt); err != nil {
+			return AuditEntry{}, err
+		}
+		entry.ID = pgObjectID(id)
+		if userID != nil {
+			oid := pgObjectID(*userID)
+			entry.UserID = &oid
+		}
+		entry.CreatedAt = entry.CreatedAt.UTC()
+		return entry, nil
+	})
+}
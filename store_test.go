@@ -0,0 +1,772 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The store tests run against every backend: memory and bolt always,
+// postgres when DATABASE_URL is set and MongoDB when MONGO_URI is set. Point
+// those at scratch databases; CI runs them against service containers. The
+// tests only look at records they made, so they do not need empty ones, but
+// they leave records behind.
+
+var testStoreBackends = []struct {
+	name string
+	open func(t *testing.T) (Store, error)
+}{
+	{"memory", func(t *testing.T) (Store, error) { return newMemoryStore(), nil }},
+	{"bolt", func(t *testing.T) (Store, error) {
+		s, err := newBoltStore(filepath.Join(t.TempDir(), "todos.db"))
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}},
+	{"postgres", func(t *testing.T) (Store, error) {
+		url := os.Getenv("DATABASE_URL")
+		if url == "" {
+			t.Skip("DATABASE_URL is not set")
+		}
+		s, err := newPostgresStore(context.Background(), url)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}},
+	{"mongo", func(t *testing.T) (Store, error) {
+		uri := os.Getenv("MONGO_URI")
+		if uri == "" {
+			t.Skip("MONGO_URI is not set")
+		}
+		s, err := newMongoStore(context.Background(), uri)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}},
+}
+
+// forEachStore runs test as a subtest against each backend.
+func forEachStore(t *testing.T, test func(t *testing.T, s Store)) {
+	for _, b := range testStoreBackends {
+		t.Run(b.name, func(t *testing.T) {
+			s, err := b.open(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { s.Close(context.Background()) })
+			test(t, s)
+		})
+	}
+}
+
+// storeTestTime is minutes past a fixed time. Backends keep at least
+// millisecond precision, so whole minutes compare equal after a round trip.
+func storeTestTime(minutes int) time.Time {
+	return time.Date(2025, 3, 1, 9, minutes, 0, 0, time.UTC)
+}
+
+func timePtr(t time.Time) *time.Time { return &t }
+
+// uniqueWord is a word no other test uses, to tell this test's records
+// apart in a shared database.
+func uniqueWord() string {
+	return "w" + primitive.NewObjectID().Hex()
+}
+
+// storeTestUser stores a user to own test records, which postgres requires.
+func storeTestUser(t *testing.T, s Store) primitive.ObjectID {
+	t.Helper()
+	user := &User{Name: "Test", Email: uniqueWord() + "@example.com", CreatedAt: storeTestTime(0), UpdatedAt: storeTestTime(0)}
+	if err := s.CreateUser(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+// seedSortFixture stores five todos, a to e, owned by a new user and made so
+// that each sort field orders them differently. It returns the owner.
+func seedSortFixture(t *testing.T, s Store) primitive.ObjectID {
+	t.Helper()
+	owner := storeTestUser(t, s)
+	fixture := []struct {
+		body           string
+		updated        int
+		due, completed *time.Time
+		priority       string
+		tags           []string
+		notes          string
+	}{
+		{"a buy oat milk", 5, timePtr(storeTestTime(30)), nil, "high", []string{"Home", "errands"}, ""},
+		{"b write report", 4, nil, timePtr(storeTestTime(42)), "low", []string{"work"}, ""},
+		{"c call mom", 3, timePtr(storeTestTime(10)), nil, "", nil, "ask about milk"},
+		{"d pay rent", 2, timePtr(storeTestTime(20)), timePtr(storeTestTime(41)), "high", []string{"home"}, ""},
+		{"e water plants", 1, nil, nil, "medium", nil, ""},
+	}
+	for i, f := range fixture {
+		todo := &Todo{
+			Body: f.body, Tags: f.tags, Notes: f.notes, Priority: f.priority, DueDate: f.due,
+			Completed: f.completed != nil, CompletedAt: f.completed, OwnerID: &owner,
+			CreatedAt: storeTestTime(i + 1), UpdatedAt: storeTestTime(f.updated),
+		}
+		if err := s.CreateTodo(context.Background(), todo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return owner
+}
+
+// todoLetters returns the first letter of each todo's body.
+func todoLetters(todos []Todo) string {
+	out := ""
+	for _, todo := range todos {
+		out += todo.Body[:1]
+	}
+	return out
+}
+
+func listLetters(t *testing.T, s Store, q TodoQuery) string {
+	t.Helper()
+	page, err := s.ListTodos(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Next != nil {
+		t.Errorf("unexpected next page for %+v", q)
+	}
+	return todoLetters(page.Items)
+}
+
+// Todos without a value for the sort field come last in both directions;
+// ties are broken by id in the sort direction.
+var sortFixtureOrders = []struct {
+	sort TodoSort
+	want string
+}{
+	{TodoSort{Field: "createdAt"}, "abcde"},
+	{TodoSort{Field: "createdAt", Desc: true}, "edcba"},
+	{TodoSort{Field: "updatedAt"}, "edcba"},
+	{TodoSort{Field: "updatedAt", Desc: true}, "abcde"},
+	{TodoSort{Field: "dueDate"}, "cdabe"},
+	{TodoSort{Field: "dueDate", Desc: true}, "adceb"},
+	{TodoSort{Field: "priority"}, "cbead"},
+	{TodoSort{Field: "priority", Desc: true}, "daebc"},
+	{TodoSort{Field: "completedAt"}, "dbace"},
+	{TodoSort{Field: "completedAt", Desc: true}, "bdeca"},
+}
+
+func TestStoreListTodosSorts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		owner := seedSortFixture(t, s)
+		if got := listLetters(t, s, TodoQuery{TodoFilter: TodoFilter{OwnerID: &owner}}); got != "edcba" {
+			t.Errorf("default order: got %s, want edcba", got)
+		}
+		for _, tc := range sortFixtureOrders {
+			got := listLetters(t, s, TodoQuery{TodoFilter: TodoFilter{OwnerID: &owner}, Sort: tc.sort})
+			if got != tc.want {
+				t.Errorf("%s: got %s, want %s", tc.sort, got, tc.want)
+			}
+		}
+	})
+}
+
+func TestStoreListTodosPages(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		owner := seedSortFixture(t, s)
+		for _, tc := range sortFixtureOrders {
+			q := TodoQuery{TodoFilter: TodoFilter{OwnerID: &owner}, Sort: tc.sort, Limit: 2}
+			got := ""
+			for pages := 0; ; pages++ {
+				if pages == 4 {
+					t.Fatalf("%s: too many pages", tc.sort)
+				}
+				page, err := s.ListTodos(context.Background(), q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if page.Total != 5 {
+					t.Errorf("%s: total %d, want 5", tc.sort, page.Total)
+				}
+				got += todoLetters(page.Items)
+				if page.Next == nil {
+					break
+				}
+				q.After = page.Next
+			}
+			if got != tc.want {
+				t.Errorf("%s: pages gave %s, want %s", tc.sort, got, tc.want)
+			}
+		}
+	})
+}
+
+func TestStoreListTodosFilters(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		owner := seedSortFixture(t, s)
+		todos, err := s.ListTodos(context.Background(), TodoQuery{TodoFilter: TodoFilter{OwnerID: &owner}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		starrer := storeTestUser(t, s)
+		for _, todo := range todos.Items {
+			if todo.Body[0] == 'e' {
+				if err := s.SetTodoStarred(context.Background(), todo.ID, starrer, true, storeTestTime(50)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		yes, no := true, false
+		for _, tc := range []struct {
+			name   string
+			filter TodoFilter
+			want   string
+		}{
+			{"completed", TodoFilter{Completed: &yes}, "bd"},
+			{"active", TodoFilter{Completed: &no}, "ace"},
+			{"priority high", TodoFilter{Priorities: []string{"high"}}, "ad"},
+			{"no priority", TodoFilter{Priorities: []string{""}}, "c"},
+			{"not high", TodoFilter{ExcludePriorities: []string{"high"}}, "bce"},
+			{"no due date", TodoFilter{HasDueDate: &no}, "be"},
+			{"has due date", TodoFilter{HasDueDate: &yes}, "acd"},
+			{"due range", TodoFilter{DueAfter: timePtr(storeTestTime(20)), DueBefore: timePtr(storeTestTime(30))}, "d"},
+			{"tag", TodoFilter{Tags: []string{"HOME"}}, "ad"},
+			{"tags", TodoFilter{Tags: []string{"home", "errands"}}, "a"},
+			{"not tag", TodoFilter{ExcludeTags: []string{"work", "errands"}}, "cde"},
+			{"starred", TodoFilter{StarredBy: &starrer}, "e"},
+			{"not starred", TodoFilter{NotStarredBy: &starrer}, "abcd"},
+			{"word", TodoFilter{Search: parseSearchQuery("milk")}, "ac"},
+			{"prefix", TodoFilter{Search: parseSearchQuery("rep*")}, "b"},
+			{"words", TodoFilter{Search: parseSearchQuery("oat milk")}, "a"},
+		} {
+			tc.filter.OwnerID = &owner
+			q := TodoQuery{TodoFilter: tc.filter, Sort: TodoSort{Field: "createdAt"}}
+			if got := listLetters(t, s, q); got != tc.want {
+				t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+			}
+		}
+	})
+}
+
+func TestStoreOwnershipAndArchive(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		word := uniqueWord()
+		me, other := storeTestUser(t, s), storeTestUser(t, s)
+		for i, owner := range []*primitive.ObjectID{nil, &me, &other} {
+			todo := &Todo{Body: string(rune('a'+i)) + " " + word, OwnerID: owner, CreatedAt: storeTestTime(i), UpdatedAt: storeTestTime(i)}
+			if err := s.CreateTodo(ctx, todo); err != nil {
+				t.Fatal(err)
+			}
+		}
+		search := parseSearchQuery(word)
+		list := func(f TodoFilter) string {
+			f.Search = search
+			return listLetters(t, s, TodoQuery{TodoFilter: f, Sort: TodoSort{Field: "createdAt"}})
+		}
+		if got := list(TodoFilter{ViewerID: &me}); got != "ab" {
+			t.Errorf("viewer: got %s, want ab", got)
+		}
+		if got := list(TodoFilter{Ownerless: true}); got != "a" {
+			t.Errorf("ownerless: got %s, want a", got)
+		}
+		if got := list(TodoFilter{}); got != "abc" {
+			t.Errorf("everything: got %s, want abc", got)
+		}
+
+		page, err := s.ListTodos(ctx, TodoQuery{TodoFilter: TodoFilter{Ownerless: true, Search: search}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := s.ClaimOwnerlessTodos(ctx, []primitive.ObjectID{page.Items[0].ID}, nil, storeTestTime(10))
+		if err != nil || n != 1 {
+			t.Fatalf("archive: %d, %v", n, err)
+		}
+		if got := list(TodoFilter{ViewerID: &me}); got != "b" {
+			t.Errorf("viewer after archiving: got %s, want b", got)
+		}
+		if got := list(TodoFilter{Archived: true}); got != "a" {
+			t.Errorf("archived: got %s, want a", got)
+		}
+	})
+}
+
+func TestStoreTodoCRUD(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		todo := &Todo{Body: "first", CreatedAt: storeTestTime(0), UpdatedAt: storeTestTime(0)}
+		if err := s.CreateTodo(ctx, todo); err != nil {
+			t.Fatal(err)
+		}
+		if todo.ID.IsZero() {
+			t.Fatal("CreateTodo did not set the id")
+		}
+		body, done, due := "second", true, timePtr(storeTestTime(5))
+		completedAt := timePtr(storeTestTime(6))
+		err := s.UpdateTodo(ctx, todo.ID, TodoUpdate{Body: &body, Completed: &done, CompletedAt: &completedAt, DueDate: &due, UpdatedAt: storeTestTime(6)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.GetTodo(ctx, todo.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Body != "second" || !got.Completed || got.DueDate == nil || !got.DueDate.Equal(*due) || !got.UpdatedAt.Equal(storeTestTime(6)) {
+			t.Errorf("after update: %+v", got)
+		}
+		var noDue *time.Time
+		if err := s.UpdateTodo(ctx, todo.ID, TodoUpdate{DueDate: &noDue, UpdatedAt: storeTestTime(7)}); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := s.GetTodo(ctx, todo.ID); got.DueDate != nil {
+			t.Errorf("due date not cleared: %v", got.DueDate)
+		}
+
+		starrer := storeTestUser(t, s)
+		for _, starred := range []bool{true, true, false} {
+			if err := s.SetTodoStarred(ctx, todo.ID, starrer, starred, storeTestTime(8)); err != nil {
+				t.Fatal(err)
+			}
+			want := 0
+			if starred {
+				want = 1
+			}
+			if got, _ := s.GetTodo(ctx, todo.ID); len(got.StarredBy) != want || (starred && got.StarredBy[0] != starrer) {
+				t.Errorf("starred %v: starredBy %v", starred, got.StarredBy)
+			}
+		}
+
+		if err := s.DeleteTodo(ctx, todo.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.GetTodo(ctx, todo.ID); err != ErrNotFound {
+			t.Errorf("get deleted todo: %v", err)
+		}
+		if err := s.UpdateTodo(ctx, todo.ID, TodoUpdate{Body: &body, UpdatedAt: storeTestTime(8)}); err != ErrNotFound {
+			t.Errorf("update deleted todo: %v", err)
+		}
+		if err := s.DeleteTodo(ctx, todo.ID); err != ErrNotFound {
+			t.Errorf("delete deleted todo: %v", err)
+		}
+	})
+}
+
+func TestStoreUsers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		email := uniqueWord() + "@example.com"
+		user := &User{Name: "Ann", Email: email, PasswordHash: "hash", CreatedAt: storeTestTime(0), UpdatedAt: storeTestTime(0)}
+		if err := s.CreateUser(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.CreateUser(ctx, &User{Name: "Copy", Email: email, CreatedAt: storeTestTime(0)}); err != ErrDuplicateEmail {
+			t.Errorf("duplicate email: %v", err)
+		}
+		got, err := s.GetUserByEmail(ctx, email)
+		if err != nil || got.ID != user.ID || got.EmailVerifiedAt != nil {
+			t.Fatalf("by email: %+v, %v", got, err)
+		}
+		if _, err := s.GetUserByID(ctx, primitive.NewObjectID()); err != ErrNotFound {
+			t.Errorf("unknown id: %v", err)
+		}
+
+		other := &User{Name: "Ben", Email: uniqueWord() + "@example.com", CreatedAt: storeTestTime(0)}
+		if err := s.CreateUser(ctx, other); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.UpdateUser(ctx, other.ID, UserUpdate{Email: &email, UpdatedAt: storeTestTime(1)}); err != ErrDuplicateEmail {
+			t.Errorf("update to a taken email: %v", err)
+		}
+		name, avatar, verifiedAt := "Anna", "data:image/png;base64,AA", timePtr(storeTestTime(2))
+		updated, err := s.UpdateUser(ctx, user.ID, UserUpdate{Name: &name, Avatar: &avatar, EmailVerifiedAt: &verifiedAt, UpdatedAt: storeTestTime(2)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.Name != "Anna" || updated.Avatar != avatar || updated.EmailVerifiedAt == nil || updated.PasswordHash != "hash" {
+			t.Errorf("updated: %+v", updated)
+		}
+		var unverified *time.Time
+		if updated, _ = s.UpdateUser(ctx, user.ID, UserUpdate{EmailVerifiedAt: &unverified, UpdatedAt: storeTestTime(3)}); updated.EmailVerifiedAt != nil {
+			t.Errorf("verification not cleared: %v", updated.EmailVerifiedAt)
+		}
+		noAvatar := ""
+		if updated, _ = s.UpdateUser(ctx, user.ID, UserUpdate{Avatar: &noAvatar, UpdatedAt: storeTestTime(3)}); updated.Avatar != "" {
+			t.Errorf("avatar not removed: %q", updated.Avatar)
+		}
+		if _, err := s.UpdateUser(ctx, primitive.NewObjectID(), UserUpdate{Name: &name, UpdatedAt: storeTestTime(4)}); err != ErrNotFound {
+			t.Errorf("update unknown user: %v", err)
+		}
+
+		for _, step := range []struct {
+			step int64
+			want error
+		}{{5, nil}, {5, ErrTokenUsed}, {4, ErrTokenUsed}, {6, nil}} {
+			if err := s.UseTOTPStep(ctx, user.ID, step.step); err != step.want {
+				t.Errorf("TOTP step %d: %v, want %v", step.step, err, step.want)
+			}
+		}
+		codes := []string{"h1", "h2"}
+		if _, err := s.UpdateUser(ctx, user.ID, UserUpdate{RecoveryCodes: &codes, UpdatedAt: storeTestTime(4)}); err != nil {
+			t.Fatal(err)
+		}
+		for _, use := range []struct {
+			hash string
+			want error
+		}{{"h1", nil}, {"h1", ErrTokenUsed}, {"h3", ErrTokenUsed}, {"h2", nil}} {
+			if err := s.UseRecoveryCode(ctx, user.ID, use.hash); err != use.want {
+				t.Errorf("recovery code %s: %v, want %v", use.hash, err, use.want)
+			}
+		}
+	})
+}
+
+func TestStoreViews(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		owner := storeTestUser(t, s)
+		for i, name := range []string{"first", "second"} {
+			view := &View{OwnerID: owner, Name: name, Filter: ViewFilter{Status: "active"}, CreatedAt: storeTestTime(i), UpdatedAt: storeTestTime(i)}
+			if err := s.CreateView(ctx, view); err != nil {
+				t.Fatal(err)
+			}
+		}
+		views, err := s.ListViews(ctx, owner)
+		if err != nil || len(views) != 2 || views[0].Name != "first" {
+			t.Fatalf("list: %+v, %v", views, err)
+		}
+		name, filter := "renamed", ViewFilter{Query: "tag:x"}
+		updated, err := s.UpdateView(ctx, views[0].ID, ViewUpdate{Name: &name, Filter: &filter, UpdatedAt: storeTestTime(5)})
+		if err != nil || updated.Name != "renamed" || updated.Filter.Query != "tag:x" || updated.Filter.Status != "" {
+			t.Errorf("update: %+v, %v", updated, err)
+		}
+		if err := s.DeleteView(ctx, views[1].ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.GetView(ctx, views[1].ID); err != ErrNotFound {
+			t.Errorf("get deleted view: %v", err)
+		}
+	})
+}
+
+func TestStoreRefreshTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		user, family := storeTestUser(t, s), primitive.NewObjectID()
+		hash := uniqueWord()
+		token := &RefreshToken{UserID: user, FamilyID: family, TokenHash: hash, CreatedAt: storeTestTime(0), ExpiresAt: time.Now().Add(time.Hour)}
+		if err := s.CreateRefreshToken(ctx, token); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.GetRefreshTokenByHash(ctx, hash)
+		if err != nil || got.FamilyID != family || got.UsedAt != nil {
+			t.Fatalf("by hash: %+v, %v", got, err)
+		}
+		if err := s.UseRefreshToken(ctx, got.ID, storeTestTime(1)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.UseRefreshToken(ctx, got.ID, storeTestTime(2)); err != ErrTokenUsed {
+			t.Errorf("second use: %v", err)
+		}
+		if err := s.RevokeRefreshTokenFamily(ctx, family, storeTestTime(3)); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := s.GetRefreshTokenByHash(ctx, hash); got.RevokedAt == nil {
+			t.Error("family not revoked")
+		}
+	})
+}
+
+func TestStoreRevocations(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		key := uniqueWord()
+		for _, minutes := range []int{1, 2} {
+			if err := s.RevokeToken(ctx, RevokedToken{Key: key, RevokedAt: storeTestTime(minutes), ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		found, err := s.GetRevokedTokens(ctx, []string{key, uniqueWord()})
+		if err != nil || len(found) != 1 || !found[0].RevokedAt.Equal(storeTestTime(2)) {
+			t.Errorf("get: %+v, %v", found, err)
+		}
+	})
+}
+
+func TestStoreSessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		user := storeTestUser(t, s)
+		now := time.Now().UTC().Truncate(time.Second)
+		for i, expires := range []time.Duration{time.Hour, time.Hour, -time.Minute} {
+			session := &Session{ID: primitive.NewObjectID(), UserID: user, Device: string(rune('a' + i)),
+				CreatedAt: now, LastSeenAt: now.Add(time.Duration(i) * time.Second), ExpiresAt: now.Add(expires)}
+			if err := s.CreateSession(ctx, session); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sessions, err := s.ListSessions(ctx, user, now)
+		if err != nil || len(sessions) != 2 || sessions[0].Device != "b" {
+			t.Fatalf("list: %+v, %v", sessions, err)
+		}
+		if err := s.TouchSession(ctx, sessions[1].ID, "10.0.0.1", now.Add(time.Minute), now.Add(2*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if sessions, _ = s.ListSessions(ctx, user, now); sessions[0].Device != "a" || sessions[0].IP != "10.0.0.1" {
+			t.Errorf("after touch: %+v", sessions)
+		}
+		if err := s.DeleteSession(ctx, sessions[0].ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.GetSession(ctx, sessions[0].ID); err != ErrNotFound {
+			t.Errorf("get deleted session: %v", err)
+		}
+		if err := s.DeleteUserSessions(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+		if sessions, _ = s.ListSessions(ctx, user, now); len(sessions) != 0 {
+			t.Errorf("after deleting all: %+v", sessions)
+		}
+	})
+}
+
+func TestStoreOneTimeTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		user := storeTestUser(t, s)
+		var hashes []string
+		for i := 0; i < 3; i++ {
+			hash := uniqueWord()
+			hashes = append(hashes, hash)
+			token := &OneTimeToken{UserID: user, Purpose: "test", TokenHash: hash, Email: "a@example.com",
+				CreatedAt: storeTestTime(i), ExpiresAt: storeTestTime(60)}
+			if err := s.CreateOneTimeToken(ctx, token); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := s.GetOneTimeToken(ctx, "other", hashes[0]); err != ErrNotFound {
+			t.Errorf("wrong purpose: %v", err)
+		}
+		first, err := s.GetOneTimeToken(ctx, "test", hashes[0])
+		if err != nil || first.Email != "a@example.com" {
+			t.Fatalf("get: %+v, %v", first, err)
+		}
+		recent, err := s.RecentOneTimeTokens(ctx, user, "test", storeTestTime(0))
+		if err != nil || len(recent) != 2 || recent[0].TokenHash != hashes[2] {
+			t.Errorf("recent: %+v, %v", recent, err)
+		}
+		if err := s.UseOneTimeToken(ctx, first.ID, storeTestTime(5)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.UseOneTimeToken(ctx, first.ID, storeTestTime(6)); err != ErrTokenUsed {
+			t.Errorf("second use: %v", err)
+		}
+		if err := s.UseUserOneTimeTokens(ctx, user, "test", storeTestTime(7)); err != nil {
+			t.Fatal(err)
+		}
+		for _, hash := range hashes {
+			if token, _ := s.GetOneTimeToken(ctx, "test", hash); token.UsedAt == nil {
+				t.Errorf("token %s not used", hash)
+			}
+		}
+		if first, _ = s.GetOneTimeToken(ctx, "test", hashes[0]); !first.UsedAt.Equal(storeTestTime(5)) {
+			t.Errorf("used token's time changed to %v", first.UsedAt)
+		}
+	})
+}
+
+func TestStoreRateLimits(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		key := uniqueWord()
+		now := time.Now().UTC().Truncate(time.Second)
+		for i, want := range []int{1, 2} {
+			a, err := s.HitRateLimit(ctx, key, now.Add(time.Duration(i)*time.Second), time.Minute)
+			if err != nil || a.Hits != want || !a.ExpiresAt.Equal(now.Add(time.Duration(i)*time.Second+time.Minute)) {
+				t.Fatalf("hit %d: %+v, %v", i+1, a, err)
+			}
+		}
+		// An expired record starts over
+		a, err := s.HitRateLimit(ctx, key, now.Add(2*time.Minute), time.Minute)
+		if err != nil || a.Hits != 1 {
+			t.Errorf("after the window: %+v, %v", a, err)
+		}
+		found, err := s.GetRateLimits(ctx, []string{key, uniqueWord()})
+		if err != nil || len(found) != 1 || found[0].Key != key {
+			t.Errorf("get: %+v, %v", found, err)
+		}
+		if err := s.ResetRateLimit(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+		if found, _ = s.GetRateLimits(ctx, []string{key}); len(found) != 0 {
+			t.Errorf("after reset: %+v", found)
+		}
+	})
+}
+
+func TestStorePasskeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		user := storeTestUser(t, s)
+		base := uniqueWord()
+		credentialID := func(i byte) []byte { return append([]byte(base), i) }
+		for i, name := range []string{"laptop", "phone"} {
+			passkey := &Passkey{UserID: user, Name: name, CredentialID: credentialID(byte(i)), PublicKey: []byte{1}, CreatedAt: storeTestTime(i)}
+			if err := s.CreatePasskey(ctx, passkey); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.CreatePasskey(ctx, &Passkey{UserID: user, CredentialID: credentialID(0), CreatedAt: storeTestTime(3)}); err != ErrDuplicatePasskey {
+			t.Errorf("duplicate passkey: %v", err)
+		}
+		passkeys, err := s.ListPasskeys(ctx, user)
+		if err != nil || len(passkeys) != 2 || passkeys[0].Name != "laptop" {
+			t.Fatalf("list passkeys: %+v, %v", passkeys, err)
+		}
+		if err := s.UsePasskey(ctx, passkeys[0].ID, 7, true, storeTestTime(5)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.GetPasskeyByCredentialID(ctx, credentialID(0))
+		if err != nil || got.SignCount != 7 || !got.BackupState || got.LastUsedAt == nil {
+			t.Errorf("used passkey: %+v, %v", got, err)
+		}
+		if err := s.DeletePasskey(ctx, primitive.NewObjectID(), passkeys[0].ID); err != ErrNotFound {
+			t.Errorf("delete another user's passkey: %v", err)
+		}
+		if err := s.DeletePasskey(ctx, user, passkeys[0].ID); err != nil {
+			t.Fatal(err)
+		}
+		if passkeys, _ := s.ListPasskeys(ctx, user); len(passkeys) != 1 || passkeys[0].Name != "phone" {
+			t.Errorf("after delete: %+v", passkeys)
+		}
+	})
+}
+
+func TestStoreIdentities(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		user := storeTestUser(t, s)
+		subject := uniqueWord()
+		identity := &Identity{UserID: user, Provider: "test", Subject: subject, Email: "a@example.com", CreatedAt: storeTestTime(0)}
+		if err := s.CreateIdentity(ctx, identity); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.CreateIdentity(ctx, &Identity{UserID: storeTestUser(t, s), Provider: "test", Subject: subject, CreatedAt: storeTestTime(1)}); err != ErrDuplicateIdentity {
+			t.Errorf("duplicate identity: %v", err)
+		}
+		if err := s.UseIdentity(ctx, identity.ID, "b@example.com", storeTestTime(2)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.GetIdentity(ctx, "test", subject)
+		if err != nil || got.Email != "b@example.com" || got.LastUsedAt == nil {
+			t.Errorf("get identity: %+v, %v", got, err)
+		}
+		if _, err := s.GetIdentity(ctx, "other", subject); err != ErrNotFound {
+			t.Errorf("identity of another provider: %v", err)
+		}
+		if err := s.DeleteIdentity(ctx, primitive.NewObjectID(), identity.ID); err != ErrNotFound {
+			t.Errorf("delete another user's identity: %v", err)
+		}
+		if err := s.DeleteIdentity(ctx, user, identity.ID); err != nil {
+			t.Fatal(err)
+		}
+		if identities, _ := s.ListIdentities(ctx, user); len(identities) != 0 {
+			t.Errorf("after delete: %+v", identities)
+		}
+	})
+}
+
+func TestStoreAPITokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		ctx := context.Background()
+		user := storeTestUser(t, s)
+		var hashes []string
+		for i, name := range []string{"old", "new"} {
+			hash := uniqueWord()
+			hashes = append(hashes, hash)
+			token := &APIToken{UserID: user, Name: name, Scopes: []string{scopeTodosRead}, TokenHash: hash, Hint: "tdp_abcd",
+				CreatedAt: storeTestTime(i), ExpiresAt: time.Now().Add(time.Hour)}
+			if err := s.CreateAPIToken(ctx, token); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tokens, err := s.ListAPITokens(ctx, user)
+		if err != nil || len(tokens) != 2 || tokens[0].Name != "new" {
+			t.Fatalf("list: %+v, %v", tokens, err)
+		}
+		if err := s.UseAPIToken(ctx, tokens[1].ID, storeTestTime(5)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.GetAPITokenByHash(ctx, hashes[0])
+		if err != nil || got.LastUsedAt == nil || len(got.Scopes) != 1 {
+			t.Errorf("by hash: %+v, %v", got, err)
+		}
+		if err := s.DeleteAPIToken(ctx, primitive.NewObjectID(), got.ID); err != ErrNotFound {
+			t.Errorf("delete another user's token: %v", err)
+		}
+		if err := s.DeleteAPIToken(ctx, user, got.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.GetAPITokenByHash(ctx, hashes[0]); err != ErrNotFound {
+			t.Errorf("deleted token: %v", err)
+		}
+
+		other := &APIToken{UserID: storeTestUser(t, s), Name: "other", Scopes: []string{scopeTodosRead}, TokenHash: uniqueWord(),
+			Hint: "tdp_efgh", CreatedAt: storeTestTime(2), ExpiresAt: time.Now().Add(time.Hour)}
+		if err := s.CreateAPIToken(ctx, other); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DeleteUserAPITokens(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+		if tokens, err := s.ListAPITokens(ctx, user); err != nil || len(tokens) != 0 {
+			t.Errorf("after deleting the user's tokens: %+v, %v", tokens, err)
+		}
+		if _, err := s.GetAPITokenByHash(ctx, other.TokenHash); err != nil {
+			t.Errorf("another user's token: %v", err)
+		}
+	})
+}
+
+func TestBoltStoreCountsOldUsersAsVerified(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "todos.db")
+	s, err := newBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A user as stored before email verification existed
+	oldID := primitive.NewObjectID()
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltUsersByEmailBucket).Put([]byte("old@example.com"), oldID[:]); err != nil {
+			return err
+		}
+		return boltPut(tx, boltUsersBucket, oldID, bson.M{"_id": oldID, "name": "Old", "email": "old@example.com", "createdAt": storeTestTime(1)})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unverified := &User{Name: "New", Email: "new@example.com", CreatedAt: storeTestTime(2)}
+	if err := s.CreateUser(ctx, unverified); err != nil {
+		t.Fatal(err)
+	}
+	s.Close(ctx)
+
+	if s, err = newBoltStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close(ctx)
+	old, err := s.GetUserByID(ctx, oldID)
+	if err != nil || old.EmailVerifiedAt == nil || !old.EmailVerifiedAt.Equal(storeTestTime(1)) {
+		t.Errorf("old user: %+v, %v", old, err)
+	}
+	if user, _ := s.GetUserByID(ctx, unverified.ID); user.EmailVerifiedAt != nil {
+		t.Errorf("new user became verified: %v", user.EmailVerifiedAt)
+	}
+}
@@ -1,68 +1,154 @@
 package main
 
 import (
-	"log"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func getTodos(c *fiber.Ctx) error {
-	var todos []Todo
-	filter := bson.M{}
-	search := c.Query("search")
-	status := c.Query("status")
-	priority := c.Query("priority")
-	// If a valid token is provided, show user's todos plus ownerless ones (created before auth)
-	if auth := c.Get("Authorization"); auth != "" {
-		parts := strings.SplitN(auth, " ", 2)
-		if len(parts) == 2 && strings.EqualFold(parts[0], "Bearer") {
-			if claims, err := parseToken(parts[1]); err == nil {
-				if oid, err := primitive.ObjectIDFromHex(claims.UserID); err == nil {
-					filter["$or"] = bson.A{
-						bson.M{"ownerId": oid},
-						bson.M{"ownerId": bson.M{"$exists": false}},
-						bson.M{"ownerId": nil},
-					}
-				}
-			}
+	var viewer *primitive.ObjectID
+	// Signed-in users see their todos plus ownerless ones (created before
+	// auth); anonymous callers only see the ownerless ones
+	if oid, ok := sessionUserID(c); ok {
+		viewer = &oid
+	}
+	if viewer != nil {
+		blocked, err := unverifiedBlocked(c.Context(), *viewer, false)
+		if err != nil && err != ErrNotFound {
+			return err
 		}
+		if blocked {
+			return emailUnverifiedError(c)
+		}
+	}
+	var q TodoQuery
+	if !readableTodos(&q.TodoFilter, viewer) {
+		return c.Status(401).JSON(fiber.Map{"error": "Authentication required"})
+	}
+	if res := parseTodoFilter(c, &q.TodoFilter, viewer); res != nil {
+		return c.Status(400).JSON(res)
+	}
+	if msg := parsePage(c, &q, ""); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	return listTodos(c, q)
+}
+
+// parseTodoFilter reads the search, status, priority and q query parameters
+// into f. It returns the error response to send when q is invalid.
+func parseTodoFilter(c *fiber.Ctx, f *TodoFilter, viewer *primitive.ObjectID) fiber.Map {
+	f.Search = parseSearchQuery(c.Query("search"))
+	switch c.Query("status") {
+	case "active":
+		f.Completed = new(bool)
+	case "completed":
+		completed := true
+		f.Completed = &completed
 	}
-	if search != "" {
-		filter["body"] = bson.M{"$regex": search, "$options": "i"}
+	if p := c.Query("priority"); p != "" {
+		f.Priorities = []string{p}
 	}
-	if status == "active" {
-		filter["completed"] = false
-	} else if status == "completed" {
-		filter["completed"] = true
+	if s := c.Query("q"); s != "" {
+		expr, err := parseTodoExpr(s, time.Now().UTC())
+		if err == nil {
+			err = expr.apply(f, viewer)
+		}
+		if qerr, ok := err.(*QueryError); ok {
+			return fiber.Map{"error": qerr.Message, "token": qerr.Token, "position": qerr.Pos}
+		}
 	}
-	if priority != "" {
-		filter["priority"] = priority
+	return nil
+}
+
+// listTodos runs q and responds with the page.
+func listTodos(c *fiber.Ctx, q TodoQuery) error {
+	var page *TodoPage
+	var err error
+	if q.Sort == relevanceSort {
+		page, err = listTodosByRelevance(c.Context(), q)
+	} else {
+		page, err = store.ListTodos(c.Context(), q)
 	}
-	findOpts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
-	cursor, err := collection.Find(c.Context(), filter, findOpts)
 	if err != nil {
 		return err
 	}
-	defer cursor.Close(c.Context())
-	for cursor.Next(c.Context()) {
-		var todo Todo
-		if err := cursor.Decode(&todo); err != nil {
-			return err
+	res := pageResponse(page, q.Sort)
+	if q.Search != nil {
+		res["items"] = searchResults(page.Items, q.Search, q.ViewerID)
+	} else {
+		items := make([]todoJSON, len(page.Items))
+		for i := range page.Items {
+			items[i] = newTodoJSON(&page.Items[i], q.ViewerID)
+		}
+		res["items"] = items
+	}
+	return c.JSON(res)
+}
+
+// todoJSON is a todo as the API returns it. Rather than the ids of everyone
+// who starred it, it says whether the viewer did and how many users did.
+type todoJSON struct {
+	Todo
+	StarredByMe bool `json:"starredByMe"`
+	StarCount   int  `json:"starCount"`
+}
+
+func newTodoJSON(todo *Todo, viewer *primitive.ObjectID) todoJSON {
+	res := todoJSON{Todo: *todo, StarCount: len(todo.StarredBy)}
+	if viewer != nil {
+		for _, id := range todo.StarredBy {
+			if id == *viewer {
+				res.StarredByMe = true
+				break
+			}
+		}
+	}
+	return res
+}
+
+// getWishlist lists the todos the caller starred. It takes the same filter,
+// sort and pagination parameters as getTodos.
+func getWishlist(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	var q TodoQuery
+	readableTodos(&q.TodoFilter, &userID)
+	if res := parseTodoFilter(c, &q.TodoFilter, &userID); res != nil {
+		return c.Status(400).JSON(res)
+	}
+	q.StarredBy = &userID
+	if msg := parsePage(c, &q, ""); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	return listTodos(c, q)
+}
+
+// normalizeTags trims tags and drops empty and repeated ones.
+func normalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		key := strings.ToLower(tag)
+		if tag == "" || seen[key] {
+			continue
 		}
-		todos = append(todos, todo)
+		seen[key] = true
+		out = append(out, tag)
 	}
-	return c.JSON(todos)
+	return out
 }
 
 func createTodo(c *fiber.Ctx) error {
 	var payload struct {
 		Body     string     `json:"body"`
+		Tags     []string   `json:"tags"`
+		Notes    string     `json:"notes"`
 	Starred  bool       `json:"starred"`
 		Priority string     `json:"priority"`
 		DueDate  *time.Time `json:"dueDate"`
@@ -82,6 +168,8 @@ func createTodo(c *fiber.Ctx) error {
 	}
 	todo := &Todo{
 		Body:      payload.Body,
+		Tags:      normalizeTags(payload.Tags),
+		Notes:     payload.Notes,
 		Completed: false,
 	Starred:   payload.Starred,
 	StarredBy: []primitive.ObjectID{},
@@ -91,156 +179,94 @@ func createTodo(c *fiber.Ctx) error {
 		UpdatedAt: now,
 		OwnerID:   ownerID,
 	}
-	res, err := collection.InsertOne(c.Context(), todo)
-	if err != nil {
+	if err := store.CreateTodo(c.Context(), todo); err != nil {
 		return err
 	}
-	todo.ID = res.InsertedID.(primitive.ObjectID)
-	return c.Status(201).JSON(todo)
+	return c.Status(201).JSON(newTodoJSON(todo, ownerID))
 }
 
 func updateTodo(c *fiber.Ctx) error {
-	id := c.Params("id")
-	objectID, err := primitive.ObjectIDFromHex(id)
+	existing, _, err := authorizeTodo(c, todoUpdate)
 	if err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid todo ID"})
+		return todoAccessError(c, err)
 	}
 	var payload struct {
 		Body      *string     `json:"body"`
+		Tags      *[]string   `json:"tags"`
+		Notes     *string     `json:"notes"`
 		Completed *bool       `json:"completed"`
 	Starred   *bool       `json:"starred"`
 		Priority  *string     `json:"priority"`
 		DueDate   **time.Time `json:"dueDate"`
 	}
 	_ = c.BodyParser(&payload)
-	toSet := bson.M{"updatedAt": time.Now().UTC()}
-	if payload.Body != nil {
-		toSet["body"] = *payload.Body
-	}
-	if payload.Starred != nil {
-	// For starred flag updates via generic PATCH we will set the boolean
-	// and leave per-user starredBy handling to the dedicated endpoint
-	toSet["starred"] = *payload.Starred
-	}
-	if payload.Priority != nil {
-		toSet["priority"] = *payload.Priority
+	now := time.Now().UTC()
+	update := TodoUpdate{
+		Body:     payload.Body,
+		Notes:    payload.Notes,
+		Priority: payload.Priority,
+		// For starred flag updates via generic PATCH we will set the boolean
+		// and leave per-user starredBy handling to the dedicated endpoint
+		Starred:   payload.Starred,
+		UpdatedAt: now,
 	}
 	if payload.DueDate != nil {
-		if *payload.DueDate == nil {
-			toSet["dueDate"] = nil
-		} else {
+		if *payload.DueDate != nil {
 			// validate not in the past
 			candidate := (**payload.DueDate).Truncate(24 * time.Hour)
 			today := time.Now().UTC().Truncate(24 * time.Hour)
 			if candidate.Before(today) {
 				return c.Status(400).JSON(fiber.Map{"error": "Due date cannot be in the past"})
 			}
-			toSet["dueDate"] = **payload.DueDate
 		}
+		update.DueDate = payload.DueDate
+	}
+	if payload.Tags != nil {
+		tags := normalizeTags(*payload.Tags)
+		update.Tags = &tags
+	}
+	if payload.Completed == nil && payload.Body == nil && payload.Tags == nil && payload.Notes == nil &&
+		payload.Priority == nil && payload.DueDate == nil && payload.Starred == nil {
+		// An empty body toggles completion
+		newCompleted := !existing.Completed
+		payload.Completed = &newCompleted
 	}
 	if payload.Completed != nil {
-		toSet["completed"] = *payload.Completed
+		var completedAt *time.Time
 		if *payload.Completed {
-			t := time.Now().UTC()
-			toSet["completedAt"] = t
-		} else {
-			toSet["completedAt"] = nil
-		}
-	} else if payload.Body == nil && payload.Priority == nil && payload.DueDate == nil && payload.Completed == nil && payload.Starred == nil {
-		var existing Todo
-		if err := collection.FindOne(c.Context(), bson.M{"_id": objectID}).Decode(&existing); err != nil {
-			if err == mongo.ErrNoDocuments {
-				return c.Status(404).JSON(fiber.Map{"error": "Todo not found"})
-			}
-			return err
-		}
-		newCompleted := !existing.Completed
-		toSet["completed"] = newCompleted
-		if newCompleted {
-			t := time.Now().UTC()
-			toSet["completedAt"] = t
-		} else {
-			toSet["completedAt"] = nil
+			completedAt = &now
 		}
+		update.Completed = payload.Completed
+		update.CompletedAt = &completedAt
 	}
-	update := bson.M{"$set": toSet}
-	if _, err := collection.UpdateOne(c.Context(), bson.M{"_id": objectID}, update); err != nil {
-		return err
+	if err := store.UpdateTodo(c.Context(), existing.ID, update); err != nil {
+		return todoAccessError(c, err)
 	}
 	return c.Status(200).JSON(fiber.Map{"success": true})
 }
 
 func deleteTodos(c *fiber.Ctx) error {
-	id := c.Params("id")
-	objectID, err := primitive.ObjectIDFromHex(id)
+	existing, _, err := authorizeTodo(c, todoDelete)
 	if err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid todo ID"})
-	}
-	// Require auth (enforced by route middleware) and verify ownership if present
-	uid, _ := c.Locals("userId").(string)
-	var existing Todo
-	if err := collection.FindOne(c.Context(), bson.M{"_id": objectID}).Decode(&existing); err != nil {
-		if err == mongo.ErrNoDocuments {
-			return c.Status(404).JSON(fiber.Map{"error": "Todo not found"})
-		}
-		return err
+		return todoAccessError(c, err)
 	}
-	if existing.OwnerID != nil {
-		if uid == "" || existing.OwnerID.Hex() != uid {
-			// Provide a reason to help clients and debugging tools distinguish ownership rejections
-			return c.Status(403).JSON(fiber.Map{"error": "Forbidden", "reason": "ownership_mismatch", "message": "You are not the owner of this item"})
-		}
-	}
-	if _, err := collection.DeleteOne(c.Context(), bson.M{"_id": objectID}); err != nil {
-		return err
+	if err := store.DeleteTodo(c.Context(), existing.ID); err != nil {
+		return todoAccessError(c, err)
 	}
 	return c.Status(200).JSON(fiber.Map{"success": true})
 }
 
-// toggleStarred sets starred state; requires auth and ownership
+// toggleStarred adds the caller to or removes them from the todo's starredBy
+// list; anyone who can read the todo may star it
 func toggleStarred(c *fiber.Ctx) error {
-	id := c.Params("id")
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid todo ID"})
-	}
-	uid, _ := c.Locals("userId").(string)
-	if uid == "" {
-		return c.Status(401).JSON(fiber.Map{"error": "Authentication required"})
-	}
 	var payload struct{ Starred bool `json:"starred"` }
 	_ = c.BodyParser(&payload)
-	// Ensure the todo exists
-	var existing Todo
-	if err := collection.FindOne(c.Context(), bson.M{"_id": objectID}).Decode(&existing); err != nil {
-		if err == mongo.ErrNoDocuments {
-			return c.Status(404).JSON(fiber.Map{"error": "Todo not found"})
-		}
-		return err
-	}
-
-	// Per-user wishlist: add/remove current user id from starredBy array.
-	// We intentionally do NOT check ownership here -- any authenticated user
-	// may add/remove themselves to/from starredBy.
-	log.Printf("toggleStarred: user=%s todo=%s payload=%v owner=%v", uid, id, payload.Starred, existing.OwnerID)
-	oid, err := primitive.ObjectIDFromHex(uid)
+	existing, user, err := authorizeTodo(c, todoStar)
 	if err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
-	}
-
-	var update bson.M
-	if payload.Starred {
-		update = bson.M{"$addToSet": bson.M{"starredBy": oid}, "$set": bson.M{"starred": true, "updatedAt": time.Now().UTC()}}
-	} else {
-		update = bson.M{"$pull": bson.M{"starredBy": oid}, "$set": bson.M{"updatedAt": time.Now().UTC()}}
-	}
-
-	res, err := collection.UpdateOne(c.Context(), bson.M{"_id": objectID}, update)
-	if err != nil {
-		return err
+		return todoAccessError(c, err)
 	}
-	if res.MatchedCount == 0 {
-		return c.Status(404).JSON(fiber.Map{"error": "Todo not found"})
+	if err := store.SetTodoStarred(c.Context(), existing.ID, *user, payload.Starred, time.Now().UTC()); err != nil {
+		return todoAccessError(c, err)
 	}
 
 	return c.Status(200).JSON(fiber.Map{"success": true})
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// seedTodo stores a todo owned by owner, or an ownerless one when owner is
+// empty, created minutes after a fixed time.
+func seedTodo(t *testing.T, body, owner string, minutes int) *Todo {
+	t.Helper()
+	at := time.Date(2025, 1, 1, 12, minutes, 0, 0, time.UTC)
+	todo := &Todo{Body: body, CreatedAt: at, UpdatedAt: at}
+	if owner != "" {
+		id, err := primitive.ObjectIDFromHex(owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		todo.OwnerID = &id
+	}
+	if err := store.CreateTodo(context.Background(), todo); err != nil {
+		t.Fatal(err)
+	}
+	return todo
+}
+
+func bodies(t *testing.T, res map[string]interface{}) []string {
+	t.Helper()
+	var out []string
+	for _, item := range items(t, res) {
+		out = append(out, item["body"].(string))
+	}
+	return out
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGetTodosNeedsSignIn(t *testing.T) {
+	app, _ := newTestApp(t)
+	seedTodo(t, "shared", "", 1)
+
+	if status, res := request(t, app, "GET", "/api/todos", "", nil); status != 401 {
+		t.Errorf("status %d, want 401: %v", status, res)
+	}
+}
+
+func TestGetTodosSignedInSeesOwnAndOwnerlessTodos(t *testing.T) {
+	app, _ := newTestApp(t)
+	token, alice := register(t, app, "Alice", "alice@example.com")
+	_, bob := register(t, app, "Bob", "bob@example.com")
+	seedTodo(t, "shared", "", 1)
+	seedTodo(t, "alice's", alice["_id"].(string), 2)
+	seedTodo(t, "bob's", bob["_id"].(string), 3)
+
+	status, res := request(t, app, "GET", "/api/todos", token, nil)
+	if status != 200 {
+		t.Fatalf("status %d: %v", status, res)
+	}
+	if got := bodies(t, res); !equalStrings(got, []string{"alice's", "shared"}) {
+		t.Errorf("got %v, want alice's and the shared todo, newest first", got)
+	}
+	if res["total"] != float64(2) {
+		t.Errorf("total = %v, want 2", res["total"])
+	}
+}
+
+func TestOwnerlessTodosAreReadOnly(t *testing.T) {
+	app, _ := newTestApp(t)
+	token, _ := register(t, app, "Alice", "alice@example.com")
+	shared := seedTodo(t, "shared", "", 1)
+	id := shared.ID.Hex()
+
+	for _, c := range []struct {
+		method, path string
+		body         interface{}
+		want         int
+	}{
+		{"PATCH", "/api/todos/" + id, fiber.Map{"body": "mine"}, 403},
+		{"PATCH", "/api/todos/" + id, nil, 403},
+		{"DELETE", "/api/todos/" + id, nil, 403},
+		{"PATCH", "/api/todos/" + id + "/star", fiber.Map{"starred": true}, 200},
+	} {
+		if status, res := request(t, app, c.method, c.path, token, c.body); status != c.want {
+			t.Errorf("%s %s %v: status %d, want %d: %v", c.method, c.path, c.body, status, c.want, res)
+		}
+	}
+	if todo, err := store.GetTodo(context.Background(), shared.ID); err != nil || todo.Body != "shared" || todo.Completed {
+		t.Errorf("ownerless todo changed: %+v, %v", todo, err)
+	}
+
+	legacyTodosMode = legacyStrict
+	t.Cleanup(func() { legacyTodosMode = legacyShared })
+	status, res := request(t, app, "GET", "/api/todos", token, nil)
+	if got := bodies(t, res); status != 200 || len(got) != 0 {
+		t.Errorf("strict listing: %d %v", status, got)
+	}
+	if status, res := request(t, app, "PATCH", "/api/todos/"+id+"/star", token, fiber.Map{"starred": false}); status != 403 {
+		t.Errorf("star in strict mode: status %d, want 403: %v", status, res)
+	}
+}
+
+func TestGetTodosPages(t *testing.T) {
+	app, _ := newTestApp(t)
+	token, _ := register(t, app, "Alice", "alice@example.com")
+	for i, body := range []string{"one", "two", "three"} {
+		seedTodo(t, body, "", i)
+	}
+
+	var got []string
+	path := "/api/todos?sort=createdAt&limit=2"
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatal("too many pages")
+		}
+		status, res := request(t, app, "GET", path, token, nil)
+		if status != 200 {
+			t.Fatalf("status %d: %v", status, res)
+		}
+		got = append(got, bodies(t, res)...)
+		next, _ := res["nextCursor"].(string)
+		if next == "" {
+			break
+		}
+		path = "/api/todos?sort=createdAt&limit=2&cursor=" + next
+	}
+	if !equalStrings(got, []string{"one", "two", "three"}) {
+		t.Errorf("got %v, want every todo oldest first", got)
+	}
+}
+
+func TestGetTodosRejectsBadParameters(t *testing.T) {
+	app, _ := newTestApp(t)
+	token, _ := register(t, app, "Alice", "alice@example.com")
+	for _, path := range []string{"/api/todos?sort=body", "/api/todos?limit=0", "/api/todos?cursor=nonsense"} {
+		if status, res := request(t, app, "GET", path, token, nil); status != 400 {
+			t.Errorf("%s: status %d, want 400: %v", path, status, res)
+		}
+	}
+}
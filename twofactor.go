@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A login of a user with 2FA returns a challenge token instead of tokens;
+// /api/auth/2fa/verify trades it and a code for the session.
+const (
+	purposeLoginChallenge = "login_challenge"
+	loginChallengeTTL     = 5 * time.Minute
+)
+
+// startLoginChallenge answers a correct password of a user with 2FA.
+func startLoginChallenge(c *fiber.Ctx, user *User, now time.Time) error {
+	token, hash, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	err = store.CreateOneTimeToken(c.Context(), &OneTimeToken{
+		UserID:    user.ID,
+		Purpose:   purposeLoginChallenge,
+		Email:     user.Email,
+		TokenHash: hash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(loginChallengeTTL),
+	})
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{
+		"twoFactorRequired": true,
+		"challengeToken":    token,
+		"expiresIn":         int(loginChallengeTTL / time.Second),
+	})
+}
+
+// checkSecondFactor accepts a current TOTP code or an unused recovery code of
+// the user, using it up. Wrong codes count as failed logins, so guessing is
+// throttled like guessing passwords. It sends the error response itself and
+// returns false when the code is refused.
+func checkSecondFactor(c *fiber.Ctx, user *User, code string, now time.Time) (bool, error) {
+	keys := loginAttemptKeys(user.Email, c.IP())
+	wait, err := loginAttemptWait(c.Context(), keys, now)
+	if err != nil {
+		return false, err
+	}
+	if wait > 0 {
+		return false, tooManyLoginAttempts(c, wait)
+	}
+	err = ErrTokenUsed
+	if step, ok := matchTOTP(user.TOTPSecret, code, now); ok {
+		err = store.UseTOTPStep(c.Context(), user.ID, step)
+	} else if len(code) > totpDigits {
+		err = store.UseRecoveryCode(c.Context(), user.ID, hashRecoveryCode(code))
+	}
+	if err == ErrTokenUsed {
+		if err := recordLoginFailure(c, keys, user, now); err != nil {
+			return false, err
+		}
+		return false, c.Status(400).JSON(fiber.Map{"error": "Invalid code"})
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// verifyLoginHandler finishes a login that startLoginChallenge began.
+func verifyLoginHandler(c *fiber.Ctx) error {
+	var payload struct {
+		ChallengeToken string `json:"challengeToken"`
+		Code           string `json:"code"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	now := time.Now().UTC()
+	challenge, err := store.GetOneTimeToken(c.Context(), purposeLoginChallenge, hashSecretToken(payload.ChallengeToken))
+	if err == nil && (challenge.UsedAt != nil || !now.Before(challenge.ExpiresAt)) {
+		err = ErrTokenUsed
+	}
+	var user *User
+	if err == nil {
+		user, err = store.GetUserByID(c.Context(), challenge.UserID)
+	}
+	// The challenge is void if 2FA was turned off or the email changed since
+	if err == nil && (user.TOTPEnabledAt == nil || user.Email != challenge.Email) {
+		err = ErrTokenUsed
+	}
+	if err != nil {
+		if err == ErrNotFound || err == ErrTokenUsed {
+			return c.Status(401).JSON(fiber.Map{"error": "Invalid or expired login challenge"})
+		}
+		return err
+	}
+	if ok, err := checkSecondFactor(c, user, payload.Code, now); !ok {
+		return err
+	}
+	if err := store.UseOneTimeToken(c.Context(), challenge.ID, now); err != nil {
+		if err == ErrTokenUsed {
+			return c.Status(401).JSON(fiber.Map{"error": "Invalid or expired login challenge"})
+		}
+		return err
+	}
+	if err := store.ResetRateLimit(c.Context(), loginAttemptKeys(user.Email, c.IP())[0].key); err != nil {
+		return err
+	}
+	token, refreshToken, err := startSession(c, user.ID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{
+		"token":        token,
+		"refreshToken": refreshToken,
+		"user":         userJSON(user),
+	})
+}
+
+// setupTwoFactorHandler gives the user a new authenticator key. 2FA is only
+// turned on once enableTwoFactorHandler sees a code made with it.
+func setupTwoFactorHandler(c *fiber.Ctx) error {
+	var payload struct {
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	user, err := currentUser(c, payload.Password)
+	if user == nil {
+		return err
+	}
+	if user.TOTPEnabledAt != nil {
+		return c.Status(409).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return err
+	}
+	if _, err := store.UpdateUser(c.Context(), user.ID, UserUpdate{TOTPSecret: &secret, UpdatedAt: time.Now().UTC()}); err != nil {
+		return err
+	}
+	uri := totpURI(secret, user.Email)
+	png, err := totpQRCode(uri)
+	if err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{
+		"secret":     secret,
+		"otpauthUrl": uri,
+		"qrCode":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// enableTwoFactorHandler turns 2FA on once the user proves their app has
+// the key, and returns the recovery codes. They are only shown this once.
+func enableTwoFactorHandler(c *fiber.Ctx) error {
+	var payload struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	user, err := store.GetUserByID(c.Context(), userID)
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		return err
+	}
+	if user.TOTPEnabledAt != nil {
+		return c.Status(409).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+	}
+	if user.TOTPSecret == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Start two-factor setup first"})
+	}
+	now := time.Now().UTC()
+	step, ok := matchTOTP(user.TOTPSecret, payload.Code, now)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid code"})
+	}
+	if err := store.UseTOTPStep(c.Context(), user.ID, step); err != nil {
+		if err == ErrTokenUsed {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid code"})
+		}
+		return err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return err
+	}
+	enabledAt := &now
+	updated, err := store.UpdateUser(c.Context(), user.ID, UserUpdate{TOTPEnabledAt: &enabledAt, RecoveryCodes: &hashes, UpdatedAt: now})
+	if err != nil {
+		return err
+	}
+	notifyTwoFactorChange(c.Context(), c.IP(), updated, "turned on", now)
+	return c.JSON(fiber.Map{"recoveryCodes": codes, "user": userJSON(updated)})
+}
+
+// disableTwoFactorHandler turns 2FA off. It takes the password and a code,
+// so that neither a stolen session nor a stolen password is enough.
+func disableTwoFactorHandler(c *fiber.Ctx) error {
+	var payload struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	user, err := currentUser(c, payload.Password)
+	if user == nil {
+		return err
+	}
+	if user.TOTPEnabledAt == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Two-factor authentication is not enabled"})
+	}
+	now := time.Now().UTC()
+	if ok, err := checkSecondFactor(c, user, payload.Code, now); !ok {
+		return err
+	}
+	var (
+		noSecret   string
+		notEnabled *time.Time
+		noRecovery = []string{}
+	)
+	updated, err := store.UpdateUser(c.Context(), user.ID, UserUpdate{
+		TOTPSecret: &noSecret, TOTPEnabledAt: &notEnabled, RecoveryCodes: &noRecovery, UpdatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+	// Challenges handed out before now must not complete
+	if err := store.UseUserOneTimeTokens(c.Context(), user.ID, purposeLoginChallenge, now); err != nil {
+		return err
+	}
+	notifyTwoFactorChange(c.Context(), c.IP(), updated, "turned off", now)
+	return c.JSON(userJSON(updated))
+}
+
+// recoveryCodesHandler replaces the user's recovery codes with new ones.
+func recoveryCodesHandler(c *fiber.Ctx) error {
+	var payload struct {
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	user, err := currentUser(c, payload.Password)
+	if user == nil {
+		return err
+	}
+	if user.TOTPEnabledAt == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Two-factor authentication is not enabled"})
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return err
+	}
+	if _, err := store.UpdateUser(c.Context(), user.ID, UserUpdate{RecoveryCodes: &hashes, UpdatedAt: time.Now().UTC()}); err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"recoveryCodes": codes})
+}
+
+// notifyTwoFactorChange audits 2FA being turned on or off and tells the
+// user by mail.
+func notifyTwoFactorChange(ctx context.Context, ip string, user *User, change string, now time.Time) {
+	action := "2fa_enabled"
+	if user.TOTPEnabledAt == nil {
+		action = "2fa_disabled"
+	}
+	recordAudit(ctx, AuditEntry{Action: action, UserID: &user.ID, Subject: user.Email, IP: ip, CreatedAt: now})
+	sendMailAsync(securityNotice(user, "Two-factor authentication was "+change,
+		"Two-factor authentication was just "+change+" for your account.", ""))
+}
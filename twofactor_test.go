@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// enableTwoFactor turns 2FA on for the user of token and returns the
+// recovery codes.
+func enableTwoFactor(t *testing.T, app *fiber.App, token string) []interface{} {
+	t.Helper()
+	status, res := request(t, app, "POST", "/api/auth/2fa/setup", token, fiber.Map{"password": "secret1"})
+	if status != 200 {
+		t.Fatalf("2fa setup: %d %v", status, res)
+	}
+	key, err := totpEncoding.DecodeString(res["secret"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+	status, res = request(t, app, "POST", "/api/auth/2fa/enable", token, fiber.Map{"code": code})
+	if status != 200 {
+		t.Fatalf("2fa enable: %d %v", status, res)
+	}
+	return res["recoveryCodes"].([]interface{})
+}
+
+// The SHA1 vectors of RFC 6238, appendix B, cut to six digits.
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	for _, v := range rfc6238Vectors {
+		for _, skew := range []int64{-totpPeriod, 0, totpPeriod} {
+			now := time.Unix(v.unix+skew, 0)
+			if step, ok := matchTOTP(secret, v.code, now); !ok || step != v.unix/totpPeriod {
+				t.Errorf("%s at %d%+d: step %d, %v; want step %d", v.code, v.unix, skew, step, ok, v.unix/totpPeriod)
+			}
+		}
+		for _, skew := range []int64{-2 * totpPeriod, 2 * totpPeriod} {
+			if v.unix+skew < 0 {
+				continue
+			}
+			if _, ok := matchTOTP(secret, v.code, time.Unix(v.unix+skew, 0)); ok {
+				t.Errorf("%s at %d%+d: matched outside the allowed skew", v.code, v.unix, skew)
+			}
+		}
+	}
+	now := time.Unix(59, 0)
+	for _, c := range []struct{ secret, code string }{
+		{secret, "94287082"},
+		{secret, "28708"},
+		{"not base32!", "287082"},
+	} {
+		if _, ok := matchTOTP(c.secret, c.code, now); ok {
+			t.Errorf("%q with secret %q matched", c.code, c.secret)
+		}
+	}
+}
+
+// startTwoFactorLogin logs in with the password and returns the challenge
+// token.
+func startTwoFactorLogin(t *testing.T, app *fiber.App, email string) string {
+	t.Helper()
+	status, res := login(t, app, email, "secret1")
+	if status != 200 || res["twoFactorRequired"] != true || res["challengeToken"] == nil {
+		t.Fatalf("login: %d %v", status, res)
+	}
+	if res["token"] != nil || res["refreshToken"] != nil {
+		t.Errorf("login handed out tokens before the second factor: %v", res)
+	}
+	return res["challengeToken"].(string)
+}
+
+func TestLoginWithTwoFactor(t *testing.T) {
+	app, _ := newTestApp(t)
+	token, _ := register(t, app, "Alice", "alice@example.com")
+	recoveryCodes := enableTwoFactor(t, app, token)
+	user, err := store.GetUserByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(user.TOTPSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// enableTwoFactor used up the current step
+	code := totpCode(key, time.Now().Unix()/totpPeriod+1)
+	verify := func(challenge, code string) (int, map[string]interface{}) {
+		return request(t, app, "POST", "/api/auth/2fa/verify", "", fiber.Map{"challengeToken": challenge, "code": code})
+	}
+
+	challenge := startTwoFactorLogin(t, app, "alice@example.com")
+	if status, res := verify(challenge, "000000"); status != 400 {
+		t.Errorf("wrong code: %d %v", status, res)
+	}
+	status, res := verify(challenge, code)
+	if status != 200 || res["token"] == nil || res["refreshToken"] == nil {
+		t.Fatalf("right code: %d %v", status, res)
+	}
+	if status, res := verify(challenge, code); status != 401 {
+		t.Errorf("challenge used twice: %d %v", status, res)
+	}
+
+	// A code works once, even with a fresh challenge
+	challenge = startTwoFactorLogin(t, app, "alice@example.com")
+	if status, res := verify(challenge, code); status != 400 {
+		t.Errorf("code used twice: %d %v", status, res)
+	}
+
+	// So does a recovery code, typed however the user likes
+	recovery := recoveryCodes[0].(string)
+	if status, res := verify(challenge, " "+strings.ToUpper(recovery)+" "); status != 200 {
+		t.Errorf("recovery code: %d %v", status, res)
+	}
+	challenge = startTwoFactorLogin(t, app, "alice@example.com")
+	if status, res := verify(challenge, recovery); status != 400 {
+		t.Errorf("recovery code used twice: %d %v", status, res)
+	}
+	if status, res := verify(challenge, recoveryCodes[1].(string)); status != 200 {
+		t.Errorf("another recovery code: %d %v", status, res)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const purposeEmailVerification = "email_verification"
+
+// Policies for users who have not verified their email yet.
+const (
+	unverifiedAllow    = "allow"
+	unverifiedReadOnly = "read-only"
+	unverifiedBlock    = "block"
+)
+
+var unverifiedUsersPolicy = unverifiedAllow
+
+// Verification emails may be sent once a minute and five times a day.
+const (
+	verificationResendInterval = time.Minute
+	verificationDailyLimit     = 5
+)
+
+func emailVerificationTTL() time.Duration {
+	return envDuration("EMAIL_VERIFICATION_EXPIRES_IN", 24*time.Hour)
+}
+
+// sendVerificationEmail mails user a link that verifies their current email.
+func sendVerificationEmail(ctx context.Context, user *User) error {
+	token, hash, err := newSecretToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	ttl := emailVerificationTTL()
+	err = store.CreateOneTimeToken(ctx, &OneTimeToken{
+		UserID:    user.ID,
+		Purpose:   purposeEmailVerification,
+		Email:     user.Email,
+		TokenHash: hash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+	link := appURL("/verify-email?token=" + url.QueryEscape(token))
+	sendMailAsync(Mail{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm that this is your email address by opening\n\n%s\n\n"+
+			"The link expires in %d hours. If you did not create an account, you can ignore this email.\n",
+			user.Name, link, int(ttl.Hours())),
+	})
+	return nil
+}
+
+// verificationResendWait returns how long the user has to wait before another
+// verification email may be sent.
+func verificationResendWait(ctx context.Context, userID primitive.ObjectID, now time.Time) (time.Duration, error) {
+	recent, err := store.RecentOneTimeTokens(ctx, userID, purposeEmailVerification, now.Add(-24*time.Hour))
+	if err != nil {
+		return 0, err
+	}
+	if len(recent) >= verificationDailyLimit {
+		return recent[verificationDailyLimit-1].CreatedAt.Add(24 * time.Hour).Sub(now), nil
+	}
+	if len(recent) > 0 {
+		if wait := recent[0].CreatedAt.Add(verificationResendInterval).Sub(now); wait > 0 {
+			return wait, nil
+		}
+	}
+	return 0, nil
+}
+
+func resendVerificationHandler(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	user, err := store.GetUserByID(c.Context(), userID)
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Email already verified"})
+	}
+	wait, err := verificationResendWait(c.Context(), userID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		seconds := int((wait + time.Second - 1) / time.Second)
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+		return c.Status(429).JSON(fiber.Map{"error": "Too many verification emails", "retryAfter": seconds})
+	}
+	if err := sendVerificationEmail(c.Context(), user); err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"success": true})
+}
+
+// verifyEmailHandler marks the email a verification link was sent to as
+// verified, provided it is still the user's email.
+func verifyEmailHandler(c *fiber.Ctx) error {
+	var payload struct {
+		Token string `json:"token"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	now := time.Now().UTC()
+	vt, err := store.GetOneTimeToken(c.Context(), purposeEmailVerification, hashSecretToken(payload.Token))
+	if err == nil && (vt.UsedAt != nil || !now.Before(vt.ExpiresAt)) {
+		err = ErrTokenUsed
+	}
+	var user *User
+	if err == nil {
+		user, err = store.GetUserByID(c.Context(), vt.UserID)
+	}
+	if err == nil && user.Email != vt.Email {
+		err = ErrTokenUsed
+	}
+	if err == nil {
+		err = store.UseOneTimeToken(c.Context(), vt.ID, now)
+	}
+	if err != nil {
+		if err == ErrNotFound || err == ErrTokenUsed {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid or expired verification link"})
+		}
+		return err
+	}
+	if user.EmailVerifiedAt == nil {
+		verifiedAt := &now
+		user, err = store.UpdateUser(c.Context(), user.ID, UserUpdate{EmailVerifiedAt: &verifiedAt, UpdatedAt: now})
+		if err != nil {
+			return err
+		}
+	}
+	if err := store.UseUserOneTimeTokens(c.Context(), user.ID, purposeEmailVerification, now); err != nil {
+		return err
+	}
+	return c.JSON(fiber.Map{"success": true, "user": userJSON(user)})
+}
+
+// unverifiedBlocked reports whether unverifiedUsersPolicy keeps the user from
+// reading (write false) or changing (write true) data.
+func unverifiedBlocked(ctx context.Context, userID primitive.ObjectID, write bool) (bool, error) {
+	switch {
+	case unverifiedUsersPolicy == unverifiedAllow:
+		return false, nil
+	case unverifiedUsersPolicy == unverifiedReadOnly && !write:
+		return false, nil
+	}
+	user, err := store.GetUserByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return user.EmailVerifiedAt == nil, nil
+}
+
+func emailUnverifiedError(c *fiber.Ctx) error {
+	return c.Status(403).JSON(fiber.Map{"error": "Email not verified", "reason": "email_unverified",
+		"message": "Verify your email address to continue"})
+}
+
+// requireVerifiedEmail applies unverifiedUsersPolicy to routes that read or
+// write data. It runs after authMiddleware.
+func requireVerifiedEmail(write bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, ok := sessionUserID(c)
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+		}
+		blocked, err := unverifiedBlocked(c.Context(), userID, write)
+		if err != nil {
+			if err == ErrNotFound {
+				return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+			}
+			return err
+		}
+		if blocked {
+			return emailUnverifiedError(c)
+		}
+		return c.Next()
+	}
+}
+
+// startVerification is called after registration; failing to send the email
+// must not fail the registration, since the user can ask for another.
+func startVerification(ctx context.Context, user *User) {
+	if err := sendVerificationEmail(ctx, user); err != nil {
+		log.Printf("Error creating verification email for %s: %v", user.Email, err)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var verifyLinkRegex = regexp.MustCompile(`/verify-email\?token=(\S+)`)
+
+// verifyEmail opens the verification link mailed to email.
+func verifyEmail(t *testing.T, app *fiber.App, mail *testMailer, email string) {
+	t.Helper()
+	m := verifyLinkRegex.FindStringSubmatch(mail.waitFor(t, email, "Verify").Body)
+	if m == nil {
+		t.Fatal("no verification link")
+	}
+	status, res := request(t, app, "POST", "/api/auth/verify-email", "", fiber.Map{"token": m[1]})
+	if status != 200 || res["user"].(map[string]interface{})["emailVerified"] != true {
+		t.Fatalf("verify: %d %v", status, res)
+	}
+}
+
+func TestResendVerificationIsLimited(t *testing.T) {
+	app, mail := newTestApp(t)
+	// Registration sent the first email just now
+	token, _ := register(t, app, "Alice", "alice@example.com")
+	status, res := request(t, app, "POST", "/api/auth/resend-verification", token, nil)
+	if retry, _ := res["retryAfter"].(float64); status != 429 || retry < 1 || retry > 60 {
+		t.Errorf("within a minute: %d %v", status, res)
+	}
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	bob := newUser("Bob", "", "bob@example.com", "", now)
+	if err := store.CreateUser(ctx, bob); err != nil {
+		t.Fatal(err)
+	}
+	for hours := 5; hours > 1; hours-- {
+		_, hash, err := newSecretToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		at := now.Add(-time.Duration(hours) * time.Hour)
+		err = store.CreateOneTimeToken(ctx, &OneTimeToken{UserID: bob.ID, Purpose: purposeEmailVerification, Email: bob.Email,
+			TokenHash: hash, CreatedAt: at, ExpiresAt: at.Add(emailVerificationTTL())})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	bobToken, err := generateToken(bob.ID.Hex(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, res := request(t, app, "POST", "/api/auth/resend-verification", bobToken, nil); status != 200 {
+		t.Fatalf("fifth email of the day: %d %v", status, res)
+	}
+	mail.waitFor(t, "bob@example.com", "Verify")
+	// The oldest of the five leaves the day in 19 hours
+	status, res = request(t, app, "POST", "/api/auth/resend-verification", bobToken, nil)
+	if retry, _ := res["retryAfter"].(float64); status != 429 || retry < 18*3600 || retry > 19*3600 {
+		t.Errorf("sixth email of the day: %d %v", status, res)
+	}
+
+	verifyEmail(t, app, mail, "alice@example.com")
+	if status, res := request(t, app, "POST", "/api/auth/resend-verification", token, nil); status != 400 {
+		t.Errorf("already verified: %d %v", status, res)
+	}
+}
+
+func TestUnverifiedUsersPolicy(t *testing.T) {
+	t.Cleanup(func() { unverifiedUsersPolicy = unverifiedAllow })
+	for _, c := range []struct {
+		policy      string
+		read, write int
+	}{
+		{unverifiedAllow, 200, 201},
+		{unverifiedReadOnly, 200, 403},
+		{unverifiedBlock, 403, 403},
+	} {
+		unverifiedUsersPolicy = c.policy
+		app, mail := newTestApp(t)
+		unverified, _ := register(t, app, "Alice", "alice@example.com")
+		verified, _ := register(t, app, "Bob", "bob@example.com")
+		verifyEmail(t, app, mail, "bob@example.com")
+
+		for _, path := range []string{"/api/todos", "/api/views"} {
+			status, res := request(t, app, "GET", path, unverified, nil)
+			if status != c.read || status == 403 && res["reason"] != "email_unverified" {
+				t.Errorf("%s: GET %s: %d %v, want %d", c.policy, path, status, res, c.read)
+			}
+			if status, res := request(t, app, "GET", path, verified, nil); status != 200 {
+				t.Errorf("%s: GET %s when verified: %d %v", c.policy, path, status, res)
+			}
+		}
+		status, res := request(t, app, "POST", "/api/todos", unverified, fiber.Map{"body": "milk"})
+		if status != c.write || status == 403 && res["reason"] != "email_unverified" {
+			t.Errorf("%s: create: %d %v, want %d", c.policy, status, res, c.write)
+		}
+		if status, res := request(t, app, "POST", "/api/todos", verified, fiber.Map{"body": "milk"}); status != 201 {
+			t.Errorf("%s: create when verified: %d %v", c.policy, status, res)
+		}
+	}
+}
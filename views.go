@@ -0,0 +1,318 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxViewNameLen = 100
+
+// builtinView is a smart view every user has. Its id is a name rather than
+// an ObjectID, and it cannot be changed or deleted.
+type builtinView struct {
+	ID      string     `json:"_id"`
+	Name    string     `json:"name"`
+	Filter  ViewFilter `json:"filter"`
+	Sort    string     `json:"sort,omitempty"`
+	Builtin bool       `json:"builtin"`
+}
+
+var builtinViews = []builtinView{
+	{ID: "today", Name: "Today", Filter: ViewFilter{Status: "active", DueAfter: "today", DueBefore: "tomorrow"}, Sort: "dueDate", Builtin: true},
+	{ID: "upcoming", Name: "Upcoming", Filter: ViewFilter{Status: "active", DueAfter: "tomorrow"}, Sort: "dueDate", Builtin: true},
+	{ID: "overdue", Name: "Overdue", Filter: ViewFilter{Status: "active", DueBefore: "today"}, Sort: "dueDate", Builtin: true},
+	{ID: "no-due-date", Name: "No due date", Filter: ViewFilter{Status: "active", NoDueDate: true}, Sort: "-createdAt", Builtin: true},
+}
+
+func findBuiltinView(id string) *builtinView {
+	for i := range builtinViews {
+		if builtinViews[i].ID == id {
+			return &builtinViews[i]
+		}
+	}
+	return nil
+}
+
+// viewFilterError reports an invalid field of a ViewFilter.
+type viewFilterError struct {
+	Field string
+	Err   error
+}
+
+func (e *viewFilterError) response() fiber.Map {
+	res := fiber.Map{"error": e.Err.Error(), "field": e.Field}
+	if qerr, ok := e.Err.(*QueryError); ok {
+		res["error"], res["token"], res["position"] = qerr.Message, qerr.Token, qerr.Pos
+	}
+	return res
+}
+
+// apply compiles f into tf the way the q parameter would, field by field so
+// errors can name the field at fault.
+func (f ViewFilter) apply(tf *TodoFilter, viewer *primitive.ObjectID, now time.Time) *viewFilterError {
+	tf.Search = parseSearchQuery(f.Search)
+	clauses := []struct {
+		field, expr string
+		set         bool
+	}{
+		{"status", "status:" + f.Status, f.Status != ""},
+		{"priority", "priority:" + f.Priority, f.Priority != ""},
+		{"dueAfter", "due>=" + f.DueAfter, f.DueAfter != ""},
+		{"dueBefore", "due<" + f.DueBefore, f.DueBefore != ""},
+		{"noDueDate", "due:none", f.NoDueDate},
+		{"starred", "starred", f.Starred},
+		{"q", f.Query, f.Query != ""},
+	}
+	for _, c := range clauses {
+		if !c.set {
+			continue
+		}
+		expr, err := parseTodoExpr(c.expr, now)
+		if err == nil && c.field != "q" && len(expr.Clauses) != 1 {
+			err = &QueryError{Message: "Must be a single value", Token: c.expr}
+		}
+		if err == nil {
+			err = expr.apply(tf, viewer)
+		}
+		if err != nil {
+			return &viewFilterError{Field: c.field, Err: err}
+		}
+	}
+	return nil
+}
+
+// viewPayload is the body of POST and PATCH /api/views.
+type viewPayload struct {
+	Name   *string     `json:"name"`
+	Filter *ViewFilter `json:"filter"`
+	Sort   *string     `json:"sort"`
+}
+
+// validate checks the fields of p that are set, normalizing them in place.
+// It returns the error response to send, or nil.
+func (p *viewPayload) validate(userID primitive.ObjectID) fiber.Map {
+	if p.Name != nil {
+		name := strings.TrimSpace(*p.Name)
+		if name == "" {
+			return fiber.Map{"error": "View name cannot be empty", "field": "name"}
+		}
+		if len(name) > maxViewNameLen {
+			return fiber.Map{"error": "View name is too long", "field": "name"}
+		}
+		p.Name = &name
+	}
+	var tf TodoFilter
+	if p.Filter != nil {
+		if ferr := p.Filter.apply(&tf, &userID, time.Now().UTC()); ferr != nil {
+			res := ferr.response()
+			res["field"] = "filter." + ferr.Field
+			return res
+		}
+	}
+	if p.Sort != nil && *p.Sort != "" {
+		sortBy, ok := parseTodoSort(*p.Sort)
+		if !ok {
+			return fiber.Map{"error": "Sort must be relevance or one of " + strings.Join(todoSortFields, ", ") + ", optionally prefixed with -", "field": "sort"}
+		}
+		if sortBy == relevanceSort && tf.Search == nil {
+			return fiber.Map{"error": "Sort by relevance requires a search", "field": "sort"}
+		}
+	}
+	return nil
+}
+
+// ownView loads the view named by the :id parameter. Views of other users
+// are reported as not found.
+func ownView(c *fiber.Ctx, userID primitive.ObjectID) (*View, error) {
+	id, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	view, err := store.GetView(c.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	if view.OwnerID != userID {
+		return nil, ErrNotFound
+	}
+	return view, nil
+}
+
+func listViewsHandler(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	views, err := store.ListViews(c.Context(), userID)
+	if err != nil {
+		return err
+	}
+	items := make([]interface{}, 0, len(builtinViews)+len(views))
+	for _, v := range builtinViews {
+		items = append(items, v)
+	}
+	for _, v := range views {
+		items = append(items, v)
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+func getViewHandler(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	if b := findBuiltinView(c.Params("id")); b != nil {
+		return c.JSON(b)
+	}
+	view, err := ownView(c, userID)
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "View not found"})
+		}
+		return err
+	}
+	return c.JSON(view)
+}
+
+func createViewHandler(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	var payload viewPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	if payload.Name == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "View name cannot be empty", "field": "name"})
+	}
+	if payload.Filter == nil {
+		payload.Filter = &ViewFilter{}
+	}
+	if payload.Sort == nil {
+		payload.Sort = new(string)
+	}
+	if res := payload.validate(userID); res != nil {
+		return c.Status(400).JSON(res)
+	}
+	now := time.Now().UTC()
+	view := &View{
+		OwnerID:   userID,
+		Name:      *payload.Name,
+		Filter:    *payload.Filter,
+		Sort:      *payload.Sort,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := store.CreateView(c.Context(), view); err != nil {
+		return err
+	}
+	return c.Status(201).JSON(view)
+}
+
+func updateViewHandler(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	if findBuiltinView(c.Params("id")) != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Built-in views cannot be changed"})
+	}
+	existing, err := ownView(c, userID)
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "View not found"})
+		}
+		return err
+	}
+	var payload viewPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid body"})
+	}
+	if payload.Name == nil && payload.Filter == nil && payload.Sort == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "No changes"})
+	}
+	// Sorting by relevance needs a search, so the filter and sort are
+	// checked together, taking whichever is not given from the view
+	if payload.Sort != nil && payload.Filter == nil {
+		payload.Filter = &existing.Filter
+	}
+	if payload.Filter != nil && payload.Sort == nil {
+		payload.Sort = &existing.Sort
+	}
+	if res := payload.validate(userID); res != nil {
+		return c.Status(400).JSON(res)
+	}
+	view, err := store.UpdateView(c.Context(), existing.ID, ViewUpdate{
+		Name:      payload.Name,
+		Filter:    payload.Filter,
+		Sort:      payload.Sort,
+		UpdatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "View not found"})
+		}
+		return err
+	}
+	return c.JSON(view)
+}
+
+func deleteViewHandler(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	if findBuiltinView(c.Params("id")) != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Built-in views cannot be deleted"})
+	}
+	view, err := ownView(c, userID)
+	if err == nil {
+		err = store.DeleteView(c.Context(), view.ID)
+	}
+	if err != nil {
+		if err == ErrNotFound {
+			return c.Status(404).JSON(fiber.Map{"error": "View not found"})
+		}
+		return err
+	}
+	return c.Status(200).JSON(fiber.Map{"success": true})
+}
+
+// viewTodosHandler lists the todos a view selects, evaluated now, with the
+// same pagination as GET /api/todos. The view's sort applies unless the
+// request gives one.
+func viewTodosHandler(c *fiber.Ctx) error {
+	userID, ok := sessionUserID(c)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	var filter ViewFilter
+	var sortBy string
+	if b := findBuiltinView(c.Params("id")); b != nil {
+		filter, sortBy = b.Filter, b.Sort
+	} else {
+		view, err := ownView(c, userID)
+		if err != nil {
+			if err == ErrNotFound {
+				return c.Status(404).JSON(fiber.Map{"error": "View not found"})
+			}
+			return err
+		}
+		filter, sortBy = view.Filter, view.Sort
+	}
+	var q TodoQuery
+	readableTodos(&q.TodoFilter, &userID)
+	if ferr := filter.apply(&q.TodoFilter, &userID, time.Now().UTC()); ferr != nil {
+		// Only possible for views saved before a validation rule existed
+		return c.Status(400).JSON(ferr.response())
+	}
+	if msg := parsePage(c, &q, sortBy); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	return listTodos(c, q)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// addTodo adds a todo through the API and returns its id.
+func addTodo(t *testing.T, app *fiber.App, token string, todo fiber.Map) string {
+	t.Helper()
+	status, res := request(t, app, "POST", "/api/todos", token, todo)
+	if status != 201 {
+		t.Fatalf("create %v: %d %v", todo, status, res)
+	}
+	return res["_id"].(string)
+}
+
+func TestBuiltinViewsListTodos(t *testing.T) {
+	app, _ := newTestApp(t)
+	alice, _ := register(t, app, "Alice", "alice@example.com")
+	bob, _ := register(t, app, "Bob", "bob@example.com")
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	addTodo(t, app, alice, fiber.Map{"body": "none"})
+	addTodo(t, app, alice, fiber.Map{"body": "overdue", "dueDate": today.Add(-12 * time.Hour)})
+	addTodo(t, app, alice, fiber.Map{"body": "later", "dueDate": today.AddDate(0, 0, 3)})
+	addTodo(t, app, alice, fiber.Map{"body": "today", "dueDate": today.Add(12 * time.Hour)})
+	addTodo(t, app, alice, fiber.Map{"body": "tomorrow", "dueDate": today.Add(36 * time.Hour)})
+	done := addTodo(t, app, alice, fiber.Map{"body": "done", "dueDate": today.Add(-12 * time.Hour)})
+	if status, res := request(t, app, "PATCH", "/api/todos/"+done, alice, fiber.Map{"completed": true}); status != 200 {
+		t.Fatalf("complete: %d %v", status, res)
+	}
+	addTodo(t, app, alice, fiber.Map{"body": "none either"})
+	addTodo(t, app, bob, fiber.Map{"body": "bob's", "dueDate": today.Add(12 * time.Hour)})
+
+	for view, want := range map[string][]string{
+		"today":       {"today"},
+		"upcoming":    {"tomorrow", "later"},
+		"overdue":     {"overdue"},
+		"no-due-date": {"none either", "none"},
+	} {
+		status, res := request(t, app, "GET", "/api/views/"+view+"/todos", alice, nil)
+		if got := bodies(t, res); status != 200 || !equalStrings(got, want) {
+			t.Errorf("%s: %d %v, want %v", view, status, got, want)
+		}
+	}
+	if status, res := request(t, app, "GET", "/api/views/someday/todos", alice, nil); status != 404 {
+		t.Errorf("unknown built-in view: %d %v", status, res)
+	}
+}
+
+func TestSavedViews(t *testing.T) {
+	app, _ := newTestApp(t)
+	alice, _ := register(t, app, "Alice", "alice@example.com")
+	bob, _ := register(t, app, "Bob", "bob@example.com")
+	addTodo(t, app, alice, fiber.Map{"body": "milk", "tags": []string{"home"}, "priority": "low"})
+	addTodo(t, app, alice, fiber.Map{"body": "bread", "tags": []string{"home"}, "priority": "high"})
+	addTodo(t, app, alice, fiber.Map{"body": "report", "tags": []string{"work"}, "priority": "high"})
+
+	status, view := request(t, app, "POST", "/api/views", alice, fiber.Map{"name": " Home ", "filter": fiber.Map{"q": "tag:home"}, "sort": "priority"})
+	if status != 201 || view["name"] != "Home" {
+		t.Fatalf("create: %d %v", status, view)
+	}
+	id := view["_id"].(string)
+
+	status, res := request(t, app, "GET", "/api/views", alice, nil)
+	if list := items(t, res); status != 200 || len(list) != len(builtinViews)+1 || list[0]["_id"] != "today" || list[len(list)-1]["_id"] != id {
+		t.Errorf("list: %d %v", status, list)
+	}
+	status, res = request(t, app, "GET", "/api/views/"+id+"/todos", alice, nil)
+	if got := bodies(t, res); status != 200 || !equalStrings(got, []string{"milk", "bread"}) {
+		t.Errorf("todos: %d %v", status, got)
+	}
+	// The request's sort wins over the view's
+	status, res = request(t, app, "GET", "/api/views/"+id+"/todos?sort=-priority", alice, nil)
+	if got := bodies(t, res); status != 200 || !equalStrings(got, []string{"bread", "milk"}) {
+		t.Errorf("todos sorted by the request: %d %v", status, got)
+	}
+
+	status, res = request(t, app, "PATCH", "/api/views/"+id, alice, fiber.Map{"filter": fiber.Map{"priority": "high"}})
+	if status != 200 || res["name"] != "Home" || res["sort"] != "priority" {
+		t.Errorf("update: %d %v", status, res)
+	}
+	status, res = request(t, app, "GET", "/api/views/"+id+"/todos", alice, nil)
+	if got := bodies(t, res); status != 200 || (!equalStrings(got, []string{"bread", "report"}) && !equalStrings(got, []string{"report", "bread"})) {
+		t.Errorf("todos after update: %d %v", status, got)
+	}
+
+	for _, method := range []string{"GET", "PATCH", "DELETE"} {
+		if status, res := request(t, app, method, "/api/views/"+id, bob, fiber.Map{"name": "Mine"}); status != 404 {
+			t.Errorf("%s by another user: %d %v", method, status, res)
+		}
+	}
+	if status, res := request(t, app, "GET", "/api/views/"+id+"/todos", bob, nil); status != 404 {
+		t.Errorf("todos by another user: %d %v", status, res)
+	}
+	for _, method := range []string{"PATCH", "DELETE"} {
+		if status, res := request(t, app, method, "/api/views/today", alice, fiber.Map{"name": "Now"}); status != 400 {
+			t.Errorf("%s a built-in view: %d %v", method, status, res)
+		}
+	}
+
+	if status, res := request(t, app, "DELETE", "/api/views/"+id, alice, nil); status != 200 {
+		t.Fatalf("delete: %d %v", status, res)
+	}
+	if status, res := request(t, app, "GET", "/api/views/"+id, alice, nil); status != 404 {
+		t.Errorf("after delete: %d %v", status, res)
+	}
+}
+
+func TestCreateViewValidates(t *testing.T) {
+	app, _ := newTestApp(t)
+	token, _ := register(t, app, "Alice", "alice@example.com")
+	for _, c := range []struct {
+		view         fiber.Map
+		field, token string
+	}{
+		{fiber.Map{"name": "  "}, "name", ""},
+		{fiber.Map{"name": "Soon", "filter": fiber.Map{"dueAfter": "soon"}}, "filter.dueAfter", "due>=soon"},
+		{fiber.Map{"name": "Two", "filter": fiber.Map{"status": "active completed"}}, "filter.status", ""},
+		{fiber.Map{"name": "Clash", "filter": fiber.Map{"dueBefore": "7d", "noDueDate": true}}, "filter.noDueDate", "due:none"},
+		{fiber.Map{"name": "Clash", "filter": fiber.Map{"noDueDate": true, "q": "milk due<7d"}}, "filter.q", "due<7d"},
+		{fiber.Map{"name": "Best", "sort": "relevance"}, "sort", ""},
+		{fiber.Map{"name": "Odd", "sort": "body"}, "sort", ""},
+	} {
+		status, res := request(t, app, "POST", "/api/views", token, c.view)
+		if status != 400 || res["field"] != c.field || c.token != "" && res["token"] != c.token {
+			t.Errorf("%v: %d %v, want field %s", c.view, status, res, c.field)
+		}
+	}
+}